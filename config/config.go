@@ -4,17 +4,40 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 type Config struct {
 	AuthToken  string `json:"auth_token"`
 	ServerPort string `json:"server_port"`
+	MasterKey  string `json:"master_key,omitempty"` // 敏感字段加密主密钥（环境变量优先；未配置时使用master.key文件）
+
+	SessionIdleTimeout int `json:"session_idle_timeout,omitempty"` // SSH会话断开后保留时长（分钟），默认30
+
+	RecordSessions         bool `json:"record_sessions,omitempty"`          // 录制所有终端会话（asciicast v2）
+	RecordingRetentionDays int  `json:"recording_retention_days,omitempty"` // 录像保留天数，默认30
+
+	ProbeInterval    int  `json:"probe_interval,omitempty"`    // 服务器可达性探测间隔（秒），默认60，小于0关闭
+	ProbeConcurrency int  `json:"probe_concurrency,omitempty"` // 同时探测的服务器数，默认8
+	ProbeAuth        bool `json:"probe_auth,omitempty"`        // 探测时同时校验登录凭据（关闭时不做任何登录，经跳板机的服务器只复用已有的跳板连接）
 }
 
+// MasterKeyEnv 主密钥环境变量名，设置后优先于配置文件
+const MasterKeyEnv = "WEBSSH_MASTER_KEY"
+
+// MasterKeyFile 未配置主密钥时自动生成的密钥文件名（位于配置文件所在目录）
+const MasterKeyFile = "master.key"
+
 var AppConfig *Config
 
+// fileMasterKey 从主密钥文件读取的密钥
+var fileMasterKey string
+
 // LoadConfig 加载配置文件
 func LoadConfig(path string) error {
 	// 检查文件是否存在
@@ -37,6 +60,15 @@ func LoadConfig(path string) error {
 		return err
 	}
 
+	// 未配置主密钥时从独立的密钥文件读取，不存在则自动生成（丢失主密钥将无法解密已保存的密码）
+	if os.Getenv(MasterKeyEnv) == "" && AppConfig.MasterKey == "" {
+		key, err := loadMasterKeyFile(masterKeyPath(path))
+		if err != nil {
+			return err
+		}
+		fileMasterKey = key
+	}
+
 	log.Println("✓ 配置文件加载成功")
 	log.Printf("✓ Auth Token: %s...%s", AppConfig.AuthToken[:8], AppConfig.AuthToken[len(AppConfig.AuthToken)-4:])
 	return nil
@@ -49,12 +81,7 @@ func createDefaultConfig(path string) error {
 		ServerPort: "8080",
 	}
 
-	data, err := json.MarshalIndent(defaultConfig, "", "  ")
-	if err != nil {
-		return err
-	}
-
-	if err := os.WriteFile(path, data, 0644); err != nil {
+	if err := saveConfig(path, defaultConfig); err != nil {
 		return err
 	}
 
@@ -63,6 +90,45 @@ func createDefaultConfig(path string) error {
 	return nil
 }
 
+// masterKeyPath 自动生成的主密钥文件路径（与配置文件同目录，已加入.gitignore）
+func masterKeyPath(configPath string) string {
+	return filepath.Join(filepath.Dir(configPath), MasterKeyFile)
+}
+
+// loadMasterKeyFile 读取主密钥文件，不存在时生成新密钥并写入（仅所有者可读写）
+func loadMasterKeyFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key := strings.TrimSpace(string(data))
+		if key == "" {
+			return "", fmt.Errorf("主密钥文件为空: %s", path)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", fmt.Errorf("读取主密钥文件失败: %w", err)
+	}
+
+	key := generateRandomToken()
+	if err := os.WriteFile(path, []byte(key+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("写入主密钥文件失败: %w", err)
+	}
+	log.Printf("⚠️ 未配置主密钥，已自动生成并写入 %s，请妥善备份（不要提交到版本库，也可改用环境变量%s）", path, MasterKeyEnv)
+	return key, nil
+}
+
+// saveConfig 写入配置文件（仅所有者可读写）
+func saveConfig(path string, cfg *Config) error {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0600)
+}
+
 // generateRandomToken 生成随机Token
 func generateRandomToken() string {
 	b := make([]byte, 32)
@@ -85,3 +151,59 @@ func GetPort() string {
 	}
 	return "8080"
 }
+
+// GetMasterKey 获取敏感字段加密主密钥（优先级：环境变量、配置文件、主密钥文件）
+func GetMasterKey() string {
+	if key := os.Getenv(MasterKeyEnv); key != "" {
+		return key
+	}
+	if AppConfig != nil && AppConfig.MasterKey != "" {
+		return AppConfig.MasterKey
+	}
+	return fileMasterKey
+}
+
+// GetSessionIdleTimeout 获取SSH会话断开后的保留时长
+func GetSessionIdleTimeout() time.Duration {
+	if AppConfig != nil && AppConfig.SessionIdleTimeout > 0 {
+		return time.Duration(AppConfig.SessionIdleTimeout) * time.Minute
+	}
+	return 30 * time.Minute
+}
+
+// RecordSessions 是否录制所有终端会话
+func RecordSessions() bool {
+	return AppConfig != nil && AppConfig.RecordSessions
+}
+
+// GetRecordingRetentionDays 获取录像保留天数
+func GetRecordingRetentionDays() int {
+	if AppConfig != nil && AppConfig.RecordingRetentionDays > 0 {
+		return AppConfig.RecordingRetentionDays
+	}
+	return 30
+}
+
+// GetProbeInterval 获取服务器可达性探测间隔（返回0表示关闭探测）
+func GetProbeInterval() time.Duration {
+	if AppConfig == nil || AppConfig.ProbeInterval == 0 {
+		return 60 * time.Second
+	}
+	if AppConfig.ProbeInterval < 0 {
+		return 0
+	}
+	return time.Duration(AppConfig.ProbeInterval) * time.Second
+}
+
+// GetProbeConcurrency 获取同时探测的服务器数
+func GetProbeConcurrency() int {
+	if AppConfig != nil && AppConfig.ProbeConcurrency > 0 {
+		return AppConfig.ProbeConcurrency
+	}
+	return 8
+}
+
+// ProbeAuth 探测时是否校验登录凭据
+func ProbeAuth() bool {
+	return AppConfig != nil && AppConfig.ProbeAuth
+}
@@ -3,15 +3,16 @@ package handlers
 import (
 	"all_project/models"
 	"all_project/storage"
-	"bufio"
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"net/http"
-	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -40,6 +41,27 @@ type ChatRequest struct {
 	RealTimeInfo string `json:"real_time_info,omitempty"` // 终端缓冲区
 	CursorInfo   string `json:"cursor_info,omitempty"`    // 编辑器上下文
 	SourceInfo   string `json:"source_info,omitempty"`    // 来源信息
+	ServerID     string `json:"server_id,omitempty"`      // 当前终端对应的服务器（填充系统提示词模板变量）
+}
+
+// queuedChatRequest 排队等待处理的消息（stopSeq为入队时的停止序号）
+type queuedChatRequest struct {
+	req     ChatRequest
+	stopSeq int
+}
+
+// chatConn 并发安全的WebSocket写入
+// 读取协程（pong）和生成流程（内容推送）会同时写入
+type chatConn struct {
+	ws *websocket.Conn
+	mu sync.Mutex
+}
+
+// WriteJSON 加锁写入JSON消息
+func (c *chatConn) WriteJSON(v interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ws.WriteJSON(v)
 }
 
 // ChatStream 处理AI对话的WebSocket连接
@@ -48,251 +70,383 @@ func (h *AIChatHandler) ChatStream(w http.ResponseWriter, r *http.Request) {
 	upgrader := websocket.Upgrader{
 		CheckOrigin: func(r *http.Request) bool { return true },
 	}
-	ws, err := upgrader.Upgrade(w, r, nil)
+	wsConn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("WebSocket升级失败:", err)
 		return
 	}
-	defer ws.Close()
-
-	for {
-		// 读取客户端消息
-		var req ChatRequest
-		if err := ws.ReadJSON(&req); err != nil {
-			log.Println("读取消息失败:", err)
-			break
+	defer wsConn.Close()
+
+	ws := &chatConn{ws: wsConn}
+
+	// 当前生成的取消函数（收到stop或连接断开时调用）；
+	// stopSeq 每次停止时递增，入队早于停止的消息出队时直接丢弃
+	var (
+		cancelMu      sync.Mutex
+		cancelCurrent context.CancelFunc
+		stopSeq       int
+	)
+	stopCurrent := func() {
+		cancelMu.Lock()
+		stopSeq++
+		if cancelCurrent != nil {
+			cancelCurrent()
 		}
+		cancelMu.Unlock()
+	}
 
-		// 处理心跳
-		if req.Type == "ping" {
-			ws.WriteJSON(map[string]string{"type": "pong"})
-			continue
-		}
+	// 独立的读取协程：生成过程中也能及时收到ping/stop
+	requests := make(chan queuedChatRequest, 16)
+	go func() {
+		defer close(requests)
+		defer stopCurrent()
+		for {
+			// 读取客户端消息
+			var req ChatRequest
+			if err := wsConn.ReadJSON(&req); err != nil {
+				log.Println("读取消息失败:", err)
+				return
+			}
 
-		// 处理停止信号
-		if req.Type == "stop" {
-			log.Println("⏹️ 收到停止生成请求")
-			// TODO: 实现停止逻辑
-			continue
+			switch req.Type {
+			case "ping":
+				// 处理心跳
+				ws.WriteJSON(map[string]string{"type": "pong"})
+			case "stop":
+				// 处理停止信号
+				log.Println("⏹️ 收到停止生成请求")
+				stopCurrent()
+			default:
+				cancelMu.Lock()
+				queued := queuedChatRequest{req: req, stopSeq: stopSeq}
+				cancelMu.Unlock()
+				select {
+				case requests <- queued:
+				default:
+					ws.WriteJSON(map[string]interface{}{
+						"type":  "error",
+						"error": "请求过多，请等待当前回复完成",
+					})
+				}
+			}
 		}
-
-		// 获取会话
-		session, err := storage.GetSession(req.SessionID)
-		if err != nil {
-			log.Printf("❌ 获取会话失败: %v, SessionID: %s", err, req.SessionID)
+	}()
+
+	for queued := range requests {
+		ctx, cancel := context.WithCancel(r.Context())
+		cancelMu.Lock()
+		if queued.stopSeq != stopSeq {
+			// 排队期间收到了停止信号
+			cancelMu.Unlock()
+			cancel()
+			log.Printf("⏹️ 丢弃停止前排队的消息: %s", queued.req.SessionID)
 			ws.WriteJSON(map[string]interface{}{
-				"type":  "error",
-				"error": fmt.Sprintf("会话不存在: %v", err),
+				"type": "stopped",
 			})
 			continue
 		}
+		cancelCurrent = cancel
+		cancelMu.Unlock()
 
-		// 从会话配置读取模型ID
-		modelID := session.ModelID
-		if modelID == "" {
-			ws.WriteJSON(map[string]interface{}{
-				"type":  "error",
-				"error": "会话未配置模型",
-			})
-			continue
+		h.handleChatMessage(ctx, ws, queued.req)
+
+		cancelMu.Lock()
+		cancelCurrent = nil
+		cancelMu.Unlock()
+		cancel()
+	}
+}
+
+// handleChatMessage 处理一条用户消息（含工具调用循环），ctx取消时中断生成
+func (h *AIChatHandler) handleChatMessage(ctx context.Context, ws *chatConn, req ChatRequest) {
+	// 获取会话
+	session, err := storage.GetSession(req.SessionID)
+	if err != nil {
+		log.Printf("❌ 获取会话失败: %v, SessionID: %s", err, req.SessionID)
+		ws.WriteJSON(map[string]interface{}{
+			"type":  "error",
+			"error": fmt.Sprintf("会话不存在: %v", err),
+		})
+		return
+	}
+
+	// 从会话配置读取模型ID
+	modelID := session.ModelID
+	if modelID == "" {
+		ws.WriteJSON(map[string]interface{}{
+			"type":  "error",
+			"error": "会话未配置模型",
+		})
+		return
+	}
+
+	// 根据模型ID找到供应商
+	provider, err := storage.FindProviderByModel(modelID)
+	if err != nil {
+		ws.WriteJSON(map[string]interface{}{
+			"type":  "error",
+			"error": "未找到模型对应的供应商: " + modelID,
+		})
+		return
+	}
+
+	model := provider.FindModel(modelID)
+	if model == nil {
+		model = &storage.Model{ID: modelID}
+	}
+
+	// 获取全局AI配置，并按 会话 > 模型 > 全局 合并生成参数
+	globalConfig, err := storage.GetAIConfig()
+	if err != nil {
+		ws.WriteJSON(map[string]interface{}{
+			"type":  "error",
+			"error": "获取AI配置失败",
+		})
+		return
+	}
+	aiConfig := storage.ResolveAIConfig(globalConfig, model, session)
+
+	// 历史消息快照（会话是缓存中的指针，保存用户消息后Messages会包含本条消息，
+	// 本条消息注入上下文信息后单独追加）
+	history := session.Messages
+
+	// 保存用户消息
+	userMsg := storage.ChatMessage{
+		Role:      "user",
+		Content:   req.Content,
+		Timestamp: time.Now(),
+	}
+	if err := storage.AddMessage(req.SessionID, userMsg); err != nil {
+		ws.WriteJSON(map[string]interface{}{
+			"type":  "error",
+			"error": "保存消息失败",
+		})
+		return
+	}
+
+	// 构建用户消息内容（注入上下文信息）
+	userContent := req.Content
+	if req.RealTimeInfo != "" || req.CursorInfo != "" {
+		userContent = injectContextInfo(req.Content, req.RealTimeInfo, req.CursorInfo, req.SourceInfo)
+		log.Printf("📝 已注入上下文信息 - RealTimeInfo: %d字符, CursorInfo: %d字符",
+			len(req.RealTimeInfo), len(req.CursorInfo))
+	}
+
+	// 构建消息历史（系统提示词中的模板变量按当前终端填充，超出上下文预算时压缩）
+	budget := contextBudget(*model, aiConfig)
+	messages := h.buildContext(ctx, ws, provider, *model, aiConfig, session, history,
+		newPromptContext(ctx, req.ServerID), userContent, budget)
+
+	// 工具调用循环（最多10轮）
+	maxIterations := 10
+	for iteration := 0; iteration < maxIterations; iteration++ {
+		// 按供应商协议流式调用（支持工具调用），超出预算时省略早期工具结果
+		fitted, _ := elideToolResults(messages, budget)
+		toolCalls, assistantContent, reasoningContent, usage, err := h.streamChatWithTools(
+			ctx,
+			provider,
+			*model,
+			fitted,
+			aiConfig,
+			ws,
+		)
+
+		// 记录用量（停止或出错时已生成的部分同样计费）
+		h.recordUsage(ws, session, provider, *model, usage, storage.UsageKindChat, err != nil)
+
+		// 用户停止：保存已生成的部分内容（丢弃未完成的工具调用）
+		if ctx.Err() != nil {
+			h.finishInterrupted(ws, req.SessionID, assistantContent, reasoningContent, usage)
+			return
 		}
 
-		// 根据模型ID找到供应商
-		provider, err := storage.FindProviderByModel(modelID)
 		if err != nil {
 			ws.WriteJSON(map[string]interface{}{
 				"type":  "error",
-				"error": "未找到模型对应的供应商: " + modelID,
+				"error": err.Error(),
 			})
-			continue
+			return
 		}
 
-		// 获取全局AI配置
-		aiConfig, err := storage.GetAIConfig()
-		if err != nil {
-			ws.WriteJSON(map[string]interface{}{
-				"type":  "error",
-				"error": "获取AI配置失败",
-			})
-			continue
+		// 保存助手回复（包含工具调用）
+		var toolCallsForSave []map[string]interface{}
+		for _, tc := range toolCalls {
+			if tcMap, ok := tc.(map[string]interface{}); ok {
+				toolCallsForSave = append(toolCallsForSave, tcMap)
+			}
 		}
 
-		// 保存用户消息
-		userMsg := storage.ChatMessage{
-			Role:      "user",
-			Content:   req.Content,
-			Timestamp: time.Now(),
+		assistantMsg := storage.ChatMessage{
+			Role:             "assistant",
+			Content:          assistantContent,
+			ReasoningContent: reasoningContent,
+			ToolCalls:        toolCallsForSave, // 保存工具调用
+			Usage:            usage,
+			Timestamp:        time.Now(),
 		}
-		if err := storage.AddMessage(req.SessionID, userMsg); err != nil {
-			ws.WriteJSON(map[string]interface{}{
-				"type":  "error",
-				"error": "保存消息失败",
-			})
-			continue
+		if err := storage.AddMessage(req.SessionID, assistantMsg); err != nil {
+			log.Println("保存助手消息失败:", err)
 		}
 
-		// 构建消息历史
-		messages := buildMessagesForAPI(session.Messages, aiConfig.SystemPrompt)
+		// 检查是否有工具调用
+		if len(toolCalls) == 0 {
+			// 没有工具调用，结束循环
+			// 保存当前轮次的快照
+			h.saveCurrentTurnSnapshot(req.SessionID)
 
-		// 构建用户消息内容（注入上下文信息）
-		userContent := req.Content
-		if req.RealTimeInfo != "" || req.CursorInfo != "" {
-			userContent = injectContextInfo(req.Content, req.RealTimeInfo, req.CursorInfo, req.SourceInfo)
-			log.Printf("📝 已注入上下文信息 - RealTimeInfo: %d字符, CursorInfo: %d字符",
-				len(req.RealTimeInfo), len(req.CursorInfo))
+			ws.WriteJSON(map[string]interface{}{
+				"type": "done",
+			})
+			return
 		}
 
-		messages = append(messages, map[string]interface{}{
-			"role":    "user",
-			"content": userContent,
+		// 发送工具调用信息给前端
+		ws.WriteJSON(map[string]interface{}{
+			"type":       "tool_calls",
+			"tool_calls": toolCalls,
 		})
 
-		// 工具调用循环（最多10轮）
-		maxIterations := 10
-		for iteration := 0; iteration < maxIterations; iteration++ {
-			// 调用OpenAI API (流式，支持工具调用)
-			toolCalls, assistantContent, reasoningContent, err := h.streamChatWithTools(
-				provider.BaseURL,
-				provider.APIKey,
-				modelID,
-				messages,
-				aiConfig,
-				ws,
-			)
-
-			if err != nil {
-				ws.WriteJSON(map[string]interface{}{
-					"type":  "error",
-					"error": err.Error(),
-				})
-				break
-			}
-
-			// 保存助手回复（包含工具调用）
-			var toolCallsForSave []map[string]interface{}
-			for _, tc := range toolCalls {
-				if tcMap, ok := tc.(map[string]interface{}); ok {
-					toolCallsForSave = append(toolCallsForSave, tcMap)
-				}
-			}
+		// 添加助手的工具调用消息
+		messages = append(messages, map[string]interface{}{
+			"role":       "assistant",
+			"content":    assistantContent,
+			"tool_calls": toolCalls,
+		})
 
-			assistantMsg := storage.ChatMessage{
-				Role:             "assistant",
-				Content:          assistantContent,
-				ReasoningContent: reasoningContent,
-				ToolCalls:        toolCallsForSave, // 保存工具调用
-				Timestamp:        time.Now(),
-			}
-			if err := storage.AddMessage(req.SessionID, assistantMsg); err != nil {
-				log.Println("保存助手消息失败:", err)
+		// 执行工具并收集结果
+		for _, toolCall := range toolCalls {
+			tcMap, ok := toolCall.(map[string]interface{})
+			if !ok {
+				continue
 			}
 
-			// 检查是否有工具调用
-			if len(toolCalls) == 0 {
-				// 没有工具调用，结束循环
-				// 保存当前轮次的快照
-				h.saveCurrentTurnSnapshot(req.SessionID)
-
-				ws.WriteJSON(map[string]interface{}{
-					"type": "done",
+			toolCallID := getString(tcMap, "id")
+			functionData := getMap(tcMap, "function")
+			functionName := getString(functionData, "name")
+			functionArgs := getString(functionData, "arguments")
+
+			// 已停止：剩余工具不再执行，但仍需写入tool响应，保持tool_calls与响应成对
+			if ctx.Err() != nil {
+				interruptedResult, _ := json.Marshal(map[string]interface{}{
+					"success": false,
+					"status":  "interrupted",
+					"error":   "用户已停止生成，工具未执行",
 				})
-				break
+				if err := storage.AddMessage(req.SessionID, storage.ChatMessage{
+					Role:        "tool",
+					Content:     string(interruptedResult),
+					ToolCallID:  toolCallID,
+					ToolName:    functionName,
+					Interrupted: true,
+					Timestamp:   time.Now(),
+				}); err != nil {
+					log.Println("保存工具消息失败:", err)
+				}
+				continue
 			}
 
-			// 发送工具调用信息给前端
+			// 发送工具调用通知（执行前）
 			ws.WriteJSON(map[string]interface{}{
-				"type":       "tool_calls",
-				"tool_calls": toolCalls,
-			})
-
-			// 添加助手的工具调用消息
-			messages = append(messages, map[string]interface{}{
-				"role":       "assistant",
-				"content":    assistantContent,
-				"tool_calls": toolCalls,
+				"type":         "tool_call",
+				"tool_call_id": toolCallID,
+				"name":         functionName,
+				"arguments":    functionArgs,
 			})
 
-			// 执行工具并收集结果
-			for _, toolCall := range toolCalls {
-				tcMap, ok := toolCall.(map[string]interface{})
-				if !ok {
-					continue
-				}
-
-				toolCallID := getString(tcMap, "id")
-				functionData := getMap(tcMap, "function")
-				functionName := getString(functionData, "name")
-				functionArgs := getString(functionData, "arguments")
-
-				// 发送工具调用通知（执行前）
-				ws.WriteJSON(map[string]interface{}{
-					"type":         "tool_call",
-					"tool_call_id": toolCallID,
-					"name":         functionName,
-					"arguments":    functionArgs,
-				})
-
-				// 执行工具（传递sessionID和messageID）
-				result := h.executeToolCall(functionName, functionArgs, req.SessionID, toolCallID)
-
-				// 如果是file_operation且类型为edit，解析结果并发送edit_preview
-				if functionName == "file_operation" {
-					var opResult map[string]interface{}
-					if err := json.Unmarshal([]byte(result), &opResult); err == nil {
-						if success, ok := opResult["success"].(bool); ok && success {
-							if opType, ok := opResult["type"].(string); ok && opType == "edit" {
-								// 发送编辑预览给前端
-								ws.WriteJSON(map[string]interface{}{
-									"type":       "edit_preview",
-									"preview_id": opResult["preview_id"],
-									"server_id":  opResult["server_id"],
-									"file_path":  opResult["file_path"],
-									"operations": opResult["operations"],
-								})
-							}
+			// 执行工具（传递sessionID和messageID）
+			result := h.executeToolCall(ctx, functionName, functionArgs, req.SessionID, toolCallID)
+
+			// 如果是file_operation且类型为edit，解析结果并发送edit_preview
+			if functionName == "file_operation" {
+				var opResult map[string]interface{}
+				if err := json.Unmarshal([]byte(result), &opResult); err == nil {
+					if success, ok := opResult["success"].(bool); ok && success {
+						if opType, ok := opResult["type"].(string); ok && opType == "edit" {
+							// 发送编辑预览给前端
+							ws.WriteJSON(map[string]interface{}{
+								"type":       "edit_preview",
+								"preview_id": opResult["preview_id"],
+								"server_id":  opResult["server_id"],
+								"file_path":  opResult["file_path"],
+								"operations": opResult["operations"],
+							})
 						}
 					}
 				}
+			}
 
-				// 添加工具结果到消息历史（API）
-				messages = append(messages, map[string]interface{}{
-					"role":         "tool",
-					"tool_call_id": toolCallID,
-					"content":      result,
-				})
+			// 添加工具结果到消息历史（API）
+			messages = append(messages, map[string]interface{}{
+				"role":         "tool",
+				"tool_call_id": toolCallID,
+				"content":      result,
+			})
 
-				// 发送工具执行结果给前端
-				ws.WriteJSON(map[string]interface{}{
-					"type":         "tool_result",
-					"tool_call_id": toolCallID,
-					"name":         functionName,
-					"result":       result,
-				})
+			// 发送工具执行结果给前端
+			ws.WriteJSON(map[string]interface{}{
+				"type":         "tool_result",
+				"tool_call_id": toolCallID,
+				"name":         functionName,
+				"result":       result,
+			})
 
-				// 保存工具结果到数据库
-				toolMsg := storage.ChatMessage{
-					Role:       "tool",
-					Content:    result,
-					ToolCallID: toolCallID,
-					ToolName:   functionName,
-					Timestamp:  time.Now(),
-				}
-				if err := storage.AddMessage(req.SessionID, toolMsg); err != nil {
-					log.Println("保存工具消息失败:", err)
-				}
+			// 保存工具结果到数据库
+			toolMsg := storage.ChatMessage{
+				Role:       "tool",
+				Content:    result,
+				ToolCallID: toolCallID,
+				ToolName:   functionName,
+				Timestamp:  time.Now(),
+			}
+			if err := storage.AddMessage(req.SessionID, toolMsg); err != nil {
+				log.Println("保存工具消息失败:", err)
 			}
+		}
 
-			// 继续下一轮对话（带着工具结果）
+		// 工具执行期间被停止，不再发起下一轮请求
+		if ctx.Err() != nil {
+			h.finishInterrupted(ws, req.SessionID, "", "", nil)
+			return
 		}
 
-		// 如果达到最大迭代次数
-		if maxIterations >= 10 {
-			ws.WriteJSON(map[string]interface{}{
-				"type":    "warning",
-				"message": "工具调用达到最大次数限制",
-			})
+		// 继续下一轮对话（带着工具结果）
+	}
+
+	// 达到最大迭代次数
+	ws.WriteJSON(map[string]interface{}{
+		"type":    "warning",
+		"message": "工具调用达到最大次数限制",
+	})
+}
+
+// finishInterrupted 结束被停止的生成：保存部分回复并通知前端
+func (h *AIChatHandler) finishInterrupted(ws *chatConn, sessionID, partialContent, reasoningContent string, usage *storage.TokenUsage) {
+	log.Printf("⏹️ 生成已停止: %s (已生成%d字符)", sessionID, len(partialContent))
+
+	if partialContent != "" || reasoningContent != "" {
+		assistantMsg := storage.ChatMessage{
+			Role:             "assistant",
+			Content:          partialContent,
+			ReasoningContent: reasoningContent,
+			Usage:            usage,
+			Interrupted:      true,
+			Timestamp:        time.Now(),
+		}
+		if err := storage.AddMessage(sessionID, assistantMsg); err != nil {
+			log.Println("保存助手消息失败:", err)
 		}
 	}
+
+	// 已产生的pending修改仍需保存快照，保证撤销可用
+	h.saveCurrentTurnSnapshot(sessionID)
+
+	ws.WriteJSON(map[string]interface{}{
+		"type":    "content",
+		"content": "\n\n[生成已停止]",
+	})
+	ws.WriteJSON(map[string]interface{}{
+		"type": "stopped",
+	})
 }
 
 // saveCurrentTurnSnapshot 保存当前轮次的快照到file_history
@@ -334,9 +488,9 @@ func (h *AIChatHandler) saveCurrentTurnSnapshot(sessionID string) {
 
 	// 对每个文件保存快照
 	for filePath := range allFiles {
-		// 读取磁盘内容
-		diskContent, err := os.ReadFile(filePath)
-		if err != nil {
+		// 读取磁盘内容（本地或远程服务器）
+		diskContent, err := readFileByKey(filePath)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
 			log.Printf("⚠️ 读取文件失败 %s: %v", filePath, err)
 			continue
 		}
@@ -364,10 +518,14 @@ func (h *AIChatHandler) saveCurrentTurnSnapshot(sessionID string) {
 }
 
 // buildMessagesForAPI 构建API消息列表
-func buildMessagesForAPI(history []storage.ChatMessage, systemPrompt string) []map[string]interface{} {
+// vars不为nil时渲染系统提示词中的 {{变量}}；summary为较早对话的摘要（history之前的部分）
+func buildMessagesForAPI(history []storage.ChatMessage, systemPrompt, summary string, vars *promptContext) []map[string]interface{} {
 	messages := []map[string]interface{}{}
 
 	// 添加系统提示
+	if vars != nil {
+		systemPrompt = renderPromptTemplate(systemPrompt, vars.lookup)
+	}
 	if systemPrompt != "" {
 		messages = append(messages, map[string]interface{}{
 			"role":    "system",
@@ -375,6 +533,14 @@ func buildMessagesForAPI(history []storage.ChatMessage, systemPrompt string) []m
 		})
 	}
 
+	// 添加历史摘要
+	if summary != "" {
+		messages = append(messages, map[string]interface{}{
+			"role":    "system",
+			"content": "以下是本次会话较早部分的摘要，原始消息已省略：\n\n" + summary,
+		})
+	}
+
 	// 添加历史消息
 	for _, msg := range history {
 		message := map[string]interface{}{
@@ -430,181 +596,122 @@ func injectContextInfo(userMessage, realTimeInfo, cursorInfo, sourceInfo string)
 	return strings.Join(parts, "")
 }
 
-// streamChatWithTools 流式调用OpenAI API，收集tool_calls
+// streamChatWithTools 按供应商协议流式调用对话接口，收集tool_calls和用量（接口未返回用量时为nil）
+// 返回的工具调用统一为OpenAI格式，正文和推理内容实时推送给前端
+// 请求体按模型能力裁剪：不支持工具时不发送工具定义，不支持采样参数时不发送temperature等
 func (h *AIChatHandler) streamChatWithTools(
-	baseURL string,
-	apiKey string,
-	model string,
+	ctx context.Context,
+	provider *storage.Provider,
+	model storage.Model,
 	messages []map[string]interface{},
 	config *storage.AIConfig,
-	ws *websocket.Conn,
-) ([]interface{}, string, string, error) {
-	// 构建请求体
-	requestBody := map[string]interface{}{
-		"model":       model,
-		"messages":    messages,
-		"stream":      true,
-		"temperature": config.Temperature,
-		"max_tokens":  config.MaxTokens,
-		"top_p":       config.TopP,
-		"tools":       GetToolsDefinition(), // 添加工具定义
+	ws *chatConn,
+) ([]interface{}, string, string, *storage.TokenUsage, error) {
+	chatReq := &providerChatRequest{
+		Model:        model.ID,
+		Messages:     messages,
+		Config:       config,
+		Capabilities: model.Capabilities,
+	}
+	if model.Capabilities.SupportsTools() {
+		chatReq.Tools = GetToolsDefinition()
 	}
 
-	if config.FrequencyPenalty != 0 {
-		requestBody["frequency_penalty"] = config.FrequencyPenalty
+	sink := &streamSink{ws: ws}
+	err := streamProviderChat(ctx, provider, chatReq, sink)
+	toolCalls, content, reasoning := sink.result()
+	if err != nil {
+		// 被取消或出错时返回已生成的部分内容和用量（用量通常在流末尾返回，缺失时按已生成内容估算）
+		usage := sink.usage
+		if usage == nil && (content != "" || reasoning != "") {
+			usage = &storage.TokenUsage{
+				PromptTokens:     estimateMessagesTokens(messages),
+				CompletionTokens: estimateTokens(content) + estimateTokens(reasoning),
+				Estimated:        true,
+			}
+		}
+		return nil, content, reasoning, usage, err
 	}
-	if config.PresencePenalty != 0 {
-		requestBody["presence_penalty"] = config.PresencePenalty
+	return toolCalls, content, reasoning, sink.usage, nil
+}
+
+// recordUsage 补全用量的模型和费用，写入用量账本并推送给前端（usage为nil时忽略）
+// 总结请求等后台调用不推送（ws为nil）
+func (h *AIChatHandler) recordUsage(
+	ws *chatConn,
+	session *storage.ChatSession,
+	provider *storage.Provider,
+	model storage.Model,
+	usage *storage.TokenUsage,
+	kind string,
+	interrupted bool,
+) {
+	if usage == nil {
+		return
+	}
+	usage.ModelID = model.ID
+	usage.ProviderID = provider.ID
+	model.Pricing.Apply(usage)
+
+	if err := storage.RecordUsage(storage.UsageRecord{
+		SessionID:    session.ID,
+		SessionTitle: session.Title,
+		Kind:         kind,
+		Interrupted:  interrupted,
+		TokenUsage:   *usage,
+	}); err != nil {
+		log.Printf("⚠️ 记录用量失败: %v", err)
 	}
 
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return nil, "", "", err
+	if ws != nil {
+		ws.WriteJSON(map[string]interface{}{
+			"type":  "usage",
+			"usage": usage,
+		})
 	}
+}
 
-	// 创建HTTP请求
-	url := strings.TrimSuffix(baseURL, "/") + "/chat/completions"
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, "", "", err
+// streamProviderChat 发送流式请求并把响应写入sink
+func streamProviderChat(ctx context.Context, provider *storage.Provider, chatReq *providerChatRequest, sink *streamSink) error {
+	adapter := newProviderAdapter(provider.Type)
+
+	// 输出上限不能超过模型上下文
+	if maxContext := chatReq.Capabilities.MaxContext; maxContext > 0 && chatReq.Config.MaxTokens > maxContext {
+		limited := *chatReq.Config
+		limited.MaxTokens = maxContext
+		chatReq.Config = &limited
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req, err := adapter.newRequest(ctx, provider, chatReq)
+	if err != nil {
+		return err
+	}
 
 	// 发送请求
 	client := &http.Client{Timeout: 5 * time.Minute}
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, "", "", err
+		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, "", "", fmt.Errorf("API错误 %d: %s", resp.StatusCode, string(body))
+		return fmt.Errorf("API错误 %d: %s", resp.StatusCode, string(body))
 	}
 
 	// 处理流式响应
-	var fullContent strings.Builder
-	var reasoningContent string
-	var toolCalls []interface{}
-	reader := bufio.NewReader(resp.Body)
-
-	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return nil, "", "", err
-		}
-
-		line = strings.TrimSpace(line)
-		if line == "" || line == "data: [DONE]" {
-			continue
-		}
-
-		if !strings.HasPrefix(line, "data: ") {
-			continue
-		}
-
-		jsonStr := strings.TrimPrefix(line, "data: ")
-		var streamResp map[string]interface{}
-		if err := json.Unmarshal([]byte(jsonStr), &streamResp); err != nil {
-			continue
-		}
-
-		choices, ok := streamResp["choices"].([]interface{})
-		if !ok || len(choices) == 0 {
-			continue
-		}
-
-		choice := choices[0].(map[string]interface{})
-		delta, ok := choice["delta"].(map[string]interface{})
-		if !ok {
-			continue
-		}
-
-		// 处理普通内容
-		if content, ok := delta["content"].(string); ok {
-			fullContent.WriteString(content)
-			// 发送增量内容给前端
-			ws.WriteJSON(map[string]interface{}{
-				"type":    "content",
-				"content": content,
-			})
-		}
-
-		// 处理reasoning内容（o1模型）
-		if reasoning, ok := delta["reasoning_content"].(string); ok {
-			reasoningContent += reasoning
-			ws.WriteJSON(map[string]interface{}{
-				"type":              "reasoning",
-				"reasoning_content": reasoning,
-			})
-		}
-
-		// 收集tool_calls（流式累积）
-		if deltaToolCalls, ok := delta["tool_calls"].([]interface{}); ok {
-			for _, tc := range deltaToolCalls {
-				tcMap, ok := tc.(map[string]interface{})
-				if !ok {
-					continue
-				}
-
-				index, hasIndex := tcMap["index"].(float64)
-				if !hasIndex {
-					continue
-				}
-
-				idx := int(index)
-
-				// 扩展toolCalls数组
-				for len(toolCalls) <= idx {
-					toolCalls = append(toolCalls, map[string]interface{}{
-						"id":   "",
-						"type": "function",
-						"function": map[string]interface{}{
-							"name":      "",
-							"arguments": "",
-						},
-					})
-				}
-
-				currentTC := toolCalls[idx].(map[string]interface{})
-
-				// 更新id和type
-				if id, ok := tcMap["id"].(string); ok && id != "" {
-					currentTC["id"] = id
-				}
-				if tcType, ok := tcMap["type"].(string); ok && tcType != "" {
-					currentTC["type"] = tcType
-				}
-
-				// 累积function数据
-				if funcData, ok := tcMap["function"].(map[string]interface{}); ok {
-					currentFunc := currentTC["function"].(map[string]interface{})
-					if name, ok := funcData["name"].(string); ok && name != "" {
-						currentFunc["name"] = name
-					}
-					if args, ok := funcData["arguments"].(string); ok {
-						currentFunc["arguments"] = currentFunc["arguments"].(string) + args
-					}
-				}
-			}
-		}
-	}
-
-	return toolCalls, fullContent.String(), reasoningContent, nil
+	return readSSE(resp.Body, func(event, data string) error {
+		return adapter.handleEvent(event, data, sink)
+	})
 }
 
 // executeToolCall 执行工具调用
-func (h *AIChatHandler) executeToolCall(toolName, argsJSON string, conversationID string, messageID string) string {
+func (h *AIChatHandler) executeToolCall(ctx context.Context, toolName, argsJSON string, conversationID string, messageID string) string {
 	log.Printf("🔧 执行工具: %s, conversationID: %s, messageID: %s", toolName, conversationID, messageID)
 
 	// 使用统一工具执行器
-	result, err := h.toolExecutor.Execute(toolName, argsJSON, conversationID, messageID)
+	result, err := h.toolExecutor.Execute(ctx, toolName, argsJSON, conversationID, messageID)
 	if err != nil {
 		log.Printf("❌ 工具执行失败: %v", err)
 		// 返回错误信息给AI（使用json.Marshal正确转义）
@@ -628,6 +735,13 @@ func getString(m map[string]interface{}, key string) string {
 	return ""
 }
 
+func getInt(m map[string]interface{}, key string) int {
+	if v, ok := m[key].(float64); ok {
+		return int(v)
+	}
+	return 0
+}
+
 func getMap(m map[string]interface{}, key string) map[string]interface{} {
 	if v, ok := m[key].(map[string]interface{}); ok {
 		return v
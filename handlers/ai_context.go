@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"all_project/storage"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+const (
+	defaultContextWindow   = 32000 // 未配置max_context的模型按此估算
+	contextUsableRatio     = 0.9   // token数是估算值，只使用上下文的90%
+	messageTokenOverhead   = 4     // 每条消息的角色、分隔符等开销
+	summaryKeepRatio       = 0.5   // 总结后保留的最近消息最多占预算的一半
+	summaryMaxTokens       = 2048  // 摘要的输出上限
+	summaryMessageMaxRunes = 2000  // 总结时每条消息最多保留的字符数
+	summaryMinMessageRunes = 200
+)
+
+// elidedToolResultFormat 省略后的工具结果（保留消息本身，tool_call_id不变）
+const elidedToolResultFormat = "[较早的工具结果已省略以节省上下文（原%d字符），如仍需要请重新调用工具获取]"
+
+// summaryInstruction 总结历史对话的系统提示词
+const summaryInstruction = `你负责压缩一段AI助手与用户的对话历史，供后续对话继续使用。
+请输出一份简洁的摘要，保留：
+1. 用户的目标、要求和偏好
+2. 已确认的事实：涉及的服务器、文件路径、配置、执行过的命令及关键结果
+3. 已完成的工作和仍未完成的事项
+4. 重要的结论和决定
+不要编造对话中没有的内容，不要寒暄，直接输出摘要。`
+
+// estimateTokens 粗略估算文本的token数：ASCII约4字符1个token，中文等其他字符约1字符1个token
+func estimateTokens(text string) int {
+	ascii, other := 0, 0
+	for _, r := range text {
+		if r < 0x80 {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return (ascii+3)/4 + other
+}
+
+// estimateMessageTokens 估算一条API消息（含工具调用参数）的token数
+func estimateMessageTokens(msg map[string]interface{}) int {
+	tokens := messageTokenOverhead + estimateTokens(getString(msg, "content"))
+	for _, tc := range messageToolCalls(msg) {
+		function := getMap(tc, "function")
+		tokens += messageTokenOverhead + estimateTokens(getString(function, "name")) +
+			estimateTokens(getString(function, "arguments"))
+	}
+	return tokens
+}
+
+// estimateMessagesTokens 估算消息列表的token数
+func estimateMessagesTokens(messages []map[string]interface{}) int {
+	total := 0
+	for _, msg := range messages {
+		total += estimateMessageTokens(msg)
+	}
+	return total
+}
+
+// estimateChatMessageTokens 估算一条已保存消息的token数
+func estimateChatMessageTokens(msg storage.ChatMessage) int {
+	tokens := messageTokenOverhead + estimateTokens(msg.Content)
+	for _, tc := range msg.ToolCalls {
+		function := getMap(tc, "function")
+		tokens += messageTokenOverhead + estimateTokens(getString(function, "name")) +
+			estimateTokens(getString(function, "arguments"))
+	}
+	return tokens
+}
+
+// contextBudget 可用于输入消息的token预算：上下文窗口 - 输出预留 - 工具定义
+func contextBudget(model storage.Model, config *storage.AIConfig) int {
+	window := model.Capabilities.MaxContext
+	if window <= 0 {
+		window = defaultContextWindow
+	}
+
+	// 输出预留最多占一半，避免max_tokens配置过大时没有输入空间
+	reserve := config.MaxTokens
+	if reserve <= 0 || reserve > window/2 {
+		reserve = window / 2
+	}
+	budget := int(float64(window-reserve) * contextUsableRatio)
+
+	if model.Capabilities.SupportsTools() {
+		if data, err := json.Marshal(GetToolsDefinition()); err == nil {
+			budget -= estimateTokens(string(data))
+		}
+	}
+	return budget
+}
+
+// elideToolResults 超出预算时从最早的工具结果开始替换为省略说明，返回新列表和估算token数
+// 末尾连续的工具结果（模型这一步要读取的）不省略；只替换内容，不增删消息，
+// 因此assistant的tool_calls和对应的tool响应始终成对
+func elideToolResults(messages []map[string]interface{}, budget int) ([]map[string]interface{}, int) {
+	total := estimateMessagesTokens(messages)
+	if total <= budget {
+		return messages, total
+	}
+
+	protectFrom := len(messages)
+	for protectFrom > 0 && getString(messages[protectFrom-1], "role") == "tool" {
+		protectFrom--
+	}
+
+	result := make([]map[string]interface{}, len(messages))
+	copy(result, messages)
+	elided := 0
+	for i := 0; i < protectFrom && total > budget; i++ {
+		if getString(result[i], "role") != "tool" {
+			continue
+		}
+		content := getString(result[i], "content")
+		placeholder := fmt.Sprintf(elidedToolResultFormat, len([]rune(content)))
+		saved := estimateTokens(content) - estimateTokens(placeholder)
+		if saved <= 0 {
+			continue
+		}
+
+		copied := make(map[string]interface{}, len(result[i]))
+		for k, v := range result[i] {
+			copied[k] = v
+		}
+		copied["content"] = placeholder
+		result[i] = copied
+		total -= saved
+		elided++
+	}
+
+	if elided > 0 {
+		log.Printf("✂️ 已省略%d条早期工具结果，估算%d tokens（预算%d）", elided, total, budget)
+	}
+	return result, total
+}
+
+// buildContext 构建发送给模型的消息（history不含本次用户消息）
+// 省略早期工具结果后仍超出预算时，把较早的轮次总结为摘要并保存到会话
+func (h *AIChatHandler) buildContext(
+	ctx context.Context,
+	ws *chatConn,
+	provider *storage.Provider,
+	model storage.Model,
+	config *storage.AIConfig,
+	session *storage.ChatSession,
+	history []storage.ChatMessage,
+	vars *promptContext,
+	userContent string,
+	budget int,
+) []map[string]interface{} {
+	build := func(summary string, upTo int) []map[string]interface{} {
+		if upTo > len(history) {
+			summary, upTo = "", 0
+		}
+		messages := buildMessagesForAPI(history[upTo:], config.SystemPrompt, summary, vars)
+		return append(messages, map[string]interface{}{
+			"role":    "user",
+			"content": userContent,
+		})
+	}
+
+	messages := build(session.Summary, session.SummaryUpTo)
+	if _, tokens := elideToolResults(messages, budget); tokens <= budget {
+		return messages
+	}
+
+	summary, upTo, err := h.summarizeHistory(ctx, provider, model, config, session, history, budget)
+	if err != nil {
+		log.Printf("⚠️ 压缩对话历史失败: %v", err)
+		return messages
+	}
+
+	log.Printf("🗜️ 会话 %s 前%d条消息已总结为摘要（%d字符）", session.ID, upTo, len(summary))
+	ws.WriteJSON(map[string]interface{}{
+		"type":          "context_compacted",
+		"summary_up_to": upTo,
+	})
+	return build(summary, upTo)
+}
+
+// summarizeHistory 用当前模型总结较早的轮次（连同已有摘要），保存到会话并返回新摘要和覆盖范围
+// 切分点只取user消息或历史末尾，保证不拆开assistant的tool_calls和对应的tool响应
+func (h *AIChatHandler) summarizeHistory(
+	ctx context.Context,
+	provider *storage.Provider,
+	model storage.Model,
+	config *storage.AIConfig,
+	session *storage.ChatSession,
+	history []storage.ChatMessage,
+	budget int,
+) (string, int, error) {
+	start := session.SummaryUpTo
+	if start > len(history) {
+		start = 0
+	}
+
+	cut := summaryCut(history, start, int(float64(budget)*summaryKeepRatio))
+	if cut <= start {
+		return "", 0, fmt.Errorf("没有可总结的历史消息")
+	}
+
+	previous := session.Summary
+	if session.SummaryUpTo > len(history) {
+		previous = ""
+	}
+	transcript := summaryTranscript(previous, history[start:cut], budget-summaryMaxTokens)
+
+	summaryConfig := *config
+	summaryConfig.MaxTokens = summaryMaxTokens
+	sink := &streamSink{}
+	err := streamProviderChat(ctx, provider, &providerChatRequest{
+		Model: model.ID,
+		Messages: []map[string]interface{}{
+			{"role": "system", "content": summaryInstruction},
+			{"role": "user", "content": transcript},
+		},
+		Config:       &summaryConfig,
+		Capabilities: model.Capabilities,
+	}, sink)
+	h.recordUsage(nil, session, provider, model, sink.usage, storage.UsageKindSummary, err != nil)
+	if err != nil {
+		return "", 0, err
+	}
+
+	_, summary, _ := sink.result()
+	summary = strings.TrimSpace(summary)
+	if summary == "" {
+		return "", 0, fmt.Errorf("模型未返回摘要")
+	}
+
+	if err := storage.UpdateSessionSummary(session.ID, summary, cut); err != nil {
+		return "", 0, err
+	}
+	return summary, cut, nil
+}
+
+// summaryCut 计算总结的切分点：从后向前保留不超过keep的完整轮次，
+// 最近一轮即使超出keep也保留原文（其中过大的工具结果由elideToolResults省略）；
+// 返回值为保留部分第一条user消息的下标，start之后没有user消息时返回len(history)
+func summaryCut(history []storage.ChatMessage, start, keep int) int {
+	cut := len(history)
+	tokens := 0
+	for i := len(history) - 1; i > start; i-- {
+		tokens += estimateChatMessageTokens(history[i])
+		if history[i].Role != "user" {
+			continue
+		}
+		if tokens > keep && cut < len(history) {
+			break
+		}
+		cut = i
+	}
+	return cut
+}
+
+// summaryTranscript 把要总结的消息整理为文本，超出预算时逐步缩短每条消息
+func summaryTranscript(previous string, messages []storage.ChatMessage, budget int) string {
+	var transcript string
+	for limit := summaryMessageMaxRunes; ; limit /= 2 {
+		var b strings.Builder
+		if previous != "" {
+			b.WriteString("## 更早对话的摘要\n" + previous + "\n\n")
+		}
+		b.WriteString("## 对话记录\n")
+		for _, msg := range messages {
+			switch msg.Role {
+			case "user":
+				b.WriteString("\n用户: " + truncateRunes(msg.Content, limit) + "\n")
+			case "assistant":
+				if msg.Content != "" {
+					b.WriteString("\n助手: " + truncateRunes(msg.Content, limit) + "\n")
+				}
+				for _, tc := range msg.ToolCalls {
+					function := getMap(tc, "function")
+					b.WriteString(fmt.Sprintf("助手调用工具 %s: %s\n",
+						getString(function, "name"), truncateRunes(getString(function, "arguments"), limit)))
+				}
+			case "tool":
+				b.WriteString(fmt.Sprintf("工具 %s 返回: %s\n", msg.ToolName, truncateRunes(msg.Content, limit)))
+			}
+		}
+
+		transcript = b.String()
+		if estimateTokens(transcript) <= budget || limit <= summaryMinMessageRunes {
+			return transcript
+		}
+	}
+}
+
+// truncateRunes 截断到最多limit个字符
+func truncateRunes(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit]) + "…（已截断）"
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"all_project/storage"
+	"strings"
+	"testing"
+)
+
+func TestSummaryCut(t *testing.T) {
+	history := []storage.ChatMessage{
+		{Role: "user", Content: "第一个问题"},
+		{Role: "assistant", Content: "第一个回答"},
+		{Role: "user", Content: "第二个问题"},
+		{Role: "assistant", Content: "第二个回答"},
+		{Role: "user", Content: "查看日志"},
+		{Role: "assistant", ToolCalls: []map[string]interface{}{
+			{"id": "c1", "type": "function", "function": map[string]interface{}{"name": "read_file", "arguments": `{"path":"/var/log/syslog"}`}},
+		}},
+		{Role: "tool", ToolCallID: "c1", ToolName: "read_file", Content: strings.Repeat("log line\n", 2000)},
+		{Role: "assistant", Content: "日志中没有错误"},
+	}
+	// tokensFrom 从下标i到末尾的token数
+	tokensFrom := func(i int) int {
+		total := 0
+		for _, msg := range history[i:] {
+			total += estimateChatMessageTokens(msg)
+		}
+		return total
+	}
+
+	tests := []struct {
+		name  string
+		start int
+		keep  int
+		want  int
+	}{
+		{name: "预算足够时保留start之后的所有轮次", keep: tokensFrom(0), want: 2},
+		{name: "恰好容纳最近两轮", keep: tokensFrom(2), want: 2},
+		{name: "只容纳最近一轮", keep: tokensFrom(2) - 1, want: 4},
+		{name: "最近一轮超出预算仍保留原文", keep: tokensFrom(6) / 2, want: 4},
+		{name: "预算为0仍保留最近一轮", keep: 0, want: 4},
+		{name: "已有摘要覆盖到最近一轮之前", start: 2, keep: 0, want: 4},
+		{name: "start之后没有user消息", start: 4, keep: tokensFrom(0), want: len(history)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := summaryCut(history, tt.start, tt.keep); got != tt.want {
+				t.Errorf("summaryCut(start=%d, keep=%d) = %d，期望 %d", tt.start, tt.keep, got, tt.want)
+			}
+		})
+	}
+}
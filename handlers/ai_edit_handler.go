@@ -3,10 +3,11 @@ package handlers
 import (
 	"all_project/models"
 	"all_project/storage"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"net/http"
-	"os"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -21,14 +22,17 @@ func NewAIEditHandler() *AIEditHandler {
 }
 
 // ApplyEditRequest 应用编辑请求
+// tool_call_id/file_path 均为空时作用于整个会话（Accept All / Reject All），
+// 否则只作用于选中的文件或单个修改
 type ApplyEditRequest struct {
-	ToolCallID     string `json:"tool_call_id"` // 兼容旧API，实际不使用
+	ToolCallID     string `json:"tool_call_id"` // 可选：只处理该工具调用的修改
 	Status         string `json:"status"`       // "accepted" or "rejected"
-	FilePath       string `json:"file_path"`    // 兼容旧API，实际不使用
+	FilePath       string `json:"file_path"`    // 可选：只处理该文件的修改
+	ServerID       string `json:"server_id"`    // 可选：file_path所在服务器（默认local）
 	ConversationID string `json:"conversation_id"`
 }
 
-// ApplyEdit Accept All 或 Reject All
+// ApplyEdit Accept/Reject（全部、单个文件或单个修改）
 func (h *AIEditHandler) ApplyEdit(c *gin.Context) {
 	var req ApplyEditRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -42,6 +46,15 @@ func (h *AIEditHandler) ApplyEdit(c *gin.Context) {
 	pendingManager := models.GetPendingStateManager()
 	historyManager := models.GetFileHistoryManager()
 
+	selector := models.EditSelector{ToolCallID: req.ToolCallID}
+	if req.FilePath != "" {
+		selector.FileKey = models.FileKey(req.ServerID, req.FilePath)
+	}
+	if !selector.IsEmpty() {
+		h.applySelected(c, req, selector, pendingManager, historyManager)
+		return
+	}
+
 	if req.Status == "accepted" {
 		// Accept All: 应用所有pending，保存快照，写入磁盘
 		if err := h.acceptAll(req.ConversationID, pendingManager, historyManager); err != nil {
@@ -151,9 +164,9 @@ func (h *AIEditHandler) acceptAll(conversationID string, pendingManager *models.
 
 // acceptFileEdits 应用单个文件的所有edits并返回最终内容
 func (h *AIEditHandler) acceptFileEdits(conversationID, filePath string, turns []models.TurnEdits, historyManager *models.FileHistoryManager) (string, error) {
-	// 读取磁盘内容
-	diskContent, err := os.ReadFile(filePath)
-	if err != nil {
+	// 读取磁盘内容（本地或远程服务器，新建文件从空内容开始）
+	diskContent, err := readFileByKey(filePath)
+	if err != nil && !(errors.Is(err, fs.ErrNotExist) && hasWriteOperation(filePath, turns)) {
 		return "", fmt.Errorf("读取文件失败: %v", err)
 	}
 
@@ -175,13 +188,13 @@ func (h *AIEditHandler) acceptFileEdits(conversationID, filePath string, turns [
 
 		// 应用该轮的所有edits
 		for _, edit := range edits {
-			state = strings.Replace(state, edit.OldString, edit.NewString, 1)
+			state = edit.Apply(state)
 		}
 		log.Printf("✏️ Turn%d应用%d个edit: %d字节", turn.UserMessageIndex, len(edits), len(state))
 	}
 
 	// 写入最终状态到磁盘
-	if err := os.WriteFile(filePath, []byte(state), 0644); err != nil {
+	if err := writeFileByKey(filePath, []byte(state)); err != nil {
 		return "", fmt.Errorf("写入文件失败: %v", err)
 	}
 
@@ -189,6 +202,18 @@ func (h *AIEditHandler) acceptFileEdits(conversationID, filePath string, turns [
 	return state, nil
 }
 
+// hasWriteOperation 检查文件是否有整文件写入操作（新建文件）
+func hasWriteOperation(filePath string, turns []models.TurnEdits) bool {
+	for _, turn := range turns {
+		for _, edit := range turn.FileEdits[filePath] {
+			if edit.Type == "write" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // rejectAll 取消所有pending修改
 func (h *AIEditHandler) rejectAll(conversationID string, pendingManager *models.PendingStateManager, historyManager *models.FileHistoryManager) error {
 	// 1. 获取所有轮次
@@ -240,3 +265,207 @@ func (h *AIEditHandler) rejectAll(conversationID string, pendingManager *models.
 
 	return nil
 }
+
+// applySelected 处理单个文件或单个修改的Accept/Reject
+func (h *AIEditHandler) applySelected(c *gin.Context, req ApplyEditRequest, selector models.EditSelector, pendingManager *models.PendingStateManager, historyManager *models.FileHistoryManager) {
+	selected := pendingManager.SelectEdits(req.ConversationID, selector)
+	if len(selected) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "没有匹配的pending修改",
+		})
+		return
+	}
+
+	var conflicts []string
+	var err error
+	switch req.Status {
+	case "accepted":
+		err = h.acceptSelected(req.ConversationID, selector, selected, pendingManager, historyManager)
+	case "rejected":
+		conflicts, err = h.rejectSelected(req.ConversationID, selector, selected, pendingManager, historyManager)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid status",
+		})
+		return
+	}
+
+	if err != nil {
+		log.Printf("❌ 处理选中修改失败 (%s): %v", req.Status, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   fmt.Sprintf("处理失败: %v", err),
+		})
+		return
+	}
+
+	toolCallIDs := collectToolCallIDs(selected)
+	for _, toolCallID := range toolCallIDs {
+		if err := storage.UpdateToolMessageStatus(toolCallID, req.Status); err != nil {
+			log.Printf("⚠️ 更新tool消息状态失败 (%s): %v", toolCallID, err)
+		}
+	}
+
+	log.Printf("✅ 已%s %d个修改: %s", req.Status, len(toolCallIDs), req.ConversationID)
+	c.JSON(http.StatusOK, gin.H{
+		"success":       true,
+		"status":        req.Status,
+		"tool_call_ids": toolCallIDs,
+		"conflicts":     conflicts, // 拒绝后不再能应用的剩余修改
+	})
+}
+
+// acceptSelected 将选中的修改写入磁盘，其余修改保持pending
+func (h *AIEditHandler) acceptSelected(conversationID string, selector models.EditSelector, selected []models.TurnEdits, pendingManager *models.PendingStateManager, historyManager *models.FileHistoryManager) error {
+	// 1. 先计算所有文件的新内容，任何一个冲突都不写入
+	type turnSnapshot struct {
+		filePath  string
+		turnIndex int
+		content   string
+	}
+	newContents := make(map[string]string)
+	initialSnapshots := []turnSnapshot{}
+	for filePath := range filesOfTurns(selected) {
+		diskContent, err := readFileByKey(filePath)
+		if err != nil && !(errors.Is(err, fs.ErrNotExist) && hasWriteOperation(filePath, selected)) {
+			return fmt.Errorf("读取文件失败 %s: %v", filePath, err)
+		}
+
+		state := string(diskContent)
+		for _, turn := range selected {
+			edits, hasEdits := turn.FileEdits[filePath]
+			if !hasEdits {
+				continue
+			}
+
+			// 该轮开始前的快照（已有则保留最早的状态）
+			if !historyManager.HasSnapshot(conversationID, filePath, turn.UserMessageIndex) {
+				initialSnapshots = append(initialSnapshots, turnSnapshot{filePath, turn.UserMessageIndex, state})
+			}
+
+			for _, edit := range edits {
+				if edit.Type != "write" && !strings.Contains(state, edit.OldString) {
+					return fmt.Errorf("修改 %s 依赖尚未确认的修改，请先确认之前的修改", edit.ToolCallID)
+				}
+				state = edit.Apply(state)
+			}
+		}
+		newContents[filePath] = state
+	}
+
+	// 2. 写入磁盘
+	for filePath, content := range newContents {
+		if err := writeFileByKey(filePath, []byte(content)); err != nil {
+			return fmt.Errorf("写入文件失败 %s: %v", filePath, err)
+		}
+		log.Printf("💾 写入磁盘: %s (%d字节)", filePath, len(content))
+	}
+
+	// 3. 从pending移除已确认的修改
+	if err := pendingManager.RemoveEdits(conversationID, selector); err != nil {
+		return fmt.Errorf("更新pending失败: %v", err)
+	}
+
+	// 4. 保存各轮开始前的快照
+	for _, snapshot := range initialSnapshots {
+		if err := historyManager.AddSnapshot(conversationID, snapshot.filePath, snapshot.turnIndex, snapshot.content); err != nil {
+			log.Printf("⚠️ 保存Turn%d快照失败: %v", snapshot.turnIndex, err)
+		}
+	}
+
+	// 5. 补齐最终快照（Turn N+1 = 磁盘 + 剩余pending）
+	lastTurnIndex := selected[len(selected)-1].UserMessageIndex
+	for filePath, content := range newContents {
+		if historyManager.HasSnapshot(conversationID, filePath, lastTurnIndex+1) {
+			continue
+		}
+		finalContent := pendingManager.GetCurrentContent(conversationID, filePath, content)
+		if err := historyManager.AddSnapshot(conversationID, filePath, lastTurnIndex+1, finalContent); err != nil {
+			log.Printf("⚠️ 保存Turn%d快照失败: %v", lastTurnIndex+1, err)
+		}
+	}
+
+	return nil
+}
+
+// rejectSelected 丢弃选中的修改，返回因此无法再应用的剩余修改
+func (h *AIEditHandler) rejectSelected(conversationID string, selector models.EditSelector, selected []models.TurnEdits, pendingManager *models.PendingStateManager, historyManager *models.FileHistoryManager) ([]string, error) {
+	// 1. 从pending移除
+	if err := pendingManager.RemoveEdits(conversationID, selector); err != nil {
+		return nil, fmt.Errorf("更新pending失败: %v", err)
+	}
+
+	// 2. 按剩余pending重新计算受影响文件的Turn N+1快照
+	remaining := pendingManager.GetTurns(conversationID)
+	conflicts := []string{}
+	for filePath := range filesOfTurns(selected) {
+		diskContent, err := readFileByKey(filePath)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("⚠️ 读取文件失败 %s: %v", filePath, err)
+			continue
+		}
+
+		state := string(diskContent)
+		hasRemaining := false
+		for _, turn := range remaining {
+			for _, edit := range turn.FileEdits[filePath] {
+				hasRemaining = true
+				if edit.Type != "write" && !strings.Contains(state, edit.OldString) {
+					conflicts = append(conflicts, edit.ToolCallID)
+				}
+				state = edit.Apply(state)
+			}
+		}
+
+		for _, turn := range selected {
+			if _, ok := turn.FileEdits[filePath]; !ok {
+				continue
+			}
+			turnIndex := turn.UserMessageIndex + 1
+			if !hasRemaining {
+				// 该文件已无pending，与Reject All一致：删除临时快照
+				err = historyManager.RemoveFileSnapshot(conversationID, filePath, turnIndex)
+			} else if historyManager.HasSnapshot(conversationID, filePath, turnIndex) {
+				err = historyManager.SetSnapshot(conversationID, filePath, turnIndex, pendingManager.GetCurrentContent(conversationID, filePath, string(diskContent)))
+			}
+			if err != nil {
+				log.Printf("⚠️ 更新Turn%d快照失败: %v", turnIndex, err)
+			}
+		}
+	}
+
+	if len(conflicts) > 0 {
+		log.Printf("⚠️ 拒绝后有%d个剩余修改无法应用: %v", len(conflicts), conflicts)
+	}
+	return conflicts, nil
+}
+
+// filesOfTurns 收集轮次中涉及的所有文件键
+func filesOfTurns(turns []models.TurnEdits) map[string]bool {
+	files := make(map[string]bool)
+	for _, turn := range turns {
+		for filePath := range turn.FileEdits {
+			files[filePath] = true
+		}
+	}
+	return files
+}
+
+// collectToolCallIDs 收集轮次中的所有tool_call_id（去重）
+func collectToolCallIDs(turns []models.TurnEdits) []string {
+	seen := make(map[string]bool)
+	ids := []string{}
+	for _, turn := range turns {
+		for _, edits := range turn.FileEdits {
+			for _, edit := range edits {
+				if !seen[edit.ToolCallID] {
+					seen[edit.ToolCallID] = true
+					ids = append(ids, edit.ToolCallID)
+				}
+			}
+		}
+	}
+	return ids
+}
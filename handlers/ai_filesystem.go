@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"all_project/models"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/sftp"
+)
+
+// localServerID 本地主机的服务器ID
+const localServerID = "local"
+
+// FileSystem AI工具使用的文件系统抽象（本地磁盘 / 远程SFTP）
+type FileSystem interface {
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte) error
+	Stat(name string) (fs.FileInfo, error)
+	ReadDir(name string) ([]fs.FileInfo, error)
+	WalkDir(root string, fn fs.WalkDirFunc) error
+	Base(name string) string
+	Separator() string
+}
+
+// isLocalServer 判断server_id是否指向本地
+func isLocalServer(serverID string) bool {
+	return serverID == "" || serverID == localServerID
+}
+
+// resolveFileSystem 根据server_id获取文件系统
+// 远程服务器优先复用已打开终端的SFTP连接，否则使用后台连接池
+func resolveFileSystem(serverID string) (FileSystem, error) {
+	return resolveFileSystemContext(context.Background(), serverID)
+}
+
+// resolveFileSystemContext 同resolveFileSystem，ctx结束时不再等待连接池拨号
+func resolveFileSystemContext(ctx context.Context, serverID string) (FileSystem, error) {
+	if isLocalServer(serverID) {
+		return localFileSystem{}, nil
+	}
+
+	if session := GetSessionManager().FindByServer(serverID); session != nil {
+		return &sftpFileSystem{client: session.SFTPClient}, nil
+	}
+
+	client, err := GetSSHPool().GetSFTPContext(ctx, serverID)
+	if err != nil {
+		return nil, err
+	}
+	return &sftpFileSystem{client: client}, nil
+}
+
+// readFileByKey 按pending文件键读取文件（见models.FileKey）
+func readFileByKey(fileKey string) ([]byte, error) {
+	serverID, filePath := models.ParseFileKey(fileKey)
+	fsys, err := resolveFileSystem(serverID)
+	if err != nil {
+		return nil, err
+	}
+	return fsys.ReadFile(filePath)
+}
+
+// writeFileByKey 按pending文件键写入文件（见models.FileKey）
+func writeFileByKey(fileKey string, data []byte) error {
+	serverID, filePath := models.ParseFileKey(fileKey)
+	fsys, err := resolveFileSystem(serverID)
+	if err != nil {
+		return err
+	}
+	return fsys.WriteFile(filePath, data)
+}
+
+// localFileSystem 本地文件系统
+type localFileSystem struct{}
+
+func (localFileSystem) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+// WriteFile 写入文件（父目录不存在时自动创建）
+func (localFileSystem) WriteFile(name string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(name, data, 0644)
+}
+
+func (localFileSystem) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (localFileSystem) ReadDir(name string) ([]fs.FileInfo, error) {
+	entries, err := os.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]fs.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (localFileSystem) WalkDir(root string, fn fs.WalkDirFunc) error {
+	return filepath.WalkDir(root, fn)
+}
+
+func (localFileSystem) Base(name string) string {
+	return filepath.Base(name)
+}
+
+func (localFileSystem) Separator() string {
+	return string(filepath.Separator)
+}
+
+// sftpFileSystem 远程SFTP文件系统（远程路径统一使用"/"分隔）
+type sftpFileSystem struct {
+	client *sftp.Client
+}
+
+func (s *sftpFileSystem) ReadFile(name string) ([]byte, error) {
+	file, err := s.client.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// WriteFile 写入文件（父目录不存在时自动创建）
+func (s *sftpFileSystem) WriteFile(name string, data []byte) error {
+	if err := s.client.MkdirAll(path.Dir(name)); err != nil {
+		return err
+	}
+	file, err := s.client.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (s *sftpFileSystem) Stat(name string) (fs.FileInfo, error) {
+	return s.client.Stat(name)
+}
+
+func (s *sftpFileSystem) ReadDir(name string) ([]fs.FileInfo, error) {
+	infos, err := s.client.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+// WalkDir 与filepath.WalkDir语义一致（支持SkipDir/SkipAll）
+func (s *sftpFileSystem) WalkDir(root string, fn fs.WalkDirFunc) error {
+	info, err := s.client.Stat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = s.walkDir(root, fs.FileInfoToDirEntry(info), fn)
+	}
+	if errors.Is(err, fs.SkipDir) || errors.Is(err, fs.SkipAll) {
+		return nil
+	}
+	return err
+}
+
+func (s *sftpFileSystem) walkDir(name string, d fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(name, d, nil); err != nil || !d.IsDir() {
+		if errors.Is(err, fs.SkipDir) && d.IsDir() {
+			err = nil
+		}
+		return err
+	}
+
+	infos, err := s.ReadDir(name)
+	if err != nil {
+		// 与filepath.WalkDir一致：读取目录失败时再次回调，由调用方决定是否继续
+		if err = fn(name, d, err); err != nil {
+			if errors.Is(err, fs.SkipDir) {
+				err = nil
+			}
+			return err
+		}
+	}
+
+	for _, info := range infos {
+		if err := s.walkDir(path.Join(name, info.Name()), fs.FileInfoToDirEntry(info), fn); err != nil {
+			if errors.Is(err, fs.SkipDir) {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sftpFileSystem) Base(name string) string {
+	return path.Base(name)
+}
+
+func (s *sftpFileSystem) Separator() string {
+	return "/"
+}
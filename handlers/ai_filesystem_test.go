@@ -0,0 +1,307 @@
+package handlers
+
+import (
+	"all_project/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+)
+
+// testServerID 测试中由进程内SFTP服务提供的远程服务器
+const testServerID = "srv-sftp-test"
+
+// newTestSFTPClient 启动进程内SFTP服务（直接读写本机文件系统）并返回连接到它的客户端
+func newTestSFTPClient(t *testing.T) *sftp.Client {
+	t.Helper()
+
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+	server, err := sftp.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{serverReader, serverWriter})
+	if err != nil {
+		t.Fatalf("创建SFTP服务失败: %v", err)
+	}
+	go server.Serve()
+
+	client, err := sftp.NewClientPipe(clientReader, clientWriter)
+	if err != nil {
+		t.Fatalf("连接SFTP服务失败: %v", err)
+	}
+	t.Cleanup(func() {
+		// 先关闭服务端的写入端，客户端读取协程才能退出
+		server.Close()
+		client.Close()
+	})
+	return client
+}
+
+// registerSFTPSession 注册一个只带SFTP客户端的终端会话，使testServerID解析为远程文件系统
+func registerSFTPSession(t *testing.T) {
+	t.Helper()
+	session := &SSHSession{ID: "session-" + testServerID, ServerID: testServerID}
+	// 在创建客户端之前注册，使SFTP服务先于会话关闭
+	t.Cleanup(func() { GetSessionManager().RemoveSession(session.ID) })
+	session.SFTPClient = newTestSFTPClient(t)
+	GetSessionManager().AddSession(session)
+}
+
+// writeTree 按 相对路径→内容 创建文件（内容为空的以/结尾的路径创建目录）
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		full := filepath.Join(root, filepath.FromSlash(name))
+		if strings.HasSuffix(name, "/") {
+			if err := os.MkdirAll(full, 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// chdirTemp 切换到临时目录（pending状态等按相对路径写入），结束时恢复
+func chdirTemp(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestSFTPFileSystemWalkDirMatchesFilepath(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"a/1.txt":      "1",
+		"a/2.txt":      "2",
+		"b/skip/x.txt": "x",
+		"b/y.txt":      "y",
+		"c.txt":        "c",
+		"empty/":       "",
+	})
+	remote := &sftpFileSystem{client: newTestSFTPClient(t)}
+
+	// 回调按相对路径决定返回值
+	tests := []struct {
+		name   string
+		root   string
+		result map[string]error
+	}{
+		{name: "完整遍历"},
+		{name: "目录返回SkipDir", result: map[string]error{"b/skip": fs.SkipDir}},
+		{name: "文件返回SkipDir跳过同级剩余项", result: map[string]error{"a/1.txt": fs.SkipDir}},
+		{name: "根目录返回SkipDir", result: map[string]error{".": fs.SkipDir}},
+		{name: "SkipAll", result: map[string]error{"b/skip/x.txt": fs.SkipAll}},
+		{name: "其他错误中止遍历", result: map[string]error{"b": errors.New("stop")}},
+		{name: "根目录不存在", root: "missing"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := root
+			if tt.root != "" {
+				start = filepath.Join(root, tt.root)
+			}
+			walk := func(fsys FileSystem) ([]string, error) {
+				var visited []string
+				err := fsys.WalkDir(start, func(name string, d fs.DirEntry, err error) error {
+					rel, _ := filepath.Rel(root, name)
+					rel = filepath.ToSlash(rel)
+					if err != nil {
+						visited = append(visited, rel+" (error)")
+						return err
+					}
+					if d.IsDir() {
+						rel += "/"
+					}
+					visited = append(visited, rel)
+					return tt.result[strings.TrimSuffix(rel, "/")]
+				})
+				return visited, err
+			}
+
+			want, wantErr := walk(localFileSystem{})
+			got, gotErr := walk(remote)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("SFTP遍历 = %v\nfilepath.WalkDir = %v", got, want)
+			}
+			if (gotErr == nil) != (wantErr == nil) || (gotErr != nil && !errors.Is(gotErr, fs.ErrNotExist) && gotErr.Error() != wantErr.Error()) {
+				t.Errorf("错误 = %v，filepath.WalkDir返回 %v", gotErr, wantErr)
+			}
+		})
+	}
+}
+
+func TestResolveFileSystemRouting(t *testing.T) {
+	registerSFTPSession(t)
+
+	for _, serverID := range []string{"", localServerID} {
+		if fsys, err := resolveFileSystem(serverID); err != nil || !reflect.DeepEqual(fsys, FileSystem(localFileSystem{})) {
+			t.Errorf("server_id=%q 应为本地文件系统，得到 %T %v", serverID, fsys, err)
+		}
+	}
+	fsys, err := resolveFileSystem(testServerID)
+	if err != nil {
+		t.Fatalf("解析远程文件系统失败: %v", err)
+	}
+	if _, ok := fsys.(*sftpFileSystem); !ok {
+		t.Errorf("远程服务器应复用终端会话的SFTP连接，得到 %T", fsys)
+	}
+}
+
+// runFileTool 通过工具执行器执行file_operation并解析结果
+func runFileTool(t *testing.T, ctx context.Context, conversationID string, args map[string]interface{}) (map[string]interface{}, error) {
+	t.Helper()
+	argsJSON, _ := json.Marshal(args)
+	output, err := NewToolExecutor().Execute(ctx, "file_operation", string(argsJSON), conversationID, "call-"+args["type"].(string))
+	if err != nil {
+		return nil, err
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal([]byte(output), &result); err != nil {
+		t.Fatalf("工具结果不是JSON: %v", err)
+	}
+	return result, nil
+}
+
+func TestRemoteFileTools(t *testing.T) {
+	chdirTemp(t)
+	registerSFTPSession(t)
+
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"app/main.go":           "package main\n\nfunc main() {\n\tprintln(\"hello\")\n}\n",
+		"app/util.go":           "package main\n\n// hello helper\n",
+		"app/README.md":         "hello docs\n",
+		"node_modules/dep.go":   "package dep // hello\n",
+		"node_modules/sub/x.go": "package sub\n",
+	})
+	ctx := context.Background()
+	conversationID := "conv-" + t.Name()
+	mainPath := root + "/app/main.go"
+	t.Cleanup(func() { models.GetPendingStateManager().ClearAll(conversationID) })
+
+	t.Run("list", func(t *testing.T) {
+		result, err := runFileTool(t, ctx, conversationID, map[string]interface{}{
+			"type": "list", "server_id": testServerID, "file_path": root + "/app",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, f := range result["files"].([]interface{}) {
+			names = append(names, f.(map[string]interface{})["name"].(string))
+		}
+		if !reflect.DeepEqual(names, []string{"README.md", "main.go", "util.go"}) {
+			t.Errorf("目录内容 = %v", names)
+		}
+	})
+
+	t.Run("read", func(t *testing.T) {
+		result, err := runFileTool(t, ctx, conversationID, map[string]interface{}{
+			"type": "read", "server_id": testServerID, "file_path": mainPath, "offset": 3, "limit": 2,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result["content"] != "func main() {\n\tprintln(\"hello\")" || result["total_lines"] != float64(6) {
+			t.Errorf("读取结果 = %v", result)
+		}
+	})
+
+	t.Run("grep", func(t *testing.T) {
+		result, err := runFileTool(t, ctx, conversationID, map[string]interface{}{
+			"type": "grep", "server_id": testServerID, "search_path": root, "query": "hello", "includes": []string{"*.go"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result["match_count"] != float64(3) || result["file_count"] != float64(3) {
+			t.Errorf("搜索结果 = %v", result)
+		}
+	})
+
+	t.Run("find", func(t *testing.T) {
+		result, err := runFileTool(t, ctx, conversationID, map[string]interface{}{
+			"type": "find", "server_id": testServerID, "search_path": root, "pattern": "*.go", "excludes": []string{"node_modules"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var paths []string
+		for _, r := range result["results"].([]interface{}) {
+			paths = append(paths, strings.TrimPrefix(r.(map[string]interface{})["path"].(string), root))
+		}
+		if !reflect.DeepEqual(paths, []string{"/app/main.go", "/app/util.go"}) {
+			t.Errorf("查找结果 = %v", paths)
+		}
+	})
+
+	t.Run("edit", func(t *testing.T) {
+		result, err := runFileTool(t, ctx, conversationID, map[string]interface{}{
+			"type": "edit", "server_id": testServerID, "file_path": mainPath,
+			"old_string": `println("hello")`, "new_string": `println("bye")`,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result["status"] != "pending" || result["lines_added"] != float64(1) {
+			t.Errorf("编辑结果 = %v", result)
+		}
+
+		// 确认前磁盘不变，读取返回pending内容
+		if data, _ := os.ReadFile(filepath.FromSlash(mainPath)); strings.Contains(string(data), "bye") {
+			t.Errorf("确认前不应写入磁盘")
+		}
+		read, err := runFileTool(t, ctx, conversationID, map[string]interface{}{
+			"type": "read", "server_id": testServerID, "file_path": mainPath,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if read["is_pending"] != true || !strings.Contains(read["content"].(string), `println("bye")`) {
+			t.Errorf("读取结果 = %v", read)
+		}
+
+		// 确认后按文件键经SFTP写回
+		fileKey := models.FileKey(testServerID, mainPath)
+		if err := writeFileByKey(fileKey, []byte(read["content"].(string))); err != nil {
+			t.Fatalf("写入失败: %v", err)
+		}
+		data, err := readFileByKey(fileKey)
+		if err != nil || !strings.Contains(string(data), `println("bye")`) {
+			t.Errorf("写回后内容 = %q, %v", data, err)
+		}
+	})
+
+	t.Run("取消时中断遍历", func(t *testing.T) {
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := runFileTool(t, canceled, conversationID, map[string]interface{}{
+			"type": "grep", "server_id": testServerID, "search_path": root, "query": "hello",
+		})
+		if err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+			t.Errorf("错误 = %v，期望为取消", err)
+		}
+	})
+}
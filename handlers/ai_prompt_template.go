@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"all_project/storage"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 系统提示词模板变量：{{name}}，名称两侧允许空格，未知变量原样保留
+var promptVariablePattern = regexp.MustCompile(`\{\{\s*([a-z_]+)\s*\}\}`)
+
+const (
+	promptUnknownValue = "未知"
+	osDetectTimeout    = 5 * time.Second
+	osCacheTTL         = 10 * time.Minute
+	osFailureCacheTTL  = time.Minute // 检测失败（如服务器不可达）的结果也缓存，避免每轮对话都等待连接超时
+	osDetectCommand    = `. /etc/os-release 2>/dev/null && echo "$PRETTY_NAME"; uname -srm`
+)
+
+// PromptVariable 模板变量说明（前端编辑预设时展示）
+type PromptVariable struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// PromptVariables 支持的模板变量
+var PromptVariables = []PromptVariable{
+	{"date", "当前日期（2006-01-02）"},
+	{"datetime", "当前日期和时间（2006-01-02 15:04）"},
+	{"server_id", "当前服务器ID（本地为 local）"},
+	{"server_name", "当前服务器名称"},
+	{"server_host", "当前服务器地址（user@host:port）"},
+	{"os", "当前服务器的操作系统（通过SSH检测，缓存10分钟）"},
+	{"cwd", "当前终端的工作目录（来自shell集成，无则取最近命令的目录）"},
+	{"servers", "可用服务器列表（名称、地址、ID）"},
+}
+
+// promptContext 渲染系统提示词所需的上下文，变量按需求值（只有模板用到时才检测系统、读取列表）
+type promptContext struct {
+	ctx      context.Context
+	serverID string // 当前终端对应的服务器，空或local表示本地
+	values   map[string]string
+}
+
+func newPromptContext(ctx context.Context, serverID string) *promptContext {
+	return &promptContext{ctx: ctx, serverID: serverID, values: make(map[string]string)}
+}
+
+// lookup 获取变量值（同一次渲染内缓存），未知变量返回false
+func (p *promptContext) lookup(name string) (string, bool) {
+	if v, ok := p.values[name]; ok {
+		return v, true
+	}
+
+	var value string
+	switch name {
+	case "date":
+		value = time.Now().Format("2006-01-02")
+	case "datetime":
+		value = time.Now().Format("2006-01-02 15:04")
+	case "server_id":
+		value = localServerID
+		if !isLocalServer(p.serverID) {
+			value = p.serverID
+		}
+	case "server_name", "server_host":
+		serverName, serverHost := p.serverInfo()
+		p.values["server_name"], p.values["server_host"] = serverName, serverHost
+		return p.values[name], true
+	case "os":
+		value = detectServerOS(p.ctx, p.serverID)
+	case "cwd":
+		value = p.cwd()
+	case "servers":
+		value = formatServerList()
+	default:
+		return "", false
+	}
+
+	p.values[name] = value
+	return value, true
+}
+
+// serverInfo 当前服务器的名称和地址（组默认值已合并）
+func (p *promptContext) serverInfo() (string, string) {
+	if isLocalServer(p.serverID) {
+		return localHistoryServerName, "localhost"
+	}
+	server, err := storage.GetServer(p.serverID)
+	if err != nil {
+		return promptUnknownValue, promptUnknownValue
+	}
+	if resolved, err := storage.ApplyGroupDefaults(*server); err == nil {
+		server = &resolved
+	}
+	return server.Name, fmt.Sprintf("%s@%s:%d", server.Username, server.Host, server.Port)
+}
+
+// cwd 优先取已打开终端由shell集成上报的目录，其次取命令历史中最近记录的目录
+func (p *promptContext) cwd() string {
+	historyID := p.serverID
+	if isLocalServer(p.serverID) {
+		historyID = localHistoryServerID
+	} else if session := GetSessionManager().FindByServer(p.serverID); session != nil {
+		if cwd := session.Commands.Cwd(); cwd != "" {
+			return cwd
+		}
+	}
+
+	commands, err := storage.GetCommandsByServer(historyID, 50)
+	if err == nil {
+		for _, cmd := range commands {
+			if cmd.Cwd != "" {
+				return cmd.Cwd
+			}
+		}
+	}
+	return promptUnknownValue
+}
+
+// formatServerList 可用服务器列表，每行一台
+func formatServerList() string {
+	servers, err := storage.GetServers()
+	if err != nil || len(servers) == 0 {
+		return "（无）"
+	}
+
+	lines := make([]string, 0, len(servers))
+	for _, server := range servers {
+		if resolved, err := storage.ApplyGroupDefaults(server); err == nil {
+			server = resolved
+		}
+		lines = append(lines, fmt.Sprintf("- %s（%s@%s:%d，ID: %s）",
+			server.Name, server.Username, server.Host, server.Port, server.ID))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// renderPromptTemplate 替换模板中的 {{变量}}，lookup返回false的变量原样保留
+func renderPromptTemplate(template string, lookup func(name string) (string, bool)) string {
+	if !strings.Contains(template, "{{") {
+		return template
+	}
+	return promptVariablePattern.ReplaceAllStringFunc(template, func(match string) string {
+		name := promptVariablePattern.FindStringSubmatch(match)[1]
+		if value, ok := lookup(name); ok {
+			return value
+		}
+		return match
+	})
+}
+
+// ========== 操作系统检测 ==========
+
+type cachedOS struct {
+	value     string
+	checkedAt time.Time
+	failed    bool
+}
+
+// valid 缓存是否仍在有效期内
+func (c cachedOS) valid() bool {
+	ttl := osCacheTTL
+	if c.failed {
+		ttl = osFailureCacheTTL
+	}
+	return time.Since(c.checkedAt) < ttl
+}
+
+var (
+	osCache   = make(map[string]cachedOS)
+	osCacheMu sync.Mutex
+)
+
+// detectServerOS 检测服务器的操作系统（成功结果缓存10分钟，失败缓存1分钟并返回"未知"）
+func detectServerOS(ctx context.Context, serverID string) string {
+	if isLocalServer(serverID) {
+		return localOS()
+	}
+
+	osCacheMu.Lock()
+	cached, ok := osCache[serverID]
+	osCacheMu.Unlock()
+	if ok && cached.valid() {
+		return cached.value
+	}
+
+	value, err := detectRemoteOS(ctx, serverID)
+	if err != nil {
+		log.Printf("⚠️ 检测服务器 %s 操作系统失败: %v", serverID, err)
+		// 请求被取消不代表服务器不可达，不缓存
+		if ctx.Err() != nil {
+			return promptUnknownValue
+		}
+		cached = cachedOS{value: promptUnknownValue, checkedAt: time.Now(), failed: true}
+	} else {
+		cached = cachedOS{value: value, checkedAt: time.Now()}
+	}
+
+	osCacheMu.Lock()
+	osCache[serverID] = cached
+	osCacheMu.Unlock()
+	return cached.value
+}
+
+// detectRemoteOS 通过后台连接池执行检测命令，连接和执行总共不超过osDetectTimeout（超时或ctx取消时关闭会话）
+func detectRemoteOS(ctx context.Context, serverID string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, osDetectTimeout)
+	defer cancel()
+
+	client, err := GetSSHPool().GetSSHContext(ctx, serverID)
+	if err != nil {
+		return "", err
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		return "", fmt.Errorf("创建会话失败: %v", err)
+	}
+	defer session.Close()
+
+	go func() {
+		<-ctx.Done()
+		session.Close()
+	}()
+
+	var output bytes.Buffer
+	session.Stdout = &output
+	if err := session.Run(osDetectCommand); err != nil && output.Len() == 0 {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", err
+	}
+	return joinOutputLines(output.String()), nil
+}
+
+// localOS 本机的操作系统（Linux下附带发行版名称）
+func localOS() string {
+	value := runtime.GOOS + "/" + runtime.GOARCH
+	data, err := os.ReadFile("/etc/os-release")
+	if err != nil {
+		return value
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if name, ok := strings.CutPrefix(line, "PRETTY_NAME="); ok {
+			return strings.Trim(name, `"'`) + " (" + value + ")"
+		}
+	}
+	return value
+}
+
+// joinOutputLines 把多行命令输出合并为一行
+func joinOutputLines(output string) string {
+	var parts []string
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			parts = append(parts, line)
+		}
+	}
+	if len(parts) == 0 {
+		return promptUnknownValue
+	}
+	return strings.Join(parts, " / ")
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"all_project/storage"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AIPromptsHandler struct{}
+
+func NewAIPromptsHandler() *AIPromptsHandler {
+	return &AIPromptsHandler{}
+}
+
+// GetPresets 获取所有提示词预设（内置+自定义）
+func (h *AIPromptsHandler) GetPresets(c *gin.Context) {
+	presets, err := storage.GetPromptPresets()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": presets})
+}
+
+// CreatePreset 创建提示词预设（未指定ID时自动生成）
+func (h *AIPromptsHandler) CreatePreset(c *gin.Context) {
+	var preset storage.PromptPreset
+	if err := c.ShouldBindJSON(&preset); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "参数错误: " + err.Error()})
+		return
+	}
+	if preset.ID == "" {
+		preset.ID = generateID()
+	}
+
+	if err := storage.CreatePromptPreset(&preset); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": preset})
+}
+
+// UpdatePreset 更新提示词预设
+func (h *AIPromptsHandler) UpdatePreset(c *gin.Context) {
+	var preset storage.PromptPreset
+	if err := c.ShouldBindJSON(&preset); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "参数错误: " + err.Error()})
+		return
+	}
+
+	if err := storage.UpdatePromptPreset(&preset); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": preset})
+}
+
+// DeletePreset 删除提示词预设
+func (h *AIPromptsHandler) DeletePreset(c *gin.Context) {
+	id := c.Query("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "缺少id参数"})
+		return
+	}
+
+	if err := storage.DeletePromptPreset(id); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "删除成功"})
+}
+
+// GetVariables 获取支持的模板变量
+func (h *AIPromptsHandler) GetVariables(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": PromptVariables})
+}
+
+// PreviewPreset 按指定服务器渲染提示词（content为空时渲染preset_id对应的预设）
+func (h *AIPromptsHandler) PreviewPreset(c *gin.Context) {
+	var req struct {
+		PresetID string `json:"preset_id"`
+		Content  string `json:"content"`
+		ServerID string `json:"server_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "参数错误"})
+		return
+	}
+
+	content := req.Content
+	if content == "" && req.PresetID != "" {
+		preset, err := storage.GetPromptPreset(req.PresetID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		content = preset.Content
+	}
+
+	vars := newPromptContext(c.Request.Context(), req.ServerID)
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": gin.H{
+		"content": renderPromptTemplate(content, vars.lookup),
+	}})
+}
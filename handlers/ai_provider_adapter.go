@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"all_project/storage"
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// providerChatRequest 一次流式对话请求
+// 消息历史和工具定义内部统一使用OpenAI Chat Completions格式，由适配器转换
+type providerChatRequest struct {
+	Model        string
+	Messages     []map[string]interface{}
+	Tools        []map[string]interface{} // 模型不支持工具时为空
+	Config       *storage.AIConfig
+	Capabilities storage.ModelCapabilities
+}
+
+// providerAdapter 供应商接口协议适配器（每次请求新建，可保存流式解析状态）
+type providerAdapter interface {
+	// newRequest 构建流式HTTP请求
+	newRequest(ctx context.Context, provider *storage.Provider, req *providerChatRequest) (*http.Request, error)
+	// handleEvent 处理一条SSE事件（event为事件名，没有时为空），增量写入sink
+	handleEvent(event, data string, sink *streamSink) error
+}
+
+// newProviderAdapter 根据供应商类型创建适配器（未指定类型按OpenAI兼容处理）
+func newProviderAdapter(providerType string) providerAdapter {
+	switch providerType {
+	case storage.ProviderAnthropic:
+		return &anthropicAdapter{toolBlocks: make(map[int]int)}
+	case storage.ProviderGemini:
+		return &geminiAdapter{}
+	default:
+		return &openAIAdapter{}
+	}
+}
+
+// streamSink 收集流式响应，并把正文和推理增量推送给前端（ws为nil时只收集，如后台总结）
+// 工具调用统一保存为OpenAI格式：{"id","type":"function","function":{"name","arguments"}}
+type streamSink struct {
+	ws        *chatConn
+	content   strings.Builder
+	reasoning strings.Builder
+	toolCalls []interface{}
+	usage     *storage.TokenUsage // 接口返回的用量（未返回时为nil）
+}
+
+// Usage 获取用量记录（不存在时创建），由适配器按协议填充
+func (s *streamSink) Usage() *storage.TokenUsage {
+	if s.usage == nil {
+		s.usage = &storage.TokenUsage{}
+	}
+	return s.usage
+}
+
+// Content 追加正文
+func (s *streamSink) Content(text string) {
+	if text == "" {
+		return
+	}
+	s.content.WriteString(text)
+	if s.ws == nil {
+		return
+	}
+	s.ws.WriteJSON(map[string]interface{}{
+		"type":    "content",
+		"content": text,
+	})
+}
+
+// Reasoning 追加推理内容
+func (s *streamSink) Reasoning(text string) {
+	if text == "" {
+		return
+	}
+	s.reasoning.WriteString(text)
+	if s.ws == nil {
+		return
+	}
+	s.ws.WriteJSON(map[string]interface{}{
+		"type":              "reasoning",
+		"reasoning_content": text,
+	})
+}
+
+// ToolCall 获取第index个工具调用（不存在时扩展）
+func (s *streamSink) ToolCall(index int) map[string]interface{} {
+	for len(s.toolCalls) <= index {
+		s.toolCalls = append(s.toolCalls, map[string]interface{}{
+			"id":   "",
+			"type": "function",
+			"function": map[string]interface{}{
+				"name":      "",
+				"arguments": "",
+			},
+		})
+	}
+	return s.toolCalls[index].(map[string]interface{})
+}
+
+// ToolCallDelta 累积工具调用（id和name非空时覆盖，arguments追加）
+func (s *streamSink) ToolCallDelta(index int, id, name, arguments string) {
+	tc := s.ToolCall(index)
+	if id != "" {
+		tc["id"] = id
+	}
+	function := tc["function"].(map[string]interface{})
+	if name != "" {
+		function["name"] = name
+	}
+	function["arguments"] = function["arguments"].(string) + arguments
+}
+
+// result 返回收集到的工具调用、正文和推理内容
+// 没有参数的工具调用补为 {}，缺少ID的补生成ID（部分协议不返回调用ID）
+func (s *streamSink) result() ([]interface{}, string, string) {
+	for _, tc := range s.toolCalls {
+		tcMap := tc.(map[string]interface{})
+		if getString(tcMap, "id") == "" {
+			tcMap["id"] = "call_" + generateID()[:24]
+		}
+		function := getMap(tcMap, "function")
+		if strings.TrimSpace(getString(function, "arguments")) == "" {
+			function["arguments"] = "{}"
+		}
+	}
+	return s.toolCalls, s.content.String(), s.reasoning.String()
+}
+
+// readSSE 按行解析 text/event-stream，每个事件（空行结束）回调一次
+func readSSE(r io.Reader, handle func(event, data string) error) error {
+	reader := bufio.NewReader(r)
+	var event string
+	var data []string
+
+	dispatch := func() error {
+		defer func() { event, data = "", nil }()
+		if len(data) == 0 {
+			return nil
+		}
+		return handle(event, strings.Join(data, "\n"))
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "":
+			if derr := dispatch(); derr != nil {
+				return derr
+			}
+		case strings.HasPrefix(line, ":"):
+			// 注释（心跳）
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+
+		if err == io.EOF {
+			return dispatch()
+		}
+	}
+}
+
+// toolFunctions 从OpenAI格式的工具定义中取出函数定义（name/description/parameters）
+func toolFunctions(tools []map[string]interface{}) []map[string]interface{} {
+	var functions []map[string]interface{}
+	for _, tool := range tools {
+		if function, ok := tool["function"].(map[string]interface{}); ok {
+			functions = append(functions, function)
+		}
+	}
+	return functions
+}
+
+// parseToolArguments 解析工具调用参数（JSON字符串 → 对象，无效时返回空对象）
+func parseToolArguments(arguments string) map[string]interface{} {
+	args := map[string]interface{}{}
+	if strings.TrimSpace(arguments) != "" {
+		json.Unmarshal([]byte(arguments), &args)
+	}
+	if args == nil {
+		args = map[string]interface{}{}
+	}
+	return args
+}
+
+// messageToolCalls 取出assistant消息中的工具调用（兼容保存后重新读取的类型）
+func messageToolCalls(msg map[string]interface{}) []map[string]interface{} {
+	var calls []map[string]interface{}
+	switch v := msg["tool_calls"].(type) {
+	case []map[string]interface{}:
+		calls = v
+	case []interface{}:
+		for _, tc := range v {
+			if tcMap, ok := tc.(map[string]interface{}); ok {
+				calls = append(calls, tcMap)
+			}
+		}
+	}
+	return calls
+}
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"all_project/storage"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// recordedRequest 本地服务收到的请求
+type recordedRequest struct {
+	Path   string // 含查询参数
+	Header http.Header
+	Body   map[string]interface{}
+}
+
+// runStream 启动返回固定SSE响应的本地服务，按providerType走完整的请求构建→发送→解析流程
+func runStream(t *testing.T, providerType, response string, req *providerChatRequest) (*streamSink, *recordedRequest, error) {
+	t.Helper()
+
+	recorded := &recordedRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorded.Path = r.URL.RequestURI()
+		recorded.Header = r.Header.Clone()
+		if err := json.NewDecoder(r.Body).Decode(&recorded.Body); err != nil {
+			t.Errorf("请求体不是JSON: %v", err)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, response)
+	}))
+	defer server.Close()
+
+	if req.Config == nil {
+		req.Config = &storage.AIConfig{}
+	}
+	provider := &storage.Provider{ID: "test", Type: providerType, BaseURL: server.URL + "/v1/", APIKey: "test-key"}
+	sink := &streamSink{}
+	err := streamProviderChat(context.Background(), provider, req, sink)
+	return sink, recorded, err
+}
+
+// sseEvents 把事件拼成SSE文本（每项为一条data，可带 "event名|" 前缀）
+func sseEvents(events ...string) string {
+	var b strings.Builder
+	for _, e := range events {
+		if name, data, ok := strings.Cut(e, "|"); ok {
+			b.WriteString("event: " + name + "\n")
+			e = data
+		}
+		b.WriteString("data: " + e + "\n\n")
+	}
+	return b.String()
+}
+
+// toolCall 取出第i个工具调用的id、name、arguments
+func toolCall(t *testing.T, calls []interface{}, i int) (string, string, string) {
+	t.Helper()
+	if i >= len(calls) {
+		t.Fatalf("工具调用数量 %d，期望至少 %d", len(calls), i+1)
+	}
+	tc := calls[i].(map[string]interface{})
+	function := getMap(tc, "function")
+	return getString(tc, "id"), getString(function, "name"), getString(function, "arguments")
+}
+
+// testTools OpenAI格式的工具定义（各适配器自行转换）
+var testTools = []map[string]interface{}{{
+	"type": "function",
+	"function": map[string]interface{}{
+		"name":        "read_file",
+		"description": "读取文件",
+		"parameters": map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{"path": map[string]interface{}{"type": "string"}},
+		},
+	},
+}}
+
+type sseEvent struct {
+	Event string
+	Data  string
+}
+
+func TestReadSSE(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []sseEvent
+	}{
+		{
+			name:  "单行data",
+			input: "data: {\"a\":1}\n\ndata: {\"b\":2}\n\n",
+			want:  []sseEvent{{"", `{"a":1}`}, {"", `{"b":2}`}},
+		},
+		{
+			name:  "事件名与多行data",
+			input: "event: message_start\ndata: line1\ndata: line2\n\n",
+			want:  []sseEvent{{"message_start", "line1\nline2"}},
+		},
+		{
+			name:  "注释与CRLF",
+			input: ": ping\r\n\r\nevent: delta\r\ndata:no-space\r\n\r\n",
+			want:  []sseEvent{{"delta", "no-space"}},
+		},
+		{
+			name:  "末尾缺少空行",
+			input: "data: first\n\ndata: last",
+			want:  []sseEvent{{"", "first"}, {"", "last"}},
+		},
+		{
+			name:  "事件名不延续到下一个事件",
+			input: "event: a\ndata: 1\n\ndata: 2\n\n",
+			want:  []sseEvent{{"a", "1"}, {"", "2"}},
+		},
+		{
+			name:  "只有空行和注释",
+			input: "\n\n: keepalive\n\n",
+			want:  nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 逐字节读取，模拟数据被拆分到多个网络包
+			var got []sseEvent
+			err := readSSE(iotest.OneByteReader(strings.NewReader(tt.input)), func(event, data string) error {
+				got = append(got, sseEvent{event, data})
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("readSSE返回错误: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("事件 = %#v，期望 %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadSSEStopsOnHandlerError(t *testing.T) {
+	stop := errors.New("stop")
+	calls := 0
+	err := readSSE(strings.NewReader("data: 1\n\ndata: 2\n\n"), func(event, data string) error {
+		calls++
+		return stop
+	})
+	if err != stop {
+		t.Errorf("错误 = %v，期望 %v", err, stop)
+	}
+	if calls != 1 {
+		t.Errorf("回调次数 = %d，期望 1", calls)
+	}
+}
+
+func TestStreamProviderChatHTTPError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"rate limited"}`, http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	provider := &storage.Provider{Type: storage.ProviderOpenAI, BaseURL: server.URL}
+	err := streamProviderChat(context.Background(), provider, &providerChatRequest{Model: "m", Config: &storage.AIConfig{}}, &streamSink{})
+	if err == nil || !strings.Contains(err.Error(), "429") || !strings.Contains(err.Error(), "rate limited") {
+		t.Errorf("错误 = %v，期望包含状态码和响应体", err)
+	}
+}
+
+func TestStreamSinkResultFillsDefaults(t *testing.T) {
+	sink := &streamSink{}
+	sink.ToolCallDelta(0, "", "list_files", "")
+	calls, _, _ := sink.result()
+
+	id, name, args := toolCall(t, calls, 0)
+	if !strings.HasPrefix(id, "call_") {
+		t.Errorf("缺少ID时应补生成，得到 %q", id)
+	}
+	if name != "list_files" || args != "{}" {
+		t.Errorf("name=%q arguments=%q，期望 list_files {}", name, args)
+	}
+}
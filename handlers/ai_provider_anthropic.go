@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"all_project/storage"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Anthropic Messages API 版本与默认输出上限（该接口要求必须指定max_tokens）
+const (
+	anthropicVersion          = "2023-06-01"
+	anthropicDefaultMaxTokens = 4096
+)
+
+// anthropicAdapter Anthropic Messages（/messages，x-api-key认证）
+type anthropicAdapter struct {
+	toolBlocks map[int]int // 内容块index → 工具调用序号
+}
+
+func (a *anthropicAdapter) newRequest(ctx context.Context, provider *storage.Provider, req *providerChatRequest) (*http.Request, error) {
+	config := req.Config
+	system, messages := anthropicMessages(req.Messages)
+
+	maxTokens := config.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = anthropicDefaultMaxTokens
+	}
+
+	// 不支持frequency/presence penalty；temperature和top_p不能同时调整，只发送temperature
+	requestBody := map[string]interface{}{
+		"model":      req.Model,
+		"messages":   messages,
+		"max_tokens": maxTokens,
+		"stream":     true,
+	}
+	if req.Capabilities.SupportsTemperature() {
+		requestBody["temperature"] = config.Temperature
+	}
+	if system != "" {
+		requestBody["system"] = system
+	}
+
+	var tools []map[string]interface{}
+	for _, function := range toolFunctions(req.Tools) {
+		tools = append(tools, map[string]interface{}{
+			"name":         function["name"],
+			"description":  function["description"],
+			"input_schema": function["parameters"],
+		})
+	}
+	if len(tools) > 0 {
+		requestBody["tools"] = tools
+	}
+
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	url := strings.TrimSuffix(provider.BaseURL, "/") + "/messages"
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-api-key", provider.APIKey)
+	httpReq.Header.Set("anthropic-version", anthropicVersion)
+	return httpReq, nil
+}
+
+func (a *anthropicAdapter) handleEvent(event, data string, sink *streamSink) error {
+	var payload map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &payload); err != nil {
+		return nil
+	}
+	if event == "" {
+		event = getString(payload, "type")
+	}
+
+	index := -1
+	if v, ok := payload["index"].(float64); ok {
+		index = int(v)
+	}
+
+	switch event {
+	case "message_start":
+		// 输入用量：input_tokens不含缓存读写部分，合计为总输入；缓存写入单独记录，按CacheWrite价格计费
+		usage := getMap(getMap(payload, "message"), "usage")
+		u := sink.Usage()
+		u.CachedTokens = getInt(usage, "cache_read_input_tokens")
+		u.CacheWriteTokens = getInt(usage, "cache_creation_input_tokens")
+		u.PromptTokens = getInt(usage, "input_tokens") + u.CacheWriteTokens + u.CachedTokens
+		u.CompletionTokens = getInt(usage, "output_tokens")
+
+	case "message_delta":
+		// 输出用量为累计值（含thinking）
+		if usage := getMap(payload, "usage"); len(usage) > 0 {
+			sink.Usage().CompletionTokens = getInt(usage, "output_tokens")
+		}
+
+	case "content_block_start":
+		block := getMap(payload, "content_block")
+		switch getString(block, "type") {
+		case "text":
+			sink.Content(getString(block, "text"))
+		case "thinking":
+			sink.Reasoning(getString(block, "thinking"))
+		case "tool_use":
+			// 参数通过 input_json_delta 流式返回
+			a.toolBlocks[index] = len(sink.toolCalls)
+			sink.ToolCallDelta(len(sink.toolCalls), getString(block, "id"), getString(block, "name"), "")
+		}
+
+	case "content_block_delta":
+		delta := getMap(payload, "delta")
+		switch getString(delta, "type") {
+		case "text_delta":
+			sink.Content(getString(delta, "text"))
+		case "thinking_delta":
+			sink.Reasoning(getString(delta, "thinking"))
+		case "input_json_delta":
+			if toolIndex, ok := a.toolBlocks[index]; ok {
+				sink.ToolCallDelta(toolIndex, "", "", getString(delta, "partial_json"))
+			}
+		}
+
+	case "error":
+		apiErr := getMap(payload, "error")
+		return fmt.Errorf("API错误 %s: %s", getString(apiErr, "type"), getString(apiErr, "message"))
+	}
+	return nil
+}
+
+// anthropicMessages 转换消息历史：system单独返回，tool结果转为user消息中的tool_result块，
+// 相邻的同角色消息合并（Anthropic要求tool_result紧跟在对应tool_use之后的user消息中）
+func anthropicMessages(messages []map[string]interface{}) (string, []map[string]interface{}) {
+	var system []string
+	var result []map[string]interface{}
+
+	appendBlocks := func(role string, blocks []map[string]interface{}) {
+		if len(blocks) == 0 {
+			return
+		}
+		if n := len(result); n > 0 && result[n-1]["role"] == role {
+			result[n-1]["content"] = append(result[n-1]["content"].([]map[string]interface{}), blocks...)
+			return
+		}
+		result = append(result, map[string]interface{}{"role": role, "content": blocks})
+	}
+
+	for _, msg := range messages {
+		role := getString(msg, "role")
+		content := getString(msg, "content")
+
+		switch role {
+		case "system":
+			if content != "" {
+				system = append(system, content)
+			}
+
+		case "assistant":
+			var blocks []map[string]interface{}
+			if content != "" {
+				blocks = append(blocks, map[string]interface{}{"type": "text", "text": content})
+			}
+			for _, tc := range messageToolCalls(msg) {
+				function := getMap(tc, "function")
+				blocks = append(blocks, map[string]interface{}{
+					"type":  "tool_use",
+					"id":    getString(tc, "id"),
+					"name":  getString(function, "name"),
+					"input": parseToolArguments(getString(function, "arguments")),
+				})
+			}
+			appendBlocks("assistant", blocks)
+
+		case "tool":
+			appendBlocks("user", []map[string]interface{}{{
+				"type":        "tool_result",
+				"tool_use_id": getString(msg, "tool_call_id"),
+				"content":     content,
+			}})
+
+		default:
+			// 空文本块会被拒绝
+			if content != "" {
+				appendBlocks("user", []map[string]interface{}{{"type": "text", "text": content}})
+			}
+		}
+	}
+
+	return strings.Join(system, "\n\n"), result
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"all_project/storage"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAnthropicStream(t *testing.T) {
+	response := sseEvents(
+		`message_start|{"type":"message_start","message":{"usage":{"input_tokens":20,"cache_creation_input_tokens":30,"cache_read_input_tokens":50,"output_tokens":1}}}`,
+		`content_block_start|{"type":"content_block_start","index":0,"content_block":{"type":"thinking","thinking":""}}`,
+		`content_block_delta|{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"考虑"}}`,
+		`content_block_stop|{"type":"content_block_stop","index":0}`,
+		`content_block_start|{"type":"content_block_start","index":1,"content_block":{"type":"text","text":""}}`,
+		`content_block_delta|{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"读取"}}`,
+		`content_block_start|{"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"toolu_1","name":"read_file","input":{}}}`,
+		`content_block_delta|{"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"{\"path\":"}}`,
+		`ping|{"type":"ping"}`,
+		`content_block_delta|{"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"\"/tmp/a\"}"}}`,
+		`content_block_start|{"type":"content_block_start","index":3,"content_block":{"type":"tool_use","id":"toolu_2","name":"list_files","input":{}}}`,
+		`message_delta|{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":42}}`,
+		`message_stop|{"type":"message_stop"}`,
+	)
+	sink, req, err := runStream(t, storage.ProviderAnthropic, response, &providerChatRequest{
+		Model: "claude-test",
+		Messages: []map[string]interface{}{
+			{"role": "system", "content": "你是助手"},
+			{"role": "user", "content": "hi"},
+		},
+		Tools:  testTools,
+		Config: &storage.AIConfig{Temperature: 0.2, TopP: 0.9},
+	})
+	if err != nil {
+		t.Fatalf("流式请求失败: %v", err)
+	}
+
+	calls, content, reasoning := sink.result()
+	if content != "读取" || reasoning != "考虑" {
+		t.Errorf("content=%q reasoning=%q", content, reasoning)
+	}
+	if len(calls) != 2 {
+		t.Fatalf("工具调用数量 = %d，期望 2", len(calls))
+	}
+	if id, name, args := toolCall(t, calls, 0); id != "toolu_1" || name != "read_file" || args != `{"path":"/tmp/a"}` {
+		t.Errorf("第1个工具调用 = %s %s %s", id, name, args)
+	}
+	if id, name, args := toolCall(t, calls, 1); id != "toolu_2" || name != "list_files" || args != "{}" {
+		t.Errorf("第2个工具调用 = %s %s %s", id, name, args)
+	}
+	want := storage.TokenUsage{PromptTokens: 100, CompletionTokens: 42, CachedTokens: 50, CacheWriteTokens: 30}
+	if sink.usage == nil || *sink.usage != want {
+		t.Errorf("用量 = %+v，期望 %+v", sink.usage, want)
+	}
+
+	if req.Path != "/v1/messages" {
+		t.Errorf("请求路径 = %s", req.Path)
+	}
+	if req.Header.Get("x-api-key") != "test-key" || req.Header.Get("anthropic-version") != anthropicVersion {
+		t.Errorf("请求头 = %v", req.Header)
+	}
+	if req.Body["system"] != "你是助手" || req.Body["max_tokens"] != float64(anthropicDefaultMaxTokens) {
+		t.Errorf("请求体 = %v", req.Body)
+	}
+	if _, ok := req.Body["top_p"]; ok {
+		t.Errorf("不应同时发送top_p: %v", req.Body)
+	}
+	tools, _ := req.Body["tools"].([]interface{})
+	if len(tools) != 1 {
+		t.Fatalf("工具定义 = %v", req.Body["tools"])
+	}
+	tool := tools[0].(map[string]interface{})
+	if tool["name"] != "read_file" || getMap(tool, "input_schema")["type"] != "object" {
+		t.Errorf("工具定义未转换为input_schema: %v", tool)
+	}
+}
+
+func TestAnthropicStreamError(t *testing.T) {
+	response := sseEvents(
+		`message_start|{"type":"message_start","message":{"usage":{"input_tokens":10}}}`,
+		`error|{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+	)
+	sink, _, err := runStream(t, storage.ProviderAnthropic, response, &providerChatRequest{Model: "m"})
+	if err == nil || !strings.Contains(err.Error(), "overloaded_error") || !strings.Contains(err.Error(), "Overloaded") {
+		t.Fatalf("错误 = %v，期望包含错误类型和信息", err)
+	}
+	if sink.usage == nil || sink.usage.PromptTokens != 10 {
+		t.Errorf("出错前的用量应保留，得到 %+v", sink.usage)
+	}
+}
+
+func TestAnthropicMessages(t *testing.T) {
+	messages := []map[string]interface{}{
+		{"role": "system", "content": "规则一"},
+		{"role": "user", "content": "查看两个文件"},
+		{"role": "assistant", "content": "好的", "tool_calls": []interface{}{
+			map[string]interface{}{"id": "t1", "type": "function", "function": map[string]interface{}{"name": "read_file", "arguments": `{"path":"a"}`}},
+			map[string]interface{}{"id": "t2", "type": "function", "function": map[string]interface{}{"name": "read_file", "arguments": "not json"}},
+		}},
+		{"role": "tool", "tool_call_id": "t1", "content": "A"},
+		{"role": "tool", "tool_call_id": "t2", "content": "B"},
+		{"role": "user", "content": "继续"},
+		{"role": "user", "content": ""},
+		{"role": "system", "content": "规则二"},
+	}
+	system, result := anthropicMessages(messages)
+
+	if system != "规则一\n\n规则二" {
+		t.Errorf("system = %q", system)
+	}
+	want := []map[string]interface{}{
+		{"role": "user", "content": []map[string]interface{}{{"type": "text", "text": "查看两个文件"}}},
+		{"role": "assistant", "content": []map[string]interface{}{
+			{"type": "text", "text": "好的"},
+			{"type": "tool_use", "id": "t1", "name": "read_file", "input": map[string]interface{}{"path": "a"}},
+			{"type": "tool_use", "id": "t2", "name": "read_file", "input": map[string]interface{}{}},
+		}},
+		// 工具结果与随后的用户文本合并到同一个user消息，空文本被跳过
+		{"role": "user", "content": []map[string]interface{}{
+			{"type": "tool_result", "tool_use_id": "t1", "content": "A"},
+			{"type": "tool_result", "tool_use_id": "t2", "content": "B"},
+			{"type": "text", "text": "继续"},
+		}},
+	}
+	if !reflect.DeepEqual(result, want) {
+		got, _ := json.MarshalIndent(result, "", "  ")
+		t.Errorf("转换结果:\n%s", got)
+	}
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"all_project/storage"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// geminiAdapter Google Gemini（models/{model}:streamGenerateContent?alt=sse，x-goog-api-key认证）
+type geminiAdapter struct{}
+
+func (a *geminiAdapter) newRequest(ctx context.Context, provider *storage.Provider, req *providerChatRequest) (*http.Request, error) {
+	config := req.Config
+	system, contents := geminiContents(req.Messages)
+
+	generationConfig := map[string]interface{}{}
+	if config.MaxTokens > 0 {
+		generationConfig["maxOutputTokens"] = config.MaxTokens
+	}
+	if req.Capabilities.SupportsTemperature() {
+		generationConfig["temperature"] = config.Temperature
+		generationConfig["topP"] = config.TopP
+		if config.FrequencyPenalty != 0 {
+			generationConfig["frequencyPenalty"] = config.FrequencyPenalty
+		}
+		if config.PresencePenalty != 0 {
+			generationConfig["presencePenalty"] = config.PresencePenalty
+		}
+	}
+
+	requestBody := map[string]interface{}{
+		"contents":         contents,
+		"generationConfig": generationConfig,
+	}
+	if system != "" {
+		requestBody["systemInstruction"] = map[string]interface{}{
+			"parts": []map[string]interface{}{{"text": system}},
+		}
+	}
+
+	var declarations []map[string]interface{}
+	for _, function := range toolFunctions(req.Tools) {
+		declarations = append(declarations, map[string]interface{}{
+			"name":        function["name"],
+			"description": function["description"],
+			"parameters":  function["parameters"],
+		})
+	}
+	if len(declarations) > 0 {
+		requestBody["tools"] = []map[string]interface{}{{"functionDeclarations": declarations}}
+	}
+
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	model := strings.TrimPrefix(req.Model, "models/")
+	endpoint := fmt.Sprintf("%s/models/%s:streamGenerateContent?alt=sse",
+		strings.TrimSuffix(provider.BaseURL, "/"), url.PathEscape(model))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-goog-api-key", provider.APIKey)
+	return httpReq, nil
+}
+
+func (a *geminiAdapter) handleEvent(event, data string, sink *streamSink) error {
+	var chunk struct {
+		Candidates []struct {
+			Content struct {
+				Parts []struct {
+					Text             string `json:"text"`
+					Thought          bool   `json:"thought"`
+					ThoughtSignature string `json:"thoughtSignature"`
+					FunctionCall     *struct {
+						ID   string          `json:"id"`
+						Name string          `json:"name"`
+						Args json.RawMessage `json:"args"`
+					} `json:"functionCall"`
+				} `json:"parts"`
+			} `json:"content"`
+		} `json:"candidates"`
+		UsageMetadata *struct {
+			PromptTokenCount        int `json:"promptTokenCount"`
+			CandidatesTokenCount    int `json:"candidatesTokenCount"`
+			ThoughtsTokenCount      int `json:"thoughtsTokenCount"`
+			CachedContentTokenCount int `json:"cachedContentTokenCount"`
+		} `json:"usageMetadata"`
+		Error *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		return nil
+	}
+	if chunk.Error != nil {
+		return fmt.Errorf("API错误 %d: %s", chunk.Error.Code, chunk.Error.Message)
+	}
+
+	// 每个数据块都带有截至当前的用量，以最后一个为准；输出不含思考部分，合计为总输出
+	if meta := chunk.UsageMetadata; meta != nil {
+		u := sink.Usage()
+		u.PromptTokens = meta.PromptTokenCount
+		u.CompletionTokens = meta.CandidatesTokenCount + meta.ThoughtsTokenCount
+		u.ReasoningTokens = meta.ThoughtsTokenCount
+		u.CachedTokens = meta.CachedContentTokenCount
+	}
+	if len(chunk.Candidates) == 0 {
+		return nil
+	}
+
+	for _, part := range chunk.Candidates[0].Content.Parts {
+		switch {
+		case part.FunctionCall != nil:
+			// 函数调用一次性完整返回
+			args := string(part.FunctionCall.Args)
+			if args == "null" {
+				args = ""
+			}
+			index := len(sink.toolCalls)
+			sink.ToolCallDelta(index, part.FunctionCall.ID, part.FunctionCall.Name, args)
+			if part.ThoughtSignature != "" {
+				// 思考模型要求在后续请求中原样带回
+				sink.ToolCall(index)["thought_signature"] = part.ThoughtSignature
+			}
+		case part.Thought:
+			sink.Reasoning(part.Text)
+		default:
+			sink.Content(part.Text)
+		}
+	}
+	return nil
+}
+
+// geminiContents 转换消息历史：assistant → model，tool结果 → user消息中的functionResponse，
+// 相邻的同角色消息合并
+func geminiContents(messages []map[string]interface{}) (string, []map[string]interface{}) {
+	var system []string
+	var contents []map[string]interface{}
+	toolNames := make(map[string]string) // tool_call_id → 函数名（functionResponse需要函数名）
+
+	appendParts := func(role string, parts []map[string]interface{}) {
+		if len(parts) == 0 {
+			return
+		}
+		if n := len(contents); n > 0 && contents[n-1]["role"] == role {
+			contents[n-1]["parts"] = append(contents[n-1]["parts"].([]map[string]interface{}), parts...)
+			return
+		}
+		contents = append(contents, map[string]interface{}{"role": role, "parts": parts})
+	}
+
+	for _, msg := range messages {
+		role := getString(msg, "role")
+		content := getString(msg, "content")
+
+		switch role {
+		case "system":
+			if content != "" {
+				system = append(system, content)
+			}
+
+		case "assistant":
+			var parts []map[string]interface{}
+			if content != "" {
+				parts = append(parts, map[string]interface{}{"text": content})
+			}
+			for _, tc := range messageToolCalls(msg) {
+				function := getMap(tc, "function")
+				name := getString(function, "name")
+				toolNames[getString(tc, "id")] = name
+				part := map[string]interface{}{
+					"functionCall": map[string]interface{}{
+						"name": name,
+						"args": parseToolArguments(getString(function, "arguments")),
+					},
+				}
+				if signature := getString(tc, "thought_signature"); signature != "" {
+					part["thoughtSignature"] = signature
+				}
+				parts = append(parts, part)
+			}
+			appendParts("model", parts)
+
+		case "tool":
+			// 工具结果需要是对象，非对象JSON包装为 {"result": ...}
+			var response map[string]interface{}
+			if err := json.Unmarshal([]byte(content), &response); err != nil || response == nil {
+				response = map[string]interface{}{"result": content}
+			}
+			name := toolNames[getString(msg, "tool_call_id")]
+			if name == "" {
+				name = getString(msg, "name")
+			}
+			appendParts("user", []map[string]interface{}{{
+				"functionResponse": map[string]interface{}{
+					"name":     name,
+					"response": response,
+				},
+			}})
+
+		default:
+			if content != "" {
+				appendParts("user", []map[string]interface{}{{"text": content}})
+			}
+		}
+	}
+
+	return strings.Join(system, "\n\n"), contents
+}
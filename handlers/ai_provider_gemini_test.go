@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"all_project/storage"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGeminiStream(t *testing.T) {
+	response := sseEvents(
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":"先分析","thought":true}]}}],"usageMetadata":{"promptTokenCount":80}}`,
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":"我来读取"}]}}]}`,
+		`{"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"read_file","args":{"path":"/tmp/a"}},"thoughtSignature":"c2ln"},{"functionCall":{"name":"list_files"}}]}}]}`,
+		`{"candidates":[{"content":{"role":"model","parts":[]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":100,"candidatesTokenCount":20,"thoughtsTokenCount":15,"cachedContentTokenCount":60}}`,
+	)
+	sink, req, err := runStream(t, storage.ProviderGemini, response, &providerChatRequest{
+		Model: "models/gemini-test",
+		Messages: []map[string]interface{}{
+			{"role": "system", "content": "你是助手"},
+			{"role": "user", "content": "hi"},
+		},
+		Tools:  testTools,
+		Config: &storage.AIConfig{MaxTokens: 512, Temperature: 0.7, TopP: 0.95},
+	})
+	if err != nil {
+		t.Fatalf("流式请求失败: %v", err)
+	}
+
+	calls, content, reasoning := sink.result()
+	if content != "我来读取" || reasoning != "先分析" {
+		t.Errorf("content=%q reasoning=%q", content, reasoning)
+	}
+	if len(calls) != 2 {
+		t.Fatalf("工具调用数量 = %d，期望 2", len(calls))
+	}
+	id, name, args := toolCall(t, calls, 0)
+	if !strings.HasPrefix(id, "call_") || name != "read_file" || args != `{"path":"/tmp/a"}` {
+		t.Errorf("第1个工具调用 = %s %s %s", id, name, args)
+	}
+	if sig := calls[0].(map[string]interface{})["thought_signature"]; sig != "c2ln" {
+		t.Errorf("thought_signature = %v", sig)
+	}
+	if _, name, args := toolCall(t, calls, 1); name != "list_files" || args != "{}" {
+		t.Errorf("第2个工具调用 = %s %s", name, args)
+	}
+	want := storage.TokenUsage{PromptTokens: 100, CompletionTokens: 35, ReasoningTokens: 15, CachedTokens: 60}
+	if sink.usage == nil || *sink.usage != want {
+		t.Errorf("用量 = %+v，期望 %+v", sink.usage, want)
+	}
+
+	if req.Path != "/v1/models/gemini-test:streamGenerateContent?alt=sse" {
+		t.Errorf("请求路径 = %s", req.Path)
+	}
+	if req.Header.Get("x-goog-api-key") != "test-key" {
+		t.Errorf("请求头 = %v", req.Header)
+	}
+	instruction, _ := getMap(req.Body, "systemInstruction")["parts"].([]interface{})
+	if len(instruction) != 1 || instruction[0].(map[string]interface{})["text"] != "你是助手" {
+		t.Errorf("systemInstruction = %v", req.Body["systemInstruction"])
+	}
+	generation := getMap(req.Body, "generationConfig")
+	if generation["maxOutputTokens"] != float64(512) || generation["topP"] != 0.95 {
+		t.Errorf("generationConfig = %v", generation)
+	}
+	tools, _ := req.Body["tools"].([]interface{})
+	if len(tools) != 1 {
+		t.Fatalf("工具定义 = %v", req.Body["tools"])
+	}
+	declarations, _ := tools[0].(map[string]interface{})["functionDeclarations"].([]interface{})
+	if len(declarations) != 1 || declarations[0].(map[string]interface{})["name"] != "read_file" {
+		t.Errorf("functionDeclarations = %v", tools[0])
+	}
+}
+
+func TestGeminiStreamError(t *testing.T) {
+	response := sseEvents(
+		`{"candidates":[{"content":{"parts":[{"text":"部分"}]}}]}`,
+		`{"error":{"code":503,"message":"The model is overloaded","status":"UNAVAILABLE"}}`,
+	)
+	sink, _, err := runStream(t, storage.ProviderGemini, response, &providerChatRequest{Model: "m"})
+	if err == nil || !strings.Contains(err.Error(), "503") || !strings.Contains(err.Error(), "overloaded") {
+		t.Fatalf("错误 = %v，期望包含错误码和信息", err)
+	}
+	if _, content, _ := sink.result(); content != "部分" {
+		t.Errorf("出错前的内容 = %q", content)
+	}
+}
+
+func TestGeminiContents(t *testing.T) {
+	messages := []map[string]interface{}{
+		{"role": "system", "content": "规则"},
+		{"role": "user", "content": "查看状态"},
+		{"role": "assistant", "content": "", "tool_calls": []interface{}{
+			map[string]interface{}{"id": "c1", "type": "function", "thought_signature": "sig",
+				"function": map[string]interface{}{"name": "read_file", "arguments": `{"path":"a"}`}},
+			map[string]interface{}{"id": "c2", "type": "function",
+				"function": map[string]interface{}{"name": "run_command", "arguments": ""}},
+		}},
+		{"role": "tool", "tool_call_id": "c1", "content": `{"size":3}`},
+		{"role": "tool", "tool_call_id": "c2", "content": "exit 0"},
+		{"role": "tool", "tool_call_id": "unknown", "name": "fallback", "content": "[1,2]"},
+		{"role": "assistant", "content": "完成"},
+	}
+	system, contents := geminiContents(messages)
+
+	if system != "规则" {
+		t.Errorf("system = %q", system)
+	}
+	want := []map[string]interface{}{
+		{"role": "user", "parts": []map[string]interface{}{{"text": "查看状态"}}},
+		{"role": "model", "parts": []map[string]interface{}{
+			{"functionCall": map[string]interface{}{"name": "read_file", "args": map[string]interface{}{"path": "a"}}, "thoughtSignature": "sig"},
+			{"functionCall": map[string]interface{}{"name": "run_command", "args": map[string]interface{}{}}},
+		}},
+		// 多个工具结果合并到同一个user消息，函数名按tool_call_id找回，非对象结果包装为result
+		{"role": "user", "parts": []map[string]interface{}{
+			{"functionResponse": map[string]interface{}{"name": "read_file", "response": map[string]interface{}{"size": float64(3)}}},
+			{"functionResponse": map[string]interface{}{"name": "run_command", "response": map[string]interface{}{"result": "exit 0"}}},
+			{"functionResponse": map[string]interface{}{"name": "fallback", "response": map[string]interface{}{"result": "[1,2]"}}},
+		}},
+		{"role": "model", "parts": []map[string]interface{}{{"text": "完成"}}},
+	}
+	if !reflect.DeepEqual(contents, want) {
+		got, _ := json.MarshalIndent(contents, "", "  ")
+		t.Errorf("转换结果:\n%s", got)
+	}
+}
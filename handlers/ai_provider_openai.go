@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"all_project/storage"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// openAIAdapter OpenAI Chat Completions（/chat/completions，Bearer认证）
+type openAIAdapter struct{}
+
+func (a *openAIAdapter) newRequest(ctx context.Context, provider *storage.Provider, req *providerChatRequest) (*http.Request, error) {
+	config := req.Config
+	requestBody := map[string]interface{}{
+		"model":    req.Model,
+		"messages": openAIMessages(req.Messages),
+		"stream":   true,
+	}
+	// 未设置输出上限时不发送（由接口决定）；推理模型不接受max_tokens，改用max_completion_tokens
+	if config.MaxTokens > 0 {
+		if req.Capabilities.MaxCompletionTokens {
+			requestBody["max_completion_tokens"] = config.MaxTokens
+		} else {
+			requestBody["max_tokens"] = config.MaxTokens
+		}
+	}
+	if len(req.Tools) > 0 {
+		requestBody["tools"] = req.Tools
+	}
+	if !req.Capabilities.NoStreamUsage {
+		// 最后一个数据块返回本次请求的用量（choices为空）
+		requestBody["stream_options"] = map[string]interface{}{"include_usage": true}
+	}
+
+	if req.Capabilities.SupportsTemperature() {
+		requestBody["temperature"] = config.Temperature
+		requestBody["top_p"] = config.TopP
+		if config.FrequencyPenalty != 0 {
+			requestBody["frequency_penalty"] = config.FrequencyPenalty
+		}
+		if config.PresencePenalty != 0 {
+			requestBody["presence_penalty"] = config.PresencePenalty
+		}
+	}
+
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	url := strings.TrimSuffix(provider.BaseURL, "/") + "/chat/completions"
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+provider.APIKey)
+	return httpReq, nil
+}
+
+func (a *openAIAdapter) handleEvent(event, data string, sink *streamSink) error {
+	// 兼容不以空行分隔事件的实现：每行data单独解析
+	for _, chunk := range strings.Split(data, "\n") {
+		chunk = strings.TrimSpace(chunk)
+		if chunk == "" || chunk == "[DONE]" {
+			continue
+		}
+
+		var streamResp map[string]interface{}
+		if err := json.Unmarshal([]byte(chunk), &streamResp); err != nil {
+			continue
+		}
+
+		if apiErr, ok := streamResp["error"].(map[string]interface{}); ok {
+			return fmt.Errorf("API错误: %s", getString(apiErr, "message"))
+		}
+
+		// 用量（prompt_tokens含缓存命中，completion_tokens含推理）
+		if usage, ok := streamResp["usage"].(map[string]interface{}); ok {
+			u := sink.Usage()
+			u.PromptTokens = getInt(usage, "prompt_tokens")
+			u.CompletionTokens = getInt(usage, "completion_tokens")
+			u.ReasoningTokens = getInt(getMap(usage, "completion_tokens_details"), "reasoning_tokens")
+			u.CachedTokens = getInt(getMap(usage, "prompt_tokens_details"), "cached_tokens")
+		}
+
+		choices, ok := streamResp["choices"].([]interface{})
+		if !ok || len(choices) == 0 {
+			continue
+		}
+		choice, _ := choices[0].(map[string]interface{})
+		delta, ok := choice["delta"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		// 普通内容
+		if content, ok := delta["content"].(string); ok {
+			sink.Content(content)
+		}
+
+		// reasoning内容（o1等推理模型）
+		if reasoning, ok := delta["reasoning_content"].(string); ok {
+			sink.Reasoning(reasoning)
+		}
+
+		// tool_calls按index流式累积
+		if deltaToolCalls, ok := delta["tool_calls"].([]interface{}); ok {
+			for _, tc := range deltaToolCalls {
+				tcMap, ok := tc.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				index, hasIndex := tcMap["index"].(float64)
+				if !hasIndex {
+					continue
+				}
+
+				function := getMap(tcMap, "function")
+				sink.ToolCallDelta(int(index), getString(tcMap, "id"), getString(function, "name"), getString(function, "arguments"))
+				if tcType := getString(tcMap, "type"); tcType != "" {
+					sink.ToolCall(int(index))["type"] = tcType
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// openAIMessages 去掉其他协议附加在工具调用上的字段（如Gemini的thought_signature）
+func openAIMessages(messages []map[string]interface{}) []map[string]interface{} {
+	result := make([]map[string]interface{}, len(messages))
+	for i, msg := range messages {
+		calls := messageToolCalls(msg)
+		if len(calls) == 0 {
+			result[i] = msg
+			continue
+		}
+
+		cleaned := make([]map[string]interface{}, len(calls))
+		for j, tc := range calls {
+			cleaned[j] = map[string]interface{}{
+				"id":       tc["id"],
+				"type":     "function",
+				"function": tc["function"],
+			}
+		}
+		copied := make(map[string]interface{}, len(msg))
+		for k, v := range msg {
+			copied[k] = v
+		}
+		copied["tool_calls"] = cleaned
+		result[i] = copied
+	}
+	return result
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"all_project/storage"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestOpenAIStreamTextReasoningUsage(t *testing.T) {
+	response := sseEvents(
+		`{"choices":[{"delta":{"role":"assistant","reasoning_content":"先想"}}]}`,
+		`{"choices":[{"delta":{"reasoning_content":"一下"}}]}`,
+		`{"choices":[{"delta":{"content":"Hel"}}]}`,
+		`{"choices":[{"delta":{"content":"lo"}}]}`,
+		`{"choices":[],"usage":{"prompt_tokens":120,"completion_tokens":30,"prompt_tokens_details":{"cached_tokens":100},"completion_tokens_details":{"reasoning_tokens":12}}}`,
+		`[DONE]`,
+	)
+	sink, req, err := runStream(t, storage.ProviderOpenAI, response, &providerChatRequest{
+		Model:    "gpt-test",
+		Messages: []map[string]interface{}{{"role": "user", "content": "hi"}},
+		Config:   &storage.AIConfig{MaxTokens: 256, Temperature: 0.3, TopP: 1},
+	})
+	if err != nil {
+		t.Fatalf("流式请求失败: %v", err)
+	}
+
+	calls, content, reasoning := sink.result()
+	if content != "Hello" || reasoning != "先想一下" || len(calls) != 0 {
+		t.Errorf("content=%q reasoning=%q 工具调用=%d", content, reasoning, len(calls))
+	}
+	want := storage.TokenUsage{PromptTokens: 120, CompletionTokens: 30, ReasoningTokens: 12, CachedTokens: 100}
+	if sink.usage == nil || *sink.usage != want {
+		t.Errorf("用量 = %+v，期望 %+v", sink.usage, want)
+	}
+
+	if req.Path != "/v1/chat/completions" {
+		t.Errorf("请求路径 = %s", req.Path)
+	}
+	if got := req.Header.Get("Authorization"); got != "Bearer test-key" {
+		t.Errorf("Authorization = %q", got)
+	}
+	if req.Body["model"] != "gpt-test" || req.Body["stream"] != true || req.Body["max_tokens"] != float64(256) {
+		t.Errorf("请求体 = %v", req.Body)
+	}
+	if getMap(req.Body, "stream_options")["include_usage"] != true {
+		t.Errorf("未请求流式用量: %v", req.Body["stream_options"])
+	}
+}
+
+func TestOpenAIStreamToolCallDeltas(t *testing.T) {
+	response := sseEvents(
+		`{"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_a","type":"function","function":{"name":"read_file","arguments":""}}]}}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"path\":"}}]}}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"index":1,"id":"call_b","function":{"name":"list_files","arguments":"{}"}}]}}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"/etc/hosts\"}"}}]}}]}`,
+		`{"choices":[{"delta":{},"finish_reason":"tool_calls"}]}`,
+	)
+	sink, _, err := runStream(t, storage.ProviderOpenAI, response, &providerChatRequest{Model: "m"})
+	if err != nil {
+		t.Fatalf("流式请求失败: %v", err)
+	}
+
+	calls, _, _ := sink.result()
+	if len(calls) != 2 {
+		t.Fatalf("工具调用数量 = %d，期望 2", len(calls))
+	}
+	if id, name, args := toolCall(t, calls, 0); id != "call_a" || name != "read_file" || args != `{"path":"/etc/hosts"}` {
+		t.Errorf("第1个工具调用 = %s %s %s", id, name, args)
+	}
+	if id, name, args := toolCall(t, calls, 1); id != "call_b" || name != "list_files" || args != "{}" {
+		t.Errorf("第2个工具调用 = %s %s %s", id, name, args)
+	}
+	if sink.usage != nil {
+		t.Errorf("未返回用量时应为nil，得到 %+v", sink.usage)
+	}
+}
+
+func TestOpenAIStreamError(t *testing.T) {
+	response := sseEvents(
+		`{"choices":[{"delta":{"content":"部分"}}]}`,
+		`{"error":{"message":"context length exceeded","type":"invalid_request_error"}}`,
+		`{"choices":[{"delta":{"content":"不应出现"}}]}`,
+	)
+	sink, _, err := runStream(t, storage.ProviderOpenAI, response, &providerChatRequest{Model: "m"})
+	if err == nil || !strings.Contains(err.Error(), "context length exceeded") {
+		t.Fatalf("错误 = %v，期望包含接口错误信息", err)
+	}
+	if _, content, _ := sink.result(); content != "部分" {
+		t.Errorf("出错前的内容 = %q", content)
+	}
+}
+
+func TestOpenAIRequestCapabilities(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  storage.AIConfig
+		caps    storage.ModelCapabilities
+		present []string
+		absent  []string
+	}{
+		{
+			name:    "默认",
+			config:  storage.AIConfig{MaxTokens: 100, Temperature: 0.5, FrequencyPenalty: 0.1},
+			present: []string{"max_tokens", "temperature", "top_p", "frequency_penalty", "stream_options"},
+			absent:  []string{"max_completion_tokens", "presence_penalty", "tools"},
+		},
+		{
+			name:    "未设置输出上限",
+			config:  storage.AIConfig{},
+			absent:  []string{"max_tokens", "max_completion_tokens"},
+			present: []string{"temperature"},
+		},
+		{
+			name:    "推理模型",
+			config:  storage.AIConfig{MaxTokens: 100, Temperature: 0.5},
+			caps:    storage.ModelCapabilities{NoTemperature: true, MaxCompletionTokens: true},
+			present: []string{"max_completion_tokens"},
+			absent:  []string{"max_tokens", "temperature", "top_p"},
+		},
+		{
+			name:   "不支持流式用量",
+			caps:   storage.ModelCapabilities{NoStreamUsage: true},
+			absent: []string{"stream_options"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			httpReq, err := (&openAIAdapter{}).newRequest(context.Background(),
+				&storage.Provider{BaseURL: "http://example.invalid/v1"},
+				&providerChatRequest{Model: "m", Config: &config, Capabilities: tt.caps})
+			if err != nil {
+				t.Fatalf("构建请求失败: %v", err)
+			}
+			data, _ := io.ReadAll(httpReq.Body)
+			var body map[string]interface{}
+			if err := json.Unmarshal(data, &body); err != nil {
+				t.Fatalf("请求体不是JSON: %v", err)
+			}
+			for _, key := range tt.present {
+				if _, ok := body[key]; !ok {
+					t.Errorf("缺少 %s: %s", key, data)
+				}
+			}
+			for _, key := range tt.absent {
+				if _, ok := body[key]; ok {
+					t.Errorf("不应发送 %s: %s", key, data)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenAIMessagesStripsForeignFields(t *testing.T) {
+	messages := []map[string]interface{}{
+		{"role": "user", "content": "hi"},
+		{"role": "assistant", "content": "", "tool_calls": []interface{}{
+			map[string]interface{}{
+				"id":                "call_1",
+				"type":              "function",
+				"function":          map[string]interface{}{"name": "f", "arguments": "{}"},
+				"thought_signature": "sig",
+			},
+		}},
+	}
+	result := openAIMessages(messages)
+
+	calls := messageToolCalls(result[1])
+	if len(calls) != 1 || calls[0]["id"] != "call_1" {
+		t.Fatalf("工具调用 = %v", calls)
+	}
+	if _, ok := calls[0]["thought_signature"]; ok {
+		t.Errorf("应去掉thought_signature: %v", calls[0])
+	}
+	if _, ok := messageToolCalls(messages[1])[0]["thought_signature"]; !ok {
+		t.Errorf("不应修改原消息")
+	}
+}
@@ -20,7 +20,13 @@ func (h *AIProvidersHandler) GetProviders(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"success": true, "data": providers})
+
+	// API Key仅返回脱敏值，编辑时留空表示不修改
+	masked := make([]storage.Provider, len(providers))
+	for i, p := range providers {
+		masked[i] = p.Masked()
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": masked})
 }
 
 // GetProvider 获取单个供应商
@@ -36,7 +42,7 @@ func (h *AIProvidersHandler) GetProvider(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"success": true, "data": provider})
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": provider.Masked()})
 }
 
 // CreateProvider 创建供应商
@@ -52,7 +58,7 @@ func (h *AIProvidersHandler) CreateProvider(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"success": true, "data": provider})
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": provider.Masked()})
 }
 
 // UpdateProvider 更新供应商
@@ -68,7 +74,7 @@ func (h *AIProvidersHandler) UpdateProvider(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"success": true, "data": provider})
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": provider.Masked()})
 }
 
 // DeleteProvider 删除供应商
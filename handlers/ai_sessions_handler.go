@@ -7,7 +7,6 @@ import (
 	"encoding/hex"
 	"log"
 	"net/http"
-	"os"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
@@ -43,14 +42,25 @@ func (h *AISessionsHandler) GetSession(c *gin.Context) {
 		return
 	}
 
+	// 累计用量来自账本（含总结请求，撤回消息不影响）
+	usage, err := storage.GetSessionUsage(session)
+	if err != nil {
+		log.Printf("⚠️ 读取会话用量失败: %v", err)
+	}
+
 	// 返回完整的会话配置（包括模型和模板信息）
 	c.JSON(http.StatusOK, gin.H{"success": true, "data": map[string]interface{}{
-		"id":         session.ID,
-		"title":      session.Title,
-		"model_id":   session.ModelID,
-		"created_at": session.CreatedAt,
-		"updated_at": session.UpdatedAt,
-		"messages":   session.Messages,
+		"id":            session.ID,
+		"title":         session.Title,
+		"model_id":      session.ModelID,
+		"preset_id":     session.PresetID,
+		"settings":      session.Settings,
+		"summary":       session.Summary,
+		"summary_up_to": session.SummaryUpTo,
+		"usage":         usage,
+		"created_at":    session.CreatedAt,
+		"updated_at":    session.UpdatedAt,
+		"messages":      session.Messages,
 	}})
 }
 
@@ -222,6 +232,77 @@ func (h *AISessionsHandler) UpdateSessionModel(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"success": true, "message": "更新成功"})
 }
 
+// UpdateSessionSettings 更新会话级生成参数（只需传入要覆盖的字段，settings为null表示清除）
+func (h *AISessionsHandler) UpdateSessionSettings(c *gin.Context) {
+	var req struct {
+		SessionID string                      `json:"session_id"`
+		Settings  *storage.GenerationSettings `json:"settings"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil || req.SessionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "参数错误"})
+		return
+	}
+
+	if err := storage.UpdateSessionSettings(req.SessionID, req.Settings); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "更新成功"})
+}
+
+// UpdateSessionPreset 更新会话选用的提示词预设（preset_id为空表示不使用预设）
+func (h *AISessionsHandler) UpdateSessionPreset(c *gin.Context) {
+	var req struct {
+		SessionID string `json:"session_id"`
+		PresetID  string `json:"preset_id"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil || req.SessionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "参数错误"})
+		return
+	}
+
+	if err := storage.UpdateSessionPreset(req.SessionID, req.PresetID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "更新成功"})
+}
+
+// GetEffectiveConfig 获取会话实际使用的生成参数（会话 > 模型 > 全局）
+func (h *AISessionsHandler) GetEffectiveConfig(c *gin.Context) {
+	id := c.Query("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "缺少id参数"})
+		return
+	}
+
+	session, err := storage.GetSession(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	global, err := storage.GetAIConfig()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	var model *storage.Model
+	if provider, err := storage.FindProviderByModel(session.ModelID); err == nil {
+		model = provider.FindModel(session.ModelID)
+	}
+
+	data := gin.H{"config": storage.ResolveAIConfig(global, model, session)}
+	if model != nil {
+		data["capabilities"] = model.Capabilities
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": data})
+}
+
 // UpdateMessage 更新会话中的消息
 func (h *AISessionsHandler) UpdateMessage(c *gin.Context) {
 	var req struct {
@@ -294,7 +375,7 @@ func (h *AISessionsHandler) RevokeMessage(c *gin.Context) {
 
 	// 3. 恢复文件到上一个快照状态
 	for filePath, content := range restoredFiles {
-		if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+		if err := writeFileByKey(filePath, []byte(content)); err != nil {
 			log.Printf("⚠️ 恢复文件失败 %s: %v", filePath, err)
 		} else {
 			log.Printf("✅ 恢复文件: %s (%d字节)", filePath, len(content))
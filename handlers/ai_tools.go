@@ -3,11 +3,12 @@ package handlers
 import (
 	"all_project/models"
 	"all_project/storage"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/fs"
 	"log"
-	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
@@ -61,17 +62,17 @@ type FileOperationArgs struct {
 	Limit  int `json:"limit,omitempty"`  // 读取行数
 }
 
-// Execute 执行工具调用
-func (te *ToolExecutor) Execute(toolName string, argsJSON string, conversationID string, messageID string) (string, error) {
+// Execute 执行工具调用（ctx取消时中断连接和目录遍历）
+func (te *ToolExecutor) Execute(ctx context.Context, toolName string, argsJSON string, conversationID string, messageID string) (string, error) {
 	if toolName != "file_operation" {
 		return "", fmt.Errorf("未知工具: %s", toolName)
 	}
 
-	return te.fileOperation(argsJSON, conversationID, messageID)
+	return te.fileOperation(ctx, argsJSON, conversationID, messageID)
 }
 
 // fileOperation 统一的文件操作入口
-func (te *ToolExecutor) fileOperation(argsJSON string, conversationID string, messageID string) (string, error) {
+func (te *ToolExecutor) fileOperation(ctx context.Context, argsJSON string, conversationID string, messageID string) (string, error) {
 	var args FileOperationArgs
 	if err := json.Unmarshal([]byte(argsJSON), &args); err != nil {
 		return "", fmt.Errorf("解析参数失败: %v", err)
@@ -80,38 +81,43 @@ func (te *ToolExecutor) fileOperation(argsJSON string, conversationID string, me
 	// 根据操作类型分发
 	switch args.Type {
 	case "read":
-		return te.readFile(args, conversationID)
+		return te.readFile(ctx, args, conversationID)
 	case "write":
-		return te.writeFile(args)
+		return te.writeFile(ctx, args, conversationID, messageID)
 	case "edit":
-		return te.editFile(args, conversationID, messageID)
+		return te.editFile(ctx, args, conversationID, messageID)
 	case "list":
-		return te.listDir(args)
+		return te.listDir(ctx, args)
 	case "grep":
-		return te.grepSearch(args)
+		return te.grepSearch(ctx, args)
 	case "find":
-		return te.findByName(args)
+		return te.findByName(ctx, args)
 	default:
 		return "", fmt.Errorf("未知操作类型: %s", args.Type)
 	}
 }
 
 // readFile 读取文件内容（支持行范围读取）
-func (te *ToolExecutor) readFile(args FileOperationArgs, conversationID string) (string, error) {
+func (te *ToolExecutor) readFile(ctx context.Context, args FileOperationArgs, conversationID string) (string, error) {
 	manager := models.GetPendingStateManager()
 
-	log.Printf("📖 readFile调用: conversationID=%s, filePath=%s, offset=%d, limit=%d",
-		conversationID, args.FilePath, args.Offset, args.Limit)
+	log.Printf("📖 readFile调用: conversationID=%s, serverID=%s, filePath=%s, offset=%d, limit=%d",
+		conversationID, args.ServerID, args.FilePath, args.Offset, args.Limit)
 
-	// 读取磁盘文件
-	fileContent, err := os.ReadFile(args.FilePath)
+	fsys, err := resolveFileSystemContext(ctx, args.ServerID)
 	if err != nil {
-		return "", fmt.Errorf("读取文件失败: %v", err)
+		return "", fmt.Errorf("连接服务器失败: %v", err)
+	}
+
+	// 读取磁盘文件（文件不存在但有pending写入时，以空内容为基础）
+	fileKey := models.FileKey(args.ServerID, args.FilePath)
+	diskContent, err := te.readDiskContent(fsys, args.FilePath, conversationID, fileKey)
+	if err != nil {
+		return "", err
 	}
-	diskContent := string(fileContent)
 
 	// 获取pending内容（应用所有edits）
-	fullContent := manager.GetCurrentContent(conversationID, args.FilePath, diskContent)
+	fullContent := manager.GetCurrentContent(conversationID, fileKey, diskContent)
 	isPending := (fullContent != diskContent)
 
 	// 按行分割
@@ -188,29 +194,57 @@ func (te *ToolExecutor) readFile(args FileOperationArgs, conversationID string)
 	return string(resultJSON), nil
 }
 
-// writeFile 写入文件（创建或覆盖） - 只返回pending状态，不执行写入
-func (te *ToolExecutor) writeFile(args FileOperationArgs) (string, error) {
+// writeFile 写入文件（创建或覆盖） - 记录到pending，用户确认后由后端写入
+func (te *ToolExecutor) writeFile(ctx context.Context, args FileOperationArgs, conversationID string, messageID string) (string, error) {
+	manager := models.GetPendingStateManager()
+	messageIndex := currentTurnIndex(conversationID)
+
+	fsys, err := resolveFileSystemContext(ctx, args.ServerID)
+	if err != nil {
+		return "", fmt.Errorf("连接服务器失败: %v", err)
+	}
+
 	// 检查文件是否已存在
 	fileExists := false
-	if _, err := os.Stat(args.FilePath); err == nil {
+	diskContent := ""
+	if content, err := fsys.ReadFile(args.FilePath); err == nil {
 		fileExists = true
+		diskContent = string(content)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("读取文件失败: %v", err)
 	}
 
 	// 计算写入的行数
 	lines := strings.Split(args.Content, "\n")
 	totalLines := len(lines)
 
-	// 只返回pending状态，不执行实际操作
+	// 添加write操作到pending（整文件覆盖）
+	edit := models.EditOperation{
+		Type:       "write",
+		ToolCallID: messageID,
+		MessageID:  messageID,
+		Content:    args.Content,
+	}
+	fileKey := models.FileKey(args.ServerID, args.FilePath)
+	if err := manager.AddEdit(conversationID, fileKey, messageIndex, edit); err != nil {
+		return "", fmt.Errorf("保存pending失败: %v", err)
+	}
+
+	log.Printf("📦 已添加write到Turn%d: %s (%d行)", messageIndex, args.FilePath, totalLines)
+
+	// 返回pending状态（前端负责显示和确认）
 	result := map[string]interface{}{
-		"success":     true,
-		"status":      "pending",
-		"action":      "write",
-		"type":        "write",
-		"server_id":   args.ServerID,
-		"file_path":   args.FilePath,
-		"file_exists": fileExists,
-		"total_lines": totalLines, // 写入的总行数
-		"message":     fmt.Sprintf("等待用户确认: %s (%d行)", args.FilePath, totalLines),
+		"success":      true,
+		"status":       "pending",
+		"action":       "write",
+		"type":         "write",
+		"server_id":    args.ServerID,
+		"file_path":    args.FilePath,
+		"file_exists":  fileExists,
+		"operations":   te.computeFullDiff(diskContent, args.Content),
+		"tool_call_id": messageID,
+		"total_lines":  totalLines, // 写入的总行数
+		"message":      fmt.Sprintf("等待用户确认: %s (%d行)", args.FilePath, totalLines),
 	}
 
 	resultJSON, _ := json.Marshal(result)
@@ -218,41 +252,25 @@ func (te *ToolExecutor) writeFile(args FileOperationArgs) (string, error) {
 }
 
 // editFile 精确编辑文件（搜索替换）
-func (te *ToolExecutor) editFile(args FileOperationArgs, conversationID string, messageID string) (string, error) {
+func (te *ToolExecutor) editFile(ctx context.Context, args FileOperationArgs, conversationID string, messageID string) (string, error) {
 	manager := models.GetPendingStateManager()
 
 	// 0. 获取当前用户消息数量作为messageIndex（Turn从0开始）
-	var messageIndex int
-	session, err := storage.GetSession(conversationID)
-	if err != nil {
-		log.Printf("⚠️ 获取会话失败: %v，使用默认messageIndex=0", err)
-		messageIndex = 0
-	} else {
-		// 统计用户消息数量（只计算role="user"的消息）
-		userMessageCount := 0
-		for _, msg := range session.Messages {
-			if msg.Role == "user" {
-				userMessageCount++
-			}
-		}
-
-		// messageIndex = 用户消息数 - 1（Turn从0开始）
-		messageIndex = userMessageCount - 1
-		if messageIndex < 0 {
-			messageIndex = 0
-		}
-		log.Printf("📊 当前会话共%d个用户消息，messageIndex(Turn)=%d", userMessageCount, messageIndex)
-	}
+	messageIndex := currentTurnIndex(conversationID)
 
 	// 1. 读取磁盘原始内容（用于计算累计diff）
-	diskContent, err := os.ReadFile(args.FilePath)
+	fsys, err := resolveFileSystemContext(ctx, args.ServerID)
 	if err != nil {
-		return "", fmt.Errorf("读取文件失败: %v", err)
+		return "", fmt.Errorf("连接服务器失败: %v", err)
+	}
+	fileKey := models.FileKey(args.ServerID, args.FilePath)
+	diskContentStr, err := te.readDiskContent(fsys, args.FilePath, conversationID, fileKey)
+	if err != nil {
+		return "", err
 	}
-	diskContentStr := string(diskContent)
 
 	// 2. 读取当前编辑基础内容（应用所有pending edits）
-	baseContent := manager.GetCurrentContent(conversationID, args.FilePath, diskContentStr)
+	baseContent := manager.GetCurrentContent(conversationID, fileKey, diskContentStr)
 
 	// 3. 检查 old_string 是否存在（在baseContent中）
 	if !strings.Contains(baseContent, args.OldString) {
@@ -285,7 +303,7 @@ func (te *ToolExecutor) editFile(args FileOperationArgs, conversationID string,
 		OldString:  args.OldString,
 		NewString:  args.NewString,
 	}
-	if err := manager.AddEdit(conversationID, args.FilePath, messageIndex, edit); err != nil {
+	if err := manager.AddEdit(conversationID, fileKey, messageIndex, edit); err != nil {
 		return "", fmt.Errorf("保存pending失败: %v", err)
 	}
 
@@ -308,7 +326,7 @@ func (te *ToolExecutor) editFile(args FileOperationArgs, conversationID string,
 		"lines_added":   linesAdded,   // 本次编辑新增的行数
 		"summary": fmt.Sprintf(
 			"等待用户确认: %s (-%d行, +%d行)",
-			filepath.Base(args.FilePath),
+			fsys.Base(args.FilePath),
 			linesDeleted,
 			linesAdded,
 		),
@@ -320,10 +338,52 @@ func (te *ToolExecutor) editFile(args FileOperationArgs, conversationID string,
 	return string(resultJSON), nil
 }
 
+// currentTurnIndex 获取当前轮次（用户消息数 - 1，Turn从0开始）
+func currentTurnIndex(conversationID string) int {
+	session, err := storage.GetSession(conversationID)
+	if err != nil {
+		log.Printf("⚠️ 获取会话失败: %v，使用默认messageIndex=0", err)
+		return 0
+	}
+
+	// 统计用户消息数量（只计算role="user"的消息）
+	userMessageCount := 0
+	for _, msg := range session.Messages {
+		if msg.Role == "user" {
+			userMessageCount++
+		}
+	}
+
+	messageIndex := userMessageCount - 1
+	if messageIndex < 0 {
+		messageIndex = 0
+	}
+	log.Printf("📊 当前会话共%d个用户消息，messageIndex(Turn)=%d", userMessageCount, messageIndex)
+	return messageIndex
+}
+
+// readDiskContent 读取磁盘内容
+// 文件不存在但已有pending操作（如尚未确认的write）时返回空内容，由pending补全
+func (te *ToolExecutor) readDiskContent(fsys FileSystem, filePath, conversationID, fileKey string) (string, error) {
+	content, err := fsys.ReadFile(filePath)
+	if err == nil {
+		return string(content), nil
+	}
+	if errors.Is(err, fs.ErrNotExist) && models.GetPendingStateManager().GetAllPendingFiles(conversationID)[fileKey] {
+		return "", nil
+	}
+	return "", fmt.Errorf("读取文件失败: %v", err)
+}
+
 // listDir 列出目录内容
-func (te *ToolExecutor) listDir(args FileOperationArgs) (string, error) {
+func (te *ToolExecutor) listDir(ctx context.Context, args FileOperationArgs) (string, error) {
+	fsys, err := resolveFileSystemContext(ctx, args.ServerID)
+	if err != nil {
+		return "", fmt.Errorf("连接服务器失败: %v", err)
+	}
+
 	// 读取目录
-	entries, err := os.ReadDir(args.FilePath)
+	entries, err := fsys.ReadDir(args.FilePath)
 	if err != nil {
 		return "", fmt.Errorf("读取目录失败: %v", err)
 	}
@@ -337,12 +397,11 @@ func (te *ToolExecutor) listDir(args FileOperationArgs) (string, error) {
 		if i >= maxItems {
 			break
 		}
-		info, _ := entry.Info()
 		fileInfo := map[string]interface{}{
 			"name":  entry.Name(),
 			"isDir": entry.IsDir(),
-			"size":  info.Size(),
-			"mtime": info.ModTime().Format("2006-01-02 15:04:05"),
+			"size":  entry.Size(),
+			"mtime": entry.ModTime().Format("2006-01-02 15:04:05"),
 		}
 		files = append(files, fileInfo)
 	}
@@ -370,15 +429,19 @@ func (te *ToolExecutor) listDir(args FileOperationArgs) (string, error) {
 }
 
 // grepSearch 搜索文件内容（支持正则表达式和文件类型过滤）
-func (te *ToolExecutor) grepSearch(args FileOperationArgs) (string, error) {
+func (te *ToolExecutor) grepSearch(ctx context.Context, args FileOperationArgs) (string, error) {
 	searchPath := args.SearchPath
 	if searchPath == "" {
 		searchPath = args.FilePath // 兼容旧参数
 	}
 
+	fsys, err := resolveFileSystemContext(ctx, args.ServerID)
+	if err != nil {
+		return "", fmt.Errorf("连接服务器失败: %v", err)
+	}
+
 	// 编译正则表达式（如果需要）
 	var regex *regexp.Regexp
-	var err error
 	if args.IsRegex {
 		regex, err = regexp.Compile(args.Query)
 		if err != nil {
@@ -398,7 +461,11 @@ func (te *ToolExecutor) grepSearch(args FileOperationArgs) (string, error) {
 	fileCount := 0
 
 	// 遍历目录
-	err = filepath.WalkDir(searchPath, func(path string, d fs.DirEntry, err error) error {
+	err = fsys.WalkDir(searchPath, func(path string, d fs.DirEntry, err error) error {
+		// 用户停止生成时中断遍历（远程目录较大时可能持续很久）
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
 		if err != nil {
 			return nil // 跳过错误
 		}
@@ -412,7 +479,7 @@ func (te *ToolExecutor) grepSearch(args FileOperationArgs) (string, error) {
 		if len(args.Includes) > 0 {
 			matched := false
 			for _, pattern := range args.Includes {
-				if m, _ := filepath.Match(pattern, filepath.Base(path)); m {
+				if m, _ := filepath.Match(pattern, fsys.Base(path)); m {
 					matched = true
 					break
 				}
@@ -423,7 +490,7 @@ func (te *ToolExecutor) grepSearch(args FileOperationArgs) (string, error) {
 		}
 
 		// 读取文件
-		content, err := os.ReadFile(path)
+		content, err := fsys.ReadFile(path)
 		if err != nil {
 			return nil // 跳过无法读取的文件
 		}
@@ -509,7 +576,7 @@ func (te *ToolExecutor) grepSearch(args FileOperationArgs) (string, error) {
 }
 
 // findByName 按文件名搜索（支持通配符和深度限制）
-func (te *ToolExecutor) findByName(args FileOperationArgs) (string, error) {
+func (te *ToolExecutor) findByName(ctx context.Context, args FileOperationArgs) (string, error) {
 	searchPath := args.SearchPath
 	if searchPath == "" {
 		searchPath = args.FilePath
@@ -521,8 +588,14 @@ func (te *ToolExecutor) findByName(args FileOperationArgs) (string, error) {
 		Size  int64  `json:"size"`
 	}
 
+	fsys, err := resolveFileSystemContext(ctx, args.ServerID)
+	if err != nil {
+		return "", fmt.Errorf("连接服务器失败: %v", err)
+	}
+
 	results := []FileInfo{}
-	baseDepth := strings.Count(searchPath, string(filepath.Separator))
+	separator := fsys.Separator()
+	baseDepth := strings.Count(searchPath, separator)
 
 	// 排除目录集合
 	excludeSet := make(map[string]bool)
@@ -530,14 +603,17 @@ func (te *ToolExecutor) findByName(args FileOperationArgs) (string, error) {
 		excludeSet[exclude] = true
 	}
 
-	err := filepath.WalkDir(searchPath, func(path string, d fs.DirEntry, err error) error {
+	err = fsys.WalkDir(searchPath, func(path string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
 		if err != nil {
 			return nil
 		}
 
 		// 检查深度限制
 		if args.MaxDepth > 0 {
-			currentDepth := strings.Count(path, string(filepath.Separator)) - baseDepth
+			currentDepth := strings.Count(path, separator) - baseDepth
 			if currentDepth > args.MaxDepth {
 				if d.IsDir() {
 					return filepath.SkipDir
@@ -704,8 +780,6 @@ func (te *ToolExecutor) calculateLineDiff(oldContent, newContent string) (linesD
 	return linesDeleted, linesAdded
 }
 
-// 前端确认后，直接调用文件API执行写入，不需要后端保存预览
-
 // GetToolsDefinition 获取工具定义（发送给AI）
 func GetToolsDefinition() []map[string]interface{} {
 	return []map[string]interface{}{
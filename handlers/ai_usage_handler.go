@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"all_project/storage"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AIUsageHandler struct{}
+
+func NewAIUsageHandler() *AIUsageHandler {
+	return &AIUsageHandler{}
+}
+
+// GetUsage 用量报表
+// group_by: day（默认）/session/model/provider；from、to为本地日期（YYYY-MM-DD，均包含）
+func (h *AIUsageHandler) GetUsage(c *gin.Context) {
+	groupBy := c.DefaultQuery("group_by", storage.UsageByDay)
+
+	var from, to time.Time
+	if v := c.Query("from"); v != "" {
+		t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "from格式应为YYYY-MM-DD"})
+			return
+		}
+		from = t
+	}
+	if v := c.Query("to"); v != "" {
+		t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "to格式应为YYYY-MM-DD"})
+			return
+		}
+		to = t.AddDate(0, 0, 1)
+	}
+
+	report, err := storage.GetUsageReport(groupBy, from, to)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": report})
+}
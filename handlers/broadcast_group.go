@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BroadcastGroup 输入广播组（cluster SSH）：任一成员终端的键盘输入会同时写入所有成员的stdin
+// 每个SSH会话同一时间只属于一个组，会话结束后自动移出
+type BroadcastGroup struct {
+	ID        string
+	Name      string
+	Enabled   bool // 暂停时成员各自独立输入，保留成员关系
+	CreatedAt time.Time
+	members   map[string]bool // SSH会话ID
+}
+
+// BroadcastGroupInfo 广播组信息（列表接口返回）
+type BroadcastGroupInfo struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Enabled   bool      `json:"enabled"`
+	Members   []string  `json:"members"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (g *BroadcastGroup) info() BroadcastGroupInfo {
+	members := make([]string, 0, len(g.members))
+	for id := range g.members {
+		members = append(members, id)
+	}
+	sort.Strings(members)
+	return BroadcastGroupInfo{
+		ID:        g.ID,
+		Name:      g.Name,
+		Enabled:   g.Enabled,
+		Members:   members,
+		CreatedAt: g.CreatedAt,
+	}
+}
+
+// BroadcastManager 全局广播组管理器
+type BroadcastManager struct {
+	groups map[string]*BroadcastGroup
+	mu     sync.RWMutex
+}
+
+var globalBroadcastManager = &BroadcastManager{
+	groups: make(map[string]*BroadcastGroup),
+}
+
+// GetBroadcastManager 获取全局广播组管理器
+func GetBroadcastManager() *BroadcastManager {
+	return globalBroadcastManager
+}
+
+// Create 创建广播组（默认启用），成员已在其他组中的会移到新组
+func (bm *BroadcastManager) Create(name string, sessionIDs []string) (BroadcastGroupInfo, error) {
+	for _, id := range sessionIDs {
+		if GetSessionManager().GetSession(id) == nil {
+			return BroadcastGroupInfo{}, fmt.Errorf("会话不存在: %s", id)
+		}
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "广播组"
+	}
+	group := &BroadcastGroup{
+		ID:        generateID(),
+		Name:      name,
+		Enabled:   true,
+		CreatedAt: time.Now(),
+		members:   make(map[string]bool),
+	}
+
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	for _, id := range sessionIDs {
+		bm.leaveAllLocked(id)
+		group.members[id] = true
+	}
+	bm.groups[group.ID] = group
+
+	log.Printf("📡 创建广播组: %s (%d 个会话)", group.Name, len(group.members))
+	return group.info(), nil
+}
+
+// Delete 删除广播组
+func (bm *BroadcastManager) Delete(groupID string) error {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	if _, ok := bm.groups[groupID]; !ok {
+		return fmt.Errorf("广播组不存在: %s", groupID)
+	}
+	delete(bm.groups, groupID)
+	return nil
+}
+
+// Join 将会话加入广播组（会先离开原来的组）
+func (bm *BroadcastManager) Join(groupID, sessionID string) (BroadcastGroupInfo, error) {
+	if GetSessionManager().GetSession(sessionID) == nil {
+		return BroadcastGroupInfo{}, fmt.Errorf("会话不存在: %s", sessionID)
+	}
+
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	group, ok := bm.groups[groupID]
+	if !ok {
+		return BroadcastGroupInfo{}, fmt.Errorf("广播组不存在: %s", groupID)
+	}
+	bm.leaveAllLocked(sessionID)
+	group.members[sessionID] = true
+	return group.info(), nil
+}
+
+// Leave 将会话移出广播组
+func (bm *BroadcastManager) Leave(groupID, sessionID string) (BroadcastGroupInfo, error) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	group, ok := bm.groups[groupID]
+	if !ok {
+		return BroadcastGroupInfo{}, fmt.Errorf("广播组不存在: %s", groupID)
+	}
+	delete(group.members, sessionID)
+	return group.info(), nil
+}
+
+// SetEnabled 启用或暂停广播
+func (bm *BroadcastManager) SetEnabled(groupID string, enabled bool) (BroadcastGroupInfo, error) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	group, ok := bm.groups[groupID]
+	if !ok {
+		return BroadcastGroupInfo{}, fmt.Errorf("广播组不存在: %s", groupID)
+	}
+	group.Enabled = enabled
+	return group.info(), nil
+}
+
+// List 列出所有广播组（按创建时间排序）
+func (bm *BroadcastManager) List() []BroadcastGroupInfo {
+	bm.mu.RLock()
+	infos := make([]BroadcastGroupInfo, 0, len(bm.groups))
+	for _, group := range bm.groups {
+		infos = append(infos, group.info())
+	}
+	bm.mu.RUnlock()
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].CreatedAt.Before(infos[j].CreatedAt) })
+	return infos
+}
+
+// groupOf 会话所在的广播组ID（不在任何组中时为空）
+func (bm *BroadcastManager) groupOf(sessionID string) string {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+
+	for _, group := range bm.groups {
+		if group.members[sessionID] {
+			return group.ID
+		}
+	}
+	return ""
+}
+
+// targets 会话的输入需要同步写入的其他会话（所在组未启用时为空）
+func (bm *BroadcastManager) targets(sessionID string) []string {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+
+	for _, group := range bm.groups {
+		if !group.members[sessionID] {
+			continue
+		}
+		if !group.Enabled {
+			return nil
+		}
+		targets := make([]string, 0, len(group.members)-1)
+		for id := range group.members {
+			if id != sessionID {
+				targets = append(targets, id)
+			}
+		}
+		return targets
+	}
+	return nil
+}
+
+// removeSession 会话结束时移出所在的组
+func (bm *BroadcastManager) removeSession(sessionID string) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	bm.leaveAllLocked(sessionID)
+}
+
+// leaveAllLocked 将会话移出所有组（需持有锁）
+func (bm *BroadcastManager) leaveAllLocked(sessionID string) {
+	for _, group := range bm.groups {
+		delete(group.members, sessionID)
+	}
+}
+
+// broadcastInput 将输入同步写入同组的其他会话（经各会话的输入队列，保持按键顺序）
+// 某个目标的队列已满（窗口阻塞）时丢弃该目标的这次输入，不影响源会话和其他目标
+func broadcastInput(source *SSHSession, data []byte) {
+	for _, id := range GetBroadcastManager().targets(source.ID) {
+		target := GetSessionManager().GetSession(id)
+		if target == nil {
+			continue
+		}
+		if !target.TryWriteInput(data) {
+			log.Printf("⚠️ 会话 %s 输入队列已满或已关闭，广播输入被丢弃", id)
+			continue
+		}
+		target.Recorder.Input(data)
+	}
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BroadcastHandler 输入广播组处理器
+type BroadcastHandler struct{}
+
+// NewBroadcastHandler 创建输入广播组处理器
+func NewBroadcastHandler() *BroadcastHandler {
+	return &BroadcastHandler{}
+}
+
+// broadcastMemberRequest 加入/离开广播组请求
+type broadcastMemberRequest struct {
+	GroupID   string `json:"group_id"`
+	SessionID string `json:"session_id"`
+}
+
+// GinListBroadcastGroups 列出广播组
+func (h *BroadcastHandler) GinListBroadcastGroups(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": GetBroadcastManager().List()})
+}
+
+// GinCreateBroadcastGroup 创建广播组
+func (h *BroadcastHandler) GinCreateBroadcastGroup(c *gin.Context) {
+	var req struct {
+		Name       string   `json:"name"`
+		SessionIDs []string `json:"session_ids"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "参数错误: " + err.Error()})
+		return
+	}
+
+	group, err := GetBroadcastManager().Create(req.Name, req.SessionIDs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": group})
+}
+
+// GinDeleteBroadcastGroup 删除广播组
+func (h *BroadcastHandler) GinDeleteBroadcastGroup(c *gin.Context) {
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.ID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "缺少id"})
+		return
+	}
+
+	if err := GetBroadcastManager().Delete(req.ID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "删除成功"})
+}
+
+// GinJoinBroadcastGroup 将会话加入广播组
+func (h *BroadcastHandler) GinJoinBroadcastGroup(c *gin.Context) {
+	var req broadcastMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.GroupID == "" || req.SessionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "缺少group_id或session_id"})
+		return
+	}
+
+	group, err := GetBroadcastManager().Join(req.GroupID, req.SessionID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": group})
+}
+
+// GinLeaveBroadcastGroup 将会话移出广播组
+func (h *BroadcastHandler) GinLeaveBroadcastGroup(c *gin.Context) {
+	var req broadcastMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.GroupID == "" || req.SessionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "缺少group_id或session_id"})
+		return
+	}
+
+	group, err := GetBroadcastManager().Leave(req.GroupID, req.SessionID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": group})
+}
+
+// GinToggleBroadcastGroup 启用或暂停广播
+func (h *BroadcastHandler) GinToggleBroadcastGroup(c *gin.Context) {
+	var req struct {
+		ID      string `json:"id"`
+		Enabled bool   `json:"enabled"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.ID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "缺少id"})
+		return
+	}
+
+	group, err := GetBroadcastManager().SetEnabled(req.ID, req.Enabled)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": group})
+}
@@ -0,0 +1,695 @@
+package handlers
+
+import (
+	"all_project/storage"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/ssh"
+)
+
+// 批量执行限制
+const (
+	defaultExecConcurrency = 5
+	maxExecConcurrency     = 50
+	defaultExecTimeout     = 60         // 秒
+	maxExecTimeout         = 3600       // 秒
+	maxExecOutput          = 256 * 1024 // 单台服务器每个输出流保存的上限
+)
+
+// ExecRequest 批量执行请求（server_ids 与 tags 取并集）
+type ExecRequest struct {
+	Command     string   `json:"command"`
+	ServerIDs   []string `json:"server_ids"`
+	Tags        []string `json:"tags"`
+	Concurrency int      `json:"concurrency"`
+	Timeout     int      `json:"timeout"` // 单台服务器超时（秒）
+}
+
+// ExecEvent 推送给客户端的执行事件
+type ExecEvent struct {
+	Type       string `json:"type"` // start/stdout/stderr/exit/done
+	ServerID   string `json:"server_id,omitempty"`
+	ServerName string `json:"server_name,omitempty"`
+	Data       string `json:"data,omitempty"`
+	Status     string `json:"status,omitempty"` // exit：服务器执行状态；done：任务状态
+	ExitCode   *int   `json:"exit_code,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms,omitempty"`
+}
+
+// execRun 执行中的任务：事件按顺序追加，每个WebSocket客户端按自己的下标读取
+type execRun struct {
+	job     *storage.ExecJob
+	servers []storage.Server
+	mu      sync.Mutex
+	events  []ExecEvent
+	changed chan struct{} // 有新事件时关闭并替换
+	done    bool
+	cancel  context.CancelFunc
+
+	// 多台服务器并发完成时，保证写入磁盘的快照不会被更早的快照覆盖
+	version      int        // 快照版本（持有mu时递增）
+	saveMu       sync.Mutex // 串行化写盘
+	savedVersion int        // 已写入的最新版本（持有saveMu）
+}
+
+// ExecHandler 批量执行处理器
+type ExecHandler struct {
+	runs map[string]*execRun // 执行中的任务，结束后只保留存储中的记录
+	mu   sync.Mutex
+}
+
+// NewExecHandler 创建批量执行处理器
+func NewExecHandler() *ExecHandler {
+	return &ExecHandler{runs: make(map[string]*execRun)}
+}
+
+// GinRunExec 创建并启动批量执行任务，通过 /ws/exec?job_id= 获取实时输出
+func (h *ExecHandler) GinRunExec(c *gin.Context) {
+	var req ExecRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "参数错误: " + err.Error()})
+		return
+	}
+
+	req.Command = strings.TrimSpace(req.Command)
+	if req.Command == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "命令不能为空"})
+		return
+	}
+
+	servers, err := resolveExecServers(req.ServerIDs, req.Tags)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	if req.Concurrency <= 0 {
+		req.Concurrency = defaultExecConcurrency
+	}
+	if req.Concurrency > maxExecConcurrency {
+		req.Concurrency = maxExecConcurrency
+	}
+	if req.Timeout <= 0 {
+		req.Timeout = defaultExecTimeout
+	}
+	if req.Timeout > maxExecTimeout {
+		req.Timeout = maxExecTimeout
+	}
+
+	job := &storage.ExecJob{
+		ID:          generateID(),
+		Command:     req.Command,
+		ServerIDs:   req.ServerIDs,
+		Tags:        req.Tags,
+		Concurrency: req.Concurrency,
+		Timeout:     req.Timeout,
+		Status:      storage.ExecJobRunning,
+		Results:     make([]storage.ExecResult, len(servers)),
+		CreatedAt:   time.Now(),
+	}
+	for i, server := range servers {
+		job.Results[i] = storage.ExecResult{
+			ServerID:   server.ID,
+			ServerName: server.Name,
+			Status:     storage.ExecHostPending,
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &execRun{
+		job:     job,
+		servers: servers,
+		changed: make(chan struct{}),
+		cancel:  cancel,
+	}
+	snapshot := run.snapshot()
+
+	// 先登记再保存，避免记录被误判为中断
+	h.mu.Lock()
+	h.runs[job.ID] = run
+	h.mu.Unlock()
+
+	if err := storage.SaveExecJob(snapshot); err != nil {
+		h.mu.Lock()
+		delete(h.runs, job.ID)
+		h.mu.Unlock()
+		cancel()
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	log.Printf("▶️ 批量执行: %s (%d 台服务器) %s", job.ID, len(servers), job.Command)
+	go h.execute(ctx, run)
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": snapshot})
+}
+
+// GinListExecJobs 列出批量执行任务（不含输出）
+func (h *ExecHandler) GinListExecJobs(c *gin.Context) {
+	jobs, err := storage.ListExecJobs()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	for i := range jobs {
+		h.fixInterrupted(&jobs[i])
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": jobs})
+}
+
+// GinGetExecJob 获取批量执行任务（含各服务器输出）
+func (h *ExecHandler) GinGetExecJob(c *gin.Context) {
+	id := c.Query("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "缺少id参数"})
+		return
+	}
+
+	job, err := h.getJob(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": job})
+}
+
+// GinCancelExecJob 取消执行中的任务（正在执行的命令会被终止）
+func (h *ExecHandler) GinCancelExecJob(c *gin.Context) {
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.ID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "缺少id"})
+		return
+	}
+
+	run := h.getRun(req.ID)
+	if run == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "任务不存在或已结束"})
+		return
+	}
+	run.cancel()
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "已取消"})
+}
+
+// GinDeleteExecJob 删除任务记录
+func (h *ExecHandler) GinDeleteExecJob(c *gin.Context) {
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.ID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "缺少id"})
+		return
+	}
+
+	if h.getRun(req.ID) != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "任务执行中，请先取消"})
+		return
+	}
+	if err := storage.DeleteExecJob(req.ID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "删除成功"})
+}
+
+// GinExecStream 通过WebSocket推送任务事件
+// 先回放已产生的事件，再实时推送直到任务结束；客户端可发送 {"type":"cancel"} 取消任务
+func (h *ExecHandler) GinExecStream(c *gin.Context) {
+	jobID := c.Query("job_id")
+
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool { return true },
+	}
+	wsConn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Println("WebSocket升级失败:", err)
+		return
+	}
+	defer wsConn.Close()
+
+	ws := &chatConn{ws: wsConn}
+
+	run := h.getRun(jobID)
+	if run == nil {
+		// 已结束的任务：根据存储的记录回放
+		job, err := h.getJob(jobID)
+		if err != nil {
+			ws.WriteJSON(map[string]interface{}{"type": "error", "error": err.Error()})
+			return
+		}
+		for _, event := range replayExecEvents(job) {
+			if err := ws.WriteJSON(event); err != nil {
+				return
+			}
+		}
+		return
+	}
+
+	// 读取协程：心跳与取消
+	stop := make(chan struct{})
+	go func() {
+		defer close(stop)
+		for {
+			var msg struct {
+				Type string `json:"type"`
+			}
+			if err := wsConn.ReadJSON(&msg); err != nil {
+				return
+			}
+			switch msg.Type {
+			case "ping":
+				ws.WriteJSON(map[string]string{"type": "pong"})
+			case "cancel":
+				log.Printf("⏹️ 取消批量执行: %s", jobID)
+				run.cancel()
+			}
+		}
+	}()
+
+	cursor := 0
+	for {
+		events, done, changed := run.eventsSince(cursor)
+		for _, event := range events {
+			if err := ws.WriteJSON(event); err != nil {
+				return
+			}
+		}
+		cursor += len(events)
+		if done {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// execute 按并发上限在各服务器上执行命令
+func (h *ExecHandler) execute(ctx context.Context, run *execRun) {
+	defer run.cancel()
+
+	timeout := time.Duration(run.job.Timeout) * time.Second
+	sem := make(chan struct{}, run.job.Concurrency)
+	var wg sync.WaitGroup
+	for i := range run.servers {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			run.execHost(ctx, i, timeout)
+		}(i)
+	}
+	wg.Wait()
+
+	run.finish(ctx.Err() != nil)
+
+	h.mu.Lock()
+	delete(h.runs, run.job.ID)
+	h.mu.Unlock()
+}
+
+// getRun 获取执行中的任务
+func (h *ExecHandler) getRun(id string) *execRun {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.runs[id]
+}
+
+// getJob 获取任务（执行中的取内存快照，否则读取存储）
+func (h *ExecHandler) getJob(id string) (*storage.ExecJob, error) {
+	if run := h.getRun(id); run != nil {
+		return run.snapshot(), nil
+	}
+	job, err := storage.GetExecJob(id)
+	if err != nil {
+		return nil, err
+	}
+	h.fixInterrupted(job)
+	return job, nil
+}
+
+// fixInterrupted 记录为执行中但实际未在执行（服务重启）的任务标记为中断
+func (h *ExecHandler) fixInterrupted(job *storage.ExecJob) {
+	if job.Status == storage.ExecJobRunning && h.getRun(job.ID) == nil {
+		job.Status = storage.ExecJobInterrupted
+	}
+}
+
+// execHost 在第i台服务器上执行命令
+func (r *execRun) execHost(ctx context.Context, i int, timeout time.Duration) {
+	if ctx.Err() != nil {
+		r.hostDone(i, storage.ExecHostCancelled, nil, "任务已取消")
+		return
+	}
+	r.hostStart(i)
+
+	hostCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	stdout := &execOutput{run: r, index: i, stream: "stdout"}
+	stderr := &execOutput{run: r, index: i, stream: "stderr"}
+	exitCode, err := runRemoteCommand(hostCtx, &r.servers[i], r.job.Command, stdout, stderr)
+
+	switch {
+	case err == nil && *exitCode == 0:
+		r.hostDone(i, storage.ExecHostSuccess, exitCode, "", stdout, stderr)
+	case err == nil:
+		r.hostDone(i, storage.ExecHostFailed, exitCode, "", stdout, stderr)
+	case ctx.Err() != nil:
+		r.hostDone(i, storage.ExecHostCancelled, nil, "任务已取消", stdout, stderr)
+	case errors.Is(hostCtx.Err(), context.DeadlineExceeded):
+		r.hostDone(i, storage.ExecHostTimeout, nil, fmt.Sprintf("执行超时（%d秒）", r.job.Timeout), stdout, stderr)
+	default:
+		r.hostDone(i, storage.ExecHostError, nil, err.Error(), stdout, stderr)
+	}
+}
+
+// runRemoteCommand 通过exec通道执行命令（不分配PTY），ctx结束时终止命令并断开连接
+func runRemoteCommand(ctx context.Context, server *storage.Server, command string, stdout, stderr io.Writer) (*int, error) {
+	type dialResult struct {
+		client *ssh.Client
+		err    error
+	}
+	dialed := make(chan dialResult, 1)
+	go func() {
+		client, err := connectSSH(server)
+		dialed <- dialResult{client, err}
+	}()
+
+	var client *ssh.Client
+	select {
+	case result := <-dialed:
+		if result.err != nil {
+			return nil, fmt.Errorf("SSH 连接失败: %v", result.err)
+		}
+		client = result.client
+	case <-ctx.Done():
+		// 连接晚于超时建立时直接关闭
+		go func() {
+			if result := <-dialed; result.client != nil {
+				result.client.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("创建会话失败: %v", err)
+	}
+	defer session.Close()
+
+	session.Stdout = stdout
+	session.Stderr = stderr
+	if err := session.Start(command); err != nil {
+		return nil, fmt.Errorf("执行命令失败: %v", err)
+	}
+
+	waitErr := make(chan error, 1)
+	go func() { waitErr <- session.Wait() }()
+
+	select {
+	case err := <-waitErr:
+		if err == nil {
+			code := 0
+			return &code, nil
+		}
+		var exitErr *ssh.ExitError
+		if errors.As(err, &exitErr) {
+			if exitErr.Signal() != "" {
+				return nil, fmt.Errorf("命令被信号终止: %s", exitErr.Signal())
+			}
+			code := exitErr.ExitStatus()
+			return &code, nil
+		}
+		return nil, err
+	case <-ctx.Done():
+		session.Signal(ssh.SIGKILL)
+		client.Close()
+		return nil, ctx.Err()
+	}
+}
+
+// execOutput 单台服务器的stdout/stderr写入器
+type execOutput struct {
+	run     *execRun
+	index   int
+	stream  string // stdout/stderr
+	pending []byte // 尚未凑成完整UTF-8字符的尾部字节
+}
+
+// Write 追加输出并推送事件（永不返回错误，超过上限的部分丢弃）
+func (o *execOutput) Write(p []byte) (int, error) {
+	o.run.appendOutput(o, p)
+	return len(p), nil
+}
+
+func (r *execRun) appendOutput(o *execOutput, p []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := &r.job.Results[o.index]
+	if result.FinishedAt != nil {
+		return
+	}
+
+	data := append(o.pending, p...)
+	cut := utf8Boundary(data)
+	o.pending = append([]byte(nil), data[cut:]...)
+	if cut == 0 {
+		return
+	}
+	r.appendTextLocked(o, string(data[:cut]))
+}
+
+// flushPendingLocked 输出结束时写入仍未凑成完整字符的字节（二进制输出或在字符中间截断），无效字节替换为U+FFFD
+func (r *execRun) flushPendingLocked(o *execOutput) {
+	if len(o.pending) == 0 {
+		return
+	}
+	text := strings.ToValidUTF8(string(o.pending), "\uFFFD")
+	o.pending = nil
+	r.appendTextLocked(o, text)
+}
+
+// appendTextLocked 追加完整的文本并推送事件（超过上限的部分丢弃）
+func (r *execRun) appendTextLocked(o *execOutput, text string) {
+	result := &r.job.Results[o.index]
+	target := &result.Stdout
+	if o.stream == "stderr" {
+		target = &result.Stderr
+	}
+	room := maxExecOutput - len(*target)
+	if room <= 0 {
+		result.Truncated = true
+		return
+	}
+	if len(text) > room {
+		text = strings.ToValidUTF8(text[:room], "")
+		result.Truncated = true
+	}
+	*target += text
+
+	r.emit(ExecEvent{
+		Type:     o.stream,
+		ServerID: result.ServerID,
+		Data:     text,
+	})
+}
+
+func (r *execRun) hostStart(i int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	result := &r.job.Results[i]
+	result.Status = storage.ExecHostRunning
+	result.StartedAt = &now
+
+	r.emit(ExecEvent{
+		Type:       "start",
+		ServerID:   result.ServerID,
+		ServerName: result.ServerName,
+	})
+}
+
+// hostDone 记录单台服务器的结果（outputs为该服务器的输出写入器，结束前写入剩余字节）
+func (r *execRun) hostDone(i int, status string, exitCode *int, errMsg string, outputs ...*execOutput) {
+	r.mu.Lock()
+	for _, o := range outputs {
+		r.flushPendingLocked(o)
+	}
+	now := time.Now()
+	result := &r.job.Results[i]
+	result.Status = status
+	result.ExitCode = exitCode
+	result.Error = errMsg
+	result.FinishedAt = &now
+	if result.StartedAt != nil {
+		result.DurationMs = now.Sub(*result.StartedAt).Milliseconds()
+	}
+
+	r.emit(ExecEvent{
+		Type:       "exit",
+		ServerID:   result.ServerID,
+		ServerName: result.ServerName,
+		Status:     status,
+		ExitCode:   exitCode,
+		Error:      errMsg,
+		DurationMs: result.DurationMs,
+	})
+	snapshot, version := r.versionedSnapshotLocked()
+	r.mu.Unlock()
+
+	// 每台完成后保存进度
+	r.save(snapshot, version)
+}
+
+// finish 任务结束：保存记录并通知所有客户端
+func (r *execRun) finish(cancelled bool) {
+	r.mu.Lock()
+	now := time.Now()
+	r.job.Status = storage.ExecJobCompleted
+	if cancelled {
+		r.job.Status = storage.ExecJobCancelled
+	}
+	r.job.FinishedAt = &now
+	snapshot, version := r.versionedSnapshotLocked()
+	r.done = true
+	r.emit(ExecEvent{Type: "done", Status: r.job.Status})
+	r.mu.Unlock()
+
+	r.save(snapshot, version)
+	log.Printf("✓ 批量执行结束: %s (%s)", snapshot.ID, snapshot.Status)
+}
+
+// emit 追加事件并唤醒等待的客户端（需持有锁）
+func (r *execRun) emit(event ExecEvent) {
+	r.events = append(r.events, event)
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// eventsSince 获取从下标from开始的事件，以及任务是否结束、下次有新事件时关闭的channel
+func (r *execRun) eventsSince(from int) ([]ExecEvent, bool, <-chan struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := append([]ExecEvent(nil), r.events[from:]...)
+	return events, r.done, r.changed
+}
+
+// snapshot 任务当前状态的副本
+func (r *execRun) snapshot() *storage.ExecJob {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.snapshotLocked()
+}
+
+// versionedSnapshotLocked 用于写盘的快照及其版本（需持有锁）
+func (r *execRun) versionedSnapshotLocked() (*storage.ExecJob, int) {
+	r.version++
+	return r.snapshotLocked(), r.version
+}
+
+// save 保存快照，已写入更新的版本时跳过（并发完成的主机可能乱序到达这里）
+func (r *execRun) save(snapshot *storage.ExecJob, version int) {
+	r.saveMu.Lock()
+	defer r.saveMu.Unlock()
+
+	if version <= r.savedVersion {
+		return
+	}
+	if err := storage.SaveExecJob(snapshot); err != nil {
+		log.Printf("⚠️ 保存批量执行任务失败: %v", err)
+		return
+	}
+	r.savedVersion = version
+}
+
+func (r *execRun) snapshotLocked() *storage.ExecJob {
+	job := *r.job
+	job.Results = append([]storage.ExecResult(nil), r.job.Results...)
+	return &job
+}
+
+// replayExecEvents 根据任务记录生成事件序列（用于已结束的任务）
+func replayExecEvents(job *storage.ExecJob) []ExecEvent {
+	var events []ExecEvent
+	for _, result := range job.Results {
+		if result.StartedAt == nil && result.FinishedAt == nil {
+			continue
+		}
+		events = append(events, ExecEvent{Type: "start", ServerID: result.ServerID, ServerName: result.ServerName})
+		if result.Stdout != "" {
+			events = append(events, ExecEvent{Type: "stdout", ServerID: result.ServerID, Data: result.Stdout})
+		}
+		if result.Stderr != "" {
+			events = append(events, ExecEvent{Type: "stderr", ServerID: result.ServerID, Data: result.Stderr})
+		}
+		if result.FinishedAt != nil {
+			events = append(events, ExecEvent{
+				Type:       "exit",
+				ServerID:   result.ServerID,
+				ServerName: result.ServerName,
+				Status:     result.Status,
+				ExitCode:   result.ExitCode,
+				Error:      result.Error,
+				DurationMs: result.DurationMs,
+			})
+		}
+	}
+	return append(events, ExecEvent{Type: "done", Status: job.Status})
+}
+
+// resolveExecServers 根据服务器ID和标签确定目标服务器（去重，保持顺序）
+func resolveExecServers(serverIDs, tags []string) ([]storage.Server, error) {
+	var servers []storage.Server
+	seen := make(map[string]bool)
+
+	for _, id := range serverIDs {
+		if id == "" || seen[id] {
+			continue
+		}
+		server, err := storage.GetServer(id)
+		if err != nil {
+			return nil, err
+		}
+		seen[id] = true
+		servers = append(servers, *server)
+	}
+
+	if len(tags) > 0 {
+		tagged, err := storage.GetServersByTags(tags)
+		if err != nil {
+			return nil, err
+		}
+		for _, server := range tagged {
+			if !seen[server.ID] {
+				seen[server.ID] = true
+				servers = append(servers, server)
+			}
+		}
+	}
+
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("没有匹配的服务器")
+	}
+	return servers, nil
+}
@@ -1,13 +1,16 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"os"
 	"os/exec"
 	"runtime"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/creack/pty"
@@ -15,188 +18,371 @@ import (
 	"github.com/gorilla/websocket"
 )
 
-// 全局本地终端实例
-var (
-	globalLocalTerminal *LocalTerminalSession
-	localTerminalMutex  sync.RWMutex
+// defaultLocalSessionID 默认本地终端（服务启动时创建，兼容未指定session_id的旧前端）
+const defaultLocalSessionID = "local"
+
+// 进程在启动后很快退出视为启动失败，连续多次则不再自动重启
+const (
+	localRestartMinUptime = 2 * time.Second
+	localRestartMaxFails  = 3
 )
 
+// LocalTerminalOptions 本地终端创建参数
+type LocalTerminalOptions struct {
+	Name        string `json:"name"`
+	Shell       string `json:"shell"`        // 为空时使用 $SHELL 或 /bin/bash
+	Cwd         string `json:"cwd"`          // 为空时使用服务进程工作目录
+	AutoRestart bool   `json:"auto_restart"` // shell退出后自动重启（否则清理会话）
+	Record      bool   `json:"record"`       // 录制会话（全局开启录像时总是录制）
+}
+
+// LocalTerminalInfo 本地终端信息（列表接口返回）
+type LocalTerminalInfo struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Shell       string    `json:"shell"`
+	Cwd         string    `json:"cwd"`
+	AutoRestart bool      `json:"auto_restart"`
+	PID         int       `json:"pid"`
+	Clients     int       `json:"clients"`
+	Restarts    int       `json:"restarts"`
+	Recording   string    `json:"recording,omitempty"` // 录像ID
+	CreatedAt   time.Time `json:"created_at"`
+}
+
 // LocalTerminalSession 本地终端会话
 type LocalTerminalSession struct {
-	cmd          *exec.Cmd
-	ptmx         *os.File
-	clients      map[*websocket.Conn]*clientInfo
-	clientsMu    sync.RWMutex
-	input        chan []byte
-	lastDropWarn time.Time  // 上次打印丢弃警告的时间
-	dropWarnMu   sync.Mutex // 保护lastDropWarn
+	ID          string
+	Name        string
+	Shell       string
+	Cwd         string
+	AutoRestart bool
+	CreatedAt   time.Time
+
+	cmd       *exec.Cmd
+	ptmx      *os.File
+	startedAt time.Time
+	restarts  int
+	fails     int // 连续快速退出次数
+	killed    bool
+	rows      uint16
+	cols      uint16
+	procMu    sync.Mutex // 保护进程相关字段
+
+	output    *terminalStream   // 输出回滚缓冲（重启后继续使用）
+	recorder  *terminalRecorder // 会话录像（未开启时为nil，重启后继续使用）
+	commands  *commandCapture   // 从shell集成序列中捕获命令历史
+	clients   map[*websocket.Conn]*streamSubscriber
+	clientsMu sync.Mutex
+	input     chan []byte
 }
 
-// clientInfo 客户端信息
-type clientInfo struct {
-	ws     *websocket.Conn
-	output chan []byte
-	closed bool
-}
+// newLocalTerminalSession 创建并启动本地终端会话
+func newLocalTerminalSession(id string, opts LocalTerminalOptions) (*LocalTerminalSession, error) {
+	// Windows使用管道，Linux/Mac使用PTY
+	if runtime.GOOS == "windows" {
+		return nil, fmt.Errorf("Windows本地终端暂不支持")
+	}
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		return nil, fmt.Errorf("不支持的操作系统: %s", runtime.GOOS)
+	}
 
-// InitGlobalLocalTerminal 初始化全局本地终端（服务启动时调用）
-func InitGlobalLocalTerminal() error {
-	localTerminalMutex.Lock()
-	defer localTerminalMutex.Unlock()
+	shell := opts.Shell
+	if shell == "" {
+		shell = os.Getenv("SHELL")
+	}
+	if shell == "" {
+		shell = "/bin/bash"
+	}
+	if _, err := exec.LookPath(shell); err != nil {
+		return nil, fmt.Errorf("shell不可用: %s", shell)
+	}
 
-	if globalLocalTerminal != nil {
-		return nil // 已初始化
+	if opts.Cwd != "" {
+		info, err := os.Stat(expandHome(opts.Cwd))
+		if err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("工作目录不存在: %s", opts.Cwd)
+		}
 	}
 
-	var cmd *exec.Cmd
-	switch runtime.GOOS {
-	case "windows":
-		cmd = exec.Command("powershell.exe")
-	case "linux", "darwin":
-		shell := os.Getenv("SHELL")
-		if shell == "" {
-			shell = "/bin/bash"
+	name := opts.Name
+	if name == "" {
+		name = "终端 " + id
+	}
+
+	session := &LocalTerminalSession{
+		ID:          id,
+		Name:        name,
+		Shell:       shell,
+		Cwd:         opts.Cwd,
+		AutoRestart: opts.AutoRestart,
+		CreatedAt:   time.Now(),
+		rows:        defaultTermRows,
+		cols:        defaultTermCols,
+		output:      newTerminalStream(),
+		commands:    newCommandCapture(localHistoryServerID, localHistoryServerName),
+		clients:     make(map[*websocket.Conn]*streamSubscriber),
+		input:       make(chan []byte, 100),
+	}
+
+	// 录像需在进程启动前创建，以免遗漏首屏输出
+	if shouldRecord(opts.Record) {
+		recorder, err := newTerminalRecorder(localServerID, id, name, shell)
+		if err != nil {
+			log.Printf("⚠️ 创建录像失败: %v", err)
 		}
-		cmd = exec.Command(shell)
-	default:
-		return fmt.Errorf("不支持的操作系统: %s", runtime.GOOS)
+		session.recorder = recorder
 	}
 
+	if err := session.start(); err != nil {
+		session.recorder.Close()
+		return nil, err
+	}
+
+	// 从input channel写入PTY（会话生命周期内唯一，重启后继续使用）
+	go session.handleInput()
+
+	return session, nil
+}
+
+// start 启动shell进程（首次创建和自动重启时调用）
+func (s *LocalTerminalSession) start() error {
+	cmd := exec.Command(s.Shell)
+	if s.Cwd != "" {
+		cmd.Dir = expandHome(s.Cwd)
+	}
 	cmd.Env = append(os.Environ(),
 		"TERM=xterm-256color",
 		"COLORTERM=truecolor",
 	)
 
-	session := &LocalTerminalSession{
-		cmd:     cmd,
-		clients: make(map[*websocket.Conn]*clientInfo),
-		input:   make(chan []byte, 100),
+	s.procMu.Lock()
+	defer s.procMu.Unlock()
+
+	// 启动PTY（沿用上次的终端大小）
+	ptmx, err := pty.StartWithSize(cmd, &pty.Winsize{Rows: s.rows, Cols: s.cols})
+	if err != nil {
+		return fmt.Errorf("启动PTY失败: %v", err)
 	}
 
-	// Windows使用管道，Linux/Mac使用PTY
-	if runtime.GOOS == "windows" {
-		return fmt.Errorf("Windows本地终端暂不支持全局模式")
+	s.cmd = cmd
+	s.ptmx = ptmx
+	s.startedAt = time.Now()
+
+	// 从PTY读取并广播给所有客户端
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		s.broadcastOutput(ptmx)
+	}()
+
+	// 等待进程退出，按配置重启或清理
+	go s.wait(cmd, ptmx, readDone)
+
+	log.Printf("✅ 本地终端已启动: %s (%s, pid=%d)", s.ID, s.Shell, cmd.Process.Pid)
+	return nil
+}
+
+// wait 等待shell退出
+func (s *LocalTerminalSession) wait(cmd *exec.Cmd, ptmx *os.File, readDone <-chan struct{}) {
+	err := cmd.Wait()
+
+	// 等待剩余输出读完（后台子进程仍持有PTY时不无限等待）
+	select {
+	case <-readDone:
+	case <-time.After(time.Second):
+	}
+	ptmx.Close()
+
+	s.procMu.Lock()
+	killed := s.killed
+	if time.Since(s.startedAt) < localRestartMinUptime {
+		s.fails++
+	} else {
+		s.fails = 0
 	}
+	restart := s.AutoRestart && !killed && s.fails < localRestartMaxFails
+	s.procMu.Unlock()
 
-	// 启动PTY
-	ptmx, err := pty.Start(cmd)
-	if err != nil {
-		return fmt.Errorf("启动PTY失败: %v", err)
+	if killed {
+		return
 	}
 
-	session.ptmx = ptmx
+	log.Printf("本地终端进程已退出: %s (%v)", s.ID, err)
 
-	// 设置终端大小
-	pty.Setsize(ptmx, &pty.Winsize{
-		Rows: 40,
-		Cols: 120,
-	})
+	if restart {
+		s.broadcast([]byte("\r\n\x1b[33m[shell 已退出，正在重启...]\x1b[0m\r\n"))
+		startErr := s.start()
+		if startErr == nil {
+			s.procMu.Lock()
+			s.restarts++
+			s.procMu.Unlock()
+			return
+		}
+		log.Printf("⚠️ 本地终端重启失败: %s (%v)", s.ID, startErr)
+	}
 
-	// 从PTY读取并广播给所有客户端
-	go session.broadcastOutput()
+	s.broadcast([]byte("\r\n\x1b[33m[shell 已退出]\x1b[0m\r\n"))
+	GetLocalTerminalManager().remove(s.ID)
+	s.close()
+}
 
-	// 从input channel写入PTY
-	go session.handleInput()
+// kill 结束shell进程并断开所有客户端
+func (s *LocalTerminalSession) kill() {
+	s.procMu.Lock()
+	s.killed = true
+	cmd := s.cmd
+	s.procMu.Unlock()
 
-	globalLocalTerminal = session
-	log.Println("✅ 全局本地终端已启动")
+	if cmd != nil && cmd.Process != nil {
+		cmd.Process.Kill()
+	}
+	s.close()
+}
 
-	return nil
+// close 断开所有客户端并停止输入处理
+func (s *LocalTerminalSession) close() {
+	// 客户端的writer goroutine写完剩余数据后关闭连接
+	s.output.Close()
+
+	s.procMu.Lock()
+	if s.input != nil {
+		close(s.input)
+		s.input = nil
+	}
+	s.procMu.Unlock()
+
+	s.recorder.Close()
+}
+
+// info 会话信息快照
+func (s *LocalTerminalSession) info() LocalTerminalInfo {
+	s.procMu.Lock()
+	pid := 0
+	if s.cmd != nil && s.cmd.Process != nil {
+		pid = s.cmd.Process.Pid
+	}
+	restarts := s.restarts
+	s.procMu.Unlock()
+
+	clients := s.output.Subscribers()
+
+	return LocalTerminalInfo{
+		ID:          s.ID,
+		Name:        s.Name,
+		Shell:       s.Shell,
+		Cwd:         s.Cwd,
+		AutoRestart: s.AutoRestart,
+		PID:         pid,
+		Clients:     clients,
+		Restarts:    restarts,
+		Recording:   s.recordingID(),
+		CreatedAt:   s.CreatedAt,
+	}
 }
 
 // broadcastOutput 从PTY读取并广播给所有客户端
-func (s *LocalTerminalSession) broadcastOutput() {
+func (s *LocalTerminalSession) broadcastOutput(ptmx *os.File) {
 	buffer := make([]byte, 32768)
 	for {
-		n, err := s.ptmx.Read(buffer)
+		n, err := ptmx.Read(buffer)
 		if err != nil {
-			if err != io.EOF {
+			// 进程退出后读取返回EIO或文件已关闭，属正常结束
+			if err != io.EOF && !errors.Is(err, syscall.EIO) && !errors.Is(err, os.ErrClosed) {
 				log.Println("读取PTY失败:", err)
 			}
 			break
 		}
 		if n > 0 {
-			data := make([]byte, n)
-			copy(data, buffer[:n])
-
-			// 通过channel发送，每个客户端有独立的writer goroutine处理
-			s.clientsMu.RLock()
-			dropped := 0
-			for _, client := range s.clients {
-				if !client.closed {
-					select {
-					case client.output <- data:
-					default:
-						// 缓冲区满，跳过此帧
-						dropped++
-					}
-				}
-			}
-			s.clientsMu.RUnlock()
-
-			// 限流：每秒最多打印一次警告（避免日志刷屏）
-			if dropped > 0 {
-				s.dropWarnMu.Lock()
-				now := time.Now()
-				if now.Sub(s.lastDropWarn) >= time.Second {
-					log.Printf("⚠️ 终端输出过快，缓冲区溢出（已跳过部分数据）")
-					s.lastDropWarn = now
-				}
-				s.dropWarnMu.Unlock()
-			}
+			s.broadcast(buffer[:n])
 		}
 	}
 }
 
-// handleInput 处理输入
+// broadcast 写入回滚缓冲，由各客户端按自己的进度读取（慢客户端不丢帧）
+func (s *LocalTerminalSession) broadcast(data []byte) {
+	s.output.Write(data)
+	s.commands.Write(data)
+	s.recorder.Write(data)
+}
+
+// recordingID 录像ID（未录像时为空）
+func (s *LocalTerminalSession) recordingID() string {
+	if s.recorder == nil {
+		return ""
+	}
+	return s.recorder.ID
+}
+
+// handleInput 处理输入（写入当前进程的PTY）
 func (s *LocalTerminalSession) handleInput() {
-	for data := range s.input {
-		if _, err := s.ptmx.Write(data); err != nil {
+	s.procMu.Lock()
+	input := s.input
+	s.procMu.Unlock()
+
+	for data := range input {
+		s.procMu.Lock()
+		ptmx := s.ptmx
+		s.procMu.Unlock()
+
+		if _, err := ptmx.Write(data); err != nil {
 			log.Println("写入PTY失败:", err)
 		}
 	}
 }
 
-// addClient 添加客户端
+// addClient 添加客户端（先回放回滚缓冲，再接收实时输出）
 func (s *LocalTerminalSession) addClient(ws *websocket.Conn) {
-	client := &clientInfo{
-		ws:     ws,
-		output: make(chan []byte, 1000), // 1000帧缓冲，避免快速输出时溢出
-		closed: false,
-	}
+	sub := s.output.Subscribe()
 
 	s.clientsMu.Lock()
-	s.clients[ws] = client
+	s.clients[ws] = sub
 	s.clientsMu.Unlock()
 
 	// 启动独立的writer goroutine（避免并发写入WebSocket）
 	go func() {
-		for data := range client.output {
-			if err := ws.WriteMessage(websocket.BinaryMessage, data); err != nil {
-				log.Println("写入WebSocket失败:", err)
-				client.closed = true
-				return
-			}
+		if err := writeStream(ws, sub); err != nil {
+			log.Println("写入WebSocket失败:", err)
 		}
+		// 会话结束（而非客户端主动断开）时关闭连接，结束读取循环
+		ws.Close()
 	}()
 
-	log.Printf("本地终端客户端已连接，当前客户端数: %d", len(s.clients))
+	log.Printf("本地终端客户端已连接: %s，当前客户端数: %d", s.ID, s.output.Subscribers())
 }
 
 // removeClient 移除客户端
 func (s *LocalTerminalSession) removeClient(ws *websocket.Conn) {
 	s.clientsMu.Lock()
-	if client, ok := s.clients[ws]; ok {
-		client.closed = true
-		close(client.output) // 关闭channel，终止writer goroutine
+	if sub, ok := s.clients[ws]; ok {
+		s.output.Unsubscribe(sub) // 终止writer goroutine
 		delete(s.clients, ws)
 	}
 	s.clientsMu.Unlock()
-	log.Printf("本地终端客户端已断开，当前客户端数: %d", len(s.clients))
+	log.Printf("本地终端客户端已断开: %s，当前客户端数: %d", s.ID, s.output.Subscribers())
+}
+
+// resize 调整PTY大小（多个客户端共享同一PTY，以最近一次为准）
+func (s *LocalTerminalSession) resize(rows, cols int) {
+	s.procMu.Lock()
+	defer s.procMu.Unlock()
+
+	s.rows, s.cols = uint16(rows), uint16(cols)
+	s.recorder.Resize(cols, rows)
+	if err := pty.Setsize(s.ptmx, &pty.Winsize{Rows: s.rows, Cols: s.cols}); err != nil {
+		log.Println("调整PTY大小失败:", err)
+	}
 }
 
 // sendInput 发送输入
 func (s *LocalTerminalSession) sendInput(data []byte) {
+	s.procMu.Lock()
+	defer s.procMu.Unlock()
+
+	if s.input == nil {
+		return // 会话已关闭
+	}
+	s.recorder.Input(data)
 	select {
 	case s.input <- data:
 	default:
@@ -204,14 +390,32 @@ func (s *LocalTerminalSession) sendInput(data []byte) {
 	}
 }
 
-// GinHandleLocalTerminal 处理本地终端 WebSocket 连接（使用全局实例）
+// InitGlobalLocalTerminal 创建默认本地终端（服务启动时调用）
+func InitGlobalLocalTerminal() error {
+	_, err := GetLocalTerminalManager().GetOrCreateDefault()
+	return err
+}
+
+// GinHandleLocalTerminal 处理本地终端 WebSocket 连接（?session_id= 指定会话，缺省为默认终端）
 func GinHandleLocalTerminal(c *gin.Context) {
-	localTerminalMutex.RLock()
-	session := globalLocalTerminal
-	localTerminalMutex.RUnlock()
+	manager := GetLocalTerminalManager()
+
+	var session *LocalTerminalSession
+	sessionID := c.Query("session_id")
+	if sessionID == "" || sessionID == defaultLocalSessionID {
+		// 默认终端退出被清理后按需重新创建
+		s, err := manager.GetOrCreateDefault()
+		if err != nil {
+			c.JSON(500, gin.H{"error": "本地终端启动失败: " + err.Error()})
+			return
+		}
+		session = s
+	} else {
+		session = manager.Get(sessionID)
+	}
 
 	if session == nil {
-		c.JSON(500, gin.H{"error": "本地终端未初始化"})
+		c.JSON(404, gin.H{"error": "本地终端不存在"})
 		return
 	}
 
@@ -231,13 +435,59 @@ func GinHandleLocalTerminal(c *gin.Context) {
 
 	// 持续读取客户端输入并发送到终端
 	for {
-		_, data, err := ws.ReadMessage()
+		msgType, data, err := ws.ReadMessage()
 		if err != nil {
 			if err != io.EOF {
 				log.Println("读取WebSocket失败:", err)
 			}
 			break
 		}
+
+		// 控制消息（调整窗口大小等）
+		if ctrl, ok := parseTerminalControl(msgType, data); ok {
+			if ctrl.Type == termCtrlResize && ctrl.validSize() {
+				session.resize(ctrl.Rows, ctrl.Cols)
+			}
+			continue
+		}
 		session.sendInput(data)
 	}
 }
+
+// GinListLocalTerminals 列出本地终端会话
+func GinListLocalTerminals(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": GetLocalTerminalManager().List()})
+}
+
+// GinCreateLocalTerminal 创建本地终端会话
+func GinCreateLocalTerminal(c *gin.Context) {
+	var opts LocalTerminalOptions
+	if err := c.ShouldBindJSON(&opts); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "参数错误: " + err.Error()})
+		return
+	}
+
+	session, err := GetLocalTerminalManager().Create(opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": session.info()})
+}
+
+// GinKillLocalTerminal 结束本地终端会话
+func GinKillLocalTerminal(c *gin.Context) {
+	id := c.Query("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "缺少id参数"})
+		return
+	}
+
+	if err := GetLocalTerminalManager().Kill(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "终端已结束"})
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// LocalTerminalManager 本地终端会话管理器（按会话ID管理多个独立shell）
+type LocalTerminalManager struct {
+	sessions map[string]*LocalTerminalSession
+	mu       sync.Mutex
+}
+
+var (
+	localTerminalManager     *LocalTerminalManager
+	localTerminalManagerOnce sync.Once
+)
+
+// GetLocalTerminalManager 获取本地终端管理器单例
+func GetLocalTerminalManager() *LocalTerminalManager {
+	localTerminalManagerOnce.Do(func() {
+		localTerminalManager = &LocalTerminalManager{
+			sessions: make(map[string]*LocalTerminalSession),
+		}
+	})
+	return localTerminalManager
+}
+
+// Create 创建新的本地终端会话
+func (m *LocalTerminalManager) Create(opts LocalTerminalOptions) (*LocalTerminalSession, error) {
+	id := generateID()[:8]
+	session, err := newLocalTerminalSession(id, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	m.sessions[id] = session
+	m.mu.Unlock()
+
+	return session, nil
+}
+
+// GetOrCreateDefault 获取默认本地终端，不存在时创建（shell退出后自动重启）
+func (m *LocalTerminalManager) GetOrCreateDefault() (*LocalTerminalSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if session, ok := m.sessions[defaultLocalSessionID]; ok {
+		return session, nil
+	}
+
+	session, err := newLocalTerminalSession(defaultLocalSessionID, LocalTerminalOptions{
+		Name:        "本地终端",
+		AutoRestart: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	m.sessions[defaultLocalSessionID] = session
+	return session, nil
+}
+
+// Get 获取会话
+func (m *LocalTerminalManager) Get(id string) *LocalTerminalSession {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sessions[id]
+}
+
+// List 列出所有会话（按创建时间排序）
+func (m *LocalTerminalManager) List() []LocalTerminalInfo {
+	m.mu.Lock()
+	sessions := make([]*LocalTerminalSession, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		sessions = append(sessions, session)
+	}
+	m.mu.Unlock()
+
+	infos := make([]LocalTerminalInfo, 0, len(sessions))
+	for _, session := range sessions {
+		infos = append(infos, session.info())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].CreatedAt.Before(infos[j].CreatedAt) })
+	return infos
+}
+
+// Kill 结束会话的shell进程并移除
+func (m *LocalTerminalManager) Kill(id string) error {
+	m.mu.Lock()
+	session, ok := m.sessions[id]
+	delete(m.sessions, id)
+	m.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("本地终端不存在: %s", id)
+	}
+	session.kill()
+	return nil
+}
+
+// remove 移除会话（进程退出且不再重启时调用）
+func (m *LocalTerminalManager) remove(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+}
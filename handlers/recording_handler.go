@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"all_project/storage"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RecordingHandler 终端录像处理器
+type RecordingHandler struct{}
+
+// NewRecordingHandler 创建终端录像处理器
+func NewRecordingHandler() *RecordingHandler {
+	return &RecordingHandler{}
+}
+
+// GinListRecordings 列出录像（可按 server_id、date=YYYY-MM-DD 过滤）
+func (h *RecordingHandler) GinListRecordings(c *gin.Context) {
+	recordings, err := storage.ListRecordings(c.Query("server_id"), c.Query("date"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": recordings})
+}
+
+// GinDownloadRecording 下载录像文件
+func (h *RecordingHandler) GinDownloadRecording(c *gin.Context) {
+	path, err := storage.GetRecordingPath(c.Query("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	c.FileAttachment(path, filepath.Base(path))
+}
+
+// GinPlayRecording 回放录像：以asciicast格式返回（支持Range，可直接交给asciinema-player）
+// 会话仍在录制时返回当前已写入的内容
+func (h *RecordingHandler) GinPlayRecording(c *gin.Context) {
+	path, err := storage.GetRecordingPath(c.Query("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", "application/x-asciicast")
+	c.Header("Cache-Control", "no-cache")
+	http.ServeContent(c.Writer, c.Request, filepath.Base(path), info.ModTime(), file)
+}
+
+// GinDeleteRecording 删除录像
+func (h *RecordingHandler) GinDeleteRecording(c *gin.Context) {
+	id := c.Query("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "缺少id参数"})
+		return
+	}
+
+	if err := storage.DeleteRecording(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "删除成功"})
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"all_project/storage"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ServerTreeNode 服务器树节点（根节点没有分组，包含未分组的服务器）
+type ServerTreeNode struct {
+	Group    *storage.ServerGroup `json:"group,omitempty"`
+	Path     string               `json:"path,omitempty"` // 如 生产/华东
+	Children []*ServerTreeNode    `json:"children"`
+	Servers  []storage.Server     `json:"servers"`
+}
+
+// GinGetServerGroups 获取所有分组（扁平列表）
+func (h *ServerHandler) GinGetServerGroups(c *gin.Context) {
+	groups, err := storage.GetServerGroups()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	masked := make([]storage.ServerGroup, len(groups))
+	for i, g := range groups {
+		masked[i] = g.Masked()
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": masked})
+}
+
+// GinGetServerTree 获取分组树（分组按名称排序，服务器保持原顺序）
+func (h *ServerHandler) GinGetServerTree(c *gin.Context) {
+	groups, err := storage.GetServerGroups()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	servers, err := storage.GetServers()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": buildServerTree(groups, servers)})
+}
+
+// GinCreateServerGroup 创建分组
+func (h *ServerHandler) GinCreateServerGroup(c *gin.Context) {
+	var group storage.ServerGroup
+	if err := c.ShouldBindJSON(&group); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "参数错误: " + err.Error()})
+		return
+	}
+
+	group.ID = generateID()
+	if err := validateServerGroup(&group); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	if err := storage.CreateServerGroup(&group); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": group.Masked()})
+}
+
+// GinUpdateServerGroup 更新分组（修改上级分组即移动分组）
+func (h *ServerHandler) GinUpdateServerGroup(c *gin.Context) {
+	var req struct {
+		storage.ServerGroup
+		credentialUpdate
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "参数错误: " + err.Error()})
+		return
+	}
+	group := req.ServerGroup
+	if group.ID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "缺少id"})
+		return
+	}
+	if err := validateServerGroup(&group); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	if err := storage.UpdateServerGroup(&group, req.ClearSecrets); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	// 继承的连接参数可能已变化，关闭成员的后台连接
+	closeGroupConnections(group.ID)
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": group.Masked()})
+}
+
+// GinDeleteServerGroup 删除分组（子分组和服务器移到上级分组）
+func (h *ServerHandler) GinDeleteServerGroup(c *gin.Context) {
+	id := c.Query("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "缺少id参数"})
+		return
+	}
+
+	closeGroupConnections(id)
+
+	if err := storage.DeleteServerGroup(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "删除成功"})
+}
+
+// GinMoveServers 将服务器移到分组（group_id为空表示移出分组）
+func (h *ServerHandler) GinMoveServers(c *gin.Context) {
+	var req struct {
+		ServerIDs []string `json:"server_ids"`
+		GroupID   string   `json:"group_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.ServerIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "缺少server_ids"})
+		return
+	}
+
+	if err := storage.MoveServers(req.ServerIDs, req.GroupID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	for _, id := range req.ServerIDs {
+		GetSSHPool().Close(id)
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "移动成功"})
+}
+
+// validateServerGroup 校验分组名称和默认跳板机
+func validateServerGroup(group *storage.ServerGroup) error {
+	group.Name = strings.TrimSpace(group.Name)
+	if group.Name == "" {
+		return fmt.Errorf("分组名称不能为空")
+	}
+	if group.Port < 0 || group.Port > 65535 {
+		return fmt.Errorf("端口无效: %d", group.Port)
+	}
+	if group.JumpHostID != "" {
+		if _, err := storage.GetServer(group.JumpHostID); err != nil {
+			return fmt.Errorf("跳板机不存在: %s", group.JumpHostID)
+		}
+	}
+	return nil
+}
+
+// closeGroupConnections 关闭分组（含子分组）内服务器的后台连接
+func closeGroupConnections(groupID string) {
+	groups, err := storage.GetServerGroups()
+	if err != nil {
+		return
+	}
+	servers, err := storage.GetServers()
+	if err != nil {
+		return
+	}
+	for _, s := range servers {
+		for _, g := range storage.GroupPath(groups, s.GroupID) {
+			if g.ID == groupID {
+				GetSSHPool().Close(s.ID)
+				break
+			}
+		}
+	}
+}
+
+// buildServerTree 构建分组树（上级分组不存在的分组和服务器挂到根节点）
+func buildServerTree(groups []storage.ServerGroup, servers []storage.Server) *ServerTreeNode {
+	root := &ServerTreeNode{Children: []*ServerTreeNode{}, Servers: []storage.Server{}}
+
+	nodes := make(map[string]*ServerTreeNode, len(groups))
+	for i := range groups {
+		g := groups[i].Masked()
+		nodes[g.ID] = &ServerTreeNode{Group: &g, Children: []*ServerTreeNode{}, Servers: []storage.Server{}}
+	}
+	for _, node := range nodes {
+		var names []string
+		for _, g := range storage.GroupPath(groups, node.Group.ID) {
+			names = append(names, g.Name)
+		}
+		node.Path = strings.Join(names, "/")
+
+		parent := nodes[node.Group.ParentID]
+		if parent == nil {
+			parent = root
+		}
+		parent.Children = append(parent.Children, node)
+	}
+
+	for _, s := range servers {
+		node := nodes[s.GroupID]
+		if node == nil {
+			node = root
+		}
+		node.Servers = append(node.Servers, s.Masked())
+	}
+
+	sortTree(root)
+	return root
+}
+
+func sortTree(node *ServerTreeNode) {
+	sort.Slice(node.Children, func(i, j int) bool {
+		return strings.ToLower(node.Children[i].Group.Name) < strings.ToLower(node.Children[j].Group.Name)
+	})
+	for _, child := range node.Children {
+		sortTree(child)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"all_project/storage"
 	"crypto/rand"
 	"encoding/hex"
+	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -15,14 +16,14 @@ func NewServerHandler() *ServerHandler {
 	return &ServerHandler{}
 }
 
-// GinGetServers 获取所有服务器
+// GinGetServers 获取所有服务器（附带最近一次可达性探测结果）
 func (h *ServerHandler) GinGetServers(c *gin.Context) {
 	servers, err := storage.GetServers()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"success": true, "data": servers})
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": GetServerProber().WithStatus(servers)})
 }
 
 // GinGetServer 获取单个服务器
@@ -38,7 +39,7 @@ func (h *ServerHandler) GinGetServer(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"success": true, "data": server})
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": server.Masked()})
 }
 
 // GinCreateServer 创建服务器
@@ -52,33 +53,77 @@ func (h *ServerHandler) GinCreateServer(c *gin.Context) {
 	// 生成随机ID
 	server.ID = generateID()
 
+	if err := validateJumpHost(&server); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	if server.GroupID != "" {
+		if _, err := storage.GetServerGroup(server.GroupID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+	}
+
 	if err := storage.CreateServer(&server); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
 		return
 	}
+	GetServerProber().Refresh(server.ID)
 
-	c.JSON(http.StatusOK, gin.H{"success": true, "data": server})
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": server.Masked()})
+}
+
+// credentialUpdate 更新服务器/分组时附带的凭据清空列表
+// 凭据留空表示不修改（列表中的脱敏值不能回传，会被当作新凭据），需清空时在clear_secrets中列出字段名（password/private_key/passphrase）
+type credentialUpdate struct {
+	ClearSecrets []string `json:"clear_secrets"`
 }
 
 // GinUpdateServer 更新服务器
 func (h *ServerHandler) GinUpdateServer(c *gin.Context) {
-	var server storage.Server
-	if err := c.ShouldBindJSON(&server); err != nil {
+	var req struct {
+		storage.Server
+		credentialUpdate
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "参数错误: " + err.Error()})
 		return
 	}
+	server := req.Server
 
 	if server.ID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "缺少id"})
 		return
 	}
 
-	if err := storage.UpdateServer(&server); err != nil {
+	if err := validateJumpHost(&server); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	if server.GroupID != "" {
+		if _, err := storage.GetServerGroup(server.GroupID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+	}
+
+	old, _ := storage.GetServer(server.ID)
+
+	if err := storage.UpdateServer(&server, req.ClearSecrets); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"success": true, "data": server})
+	// 地址变更后旧的主机密钥不再适用，下次连接重新信任
+	if old != nil && (old.Host != server.Host || old.Port != server.Port) {
+		storage.ResetKnownHost(server.ID)
+	}
+
+	// 配置已变更，关闭后台连接池中的旧连接
+	GetSSHPool().Close(server.ID)
+	GetServerProber().Refresh(server.ID)
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": server.Masked()})
 }
 
 // GinDeleteServer 删除服务器
@@ -89,17 +134,83 @@ func (h *ServerHandler) GinDeleteServer(c *gin.Context) {
 		return
 	}
 
+	// 仍被用作跳板机的服务器不允许删除
+	servers, err := storage.GetServers()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	for _, s := range servers {
+		if s.JumpHostID == id {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "该服务器是 " + s.Name + " 的跳板机，请先修改其跳板配置"})
+			return
+		}
+	}
+
+	if name, err := storage.GroupUsesJumpHost(id); err == nil && name != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "该服务器是分组 " + name + " 的默认跳板机，请先修改分组配置"})
+		return
+	}
+
+	if GetTunnelManager().ServerInUse(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "该服务器配置了端口转发，请先删除相关转发"})
+		return
+	}
+
 	if err := storage.DeleteServer(id); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
 		return
 	}
 
+	GetSSHPool().Close(id)
+	GetServerProber().Forget(id)
+	storage.ResetKnownHost(id)
+
 	c.JSON(http.StatusOK, gin.H{"success": true, "message": "删除成功"})
 }
 
-// GinSearchServers 搜索服务器
+// GinGetHostKey 查看服务器已信任的主机密钥
+func (h *ServerHandler) GinGetHostKey(c *gin.Context) {
+	id := c.Query("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "缺少id参数"})
+		return
+	}
+
+	host, err := storage.GetKnownHost(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	// 尚未连接过时 data 为 null
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": host})
+}
+
+// GinResetHostKey 重置服务器的主机密钥记录（下次连接时重新信任）
+func (h *ServerHandler) GinResetHostKey(c *gin.Context) {
+	id := c.Query("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "缺少id参数"})
+		return
+	}
+
+	if err := storage.ResetKnownHost(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	GetSSHPool().Close(id)
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "主机密钥已重置"})
+}
+
+// GinSearchServers 搜索服务器（支持 tag:、group:、host: 限定词）
 func (h *ServerHandler) GinSearchServers(c *gin.Context) {
 	keyword := c.Query("keyword")
+	if keyword == "" {
+		keyword = c.Query("q")
+	}
 
 	servers, err := storage.SearchServers(keyword)
 	if err != nil {
@@ -107,7 +218,7 @@ func (h *ServerHandler) GinSearchServers(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"success": true, "data": servers})
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": GetServerProber().WithStatus(servers)})
 }
 
 // GetServerByID 根据ID获取服务器（内部使用）
@@ -115,6 +226,28 @@ func (h *ServerHandler) GetServerByID(id string) (*storage.Server, error) {
 	return storage.GetServer(id)
 }
 
+// validateJumpHost 校验跳板机存在且跳板链无循环
+func validateJumpHost(server *storage.Server) error {
+	visited := []string{server.ID}
+	for id := server.JumpHostID; id != ""; {
+		for _, v := range visited {
+			if v == id {
+				return fmt.Errorf("跳板机配置存在循环引用")
+			}
+		}
+		if len(visited) > maxJumpHops {
+			return fmt.Errorf("跳板链超过最大层数 %d", maxJumpHops)
+		}
+		jump, err := storage.GetServer(id)
+		if err != nil {
+			return fmt.Errorf("跳板机不存在: %s", id)
+		}
+		visited = append(visited, id)
+		id = jump.JumpHostID
+	}
+	return nil
+}
+
 // generateID 生成随机ID
 func generateID() string {
 	b := make([]byte, 16)
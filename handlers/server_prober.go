@@ -0,0 +1,385 @@
+package handlers
+
+import (
+	"all_project/storage"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// 服务器可达性状态
+const (
+	probeUnknown     = "unknown"     // 尚未探测或无法探测（如未开启probe_auth时经跳板机的服务器）
+	probeOnline      = "online"      // SSH握手成功（开启认证检查时认证也成功）
+	probeUnreachable = "unreachable" // TCP连接失败
+	probeSSHError    = "ssh_error"   // 端口可连接但SSH握手失败（含主机密钥不匹配）
+	probeAuthFailed  = "auth_failed" // SSH握手成功但认证失败
+)
+
+// probeTimeout 单台服务器的探测超时（TCP连接和SSH握手各自计算）
+const probeTimeout = 10 * time.Second
+
+// ServerStatus 服务器探测结果
+type ServerStatus struct {
+	ServerID    string     `json:"server_id"`
+	State       string     `json:"state"`
+	LatencyMs   int64      `json:"latency_ms,omitempty"` // TCP连接耗时（经跳板机时为打开隧道的耗时）
+	AuthChecked bool       `json:"auth_checked"`         // 本次探测是否校验了登录凭据
+	Via         string     `json:"via,omitempty"`        // 经过的跳板机名称
+	LastSeen    *time.Time `json:"last_seen,omitempty"`  // 最近一次SSH握手成功的时间
+	LastChecked *time.Time `json:"last_checked,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+// ServerListItem 服务器列表项（配置 + 最近一次探测结果）
+type ServerListItem struct {
+	storage.Server
+	Status *ServerStatus `json:"status,omitempty"`
+}
+
+// ServerProber 后台定期探测所有服务器的可达性
+type ServerProber struct {
+	statuses map[string]ServerStatus
+	probing  map[string]bool // 正在探测的服务器，避免重复探测
+	subs     map[chan ServerStatus]struct{}
+	mu       sync.Mutex
+
+	concurrency int
+	checkAuth   bool
+	running     bool
+	startOnce   sync.Once
+}
+
+var (
+	serverProber     *ServerProber
+	serverProberOnce sync.Once
+)
+
+// GetServerProber 获取单例
+func GetServerProber() *ServerProber {
+	serverProberOnce.Do(func() {
+		serverProber = &ServerProber{
+			statuses:    make(map[string]ServerStatus),
+			probing:     make(map[string]bool),
+			subs:        make(map[chan ServerStatus]struct{}),
+			concurrency: 1,
+		}
+	})
+	return serverProber
+}
+
+// Start 启动定期探测（interval为0时不启动，仍可手动探测）
+func (p *ServerProber) Start(interval time.Duration, concurrency int, checkAuth bool) {
+	p.startOnce.Do(func() {
+		p.mu.Lock()
+		if concurrency > 0 {
+			p.concurrency = concurrency
+		}
+		p.checkAuth = checkAuth
+		p.running = interval > 0
+		p.mu.Unlock()
+
+		if interval <= 0 {
+			log.Println("⚠️ 服务器可达性探测已关闭")
+			return
+		}
+		log.Printf("✓ 服务器可达性探测已启动（间隔 %v，并发 %d）", interval, p.concurrency)
+
+		go func() {
+			p.ProbeAll()
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for range ticker.C {
+				p.ProbeAll()
+			}
+		}()
+	})
+}
+
+// ProbeAll 按并发上限探测所有服务器，并清理已删除服务器的状态
+func (p *ServerProber) ProbeAll() {
+	servers, err := storage.GetServers()
+	if err != nil {
+		log.Printf("⚠️ 探测服务器失败: %v", err)
+		return
+	}
+
+	exists := make(map[string]bool, len(servers))
+	for _, s := range servers {
+		exists[s.ID] = true
+	}
+	p.mu.Lock()
+	for id := range p.statuses {
+		if !exists[id] {
+			delete(p.statuses, id)
+		}
+	}
+	sem := make(chan struct{}, p.concurrency)
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, s := range servers {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(server storage.Server) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			p.probe(server)
+		}(s)
+	}
+	wg.Wait()
+}
+
+// Probe 立即探测一台服务器并返回结果
+func (p *ServerProber) Probe(id string) (ServerStatus, error) {
+	server, err := storage.GetServer(id)
+	if err != nil {
+		return ServerStatus{}, err
+	}
+	if status, ok := p.probe(*server); ok {
+		return status, nil
+	}
+	// 已有探测在进行，返回最近一次结果
+	return p.Status(id), nil
+}
+
+// Refresh 服务器配置变更后在后台重新探测（未启动定期探测时忽略）
+func (p *ServerProber) Refresh(id string) {
+	p.mu.Lock()
+	running := p.running
+	p.mu.Unlock()
+	if running {
+		go p.Probe(id)
+	}
+}
+
+// Forget 删除服务器的探测状态
+func (p *ServerProber) Forget(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.statuses, id)
+}
+
+// Status 获取服务器最近一次探测结果（未探测时状态为unknown）
+func (p *ServerProber) Status(id string) ServerStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if status, ok := p.statuses[id]; ok {
+		return status
+	}
+	return ServerStatus{ServerID: id, State: probeUnknown}
+}
+
+// Statuses 获取所有已探测服务器的状态
+func (p *ServerProber) Statuses() []ServerStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	list := make([]ServerStatus, 0, len(p.statuses))
+	for _, status := range p.statuses {
+		list = append(list, status)
+	}
+	return list
+}
+
+// Subscribe 订阅状态变化，返回的函数用于取消订阅
+func (p *ServerProber) Subscribe() (<-chan ServerStatus, func()) {
+	ch := make(chan ServerStatus, 64)
+	p.mu.Lock()
+	p.subs[ch] = struct{}{}
+	p.mu.Unlock()
+
+	return ch, func() {
+		p.mu.Lock()
+		delete(p.subs, ch)
+		p.mu.Unlock()
+	}
+}
+
+// WithStatus 为服务器列表附加探测结果
+func (p *ServerProber) WithStatus(servers []storage.Server) []ServerListItem {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	items := make([]ServerListItem, len(servers))
+	for i, s := range servers {
+		items[i] = ServerListItem{Server: s.Masked()}
+		if status, ok := p.statuses[s.ID]; ok {
+			items[i].Status = &status
+		}
+	}
+	return items
+}
+
+// probe 探测并记录结果（同一服务器已在探测中时返回false）
+func (p *ServerProber) probe(server storage.Server) (ServerStatus, bool) {
+	p.mu.Lock()
+	if p.probing[server.ID] {
+		p.mu.Unlock()
+		return ServerStatus{}, false
+	}
+	p.probing[server.ID] = true
+	checkAuth := p.checkAuth
+	p.mu.Unlock()
+
+	status := probeServer(server, checkAuth)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.probing, server.ID)
+
+	prev, hadPrev := p.statuses[server.ID]
+	if status.LastSeen == nil {
+		status.LastSeen = prev.LastSeen
+	}
+	p.statuses[server.ID] = status
+
+	if hadPrev && prev.State != status.State {
+		log.Printf("📡 服务器 %s 状态变化: %s → %s", server.Name, prev.State, status.State)
+	}
+	for ch := range p.subs {
+		select {
+		case ch <- status:
+		default: // 订阅者处理不过来时丢弃，下一轮探测会再次推送
+		}
+	}
+	return status, true
+}
+
+// probeServer 对服务器做一次TCP连接和SSH握手（checkAuth为true时同时认证）
+func probeServer(server storage.Server, checkAuth bool) ServerStatus {
+	now := time.Now()
+	status := ServerStatus{ServerID: server.ID, AuthChecked: checkAuth, LastChecked: &now}
+
+	effective, err := storage.ApplyGroupDefaults(server)
+	if err != nil {
+		status.State = probeUnknown
+		status.LastError = err.Error()
+		return status
+	}
+	address := net.JoinHostPort(effective.Host, strconv.Itoa(effective.Port))
+
+	// TCP连接（配置了跳板机时经跳板机的direct-tcpip通道）
+	var conn net.Conn
+	start := time.Now()
+	if effective.JumpHostID == "" {
+		conn, err = net.DialTimeout("tcp", address, probeTimeout)
+	} else {
+		jumpServer, jerr := storage.GetServer(effective.JumpHostID)
+		if jerr != nil {
+			status.State = probeUnreachable
+			status.LastError = fmt.Sprintf("跳板机不存在: %v", jerr)
+			return status
+		}
+		status.Via = jumpServer.Name
+		jumpClient, reused, jerr := probeJumpClient(jumpServer, server.ID, checkAuth)
+		if jerr != nil {
+			status.State = probeUnreachable
+			status.LastError = fmt.Sprintf("连接跳板机 %s 失败: %v", jumpServer.Name, jerr)
+			return status
+		}
+		if jumpClient == nil {
+			status.State = probeUnknown
+			status.LastError = fmt.Sprintf("需登录跳板机 %s 才能探测（未开启probe_auth且没有已建立的跳板连接）", jumpServer.Name)
+			return status
+		}
+		if !reused {
+			defer jumpClient.Close()
+		}
+		start = time.Now()
+		conn, err = jumpClient.Dial("tcp", address)
+	}
+	if err != nil {
+		status.State = probeUnreachable
+		status.LastError = err.Error()
+		return status
+	}
+	defer conn.Close()
+	status.LatencyMs = time.Since(start).Milliseconds()
+
+	// SSH握手：主机密钥回调被调用说明密钥交换已完成
+	var handshaked atomic.Bool
+	verify := probeHostKeyCallback(&effective)
+	config := &ssh.ClientConfig{
+		User: effective.Username,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if err := verify(hostname, remote, key); err != nil {
+				return err
+			}
+			handshaked.Store(true)
+			return nil
+		},
+		Timeout:       probeTimeout,
+		ClientVersion: "SSH-2.0-WebSSH_Client",
+	}
+	if checkAuth {
+		auths, cleanup, err := buildAuthMethods(&effective)
+		if err != nil {
+			status.State = probeAuthFailed
+			status.LastError = err.Error()
+			return status
+		}
+		defer cleanup()
+		config.Auth = auths
+	}
+
+	// 隧道通道不支持SetDeadline，统一通过关闭连接实现握手超时
+	timer := time.AfterFunc(probeTimeout, func() { conn.Close() })
+	c, chans, reqs, err := ssh.NewClientConn(conn, address, config)
+	timer.Stop()
+	if err == nil {
+		ssh.NewClient(c, chans, reqs).Close()
+	}
+
+	switch {
+	case err == nil || (handshaked.Load() && !checkAuth):
+		// 未校验凭据时认证失败是预期结果
+		status.State = probeOnline
+		status.LastSeen = &now
+	case handshaked.Load():
+		status.State = probeAuthFailed
+		status.LastError = err.Error()
+		status.LastSeen = &now
+	default:
+		status.State = probeSSHError
+		status.LastError = err.Error()
+	}
+	return status
+}
+
+// probeJumpClient 获取探测用的跳板连接：优先复用连接池中已建立的连接（reused为true，不可关闭）；
+// 没有时仅在checkAuth为true时登录跳板链（每一跳只校验不记录主机密钥），否则返回nil，不做任何认证
+func probeJumpClient(jumpServer *storage.Server, targetID string, checkAuth bool) (*ssh.Client, bool, error) {
+	if client := GetSSHPool().Existing(jumpServer.ID); client != nil {
+		return client, true, nil
+	}
+	if !checkAuth {
+		return nil, false, nil
+	}
+	client, err := dialSSHWith(jumpServer, []string{targetID}, probeHostKeyCallback)
+	return client, false, err
+}
+
+// probeHostKeyCallback 只校验已记录的主机密钥，不记录新密钥（首次信任留给用户的实际连接）
+func probeHostKeyCallback(server *storage.Server) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		known, err := storage.GetKnownHost(server.ID)
+		if err != nil {
+			return fmt.Errorf("读取主机密钥记录失败: %v", err)
+		}
+		fingerprint := ssh.FingerprintSHA256(key)
+		if known != nil && known.Fingerprint != fingerprint {
+			return &HostKeyMismatchError{
+				ServerID: server.ID,
+				Address:  hostname,
+				Expected: known.Fingerprint,
+				Actual:   fingerprint,
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// GinGetServerStatuses 获取所有服务器的最近一次探测结果
+func (h *ServerHandler) GinGetServerStatuses(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": GetServerProber().Statuses()})
+}
+
+// GinProbeServer 立即探测一台服务器
+func (h *ServerHandler) GinProbeServer(c *gin.Context) {
+	id := c.Query("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "缺少id参数"})
+		return
+	}
+
+	status, err := GetServerProber().Probe(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": status})
+}
+
+// GinServerStatusStream 通过WebSocket推送服务器状态
+// 连接后先发送 {"type":"snapshot"} 全量状态，之后每次探测完成发送 {"type":"status"}
+// 客户端可发送 {"type":"probe","server_id":"..."} 立即探测
+func (h *ServerHandler) GinServerStatusStream(c *gin.Context) {
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool { return true },
+	}
+	wsConn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Println("WebSocket升级失败:", err)
+		return
+	}
+	defer wsConn.Close()
+
+	ws := &chatConn{ws: wsConn}
+	prober := GetServerProber()
+
+	// 先订阅再发送快照，避免漏掉两者之间完成的探测
+	updates, unsubscribe := prober.Subscribe()
+	defer unsubscribe()
+
+	if err := ws.WriteJSON(gin.H{"type": "snapshot", "data": prober.Statuses()}); err != nil {
+		return
+	}
+
+	// 读取协程：心跳与手动探测
+	stop := make(chan struct{})
+	go func() {
+		defer close(stop)
+		for {
+			var msg struct {
+				Type     string `json:"type"`
+				ServerID string `json:"server_id"`
+			}
+			if err := wsConn.ReadJSON(&msg); err != nil {
+				return
+			}
+			switch msg.Type {
+			case "ping":
+				ws.WriteJSON(gin.H{"type": "pong"})
+			case "probe":
+				// 结果通过订阅推送
+				go func(id string) {
+					if _, err := prober.Probe(id); err != nil {
+						ws.WriteJSON(gin.H{"type": "error", "server_id": id, "error": err.Error()})
+					}
+				}(msg.ServerID)
+			}
+		}
+	}()
+
+	for {
+		select {
+		case status := <-updates:
+			if err := ws.WriteJSON(gin.H{"type": "status", "data": status}); err != nil {
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,855 @@
+package handlers
+
+import (
+	"all_project/storage"
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 服务器导入导出格式
+const (
+	transferSSHConfig = "ssh_config"
+	transferCSV       = "csv"
+	transferJSON      = "json"
+)
+
+// maxImportSize 导入文件大小上限
+const maxImportSize = 5 * 1024 * 1024
+
+// 导入预览中每一项的状态
+const (
+	importNew       = "new"       // 将被创建
+	importDuplicate = "duplicate" // 与已有服务器（或文件中靠前的一项）host:port:user 相同，跳过
+	importInvalid   = "invalid"   // 缺少必填项或跳板机无法解析，跳过
+)
+
+// csvColumns CSV导出的列（导入时列名不区分大小写，另接受下方别名）
+var csvColumns = []string{
+	"name", "host", "port", "username", "auth_methods", "private_key_path",
+	"jump_host", "tags", "description", "password", "private_key", "passphrase",
+}
+
+var csvColumnAliases = map[string]string{
+	"hostname":      "host",
+	"user":          "username",
+	"identity_file": "private_key_path",
+	"identityfile":  "private_key_path",
+	"proxy_jump":    "jump_host",
+	"proxyjump":     "jump_host",
+}
+
+// ImportItem 导入预览中的一项
+type ImportItem struct {
+	Line        int            `json:"line"` // 源文件行号（JSON为数组下标+1）
+	Server      storage.Server `json:"server"`
+	Status      string         `json:"status"`
+	DuplicateOf string         `json:"duplicate_of,omitempty"` // 重复时对应的服务器名称
+	JumpHost    string         `json:"jump_host,omitempty"`    // 源文件中的跳板机引用
+	Error       string         `json:"error,omitempty"`
+}
+
+// ImportResult 导入结果（dry_run 时只预览不写入）
+type ImportResult struct {
+	DryRun     bool         `json:"dry_run"`
+	Format     string       `json:"format"`
+	Items      []ImportItem `json:"items"`
+	Created    int          `json:"created"`
+	Duplicates int          `json:"duplicates"`
+	Invalid    int          `json:"invalid"`
+	Warnings   []string     `json:"warnings,omitempty"`
+}
+
+// importEntry 解析出的一条服务器记录（尚未去重和解析跳板机）
+type importEntry struct {
+	line      int
+	server    storage.Server
+	alias     string // ssh config 的 Host 别名 / 服务器名称，供跳板机引用
+	oldID     string // JSON 导入时原来的ID，供 jump_host_id 引用
+	jumpRef   string
+	jumpChain []string // 多级 ProxyJump 中最后一跳之前的跳板（按连接顺序）
+	parseErr  string
+}
+
+// GinImportServers 导入服务器（ssh config / CSV / JSON）
+// 参数：format（缺省按文件扩展名判断）、dry_run=true 只预览、default_user 用于未指定User的条目、
+// group_id 导入到的分组（条目自身未指定分组时生效，未设置的用户名和端口从分组继承）
+// 文件通过 multipart 字段 file 上传，或直接作为请求体
+func (h *ServerHandler) GinImportServers(c *gin.Context) {
+	data, filename, err := readImportData(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	format := c.Query("format")
+	if format == "" {
+		format = guessTransferFormat(filename)
+	}
+
+	var entries []importEntry
+	var warnings []string
+	switch format {
+	case transferSSHConfig:
+		entries, warnings = parseSSHConfig(data)
+	case transferCSV:
+		entries, err = parseServersCSV(data)
+	case transferJSON:
+		entries, err = parseServersJSON(data)
+	default:
+		err = fmt.Errorf("不支持的格式: %s（可选 ssh_config/csv/json）", format)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	existing, err := storage.GetServers()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	groups, err := storage.GetServerGroups()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	if groupID := c.Query("group_id"); groupID != "" {
+		if len(storage.GroupPath(groups, groupID)) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "分组不存在"})
+			return
+		}
+		for i := range entries {
+			if entries[i].server.GroupID == "" {
+				entries[i].server.GroupID = groupID
+			}
+		}
+	}
+
+	dryRun := c.Query("dry_run") == "true" || c.Query("dry_run") == "1"
+	result := planImport(entries, existing, groups, strings.TrimSpace(c.Query("default_user")))
+	result.DryRun = dryRun
+	result.Format = format
+	result.Warnings = append(warnings, result.Warnings...)
+
+	if !dryRun {
+		var servers []storage.Server
+		for _, item := range result.Items {
+			if item.Status == importNew {
+				servers = append(servers, item.Server)
+			}
+		}
+		if len(servers) > 0 {
+			if err := storage.CreateServers(servers); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+				return
+			}
+		}
+		result.Created = len(servers)
+	}
+
+	for i := range result.Items {
+		result.Items[i].Server = result.Items[i].Server.Masked()
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": result})
+}
+
+// GinExportServers 导出服务器（format=ssh_config/csv/json，include_secrets=true 时包含明文密码和私钥）
+func (h *ServerHandler) GinExportServers(c *gin.Context) {
+	servers, err := storage.GetServers()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	format := c.DefaultQuery("format", transferJSON)
+
+	// ssh_config和CSV没有分组概念，导出继承后的实际连接参数
+	if format == transferSSHConfig || format == transferCSV {
+		for i := range servers {
+			if servers[i], err = storage.ApplyGroupDefaults(servers[i]); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+				return
+			}
+		}
+	}
+
+	includeSecrets := c.Query("include_secrets") == "true" || c.Query("include_secrets") == "1"
+	if !includeSecrets {
+		for i := range servers {
+			servers[i].Password = ""
+			servers[i].PrivateKey = ""
+			servers[i].Passphrase = ""
+		}
+	}
+
+	var data []byte
+	var filename, contentType string
+	switch format {
+	case transferSSHConfig:
+		data = []byte(formatSSHConfig(servers))
+		filename, contentType = "ssh_config", "text/plain; charset=utf-8"
+	case transferCSV:
+		data, err = formatServersCSV(servers)
+		filename, contentType = "servers.csv", "text/csv; charset=utf-8"
+	case transferJSON:
+		data, err = json.MarshalIndent(servers, "", "  ")
+		filename, contentType = "servers.json", "application/json"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "不支持的格式: " + format})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// readImportData 读取上传的文件（multipart字段file，或整个请求体）
+func readImportData(c *gin.Context) (string, string, error) {
+	var reader io.Reader
+	filename := ""
+	if file, header, err := c.Request.FormFile("file"); err == nil {
+		defer file.Close()
+		reader = file
+		filename = header.Filename
+	} else {
+		reader = c.Request.Body
+	}
+
+	data, err := io.ReadAll(io.LimitReader(reader, maxImportSize+1))
+	if err != nil {
+		return "", "", fmt.Errorf("读取文件失败: %v", err)
+	}
+	if len(data) > maxImportSize {
+		return "", "", fmt.Errorf("文件超过 %dMB", maxImportSize/1024/1024)
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return "", "", fmt.Errorf("文件为空")
+	}
+	// 去掉Excel等工具写入的BOM
+	return string(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))), filename, nil
+}
+
+// guessTransferFormat 根据文件扩展名判断格式
+func guessTransferFormat(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		return transferCSV
+	case ".json":
+		return transferJSON
+	default:
+		return transferSSHConfig
+	}
+}
+
+// planImport 校验、去重（host:port:user）并解析跳板机引用，为新服务器分配ID
+// 校验和去重使用继承分组默认值后的连接参数；分组已提供的用户名和端口保持为空，以便随分组修改
+func planImport(entries []importEntry, existing []storage.Server, groups []storage.ServerGroup, defaultUser string) ImportResult {
+	result := ImportResult{Items: make([]ImportItem, len(entries))}
+
+	// 所有条目（含重复项）最终对应的服务器ID，供跳板机引用解析
+	resolvedIDs := make([]string, len(entries))
+	// 各条目继承分组默认值后的连接参数，供跳板机按 [user@]host[:port] 匹配
+	effective := make([]storage.Server, len(entries))
+
+	existingEffective := make([]storage.Server, len(existing))
+	byKey := make(map[string]storage.Server)
+	for i, s := range existing {
+		existingEffective[i] = storage.InheritGroupDefaults(s, groups)
+		if existingEffective[i].Port == 0 {
+			existingEffective[i].Port = 22
+		}
+		byKey[serverDedupKey(existingEffective[i])] = s
+	}
+	fileKeys := make(map[string]int)
+
+	for i := range entries {
+		entry := &entries[i]
+		server := entry.server
+		item := ImportItem{Line: entry.line, JumpHost: entry.jumpRef}
+
+		inherited := storage.InheritGroupDefaults(server, groups)
+		if inherited.Username == "" {
+			server.Username = defaultUser
+			inherited.Username = defaultUser
+		}
+		if inherited.Port == 0 {
+			server.Port = 22
+			inherited.Port = 22
+		}
+		effective[i] = inherited
+
+		switch {
+		case entry.parseErr != "":
+			item.Status, item.Error = importInvalid, entry.parseErr
+		case server.Host == "":
+			item.Status, item.Error = importInvalid, "缺少主机地址"
+		case inherited.Username == "":
+			item.Status, item.Error = importInvalid, "缺少用户名（可通过 default_user 或分组指定）"
+		case inherited.Port < 1 || inherited.Port > 65535:
+			item.Status, item.Error = importInvalid, fmt.Sprintf("端口无效: %d", inherited.Port)
+		}
+
+		if item.Status == "" {
+			key := serverDedupKey(inherited)
+			if dup, ok := byKey[key]; ok {
+				item.Status, item.DuplicateOf = importDuplicate, dup.Name
+				resolvedIDs[i] = dup.ID
+			} else if j, ok := fileKeys[key]; ok {
+				item.Status, item.DuplicateOf = importDuplicate, result.Items[j].Server.Name
+				resolvedIDs[i] = resolvedIDs[j]
+			} else {
+				item.Status = importNew
+				server.ID = generateID()
+				if server.Name == "" {
+					server.Name = server.Host
+				}
+				if server.Tags == nil {
+					server.Tags = []string{}
+				}
+				resolvedIDs[i] = server.ID
+				fileKeys[key] = i
+			}
+		}
+
+		server.JumpHostID = ""
+		item.Server = server
+		result.Items[i] = item
+	}
+
+	// 跳板机引用：文件中的别名/名称、JSON中的原ID、已有服务器的名称/ID、[user@]host[:port]
+	lookup := func(ref string) string {
+		for i, entry := range entries {
+			if resolvedIDs[i] == "" {
+				continue
+			}
+			if (entry.alias != "" && strings.EqualFold(entry.alias, ref)) || (entry.oldID != "" && entry.oldID == ref) {
+				return resolvedIDs[i]
+			}
+		}
+		for _, s := range existing {
+			if s.ID == ref || strings.EqualFold(s.Name, ref) {
+				return s.ID
+			}
+		}
+
+		user, host, port := splitJumpRef(ref)
+		for i := range result.Items {
+			if resolvedIDs[i] != "" && matchesJumpRef(effective[i], user, host, port) {
+				return resolvedIDs[i]
+			}
+		}
+		for i, s := range existingEffective {
+			if matchesJumpRef(s, user, host, port) {
+				return existing[i].ID
+			}
+		}
+		return ""
+	}
+
+	jumps := make(map[string]string) // 服务器ID → 跳板机ID（已有 + 新建）
+	for _, s := range existing {
+		if s.JumpHostID != "" {
+			jumps[s.ID] = s.JumpHostID
+		}
+	}
+	for i, entry := range entries {
+		item := &result.Items[i]
+		if item.Status != importNew || entry.jumpRef == "" {
+			continue
+		}
+		jumpID := lookup(entry.jumpRef)
+		if jumpID == "" {
+			item.Status, item.Error = importInvalid, "跳板机不存在: "+entry.jumpRef
+			continue
+		}
+		item.Server.JumpHostID = jumpID
+		jumps[item.Server.ID] = jumpID
+	}
+
+	// 多级 ProxyJump（a,b）：b 经 a 连接。b 为本次新建且未指定跳板机时补上；
+	// 无法解析的跳板或已有不同设置的服务器不做修改，只给出提示
+	newIndex := make(map[string]int) // 新建服务器ID → 条目下标
+	for i, item := range result.Items {
+		if item.Status == importNew {
+			newIndex[item.Server.ID] = i
+		}
+	}
+	for i, entry := range entries {
+		if result.Items[i].Status != importNew || len(entry.jumpChain) == 0 {
+			continue
+		}
+		hops := append(append([]string{}, entry.jumpChain...), entry.jumpRef)
+		for k := len(hops) - 1; k > 0; k-- {
+			hopID, prevID := lookup(hops[k]), lookup(hops[k-1])
+			if hopID == "" || prevID == "" {
+				missing := hops[k-1]
+				if hopID == "" {
+					missing = hops[k]
+				}
+				result.Warnings = append(result.Warnings, fmt.Sprintf("%s：ProxyJump 中的跳板 %s 不存在，%s 之前的跳板需手动设置", entry.alias, missing, hops[k]))
+				break
+			}
+			if jumps[hopID] == prevID {
+				continue
+			}
+			j, isNew := newIndex[hopID]
+			switch {
+			case isNew && jumps[hopID] == "":
+				result.Items[j].Server.JumpHostID = prevID
+				jumps[hopID] = prevID
+			case isNew:
+				result.Warnings = append(result.Warnings, fmt.Sprintf("%s：ProxyJump 要求 %s 经 %s 连接，但其已指定其他跳板机，未修改", entry.alias, hops[k], hops[k-1]))
+			default:
+				result.Warnings = append(result.Warnings, fmt.Sprintf("%s：ProxyJump 要求已有服务器 %s 经 %s 连接，导入不修改已有服务器，请手动设置", entry.alias, hops[k], hops[k-1]))
+			}
+		}
+	}
+
+	// 跳板链循环检测（引用了被判为无效的条目时同样视为无效）
+	valid := make(map[string]bool)
+	for _, s := range existing {
+		valid[s.ID] = true
+	}
+	for _, item := range result.Items {
+		if item.Status == importNew {
+			valid[item.Server.ID] = true
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := range result.Items {
+			item := &result.Items[i]
+			if item.Status != importNew {
+				continue
+			}
+			if err := checkJumpChain(item.Server.ID, jumps, valid); err != nil {
+				item.Status, item.Error = importInvalid, err.Error()
+				item.Server.JumpHostID = ""
+				delete(valid, item.Server.ID)
+				delete(jumps, item.Server.ID)
+				changed = true
+			}
+		}
+	}
+
+	for _, item := range result.Items {
+		switch item.Status {
+		case importDuplicate:
+			result.Duplicates++
+		case importInvalid:
+			result.Invalid++
+		}
+	}
+	return result
+}
+
+// checkJumpChain 检查跳板链是否存在循环、超过层数或引用了无效服务器
+func checkJumpChain(id string, jumps map[string]string, valid map[string]bool) error {
+	visited := []string{id}
+	for next := jumps[id]; next != ""; next = jumps[next] {
+		if !valid[next] {
+			return fmt.Errorf("跳板机无效: %s", next)
+		}
+		for _, v := range visited {
+			if v == next {
+				return fmt.Errorf("跳板机配置存在循环引用")
+			}
+		}
+		if len(visited) > maxJumpHops {
+			return fmt.Errorf("跳板链超过最大层数 %d", maxJumpHops)
+		}
+		visited = append(visited, next)
+	}
+	return nil
+}
+
+// serverDedupKey 去重键 host:port:user（主机名不区分大小写）
+func serverDedupKey(s storage.Server) string {
+	port := s.Port
+	if port == 0 {
+		port = 22
+	}
+	return fmt.Sprintf("%s:%d:%s", strings.ToLower(s.Host), port, s.Username)
+}
+
+// splitJumpRef 解析 [user@]host[:port]（port为0表示未指定）
+func splitJumpRef(ref string) (string, string, int) {
+	user := ""
+	if i := strings.LastIndex(ref, "@"); i >= 0 {
+		user, ref = ref[:i], ref[i+1:]
+	}
+	host, port := ref, 0
+	if h, p, err := splitHostPort(ref); err == nil {
+		host, port = h, p
+	}
+	return user, host, port
+}
+
+// splitHostPort 拆分 host:port（支持 [IPv6]:port）
+func splitHostPort(s string) (string, int, error) {
+	i := strings.LastIndex(s, ":")
+	if i < 0 || strings.Count(s, ":") > 1 && !strings.HasPrefix(s, "[") {
+		return "", 0, fmt.Errorf("缺少端口")
+	}
+	port, err := strconv.Atoi(s[i+1:])
+	if err != nil {
+		return "", 0, err
+	}
+	return strings.Trim(s[:i], "[]"), port, nil
+}
+
+func matchesJumpRef(s storage.Server, user, host string, port int) bool {
+	if !strings.EqualFold(s.Host, host) {
+		return false
+	}
+	if port != 0 && s.Port != port {
+		return false
+	}
+	return user == "" || s.Username == user
+}
+
+// ==================== ssh config ====================
+
+// sshConfigBlock Host 块（文件开头 Host 之前的配置视为 Host *）
+type sshConfigBlock struct {
+	patterns []string
+	options  map[string]string // 小写关键字 → 值（块内首次出现的值生效）
+}
+
+// parseSSHConfig 解析 OpenSSH 客户端配置
+// 按 ssh 的规则，同一别名匹配的多个 Host 块中首次出现的值生效；
+// 含通配符的 Host 只作为默认值，不生成服务器；Match 块和 Include 不支持，忽略并给出提示
+func parseSSHConfig(data string) ([]importEntry, []string) {
+	var warnings []string
+	global := &sshConfigBlock{patterns: []string{"*"}, options: make(map[string]string)}
+	blocks := []*sshConfigBlock{global}
+	current := global
+
+	type aliasLine struct {
+		alias string
+		line  int
+	}
+	var aliases []aliasLine
+	seen := make(map[string]bool)
+
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), maxImportSize)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value := splitSSHConfigLine(line)
+		switch key {
+		case "host":
+			current = &sshConfigBlock{patterns: strings.Fields(value), options: make(map[string]string)}
+			blocks = append(blocks, current)
+			for _, p := range current.patterns {
+				if strings.ContainsAny(p, "*?!") || seen[p] {
+					continue
+				}
+				seen[p] = true
+				aliases = append(aliases, aliasLine{p, lineNo})
+			}
+		case "match":
+			warnings = append(warnings, fmt.Sprintf("第%d行：不支持 Match 块，已忽略", lineNo))
+			current = nil
+		case "include":
+			warnings = append(warnings, fmt.Sprintf("第%d行：不支持 Include，已忽略", lineNo))
+		default:
+			if current != nil && value != "" {
+				if _, ok := current.options[key]; !ok {
+					current.options[key] = value
+				}
+			}
+		}
+	}
+
+	entries := make([]importEntry, 0, len(aliases))
+	for _, a := range aliases {
+		opts := make(map[string]string)
+		for _, block := range blocks {
+			if !sshConfigMatch(a.alias, block.patterns) {
+				continue
+			}
+			for k, v := range block.options {
+				if _, ok := opts[k]; !ok {
+					opts[k] = v
+				}
+			}
+		}
+
+		entry := importEntry{line: a.line, alias: a.alias}
+		entry.server = storage.Server{
+			Name:           a.alias,
+			Host:           strings.ReplaceAll(opts["hostname"], "%h", a.alias),
+			Username:       opts["user"],
+			PrivateKeyPath: opts["identityfile"],
+		}
+		if entry.server.Host == "" {
+			entry.server.Host = a.alias
+		}
+		if p, ok := opts["port"]; ok {
+			port, err := strconv.Atoi(p)
+			if err != nil {
+				entry.parseErr = "端口无效: " + p
+			}
+			entry.server.Port = port
+		}
+		if jump := opts["proxyjump"]; jump != "" && !strings.EqualFold(jump, "none") {
+			hops := strings.Split(jump, ",")
+			// 多级跳板：目标经过最后一跳，前面的跳板在planImport中设置为下一跳的跳板机
+			entry.jumpRef = strings.TrimSpace(hops[len(hops)-1])
+			for _, hop := range hops[:len(hops)-1] {
+				entry.jumpChain = append(entry.jumpChain, strings.TrimSpace(hop))
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, warnings
+}
+
+// splitSSHConfigLine 拆分 "Keyword value" 或 "Keyword=value"，关键字转小写，值去掉引号
+func splitSSHConfigLine(line string) (string, string) {
+	i := strings.IndexAny(line, " \t=")
+	if i < 0 {
+		return strings.ToLower(line), ""
+	}
+	key := strings.ToLower(line[:i])
+	value := strings.TrimSpace(line[i:])
+	value = strings.TrimSpace(strings.TrimPrefix(value, "="))
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	return key, value
+}
+
+// sshConfigMatch 别名是否匹配 Host 模式列表（! 开头的模式匹配时整个块不生效）
+func sshConfigMatch(alias string, patterns []string) bool {
+	matched := false
+	for _, p := range patterns {
+		negate := strings.HasPrefix(p, "!")
+		p = strings.TrimPrefix(p, "!")
+		if ok, _ := path.Match(p, alias); ok {
+			if negate {
+				return false
+			}
+			matched = true
+		}
+	}
+	return matched
+}
+
+// formatSSHConfig 导出为 ssh config（密码等无法表示的字段写为注释）
+func formatSSHConfig(servers []storage.Server) string {
+	aliases := exportAliases(servers)
+
+	var sb strings.Builder
+	sb.WriteString("# 由 WebSSH 导出\n")
+	for _, s := range servers {
+		sb.WriteString("\n")
+		if s.Description != "" {
+			sb.WriteString("# " + strings.ReplaceAll(s.Description, "\n", " ") + "\n")
+		}
+		if len(s.Tags) > 0 {
+			sb.WriteString("# tags: " + strings.Join(s.Tags, ", ") + "\n")
+		}
+		fmt.Fprintf(&sb, "Host %s\n", aliases[s.ID])
+		fmt.Fprintf(&sb, "    HostName %s\n", s.Host)
+		if s.Port != 0 && s.Port != 22 {
+			fmt.Fprintf(&sb, "    Port %d\n", s.Port)
+		}
+		fmt.Fprintf(&sb, "    User %s\n", s.Username)
+		if s.PrivateKeyPath != "" {
+			fmt.Fprintf(&sb, "    IdentityFile %s\n", quoteSSHConfig(s.PrivateKeyPath))
+		}
+		if jump, ok := aliases[s.JumpHostID]; ok && s.JumpHostID != "" {
+			fmt.Fprintf(&sb, "    ProxyJump %s\n", jump)
+		}
+	}
+	return sb.String()
+}
+
+// exportAliases 为每台服务器生成唯一的 Host 别名（名称中的空白替换为-）
+func exportAliases(servers []storage.Server) map[string]string {
+	aliases := make(map[string]string)
+	used := make(map[string]bool)
+	for _, s := range servers {
+		alias := strings.Join(strings.Fields(s.Name), "-")
+		alias = strings.NewReplacer("*", "-", "?", "-", "!", "-", "#", "-").Replace(alias)
+		if alias == "" {
+			alias = s.Host
+		}
+		unique := alias
+		for n := 2; used[strings.ToLower(unique)]; n++ {
+			unique = fmt.Sprintf("%s-%d", alias, n)
+		}
+		used[strings.ToLower(unique)] = true
+		aliases[s.ID] = unique
+	}
+	return aliases
+}
+
+func quoteSSHConfig(value string) string {
+	if strings.ContainsAny(value, " \t") {
+		return `"` + value + `"`
+	}
+	return value
+}
+
+// ==================== CSV ====================
+
+// parseServersCSV 解析CSV（首行为列名，必须包含 host；多个标签或认证方式以 ; 分隔）
+func parseServersCSV(data string) ([]importEntry, error) {
+	reader := csv.NewReader(strings.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("读取CSV列名失败: %v", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if alias, ok := csvColumnAliases[name]; ok {
+			name = alias
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["host"]; !ok {
+		return nil, fmt.Errorf("CSV缺少 host 列")
+	}
+
+	var entries []importEntry
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("解析CSV失败: %v", err)
+		}
+		line, _ := reader.FieldPos(0)
+
+		get := func(column string) string {
+			if i, ok := columns[column]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		if strings.Join(record, "") == "" {
+			continue
+		}
+
+		entry := importEntry{line: line, alias: get("name"), jumpRef: get("jump_host")}
+		entry.server = storage.Server{
+			Name:           get("name"),
+			Host:           get("host"),
+			Username:       get("username"),
+			Password:       get("password"),
+			AuthMethods:    splitList(get("auth_methods")),
+			PrivateKey:     get("private_key"),
+			PrivateKeyPath: get("private_key_path"),
+			Passphrase:     get("passphrase"),
+			Description:    get("description"),
+			Tags:           splitList(get("tags")),
+		}
+		if p := get("port"); p != "" {
+			port, err := strconv.Atoi(p)
+			if err != nil {
+				entry.parseErr = "端口无效: " + p
+			}
+			entry.server.Port = port
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// formatServersCSV 导出为CSV（跳板机以名称表示）
+func formatServersCSV(servers []storage.Server) ([]byte, error) {
+	names := make(map[string]string)
+	for _, s := range servers {
+		names[s.ID] = s.Name
+	}
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write(csvColumns)
+	for _, s := range servers {
+		writer.Write([]string{
+			s.Name, s.Host, strconv.Itoa(s.Port), s.Username,
+			strings.Join(s.AuthMethods, ";"), s.PrivateKeyPath,
+			names[s.JumpHostID], strings.Join(s.Tags, ";"), s.Description,
+			s.Password, s.PrivateKey, s.Passphrase,
+		})
+	}
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+// splitList 拆分以 ; 或 | 分隔的列表
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.FieldsFunc(value, func(r rune) bool { return r == ';' || r == '|' }) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// ==================== JSON ====================
+
+// parseServersJSON 解析JSON（导出格式：服务器数组，jump_host_id 引用文件中的原ID或已有服务器）
+func parseServersJSON(data string) ([]importEntry, error) {
+	var servers []storage.Server
+	if err := json.Unmarshal([]byte(data), &servers); err != nil {
+		return nil, fmt.Errorf("解析JSON失败: %v", err)
+	}
+
+	// 分组不存在时导入为未分组
+	groups, err := storage.GetServerGroups()
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(groups))
+	for _, g := range groups {
+		known[g.ID] = true
+	}
+
+	entries := make([]importEntry, len(servers))
+	for i, s := range servers {
+		if !known[s.GroupID] {
+			s.GroupID = ""
+		}
+		entries[i] = importEntry{
+			line:    i + 1,
+			server:  s,
+			alias:   s.Name,
+			oldID:   s.ID,
+			jumpRef: s.JumpHostID,
+		}
+		entries[i].server.ID = ""
+	}
+	return entries, nil
+}
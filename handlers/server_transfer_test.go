@@ -0,0 +1,481 @@
+package handlers
+
+import (
+	"all_project/storage"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// parsedEntry 测试中比较的条目字段
+type parsedEntry struct {
+	Line     int
+	Alias    string
+	Host     string
+	Port     int
+	User     string
+	KeyPath  string
+	JumpRef  string
+	Chain    []string
+	ParseErr string
+}
+
+func summarizeEntries(entries []importEntry) []parsedEntry {
+	var result []parsedEntry
+	for _, e := range entries {
+		result = append(result, parsedEntry{
+			Line:     e.line,
+			Alias:    e.alias,
+			Host:     e.server.Host,
+			Port:     e.server.Port,
+			User:     e.server.Username,
+			KeyPath:  e.server.PrivateKeyPath,
+			JumpRef:  e.jumpRef,
+			Chain:    e.jumpChain,
+			ParseErr: e.parseErr,
+		})
+	}
+	return result
+}
+
+func TestParseSSHConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		want     []parsedEntry
+		warnings []string // 每条提示需包含的内容
+	}{
+		{
+			name: "通配符Host提供默认值，首次出现的值生效",
+			config: `Host web1
+    HostName 10.0.0.1
+    User deploy
+
+Host web*
+    Port 2222
+    User nobody
+    IdentityFile ~/.ssh/web
+
+Host *
+    Port 22
+`,
+			want: []parsedEntry{
+				{Line: 1, Alias: "web1", Host: "10.0.0.1", Port: 2222, User: "deploy", KeyPath: "~/.ssh/web"},
+			},
+		},
+		{
+			name: "文件开头的全局配置优先于后面的Host *",
+			config: `Port 2200
+Host db
+    HostName db.internal
+Host *
+    Port 22
+    User admin
+`,
+			want: []parsedEntry{
+				{Line: 2, Alias: "db", Host: "db.internal", Port: 2200, User: "admin"},
+			},
+		},
+		{
+			name: "HostName中的%h与多个别名",
+			config: `Host app1 app2
+    HostName %h.example.com
+Host *.example.com
+    User ignored
+`,
+			want: []parsedEntry{
+				{Line: 1, Alias: "app1", Host: "app1.example.com"},
+				{Line: 1, Alias: "app2", Host: "app2.example.com"},
+			},
+		},
+		{
+			name: "否定模式与等号语法",
+			config: `Host * !bastion
+    ProxyJump=bastion
+Host bastion
+    HostName="1.2.3.4"
+Host inner
+`,
+			want: []parsedEntry{
+				{Line: 3, Alias: "bastion", Host: "1.2.3.4"},
+				{Line: 5, Alias: "inner", Host: "inner", JumpRef: "bastion"},
+			},
+		},
+		{
+			name: "多级ProxyJump保留前面的跳板",
+			config: `Host target
+    ProxyJump ops@edge:2222, mid1,mid
+Host none
+    ProxyJump none
+`,
+			want: []parsedEntry{
+				{Line: 1, Alias: "target", Host: "target", JumpRef: "mid", Chain: []string{"ops@edge:2222", "mid1"}},
+				{Line: 3, Alias: "none", Host: "none"},
+			},
+		},
+		{
+			name: "Match与Include被忽略，端口无效",
+			config: `Include ~/.ssh/conf.d/*
+Host bad
+    Port abc
+Match host bad
+    User skipped
+`,
+			want: []parsedEntry{
+				{Line: 2, Alias: "bad", Host: "bad", ParseErr: "端口无效: abc"},
+			},
+			warnings: []string{"第1行：不支持 Include", "第4行：不支持 Match"},
+		},
+		{
+			name: "重复的别名只导入一次",
+			config: `Host a
+    HostName first
+Host a
+    HostName second
+`,
+			want: []parsedEntry{
+				{Line: 1, Alias: "a", Host: "first"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, warnings := parseSSHConfig(tt.config)
+			if got := summarizeEntries(entries); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("条目 = %+v\n期望 %+v", got, tt.want)
+			}
+			if len(warnings) != len(tt.warnings) {
+				t.Fatalf("提示 = %q，期望 %d 条", warnings, len(tt.warnings))
+			}
+			for i, w := range tt.warnings {
+				if !strings.Contains(warnings[i], w) {
+					t.Errorf("提示[%d] = %q，期望包含 %q", i, warnings[i], w)
+				}
+			}
+		})
+	}
+}
+
+func TestParseServersCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		csv     string
+		want    []parsedEntry
+		wantErr string
+	}{
+		{
+			name: "导出格式的列",
+			csv: "name,host,port,username,auth_methods,private_key_path,jump_host,tags,description,password,private_key,passphrase\n" +
+				"web,10.0.0.1,2222,deploy,key;password,~/.ssh/id,bastion,prod;web,前端,secret,,\n",
+			want: []parsedEntry{
+				{Line: 2, Alias: "web", Host: "10.0.0.1", Port: 2222, User: "deploy", KeyPath: "~/.ssh/id", JumpRef: "bastion"},
+			},
+		},
+		{
+			name: "列名别名、大小写与空行",
+			csv:  "HostName, User ,IdentityFile,ProxyJump\n\n db.internal ,admin,,ops@edge:2222\n,,,\n",
+			want: []parsedEntry{
+				{Line: 3, Host: "db.internal", User: "admin", JumpRef: "ops@edge:2222"},
+			},
+		},
+		{
+			name: "列数不足与端口无效",
+			csv:  "host,port,username\nshort\nbad,abc,root\n",
+			want: []parsedEntry{
+				{Line: 2, Host: "short"},
+				{Line: 3, Host: "bad", User: "root", ParseErr: "端口无效: abc"},
+			},
+		},
+		{
+			name:    "缺少host列",
+			csv:     "name,username\nweb,root\n",
+			wantErr: "缺少 host 列",
+		},
+		{
+			name:    "引号不匹配",
+			csv:     "host\n\"unterminated\n",
+			wantErr: "解析CSV失败",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := parseServersCSV(tt.csv)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("错误 = %v，期望包含 %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("解析失败: %v", err)
+			}
+			if got := summarizeEntries(entries); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("条目 = %+v\n期望 %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseServersCSVFields(t *testing.T) {
+	entries, err := parseServersCSV("host,tags,auth_methods,password\nh, a; b |c ,key,pw\n")
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("解析结果 = %v, %v", entries, err)
+	}
+	s := entries[0].server
+	if !reflect.DeepEqual(s.Tags, []string{"a", "b", "c"}) || !reflect.DeepEqual(s.AuthMethods, []string{"key"}) || s.Password != "pw" {
+		t.Errorf("服务器 = %+v", s)
+	}
+}
+
+// newEntry 构造导入条目（alias同时作为名称）
+func newEntry(alias, user, host string, port int, jumpRef string) importEntry {
+	return importEntry{
+		alias:   alias,
+		jumpRef: jumpRef,
+		server:  storage.Server{Name: alias, Host: host, Port: port, Username: user},
+	}
+}
+
+// withJumpChain 设置多级 ProxyJump 中最后一跳之前的跳板
+func withJumpChain(entry importEntry, hops ...string) importEntry {
+	entry.jumpChain = hops
+	return entry
+}
+
+func TestPlanImport(t *testing.T) {
+	groups := []storage.ServerGroup{
+		{ID: "g-root", Name: "生产", Username: "ops", Port: 2222},
+		{ID: "g-child", Name: "数据库", ParentID: "g-root", Username: "dba"},
+	}
+	existing := []storage.Server{
+		{ID: "s-bastion", Name: "Bastion", Host: "bastion.example.com", Port: 22, Username: "jump"},
+		{ID: "s-grouped", Name: "grouped", Host: "10.0.0.9", GroupID: "g-root"},
+		{ID: "s-app", Name: "app", Host: "10.0.0.8", Port: 22, Username: "root", JumpHostID: "s-app-jump"},
+		{ID: "s-app-jump", Name: "app-jump", Host: "10.0.0.7", Port: 22, Username: "root"},
+	}
+
+	tests := []struct {
+		name        string
+		entries     []importEntry
+		defaultUser string
+		want        []string // 各条目的状态
+		warnings    []string // 每条提示需包含的内容
+		check       func(t *testing.T, items []ImportItem)
+	}{
+		{
+			name:        "补默认用户名和端口",
+			entries:     []importEntry{newEntry("", "", "10.0.0.1", 0, "")},
+			defaultUser: "root",
+			want:        []string{importNew},
+			check: func(t *testing.T, items []ImportItem) {
+				s := items[0].Server
+				if s.Username != "root" || s.Port != 22 || s.Name != "10.0.0.1" || s.ID == "" || s.Tags == nil {
+					t.Errorf("服务器 = %+v", s)
+				}
+			},
+		},
+		{
+			name: "缺少必填项",
+			entries: []importEntry{
+				newEntry("a", "", "10.0.0.1", 22, ""),
+				newEntry("b", "root", "", 22, ""),
+				newEntry("c", "root", "10.0.0.3", 70000, ""),
+				{server: storage.Server{Host: "10.0.0.4", Username: "root"}, parseErr: "端口无效: x"},
+			},
+			want: []string{importInvalid, importInvalid, importInvalid, importInvalid},
+			check: func(t *testing.T, items []ImportItem) {
+				for i, want := range []string{"缺少用户名", "缺少主机地址", "端口无效: 70000", "端口无效: x"} {
+					if !strings.Contains(items[i].Error, want) {
+						t.Errorf("条目%d错误 = %q，期望包含 %q", i, items[i].Error, want)
+					}
+				}
+			},
+		},
+		{
+			name: "分组提供的用户名和端口保持为空",
+			entries: []importEntry{
+				{server: storage.Server{Host: "10.0.1.1", GroupID: "g-child"}},
+				{server: storage.Server{Host: "10.0.1.2", Port: 22, GroupID: "g-root"}},
+			},
+			defaultUser: "root",
+			want:        []string{importNew, importNew},
+			check: func(t *testing.T, items []ImportItem) {
+				if s := items[0].Server; s.Username != "" || s.Port != 0 {
+					t.Errorf("应从分组继承用户名和端口，得到 %s:%d", s.Username, s.Port)
+				}
+				if s := items[1].Server; s.Username != "" || s.Port != 22 {
+					t.Errorf("自身设置的端口应保留，得到 %s:%d", s.Username, s.Port)
+				}
+			},
+		},
+		{
+			name: "按继承后的host:port:user去重",
+			entries: []importEntry{
+				newEntry("b1", "jump", "BASTION.example.com", 0, ""),
+				newEntry("b2", "other", "bastion.example.com", 22, ""),
+				newEntry("g1", "ops", "10.0.0.9", 2222, ""),
+				newEntry("x1", "root", "10.0.0.5", 22, ""),
+				newEntry("x2", "root", "10.0.0.5", 0, ""),
+			},
+			want: []string{importDuplicate, importNew, importDuplicate, importNew, importDuplicate},
+			check: func(t *testing.T, items []ImportItem) {
+				for i, want := range map[int]string{0: "Bastion", 2: "grouped", 4: "x1"} {
+					if items[i].DuplicateOf != want {
+						t.Errorf("条目%d重复于 %q，期望 %q", i, items[i].DuplicateOf, want)
+					}
+				}
+			},
+		},
+		{
+			name: "解析跳板机引用",
+			entries: []importEntry{
+				newEntry("edge", "ops", "edge.example.com", 2200, ""),
+				newEntry("via-alias", "root", "10.0.2.1", 22, "EDGE"),
+				newEntry("via-existing", "root", "10.0.2.2", 22, "bastion"),
+				newEntry("via-address", "root", "10.0.2.3", 22, "ops@edge.example.com:2200"),
+				newEntry("via-group", "root", "10.0.2.4", 22, "ops@10.0.0.9:2222"),
+				newEntry("dup-bastion", "jump", "bastion.example.com", 22, ""),
+				newEntry("via-dup", "root", "10.0.2.5", 22, "dup-bastion"),
+				newEntry("missing", "root", "10.0.2.6", 22, "nowhere"),
+			},
+			want: []string{importNew, importNew, importNew, importNew, importNew, importDuplicate, importNew, importInvalid},
+			check: func(t *testing.T, items []ImportItem) {
+				edge := items[0].Server.ID
+				for i, want := range map[int]string{1: edge, 2: "s-bastion", 3: edge, 4: "s-grouped", 6: "s-bastion"} {
+					if got := items[i].Server.JumpHostID; got != want {
+						t.Errorf("条目%d跳板机 = %q，期望 %q", i, got, want)
+					}
+				}
+				if !strings.Contains(items[7].Error, "跳板机不存在: nowhere") {
+					t.Errorf("错误 = %q", items[7].Error)
+				}
+			},
+		},
+		{
+			name: "多级ProxyJump补全跳板链",
+			entries: []importEntry{
+				newEntry("edge", "ops", "edge.example.com", 22, ""),
+				newEntry("mid", "root", "10.0.5.1", 22, ""),
+				withJumpChain(newEntry("t1", "root", "10.0.5.2", 22, "mid"), "edge"),
+				withJumpChain(newEntry("t2", "root", "10.0.5.3", 22, "mid"), "EDGE"),
+				withJumpChain(newEntry("t3", "root", "10.0.5.4", 22, "app"), "app-jump"),
+				withJumpChain(newEntry("t4", "root", "10.0.5.5", 22, "bastion"), "edge"),
+				withJumpChain(newEntry("t5", "root", "10.0.5.6", 22, "mid"), "nowhere"),
+				newEntry("mid2", "root", "10.0.5.7", 22, "bastion"),
+				withJumpChain(newEntry("t6", "root", "10.0.5.8", 22, "mid2"), "edge"),
+			},
+			want: []string{importNew, importNew, importNew, importNew, importNew, importNew, importNew, importNew, importNew},
+			warnings: []string{
+				"t4：ProxyJump 要求已有服务器 bastion 经 edge 连接",
+				"t5：ProxyJump 中的跳板 nowhere 不存在",
+				"t6：ProxyJump 要求 mid2 经 edge 连接，但其已指定其他跳板机",
+			},
+			check: func(t *testing.T, items []ImportItem) {
+				edge, mid := items[0].Server.ID, items[1].Server.ID
+				// 中间跳板经前一跳连接，目标仍经最后一跳
+				for i, want := range map[int]string{0: "", 1: edge, 2: mid, 3: mid, 4: "s-app", 5: "s-bastion", 6: mid, 7: "s-bastion", 8: items[7].Server.ID} {
+					if got := items[i].Server.JumpHostID; got != want {
+						t.Errorf("条目%d跳板机 = %q，期望 %q", i, got, want)
+					}
+				}
+			},
+		},
+		{
+			name: "跳板链循环与无效跳板机",
+			entries: []importEntry{
+				newEntry("a", "root", "10.0.3.1", 22, "b"),
+				newEntry("b", "root", "10.0.3.2", 22, "a"),
+				newEntry("c", "root", "10.0.3.3", 22, "a"),
+				newEntry("no-user", "", "10.0.3.4", 22, ""),
+				newEntry("d", "root", "10.0.3.5", 22, "no-user"),
+				newEntry("e", "root", "10.0.3.6", 22, "app"),
+			},
+			want: []string{importInvalid, importInvalid, importInvalid, importInvalid, importInvalid, importNew},
+			check: func(t *testing.T, items []ImportItem) {
+				if !strings.Contains(items[0].Error, "循环引用") {
+					t.Errorf("错误 = %q", items[0].Error)
+				}
+				for _, i := range []int{1, 2} {
+					if !strings.Contains(items[i].Error, "跳板机无效") || items[i].Server.JumpHostID != "" {
+						t.Errorf("条目%d = %q %q", i, items[i].Error, items[i].Server.JumpHostID)
+					}
+				}
+				if !strings.Contains(items[4].Error, "跳板机不存在") {
+					t.Errorf("错误 = %q", items[4].Error)
+				}
+				if items[5].Server.JumpHostID != "s-app" {
+					t.Errorf("跳板机 = %q", items[5].Server.JumpHostID)
+				}
+			},
+		},
+		{
+			name: "跳板链超过最大层数",
+			entries: func() []importEntry {
+				var entries []importEntry
+				prev := ""
+				for i := 0; i <= maxJumpHops+1; i++ {
+					alias := string(rune('a' + i))
+					entries = append(entries, newEntry(alias, "root", "10.0.4."+alias, 22, prev))
+					prev = alias
+				}
+				return entries
+			}(),
+			want: func() []string {
+				want := make([]string, maxJumpHops+2)
+				for i := range want {
+					want[i] = importNew
+				}
+				want[maxJumpHops+1] = importInvalid
+				return want
+			}(),
+			check: func(t *testing.T, items []ImportItem) {
+				if last := items[len(items)-1]; !strings.Contains(last.Error, "最大层数") {
+					t.Errorf("错误 = %q", last.Error)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := planImport(tt.entries, existing, groups, tt.defaultUser)
+
+			var got []string
+			for _, item := range result.Items {
+				got = append(got, item.Status)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				for _, item := range result.Items {
+					t.Logf("%s: %s %s", item.Server.Name, item.Status, item.Error)
+				}
+				t.Fatalf("状态 = %v，期望 %v", got, tt.want)
+			}
+
+			duplicates, invalid := 0, 0
+			for _, status := range tt.want {
+				switch status {
+				case importDuplicate:
+					duplicates++
+				case importInvalid:
+					invalid++
+				}
+			}
+			if result.Duplicates != duplicates || result.Invalid != invalid {
+				t.Errorf("重复 %d 无效 %d，期望 %d %d", result.Duplicates, result.Invalid, duplicates, invalid)
+			}
+			if len(result.Warnings) != len(tt.warnings) {
+				t.Fatalf("提示 = %q，期望 %d 条", result.Warnings, len(tt.warnings))
+			}
+			for i, w := range tt.warnings {
+				if !strings.Contains(result.Warnings[i], w) {
+					t.Errorf("提示[%d] = %q，期望包含 %q", i, result.Warnings[i], w)
+				}
+			}
+			if tt.check != nil {
+				tt.check(t, result.Items)
+			}
+		})
+	}
+}
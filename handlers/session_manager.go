@@ -1,6 +1,11 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
 	"sync"
 	"time"
 
@@ -8,18 +13,219 @@ import (
 	"golang.org/x/crypto/ssh"
 )
 
-// SSHSession SSH会话（包含SSH客户端和SFTP客户端）
+// SSHSession SSH会话（SSH连接、SFTP客户端、PTY shell及其输出缓冲）
+// 会话归SessionManager所有：浏览器断开后shell继续运行，可按ID重新连接
 type SSHSession struct {
+	ID         string
+	ServerID   string
+	ServerName string
 	SSHClient  *ssh.Client
 	SFTPClient *sftp.Client
-	LastActive time.Time
+	Shell      *ssh.Session      // 交互式shell（带PTY）
+	Stdin      io.WriteCloser    // shell标准输入（通过WriteInput/TryWriteInput串行写入，不要直接写）
+	Output     *terminalStream   // shell输出回滚缓冲，重新连接时回放
+	Recorder   *terminalRecorder // 会话录像（未开启时为nil）
+	Commands   *commandCapture   // 从shell集成序列中捕获命令历史
+	CreatedAt  time.Time
+	LastActive time.Time // 最近一次客户端连接、断开或输入的时间
+	DetachedAt time.Time // 最后一个客户端断开的时间（空闲超时从此计算）
+	attached   int       // 当前连接的客户端数
+	closed     bool
 	mu         sync.Mutex
+
+	// 输入队列：本会话客户端的输入和广播输入都经此由单个goroutine写入stdin，
+	// 避免并发写入在SSH数据包层面交错，目标窗口阻塞时也不会卡住广播源
+	inputCh   chan []byte
+	inputDone chan struct{}
+	inputErr  error // 写入stdin失败的错误（持有mu），之后的输入直接丢弃
+}
+
+// inputQueueSize 输入队列长度（按消息计，超过时广播输入被丢弃）
+const inputQueueSize = 256
+
+// errSessionClosed 会话已关闭
+var errSessionClosed = errors.New("会话已关闭")
+
+// SSHSessionInfo 会话信息（列表接口返回）
+type SSHSessionInfo struct {
+	ID         string     `json:"id"`
+	ServerID   string     `json:"server_id"`
+	ServerName string     `json:"server_name"`
+	Attached   int        `json:"attached"`
+	Detached   bool       `json:"detached"`
+	Broadcast  string     `json:"broadcast_group,omitempty"` // 所在的输入广播组ID
+	DetachedAt *time.Time `json:"detached_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastActive time.Time  `json:"last_active"`
+}
+
+// Attach 客户端连接到会话
+func (s *SSHSession) Attach() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attached++
+	s.LastActive = time.Now()
+}
+
+// Detach 客户端断开（shell继续运行，等待重新连接或空闲超时）
+func (s *SSHSession) Detach() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attached > 0 {
+		s.attached--
+	}
+	s.LastActive = time.Now()
+	if s.attached == 0 {
+		s.DetachedAt = time.Now()
+	}
+}
+
+// startInput 设置shell标准输入并启动写入goroutine
+func (s *SSHSession) startInput(stdin io.WriteCloser) {
+	s.Stdin = stdin
+	s.inputCh = make(chan []byte, inputQueueSize)
+	s.inputDone = make(chan struct{})
+	go func() {
+		for {
+			select {
+			case data := <-s.inputCh:
+				s.mu.Lock()
+				failed := s.inputErr != nil
+				s.mu.Unlock()
+				if failed {
+					continue
+				}
+				if _, err := s.Stdin.Write(data); err != nil {
+					log.Printf("写入会话 %s 的 stdin 失败: %v", s.ID, err)
+					s.mu.Lock()
+					s.inputErr = err
+					s.mu.Unlock()
+				}
+			case <-s.inputDone:
+				return
+			}
+		}
+	}()
+}
+
+// WriteInput 写入本会话客户端的输入（队列满时等待，保证不丢按键）
+// 返回之前写入stdin时的错误，便于调用方结束读取循环
+func (s *SSHSession) WriteInput(data []byte) error {
+	if s.inputCh == nil {
+		return errSessionClosed
+	}
+	s.mu.Lock()
+	err := s.inputErr
+	s.LastActive = time.Now()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	select {
+	case s.inputCh <- data:
+		return nil
+	case <-s.inputDone:
+		return errSessionClosed
+	}
+}
+
+// TryWriteInput 写入广播输入（不等待：队列已满或会话已关闭时返回false）
+func (s *SSHSession) TryWriteInput(data []byte) bool {
+	if s.inputCh == nil {
+		return false
+	}
+	select {
+	case s.inputCh <- data:
+		return true
+	case <-s.inputDone:
+		return false
+	default:
+		return false
+	}
+}
+
+// Resize 调整PTY大小（多个客户端共享同一PTY，以最近一次为准）
+func (s *SSHSession) Resize(rows, cols int) error {
+	if s.Shell == nil {
+		return nil
+	}
+	s.Recorder.Resize(cols, rows)
+	return s.Shell.WindowChange(rows, cols)
+}
+
+// close 关闭shell、SFTP和SSH连接
+func (s *SSHSession) close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	if s.inputDone != nil {
+		close(s.inputDone)
+	}
+
+	GetBroadcastManager().removeSession(s.ID)
+
+	if s.Shell != nil {
+		s.Shell.Close()
+	}
+	if s.SFTPClient != nil {
+		s.SFTPClient.Close()
+	}
+	if s.SSHClient != nil {
+		s.SSHClient.Close()
+	}
+	if s.Output != nil {
+		s.Output.Close()
+	}
+	s.Recorder.Close()
+}
+
+// outputWriter shell输出的写入目标（同时解析命令历史，开启录像时写入录像）
+func (s *SSHSession) outputWriter() io.Writer {
+	writers := []io.Writer{s.Output}
+	if s.Commands != nil {
+		writers = append(writers, s.Commands)
+	}
+	if s.Recorder != nil {
+		writers = append(writers, s.Recorder)
+	}
+	if len(writers) == 1 {
+		return s.Output
+	}
+	return io.MultiWriter(writers...)
+}
+
+// info 会话信息快照
+func (s *SSHSession) info() SSHSessionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info := SSHSessionInfo{
+		ID:         s.ID,
+		ServerID:   s.ServerID,
+		ServerName: s.ServerName,
+		Attached:   s.attached,
+		Detached:   s.attached == 0,
+		CreatedAt:  s.CreatedAt,
+		LastActive: s.LastActive,
+		Broadcast:  GetBroadcastManager().groupOf(s.ID),
+	}
+	if s.attached == 0 && !s.DetachedAt.IsZero() {
+		detachedAt := s.DetachedAt
+		info.DetachedAt = &detachedAt
+	}
+	return info
 }
 
 // SessionManager 全局会话管理器
 type SessionManager struct {
-	sessions map[string]*SSHSession
-	mu       sync.RWMutex
+	sessions    map[string]*SSHSession
+	mu          sync.RWMutex
+	cleanupOnce sync.Once
 }
 
 var globalSessionManager = &SessionManager{
@@ -31,61 +237,119 @@ func GetSessionManager() *SessionManager {
 	return globalSessionManager
 }
 
-// AddSession 添加会话
-func (sm *SessionManager) AddSession(sessionID string, sshClient *ssh.Client, sftpClient *sftp.Client) {
+// AddSession 注册会话（同ID的旧会话会被关闭）
+func (sm *SessionManager) AddSession(session *SSHSession) {
 	sm.mu.Lock()
-	defer sm.mu.Unlock()
+	old := sm.sessions[session.ID]
+	session.CreatedAt = time.Now()
+	session.LastActive = time.Now()
+	sm.sessions[session.ID] = session
+	sm.mu.Unlock()
 
-	sm.sessions[sessionID] = &SSHSession{
-		SSHClient:  sshClient,
-		SFTPClient: sftpClient,
-		LastActive: time.Now(),
+	if old != nil && old != session {
+		old.close()
 	}
 }
 
-// GetSession 获取会话
+// GetSession 获取会话（只读查找，不影响空闲超时）
 func (sm *SessionManager) GetSession(sessionID string) *SSHSession {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
+	return sm.sessions[sessionID]
+}
 
-	session := sm.sessions[sessionID]
-	if session != nil {
-		session.mu.Lock()
-		session.LastActive = time.Now()
-		session.mu.Unlock()
+// FindByServer 查找指定服务器的任意一个活跃会话（用于复用SFTP连接，只读查找，不影响空闲超时）
+func (sm *SessionManager) FindByServer(serverID string) *SSHSession {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	for _, session := range sm.sessions {
+		if session.ServerID == serverID && session.SFTPClient != nil {
+			return session
+		}
+	}
+	return nil
+}
+
+// ListSessions 列出所有会话（按创建时间排序）
+func (sm *SessionManager) ListSessions() []SSHSessionInfo {
+	sm.mu.RLock()
+	infos := make([]SSHSessionInfo, 0, len(sm.sessions))
+	for _, session := range sm.sessions {
+		infos = append(infos, session.info())
 	}
-	return session
+	sm.mu.RUnlock()
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].CreatedAt.Before(infos[j].CreatedAt) })
+	return infos
 }
 
-// RemoveSession 移除会话
+// RemoveSession 移除会话并关闭shell、SFTP和SSH连接
 func (sm *SessionManager) RemoveSession(sessionID string) {
 	sm.mu.Lock()
-	defer sm.mu.Unlock()
+	session, ok := sm.sessions[sessionID]
+	delete(sm.sessions, sessionID)
+	sm.mu.Unlock()
 
-	if session, ok := sm.sessions[sessionID]; ok {
-		// 关闭SFTP客户端
-		if session.SFTPClient != nil {
-			session.SFTPClient.Close()
-		}
-		// SSH客户端由WebSocket handler管理，不在这里关闭
-		delete(sm.sessions, sessionID)
+	if ok {
+		session.close()
 	}
 }
 
-// CleanupInactiveSessions 清理不活跃的会话（可选，定期调用）
-func (sm *SessionManager) CleanupInactiveSessions(timeout time.Duration) {
+// removeIfSame 仅当注册的仍是该会话时移除（shell退出时调用，避免误删同ID的新会话）
+func (sm *SessionManager) removeIfSame(session *SSHSession) {
 	sm.mu.Lock()
-	defer sm.mu.Unlock()
+	if sm.sessions[session.ID] == session {
+		delete(sm.sessions, session.ID)
+	}
+	sm.mu.Unlock()
+	session.close()
+}
+
+// KillSession 结束会话
+func (sm *SessionManager) KillSession(sessionID string) error {
+	if sm.GetSession(sessionID) == nil {
+		return fmt.Errorf("会话不存在: %s", sessionID)
+	}
+	sm.RemoveSession(sessionID)
+	return nil
+}
 
+// CleanupInactiveSessions 清理无客户端连接超过timeout的会话
+// 从最后一个客户端断开时开始计时（从未连接过的会话从创建时开始），AI工具等对会话的使用不会延长
+func (sm *SessionManager) CleanupInactiveSessions(timeout time.Duration) {
+	sm.mu.Lock()
+	var expired []*SSHSession
 	now := time.Now()
 	for sessionID, session := range sm.sessions {
 		session.mu.Lock()
-		if now.Sub(session.LastActive) > timeout {
-			if session.SFTPClient != nil {
-				session.SFTPClient.Close()
-			}
+		idleSince := session.DetachedAt
+		if idleSince.IsZero() {
+			idleSince = session.CreatedAt
+		}
+		if session.attached == 0 && now.Sub(idleSince) > timeout {
+			expired = append(expired, session)
 			delete(sm.sessions, sessionID)
 		}
 		session.mu.Unlock()
 	}
+	sm.mu.Unlock()
+
+	for _, session := range expired {
+		log.Printf("SSH会话空闲超时，已关闭: %s (%s)", session.ID, session.ServerName)
+		session.close()
+	}
+}
+
+// StartCleanup 启动定期清理断开后空闲超时的会话
+func (sm *SessionManager) StartCleanup(timeout time.Duration) {
+	sm.cleanupOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(time.Minute)
+			defer ticker.Stop()
+			for range ticker.C {
+				sm.CleanupInactiveSessions(timeout)
+			}
+		}()
+	})
 }
@@ -0,0 +1,341 @@
+package handlers
+
+import (
+	"all_project/storage"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// 命令历史中本地终端使用的服务器ID和名称（与前端保持一致）
+const (
+	localHistoryServerID   = "0"
+	localHistoryServerName = "本地"
+)
+
+// shell集成序列的长度上限，超过视为异常数据丢弃
+const (
+	maxOSCPayload   = 8 * 1024
+	maxCommandInput = 4 * 1024
+)
+
+// oscParseState OSC序列解析状态
+type oscParseState int
+
+const (
+	oscNone    oscParseState = iota // 普通输出
+	oscEscape                       // 读到ESC
+	oscBody                         // 读取OSC内容（ESC ] 之后）
+	oscBodyEsc                      // OSC内容中读到ESC（等待 \ 结束）
+)
+
+// commandCapture 从终端输出中解析shell集成序列，捕获执行的命令
+//
+// 支持的序列（以BEL或ESC \ 结尾）：
+//
+//	OSC 133;A / 633;A        提示符开始
+//	OSC 133;B / 633;B        提示符结束，开始输入命令
+//	OSC 133;C / 633;C        命令开始执行
+//	OSC 133;D[;退出码]       命令执行结束（633同）
+//	OSC 633;E;命令行         命令原文（未提供时取B、C之间回显的输入）
+//	OSC 633;P;Cwd=路径       当前目录（也支持 OSC 7 file://host/路径）
+//
+// 未启用shell集成的shell不会输出这些序列，此时不记录任何命令
+type commandCapture struct {
+	serverID   string
+	serverName string
+	saveRecord func(storage.CommandHistory) error // 保存命令记录（测试中可替换）
+
+	mu      sync.Mutex
+	state   oscParseState
+	osc     []byte
+	oscLong bool // OSC内容超长，结束后丢弃
+
+	cwd         string
+	typing      bool   // 处于B、C之间
+	input       []byte // B、C之间回显的原始输出
+	commandLine string // 633;E 提供的命令原文
+	hasLine     bool
+	running     bool
+	command     string
+	commandCwd  string
+	startedAt   time.Time
+
+	// 待保存的命令记录，由单个goroutine按捕获顺序写入（保证去重时保留最新的一条）
+	records []storage.CommandHistory
+	saving  bool
+}
+
+func newCommandCapture(serverID, serverName string) *commandCapture {
+	return &commandCapture{serverID: serverID, serverName: serverName, saveRecord: storage.SaveCommandRecord}
+}
+
+// Write 解析终端输出（实现io.Writer，便于与输出流一起tee，永不返回错误）
+func (c *commandCapture) Write(p []byte) (int, error) {
+	if c == nil {
+		return len(p), nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, b := range p {
+		switch c.state {
+		case oscNone:
+			if b == 0x1b {
+				c.state = oscEscape
+			} else {
+				c.appendInput(b)
+			}
+		case oscEscape:
+			if b == ']' {
+				c.state = oscBody
+				c.osc = c.osc[:0]
+				c.oscLong = false
+			} else {
+				// 其他转义序列原样保留，提取命令时再剔除
+				c.state = oscNone
+				c.appendInput(0x1b)
+				c.appendInput(b)
+			}
+		case oscBody:
+			switch b {
+			case 0x07:
+				c.state = oscNone
+				c.finishOSC()
+			case 0x1b:
+				c.state = oscBodyEsc
+			default:
+				c.appendOSC(b)
+			}
+		case oscBodyEsc:
+			if b == '\\' {
+				c.state = oscNone
+				c.finishOSC()
+			} else {
+				// 不是ST，按OSC被中断处理
+				c.state = oscNone
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// Cwd shell集成上报的当前工作目录（未上报时为空）
+func (c *commandCapture) Cwd() string {
+	if c == nil {
+		return ""
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cwd
+}
+
+func (c *commandCapture) appendInput(b byte) {
+	if c.typing && len(c.input) < maxCommandInput {
+		c.input = append(c.input, b)
+	}
+}
+
+func (c *commandCapture) appendOSC(b byte) {
+	if len(c.osc) >= maxOSCPayload {
+		c.oscLong = true
+		return
+	}
+	c.osc = append(c.osc, b)
+}
+
+// finishOSC 处理一条完整的OSC序列
+func (c *commandCapture) finishOSC() {
+	if c.oscLong {
+		return
+	}
+
+	code, rest, _ := strings.Cut(string(c.osc), ";")
+	switch code {
+	case "133", "633":
+		c.handleMark(code, rest)
+	case "7":
+		if u, err := url.Parse(rest); err == nil && u.Scheme == "file" && u.Path != "" {
+			c.cwd = u.Path
+		}
+	}
+}
+
+// handleMark 处理提示符/命令标记
+func (c *commandCapture) handleMark(code, rest string) {
+	mark, params, _ := strings.Cut(rest, ";")
+	switch mark {
+	case "A":
+		c.typing = false
+		c.hasLine = false
+	case "B":
+		c.typing = true
+		c.input = c.input[:0]
+		c.hasLine = false
+	case "C":
+		if c.hasLine {
+			c.command = c.commandLine
+		} else {
+			c.command = extractTypedCommand(c.input)
+		}
+		c.typing = false
+		c.running = true
+		c.commandCwd = c.cwd
+		c.startedAt = time.Now()
+	case "D":
+		if !c.running {
+			// 未执行命令（如在提示符处按Ctrl+C）
+			return
+		}
+		c.running = false
+		var exitCode *int
+		if status, _, _ := strings.Cut(params, ";"); status != "" {
+			if n, err := strconv.Atoi(status); err == nil {
+				exitCode = &n
+			}
+		}
+		c.save(exitCode)
+	case "E":
+		if code != "633" {
+			return
+		}
+		line, _, _ := strings.Cut(params, ";") // 之后为nonce
+		c.commandLine = unescapeShellIntegration(line)
+		c.hasLine = true
+		if c.running {
+			c.command = c.commandLine
+		}
+	case "P":
+		if code != "633" {
+			return
+		}
+		if key, value, ok := strings.Cut(params, "="); ok && key == "Cwd" {
+			c.cwd = unescapeShellIntegration(value)
+		}
+	}
+}
+
+// save 保存已完成的命令（加入队列异步写入，不阻塞终端输出；时间取命令结束时）
+func (c *commandCapture) save(exitCode *int) {
+	command := strings.TrimSpace(c.command)
+	c.command = ""
+	if command == "" {
+		return
+	}
+
+	now := time.Now()
+	c.records = append(c.records, storage.CommandHistory{
+		ServerID:   c.serverID,
+		ServerName: c.serverName,
+		Command:    command,
+		Cwd:        c.commandCwd,
+		ExitCode:   exitCode,
+		DurationMs: now.Sub(c.startedAt).Milliseconds(),
+		Timestamp:  now,
+	})
+	if !c.saving {
+		c.saving = true
+		go c.saveLoop()
+	}
+}
+
+// saveLoop 按顺序保存队列中的命令记录，队列为空时退出
+func (c *commandCapture) saveLoop() {
+	for {
+		c.mu.Lock()
+		if len(c.records) == 0 {
+			c.saving = false
+			c.mu.Unlock()
+			return
+		}
+		record := c.records[0]
+		c.records = c.records[1:]
+		c.mu.Unlock()
+
+		if err := c.saveRecord(record); err != nil {
+			log.Printf("⚠️ 保存命令历史失败: %v", err)
+		}
+	}
+}
+
+// extractTypedCommand 从B、C之间的回显中提取命令：剔除转义序列，按退格删除字符
+func extractTypedCommand(raw []byte) string {
+	out := make([]rune, 0, len(raw))
+	for i := 0; i < len(raw); {
+		b := raw[i]
+		switch {
+		case b == 0x1b:
+			i = skipEscape(raw, i)
+			continue
+		case b == '\b' || b == 0x7f:
+			if len(out) > 0 {
+				out = out[:len(out)-1]
+			}
+		case b == '\r' || b == '\n' || b == '\t':
+			if b == '\t' {
+				out = append(out, ' ')
+			}
+		case b < 0x20:
+			// 忽略其他控制字符
+		default:
+			r, size := utf8.DecodeRune(raw[i:])
+			if r != utf8.RuneError {
+				out = append(out, r)
+			}
+			i += size
+			continue
+		}
+		i++
+	}
+	return string(out)
+}
+
+// skipEscape 跳过从i开始的转义序列，返回其后的位置
+func skipEscape(raw []byte, i int) int {
+	i++
+	if i >= len(raw) {
+		return i
+	}
+	if raw[i] == '[' {
+		// CSI：参数和中间字节之后以 0x40-0x7E 结尾
+		for i++; i < len(raw); i++ {
+			if raw[i] >= 0x40 && raw[i] <= 0x7e {
+				return i + 1
+			}
+		}
+		return i
+	}
+	return i + 1
+}
+
+// unescapeShellIntegration 还原633序列中的转义：\\ 表示反斜杠，\xAB 表示十六进制字节
+func unescapeShellIntegration(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			if s[i+1] == '\\' {
+				sb.WriteByte('\\')
+				i++
+				continue
+			}
+			if s[i+1] == 'x' && i+3 < len(s) {
+				if n, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+					sb.WriteByte(byte(n))
+					i += 3
+					continue
+				}
+			}
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
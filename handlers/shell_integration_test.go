@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"all_project/storage"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// capturedCommand 测试中比较的命令记录字段
+type capturedCommand struct {
+	Command  string
+	Cwd      string
+	ExitCode int // -1 表示未提供
+}
+
+// osc 构造以BEL结尾的OSC序列
+func osc(body string) string {
+	return "\x1b]" + body + "\x07"
+}
+
+// runCapture 把输出按chunk字节一段写入解析器，返回保存的命令记录（chunk<=0时整段写入）
+func runCapture(t *testing.T, output string, chunk int) []storage.CommandHistory {
+	t.Helper()
+
+	saved := make(chan storage.CommandHistory, 16)
+	c := newCommandCapture("srv", "测试服务器")
+	c.saveRecord = func(record storage.CommandHistory) error {
+		saved <- record
+		return nil
+	}
+
+	data := []byte(output)
+	if chunk <= 0 {
+		chunk = len(data)
+	}
+	for len(data) > 0 {
+		n := min(chunk, len(data))
+		c.Write(data[:n])
+		data = data[n:]
+	}
+
+	// 等待保存队列清空
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		c.mu.Lock()
+		idle := !c.saving
+		c.mu.Unlock()
+		if idle || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(saved)
+
+	var records []storage.CommandHistory
+	for record := range saved {
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestCommandCapture(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []capturedCommand
+	}{
+		{
+			name: "133提示符与回显输入",
+			output: osc("133;A") + "user@host:~$ " + osc("133;B") + "ls -la\r\n" + osc("133;C") +
+				"total 0\r\n" + osc("133;D;0") + osc("133;A") + "$ ",
+			want: []capturedCommand{{Command: "ls -la", ExitCode: 0}},
+		},
+		{
+			name:   "ST结尾与非零退出码",
+			output: "\x1b]133;B\x1b\\" + "false\r\n" + "\x1b]133;C\x1b\\" + "\x1b]133;D;1\x1b\\",
+			want:   []capturedCommand{{Command: "false", ExitCode: 1}},
+		},
+		{
+			name:   "退格、CSI和其他转义序列被剔除",
+			output: osc("133;B") + "lss\b \b\x1b[K\x1b[32m -l\x1b[0m\t/tmp\r\n" + osc("133;C") + osc("133;D;0"),
+			want:   []capturedCommand{{Command: "ls -l /tmp", ExitCode: 0}},
+		},
+		{
+			name: "633;E优先于回显，支持转义",
+			output: osc("633;A") + osc("633;B") + "ec\x1b[Kho\r\n" + osc(`633;E;echo a\x3bb \\ c;nonce123`) +
+				osc("633;C") + "a;b \\ c\r\n" + osc("633;D;0"),
+			want: []capturedCommand{{Command: `echo a;b \ c`, ExitCode: 0}},
+		},
+		{
+			name:   "C之后到达的E更新命令",
+			output: osc("633;B") + "partial" + osc("633;C") + osc("633;E;full command") + osc("633;D"),
+			want:   []capturedCommand{{Command: "full command", ExitCode: -1}},
+		},
+		{
+			name: "633;P和OSC 7上报当前目录",
+			output: osc("633;P;Cwd=/srv/my\\x20app") + osc("133;B") + "make\r\n" + osc("133;C") + osc("133;D;2") +
+				osc("7;file://host/var/log") + osc("133;B") + "tail syslog\r\n" + osc("133;C") + osc("133;D;0"),
+			want: []capturedCommand{
+				{Command: "make", Cwd: "/srv/my app", ExitCode: 2},
+				{Command: "tail syslog", Cwd: "/var/log", ExitCode: 0},
+			},
+		},
+		{
+			name: "未执行命令或空命令不记录",
+			output: osc("133;A") + osc("133;B") + "^C" + osc("133;D;130") +
+				osc("133;B") + "\r\n" + osc("133;C") + osc("133;D;0"),
+			want: nil,
+		},
+		{
+			name: "多条命令按顺序保存",
+			output: osc("133;B") + "cd /\r\n" + osc("133;C") + osc("133;D;0") +
+				osc("133;B") + "pwd\r\n" + osc("133;C") + "/\r\n" + osc("133;D;0") +
+				osc("133;B") + "cd /\r\n" + osc("133;C") + osc("133;D;0"),
+			want: []capturedCommand{
+				{Command: "cd /", ExitCode: 0},
+				{Command: "pwd", ExitCode: 0},
+				{Command: "cd /", ExitCode: 0},
+			},
+		},
+		{
+			name:   "没有shell集成时不记录",
+			output: "user@host:~$ ls\r\nfile\r\n\x1b]0;title\x07user@host:~$ ",
+			want:   nil,
+		},
+		{
+			name: "未知和超长的OSC被忽略",
+			output: osc("1337;SetMark") + osc("133;B") + "uptime\r\n" + osc("133;C") +
+				"\x1b]633;E;" + string(make([]byte, maxOSCPayload+10)) + "\x07" + osc("133;D;0"),
+			want: []capturedCommand{{Command: "uptime", ExitCode: 0}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 整段写入、逐字节写入和按3字节拆分写入的结果应一致
+			for _, chunk := range []int{0, 1, 3} {
+				records := runCapture(t, tt.output, chunk)
+
+				var got []capturedCommand
+				for _, r := range records {
+					exitCode := -1
+					if r.ExitCode != nil {
+						exitCode = *r.ExitCode
+					}
+					got = append(got, capturedCommand{Command: r.Command, Cwd: r.Cwd, ExitCode: exitCode})
+					if r.ServerID != "srv" || r.Timestamp.IsZero() {
+						t.Errorf("记录缺少服务器或时间: %+v", r)
+					}
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("chunk=%d 命令 = %+v\n期望 %+v", chunk, got, tt.want)
+				}
+				for i := 1; i < len(records); i++ {
+					if records[i].Timestamp.Before(records[i-1].Timestamp) {
+						t.Errorf("记录时间乱序: %v", records)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"all_project/storage"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// defaultAuthMethods 未显式配置认证方式时，根据已填写的凭据推断尝试顺序
+func defaultAuthMethods(server *storage.Server) []string {
+	methods := []string{}
+	if server.PrivateKey != "" || server.PrivateKeyPath != "" {
+		methods = append(methods, storage.AuthPublicKey)
+	}
+	if server.Password != "" {
+		methods = append(methods, storage.AuthPassword, storage.AuthKeyboardInteractive)
+	}
+	if len(methods) == 0 {
+		methods = append(methods, storage.AuthAgent)
+	}
+	return methods
+}
+
+// buildAuthMethods 按服务器配置构建SSH认证方式列表
+// 返回的cleanup用于在握手结束后关闭ssh-agent连接
+//
+// 私钥和ssh-agent都属于"publickey"认证，而x/crypto/ssh每种认证方式只尝试一次，
+// 因此两者合并为一个PublicKeysCallback：按配置顺序依次提供私钥和agent中的签名器
+func buildAuthMethods(server *storage.Server) ([]ssh.AuthMethod, func(), error) {
+	methods := server.AuthMethods
+	if len(methods) == 0 {
+		methods = defaultAuthMethods(server)
+	}
+
+	var auths []ssh.AuthMethod
+	var closers []func()
+	cleanup := func() {
+		for _, c := range closers {
+			c()
+		}
+	}
+
+	// signerSources 合并后的publickey认证的签名器来源，在第一个私钥/agent方式的位置加入认证列表
+	var signerSources []func() ([]ssh.Signer, error)
+	addSignerSource := func(source func() ([]ssh.Signer, error)) {
+		if signerSources == nil {
+			auths = append(auths, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+				return collectSigners(signerSources)
+			}))
+		}
+		signerSources = append(signerSources, source)
+	}
+
+	for _, method := range methods {
+		switch method {
+		case storage.AuthPassword:
+			auths = append(auths, ssh.Password(server.Password))
+
+		case storage.AuthKeyboardInteractive:
+			auths = append(auths, ssh.KeyboardInteractive(keyboardInteractiveWithPassword(server.Password)))
+
+		case storage.AuthPublicKey:
+			signer, err := loadSigner(server)
+			if err != nil {
+				cleanup()
+				return nil, nil, err
+			}
+			addSignerSource(func() ([]ssh.Signer, error) { return []ssh.Signer{signer}, nil })
+
+		case storage.AuthAgent:
+			socket := os.Getenv("SSH_AUTH_SOCK")
+			if socket == "" {
+				cleanup()
+				return nil, nil, errors.New("ssh-agent 不可用: 未设置 SSH_AUTH_SOCK")
+			}
+			conn, err := net.Dial("unix", socket)
+			if err != nil {
+				cleanup()
+				return nil, nil, fmt.Errorf("连接 ssh-agent 失败: %v", err)
+			}
+			closers = append(closers, func() { conn.Close() })
+			addSignerSource(agent.NewClient(conn).Signers)
+
+		default:
+			cleanup()
+			return nil, nil, fmt.Errorf("不支持的认证方式: %s", method)
+		}
+	}
+
+	return auths, cleanup, nil
+}
+
+// collectSigners 依次收集各来源的签名器，部分来源失败（如agent无响应）时仍使用其余签名器
+func collectSigners(sources []func() ([]ssh.Signer, error)) ([]ssh.Signer, error) {
+	var signers []ssh.Signer
+	var firstErr error
+	for _, source := range sources {
+		s, err := source()
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		signers = append(signers, s...)
+	}
+	if len(signers) == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+	return signers, nil
+}
+
+// loadSigner 解析私钥（可选密码），如配置了用户证书则包装为证书签名器
+func loadSigner(server *storage.Server) (ssh.Signer, error) {
+	keyData := []byte(server.PrivateKey)
+	if len(keyData) == 0 && server.PrivateKeyPath != "" {
+		data, err := os.ReadFile(expandHome(server.PrivateKeyPath))
+		if err != nil {
+			return nil, fmt.Errorf("读取私钥失败: %v", err)
+		}
+		keyData = data
+	}
+	if len(keyData) == 0 {
+		return nil, errors.New("未配置私钥")
+	}
+
+	var signer ssh.Signer
+	var err error
+	if server.Passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(keyData, []byte(server.Passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(keyData)
+	}
+	if err != nil {
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			return nil, errors.New("私钥已加密，请填写私钥密码")
+		}
+		return nil, fmt.Errorf("解析私钥失败: %v", err)
+	}
+
+	// 用户证书：显式配置，或与OpenSSH一致查找 <私钥路径>-cert.pub
+	certData := []byte(server.Certificate)
+	if len(certData) == 0 && server.PrivateKeyPath != "" {
+		if data, err := os.ReadFile(expandHome(server.PrivateKeyPath) + "-cert.pub"); err == nil {
+			certData = data
+		}
+	}
+	if len(certData) == 0 {
+		return signer, nil
+	}
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(certData)
+	if err != nil {
+		return nil, fmt.Errorf("解析证书失败: %v", err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, errors.New("证书格式错误: 不是OpenSSH证书")
+	}
+	if cert.CertType != ssh.UserCert {
+		return nil, errors.New("证书类型错误: 需要用户证书")
+	}
+
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("证书与私钥不匹配: %v", err)
+	}
+	return certSigner, nil
+}
+
+// keyboardInteractiveWithPassword 用保存的密码应答键盘交互认证的提示（PAM等）
+func keyboardInteractiveWithPassword(password string) ssh.KeyboardInteractiveChallenge {
+	return func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for i := range questions {
+			answers[i] = password
+		}
+		return answers, nil
+	}
+}
+
+// expandHome 展开路径中的 ~
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return home + path[1:]
+		}
+	}
+	return path
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"all_project/storage"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// HostKeyMismatchError 主机密钥与已信任记录不一致（可能遭受中间人攻击）
+type HostKeyMismatchError struct {
+	ServerID string
+	Address  string
+	Expected string // 已记录的指纹
+	Actual   string // 本次收到的指纹
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	return fmt.Sprintf("主机密钥校验失败: %s 的主机密钥已变更（已记录 %s，实际 %s），可能存在中间人攻击。如确认服务器已重装或更换密钥，请在服务器设置中重置主机密钥后重试",
+		e.Address, e.Expected, e.Actual)
+}
+
+// hostKeyCallback 基于首次信任（TOFU）的主机密钥校验
+// 首次连接记录主机密钥，之后连接密钥不一致则拒绝
+func hostKeyCallback(server *storage.Server) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		fingerprint := ssh.FingerprintSHA256(key)
+
+		known, err := storage.TrustHostKey(&storage.KnownHost{
+			ServerID:    server.ID,
+			Host:        server.Host,
+			Port:        server.Port,
+			KeyType:     key.Type(),
+			Fingerprint: fingerprint,
+			PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+			FirstSeen:   time.Now(),
+		})
+		if err != nil {
+			return fmt.Errorf("读取主机密钥记录失败: %v", err)
+		}
+
+		if known.Fingerprint != fingerprint {
+			log.Printf("⚠️ 主机密钥不匹配: %s (%s) 已记录 %s，实际 %s", server.ID, hostname, known.Fingerprint, fingerprint)
+			return &HostKeyMismatchError{
+				ServerID: server.ID,
+				Address:  hostname,
+				Expected: known.Fingerprint,
+				Actual:   fingerprint,
+			}
+		}
+		return nil
+	}
+}
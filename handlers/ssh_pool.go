@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"all_project/storage"
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// pooledConn 连接池中的一条SSH连接（供AI工具等后台任务使用）
+type pooledConn struct {
+	sshClient  *ssh.Client
+	sftpClient *sftp.Client
+	lastUsed   time.Time
+}
+
+// poolDial 正在建立的连接，同一服务器的并发请求等待同一次拨号
+type poolDial struct {
+	done  chan struct{} // 拨号结束后关闭
+	conn  *pooledConn
+	err   error
+	stale bool // 拨号期间配置已变更（Close），结果不放入连接池
+}
+
+// SSHPool 按服务器ID复用的后台SSH/SFTP连接池
+// 没有打开终端的服务器也能被AI工具访问，空闲连接定期回收
+// 拨号在锁外进行，一台不可达的服务器不会阻塞其他服务器的请求
+type SSHPool struct {
+	conns       map[string]*pooledConn // key=serverID
+	dialing     map[string]*poolDial   // key=serverID
+	mu          sync.Mutex
+	idleTimeout time.Duration
+}
+
+var sshPoolInstance *SSHPool
+var sshPoolOnce sync.Once
+
+// GetSSHPool 获取单例
+func GetSSHPool() *SSHPool {
+	sshPoolOnce.Do(func() {
+		sshPoolInstance = &SSHPool{
+			conns:       make(map[string]*pooledConn),
+			dialing:     make(map[string]*poolDial),
+			idleTimeout: 10 * time.Minute,
+		}
+		go sshPoolInstance.cleanupLoop()
+	})
+	return sshPoolInstance
+}
+
+// GetSFTP 获取指定服务器的SFTP客户端（不存在则新建连接）
+func (p *SSHPool) GetSFTP(serverID string) (*sftp.Client, error) {
+	return p.GetSFTPContext(context.Background(), serverID)
+}
+
+// GetSFTPContext 同GetSFTP，ctx结束时不再等待拨号
+func (p *SSHPool) GetSFTPContext(ctx context.Context, serverID string) (*sftp.Client, error) {
+	conn, err := p.getContext(ctx, serverID)
+	if err != nil {
+		return nil, err
+	}
+	return conn.sftpClient, nil
+}
+
+// GetSSH 获取指定服务器的SSH客户端（不存在则新建连接）
+func (p *SSHPool) GetSSH(serverID string) (*ssh.Client, error) {
+	return p.GetSSHContext(context.Background(), serverID)
+}
+
+// GetSSHContext 同GetSSH，ctx结束时不再等待拨号（拨号在后台继续，成功后仍放入连接池）
+func (p *SSHPool) GetSSHContext(ctx context.Context, serverID string) (*ssh.Client, error) {
+	conn, err := p.getContext(ctx, serverID)
+	if err != nil {
+		return nil, err
+	}
+	return conn.sshClient, nil
+}
+
+// Existing 获取已建立的SSH连接（不新建、不刷新空闲时间），不存在时返回nil
+func (p *SSHPool) Existing(serverID string) *ssh.Client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if conn, ok := p.conns[serverID]; ok {
+		return conn.sshClient
+	}
+	return nil
+}
+
+// get 获取或创建连接
+func (p *SSHPool) get(serverID string) (*pooledConn, error) {
+	return p.getContext(context.Background(), serverID)
+}
+
+// getContext 获取或创建连接（同一服务器正在拨号时等待该次拨号的结果）
+func (p *SSHPool) getContext(ctx context.Context, serverID string) (*pooledConn, error) {
+	p.mu.Lock()
+	if conn, ok := p.conns[serverID]; ok {
+		conn.lastUsed = time.Now()
+		p.mu.Unlock()
+		return conn, nil
+	}
+	dial, ok := p.dialing[serverID]
+	if !ok {
+		dial = &poolDial{done: make(chan struct{})}
+		p.dialing[serverID] = dial
+		go p.dial(serverID, dial)
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-dial.done:
+		return dial.conn, dial.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// dial 建立连接，完成后在锁内放入连接池并唤醒等待者
+func (p *SSHPool) dial(serverID string, dial *poolDial) {
+	conn, err := p.connect(serverID)
+
+	p.mu.Lock()
+	if p.dialing[serverID] == dial {
+		delete(p.dialing, serverID)
+	}
+	if err == nil && dial.stale {
+		err = fmt.Errorf("服务器配置已变更，请重试")
+	}
+	if err == nil {
+		p.conns[serverID] = conn
+	}
+	p.mu.Unlock()
+
+	if err != nil && conn != nil {
+		conn.sftpClient.Close()
+		conn.sshClient.Close()
+		conn = nil
+	}
+	if err == nil {
+		// 连接断开时自动移出连接池
+		go func() {
+			conn.sshClient.Wait()
+			p.remove(serverID, conn)
+		}()
+	}
+
+	dial.conn, dial.err = conn, err
+	close(dial.done)
+}
+
+// connect 建立SSH连接并创建SFTP客户端（不持有锁）
+func (p *SSHPool) connect(serverID string) (*pooledConn, error) {
+	server, err := storage.GetServer(serverID)
+	if err != nil {
+		return nil, err
+	}
+
+	sshClient, err := connectSSH(server)
+	if err != nil {
+		return nil, fmt.Errorf("SSH 连接失败: %v", err)
+	}
+
+	sftpClient, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, fmt.Errorf("创建 SFTP 客户端失败: %v", err)
+	}
+
+	log.Printf("🔌 连接池新建连接: %s@%s:%d", server.Username, server.Host, server.Port)
+	return &pooledConn{
+		sshClient:  sshClient,
+		sftpClient: sftpClient,
+		lastUsed:   time.Now(),
+	}, nil
+}
+
+// remove 移除并关闭连接（仅当池中仍是同一条连接时）
+func (p *SSHPool) remove(serverID string, conn *pooledConn) {
+	p.mu.Lock()
+	if current, ok := p.conns[serverID]; ok && current == conn {
+		delete(p.conns, serverID)
+	}
+	p.mu.Unlock()
+
+	conn.sftpClient.Close()
+	conn.sshClient.Close()
+}
+
+// Close 关闭指定服务器的连接（服务器配置变更后调用），正在进行的拨号结果不再放入连接池
+func (p *SSHPool) Close(serverID string) {
+	p.mu.Lock()
+	conn, ok := p.conns[serverID]
+	if dial, dialing := p.dialing[serverID]; dialing {
+		dial.stale = true
+		delete(p.dialing, serverID)
+	}
+	p.mu.Unlock()
+
+	if ok {
+		p.remove(serverID, conn)
+	}
+}
+
+// cleanupLoop 定期关闭空闲连接
+func (p *SSHPool) cleanupLoop() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+		var idle []string
+
+		p.mu.Lock()
+		for serverID, conn := range p.conns {
+			if now.Sub(conn.lastUsed) > p.idleTimeout {
+				idle = append(idle, serverID)
+			}
+		}
+		p.mu.Unlock()
+
+		for _, serverID := range idle {
+			log.Printf("🔌 连接池回收空闲连接: %s", serverID)
+			p.Close(serverID)
+		}
+	}
+}
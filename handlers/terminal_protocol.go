@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+)
+
+// 终端WebSocket帧约定：
+//   - 二进制帧：原始输入，直接写入终端
+//   - 文本帧：JSON控制消息，如 {"type":"resize","cols":120,"rows":40}
+//     无法解析为已知控制消息的文本帧按原始输入处理（兼容旧前端）
+const (
+	termCtrlResize = "resize" // 调整终端窗口大小
+	termCtrlPing   = "ping"   // 心跳保活，无需处理
+)
+
+// 终端默认大小（收到首个resize前使用）
+const (
+	defaultTermRows = 40
+	defaultTermCols = 120
+)
+
+// terminalControl 终端控制消息
+type terminalControl struct {
+	Type string `json:"type"`
+	Cols int    `json:"cols,omitempty"`
+	Rows int    `json:"rows,omitempty"`
+}
+
+// parseTerminalControl 解析控制消息，非控制消息返回false
+func parseTerminalControl(msgType int, data []byte) (*terminalControl, bool) {
+	if msgType != websocket.TextMessage || len(data) == 0 || data[0] != '{' {
+		return nil, false
+	}
+
+	var ctrl terminalControl
+	if err := json.Unmarshal(data, &ctrl); err != nil {
+		return nil, false
+	}
+
+	switch ctrl.Type {
+	case termCtrlResize, termCtrlPing:
+		return &ctrl, true
+	}
+	return nil, false
+}
+
+// validSize 尺寸是否有效（前端未完成布局时可能为0）
+func (c *terminalControl) validSize() bool {
+	return c.Cols > 0 && c.Rows > 0 && c.Cols <= 1000 && c.Rows <= 1000
+}
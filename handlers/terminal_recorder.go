@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"all_project/config"
+	"all_project/storage"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// terminalRecorder asciicast v2 录像（输出、输入及窗口大小变化）
+// 格式：首行为JSON头，之后每行一个事件 [秒数, "o"|"i"|"r", 数据]
+// 所有方法对nil接收者安全，未开启录像时直接忽略
+type terminalRecorder struct {
+	ID      string // 录像ID（见storage.RecordingInfo）
+	mu      sync.Mutex
+	file    *os.File
+	w       *bufio.Writer
+	start   time.Time
+	pending map[string][]byte // 各事件流中尚未凑成完整UTF-8字符的尾部字节
+	failed  bool
+}
+
+// asciicastHeader asciicast v2 文件头
+type asciicastHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// newTerminalRecorder 创建录像文件并写入文件头
+func newTerminalRecorder(serverID, sessionID, title, shell string) (*terminalRecorder, error) {
+	start := time.Now()
+	file, id, err := storage.CreateRecordingFile(serverID, sessionID, start)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &terminalRecorder{
+		file:    file,
+		w:       bufio.NewWriter(file),
+		start:   start,
+		pending: make(map[string][]byte),
+		ID:      id,
+	}
+
+	header, _ := json.Marshal(asciicastHeader{
+		Version:   2,
+		Width:     defaultTermCols,
+		Height:    defaultTermRows,
+		Timestamp: start.Unix(),
+		Title:     title,
+		Env:       map[string]string{"TERM": "xterm-256color", "SHELL": shell},
+	})
+	r.w.Write(header)
+	r.w.WriteByte('\n')
+	r.w.Flush()
+
+	log.Printf("⏺ 开始录像: %s", id)
+	return r, nil
+}
+
+// Write 记录终端输出（实现io.Writer，便于与输出流一起tee，永不返回错误）
+func (r *terminalRecorder) Write(p []byte) (int, error) {
+	r.event("o", p)
+	return len(p), nil
+}
+
+// Input 记录用户输入
+func (r *terminalRecorder) Input(p []byte) {
+	r.event("i", p)
+}
+
+// Resize 记录窗口大小变化
+func (r *terminalRecorder) Resize(cols, rows int) {
+	r.event("r", []byte(fmt.Sprintf("%dx%d", cols, rows)))
+}
+
+// event 写入一条事件（数据按完整UTF-8字符切分，不完整的尾部留到下次）
+func (r *terminalRecorder) event(code string, p []byte) {
+	if r == nil || len(p) == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.failed || r.file == nil {
+		return
+	}
+
+	data := append(r.pending[code], p...)
+	cut := utf8Boundary(data)
+	r.pending[code] = append([]byte(nil), data[cut:]...)
+	if cut == 0 {
+		return
+	}
+
+	line, _ := json.Marshal([]interface{}{
+		float64(time.Since(r.start).Microseconds()) / 1e6,
+		code,
+		string(data[:cut]),
+	})
+	r.w.Write(line)
+	r.w.WriteByte('\n')
+
+	// 输出频繁时由bufio合并写入，输入和窗口变化立即落盘
+	if code != "o" || r.w.Buffered() > 16*1024 {
+		if err := r.w.Flush(); err != nil {
+			log.Printf("⚠️ 写入录像失败，停止录像: %s (%v)", r.ID, err)
+			r.failed = true
+		}
+	}
+}
+
+// Close 结束录像
+func (r *terminalRecorder) Close() {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return
+	}
+	r.w.Flush()
+	r.file.Close()
+	r.file = nil
+	log.Printf("⏹ 录像结束: %s", r.ID)
+}
+
+// utf8Boundary 返回最后一个完整UTF-8字符之后的位置（末尾最多保留3个不完整字节）
+func utf8Boundary(data []byte) int {
+	n := len(data)
+	for i := n - 1; i >= 0 && i >= n-utf8.UTFMax; i-- {
+		if !utf8.RuneStart(data[i]) {
+			continue
+		}
+		if utf8.FullRune(data[i:]) {
+			return n
+		}
+		return i
+	}
+	return n
+}
+
+// shouldRecord 是否录制会话（全局开启或服务器单独开启）
+func shouldRecord(serverRecord bool) bool {
+	return serverRecord || config.RecordSessions()
+}
@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// 终端回滚缓冲大小及单次发送上限
+const (
+	scrollbackSize   = 512 * 1024
+	streamChunkLimit = 64 * 1024
+)
+
+// outputLostNotice 客户端落后超过回滚缓冲时插入的提示
+var outputLostNotice = []byte("\r\n\x1b[33m[部分输出已丢失]\x1b[0m\r\n")
+
+// terminalStream 终端输出流：固定大小的回滚环形缓冲 + 多个订阅者
+// 每个订阅者持有独立的读取位置，慢客户端从缓冲中追赶而不是丢帧；
+// 新订阅者从缓冲中最早的数据开始回放
+type terminalStream struct {
+	mu     sync.Mutex
+	buf    []byte
+	total  int64 // 累计写入字节数（绝对偏移）
+	subs   map[*streamSubscriber]struct{}
+	closed bool
+}
+
+// streamSubscriber 输出流订阅者（一个WebSocket客户端）
+type streamSubscriber struct {
+	stream *terminalStream
+	cursor int64         // 下一个要读取的绝对偏移
+	notify chan struct{} // 有新数据或流关闭时唤醒
+	trim   bool          // 从缓冲截断处开始读取，需跳到下一行
+	lost   bool          // 有数据被覆盖，下次发送时附带提示
+}
+
+func newTerminalStream() *terminalStream {
+	return &terminalStream{
+		buf:  make([]byte, scrollbackSize),
+		subs: make(map[*streamSubscriber]struct{}),
+	}
+}
+
+// Write 写入输出并唤醒所有订阅者（不会阻塞）
+func (t *terminalStream) Write(p []byte) (int, error) {
+	n := len(p)
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return n, nil
+	}
+
+	// 超过缓冲大小的部分只保留末尾
+	size := int64(len(t.buf))
+	if int64(len(p)) > size {
+		t.total += int64(len(p)) - size
+		p = p[int64(len(p))-size:]
+	}
+	for len(p) > 0 {
+		pos := int(t.total % size)
+		c := copy(t.buf[pos:], p)
+		t.total += int64(c)
+		p = p[c:]
+	}
+
+	for sub := range t.subs {
+		sub.wake()
+	}
+	t.mu.Unlock()
+	return n, nil
+}
+
+// Subscribe 订阅输出（从回滚缓冲最早的数据开始回放）
+func (t *terminalStream) Subscribe() *streamSubscriber {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	sub := &streamSubscriber{
+		stream: t,
+		cursor: t.oldest(),
+		notify: make(chan struct{}, 1),
+		trim:   t.oldest() > 0,
+	}
+	t.subs[sub] = struct{}{}
+	sub.wake()
+	return sub
+}
+
+// Unsubscribe 取消订阅
+func (t *terminalStream) Unsubscribe(sub *streamSubscriber) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.subs[sub]; ok {
+		delete(t.subs, sub)
+		sub.wake()
+	}
+}
+
+// Subscribers 当前订阅者数量
+func (t *terminalStream) Subscribers() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.subs)
+}
+
+// Close 关闭输出流，订阅者读完剩余数据后结束
+func (t *terminalStream) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	for sub := range t.subs {
+		sub.wake()
+	}
+}
+
+// oldest 缓冲中最早数据的绝对偏移（需持有锁）
+func (t *terminalStream) oldest() int64 {
+	if size := int64(len(t.buf)); t.total > size {
+		return t.total - size
+	}
+	return 0
+}
+
+// readFrom 读取[from, total)的数据（最多limit字节，需持有锁）
+func (t *terminalStream) readFrom(from int64, limit int) []byte {
+	n := t.total - from
+	if n > int64(limit) {
+		n = int64(limit)
+	}
+	out := make([]byte, n)
+	size := int64(len(t.buf))
+	pos := from % size
+	c := copy(out, t.buf[pos:])
+	copy(out[c:], t.buf[:n-int64(c)])
+	return out
+}
+
+func (s *streamSubscriber) wake() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Next 阻塞直到有新数据，返回false表示流已关闭或已取消订阅
+func (s *streamSubscriber) Next() ([]byte, bool) {
+	for {
+		t := s.stream
+		t.mu.Lock()
+		_, subscribed := t.subs[s]
+		if !subscribed {
+			t.mu.Unlock()
+			return nil, false
+		}
+
+		if s.cursor < t.total {
+			if oldest := t.oldest(); s.cursor < oldest {
+				// 落后超过缓冲大小，从最早的数据继续追赶
+				s.cursor = oldest
+				s.trim = true
+				s.lost = true
+			}
+
+			data := t.readFrom(s.cursor, streamChunkLimit)
+			s.cursor += int64(len(data))
+			t.mu.Unlock()
+
+			// 从截断处开始时跳到下一行，避免输出半个转义序列
+			if s.trim {
+				if i := bytes.IndexByte(data, '\n'); i >= 0 {
+					data = data[i+1:]
+					s.trim = false
+				} else {
+					continue
+				}
+			}
+			if s.lost {
+				data = append(append([]byte{}, outputLostNotice...), data...)
+				s.lost = false
+			}
+			if len(data) == 0 {
+				continue
+			}
+			return data, true
+		}
+
+		if t.closed {
+			t.mu.Unlock()
+			return nil, false
+		}
+		t.mu.Unlock()
+
+		<-s.notify
+	}
+}
+
+// writeStream 将订阅的输出持续写入WebSocket，直到流关闭、取消订阅或写入失败
+func writeStream(ws *websocket.Conn, sub *streamSubscriber) error {
+	for {
+		data, ok := sub.Next()
+		if !ok {
+			return nil
+		}
+		if err := ws.WriteMessage(websocket.BinaryMessage, data); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"all_project/storage"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TunnelHandler 端口转发处理器
+type TunnelHandler struct{}
+
+// NewTunnelHandler 创建端口转发处理器
+func NewTunnelHandler() *TunnelHandler {
+	return &TunnelHandler{}
+}
+
+// GinListTunnels 列出端口转发（含运行状态和流量统计）
+func (h *TunnelHandler) GinListTunnels(c *gin.Context) {
+	tunnels, err := GetTunnelManager().List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": tunnels})
+}
+
+// GinCreateTunnel 创建端口转发并立即打开
+func (h *TunnelHandler) GinCreateTunnel(c *gin.Context) {
+	var tunnel storage.Tunnel
+	if err := c.ShouldBindJSON(&tunnel); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "参数错误: " + err.Error()})
+		return
+	}
+
+	if err := normalizeTunnel(&tunnel); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	tunnel.ID = generateID()
+	tunnel.Enabled = false
+	if err := storage.SaveTunnel(&tunnel); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	info, err := GetTunnelManager().Open(tunnel.ID)
+	if err != nil {
+		// 定义已保存，可修正后重新打开
+		c.JSON(http.StatusBadGateway, gin.H{"success": false, "error": err.Error(), "data": TunnelInfo{Tunnel: tunnel, Status: tunnelFailed}})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": info})
+}
+
+// GinOpenTunnel 打开已保存的端口转发
+func (h *TunnelHandler) GinOpenTunnel(c *gin.Context) {
+	id := c.Query("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "缺少id参数"})
+		return
+	}
+
+	info, err := GetTunnelManager().Open(id)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": info})
+}
+
+// GinCloseTunnel 关闭端口转发（保留定义）
+func (h *TunnelHandler) GinCloseTunnel(c *gin.Context) {
+	id := c.Query("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "缺少id参数"})
+		return
+	}
+
+	if err := GetTunnelManager().Close(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "已关闭"})
+}
+
+// GinDeleteTunnel 关闭并删除端口转发
+func (h *TunnelHandler) GinDeleteTunnel(c *gin.Context) {
+	id := c.Query("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "缺少id参数"})
+		return
+	}
+
+	if err := GetTunnelManager().Delete(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "删除成功"})
+}
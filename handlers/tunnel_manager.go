@@ -0,0 +1,504 @@
+package handlers
+
+import (
+	"all_project/storage"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// 端口转发运行状态
+const (
+	tunnelConnecting   = "connecting"   // 正在连接
+	tunnelRunning      = "running"      // 正在转发
+	tunnelReconnecting = "reconnecting" // 断开后等待重连
+	tunnelFailed       = "failed"       // 出错且未开启自动重连（保留错误信息直到关闭或重新打开）
+	tunnelStopped      = "stopped"      // 未打开
+)
+
+// 自动重连的退避间隔
+const (
+	tunnelRetryMin = time.Second
+	tunnelRetryMax = 30 * time.Second
+)
+
+// TunnelInfo 端口转发定义及运行状态（列表接口返回）
+type TunnelInfo struct {
+	storage.Tunnel
+	Status        string     `json:"status"`
+	Error         string     `json:"error,omitempty"`
+	ListenAddr    string     `json:"listen_addr,omitempty"` // 实际监听地址（端口为0时由系统分配）
+	ActiveConns   int64      `json:"active_conns"`
+	BytesSent     int64      `json:"bytes_sent"`     // 监听端 → 目标
+	BytesReceived int64      `json:"bytes_received"` // 目标 → 监听端
+	StartedAt     *time.Time `json:"started_at,omitempty"`
+}
+
+// tunnelRuntime 一个打开的端口转发
+type tunnelRuntime struct {
+	def storage.Tunnel
+
+	mu         sync.Mutex
+	status     string
+	lastErr    string
+	listenAddr string
+	startedAt  time.Time
+
+	activeConns   int64 // 原子操作
+	bytesSent     int64 // 原子操作
+	bytesReceived int64 // 原子操作
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// TunnelManager 端口转发管理器
+type TunnelManager struct {
+	tunnels map[string]*tunnelRuntime // 打开中的转发，以及已失败、尚未关闭或重新打开的转发
+	mu      sync.Mutex
+}
+
+var (
+	tunnelManager     *TunnelManager
+	tunnelManagerOnce sync.Once
+)
+
+// GetTunnelManager 获取单例
+func GetTunnelManager() *TunnelManager {
+	tunnelManagerOnce.Do(func() {
+		tunnelManager = &TunnelManager{tunnels: make(map[string]*tunnelRuntime)}
+	})
+	return tunnelManager
+}
+
+// Restore 服务启动时恢复之前处于打开状态的转发
+func (tm *TunnelManager) Restore() {
+	tunnels, err := storage.GetTunnels()
+	if err != nil {
+		log.Printf("⚠️ 读取端口转发配置失败: %v", err)
+		return
+	}
+	for _, t := range tunnels {
+		if !t.Enabled {
+			continue
+		}
+		if _, err := tm.start(t); err != nil {
+			log.Printf("⚠️ 恢复端口转发失败: %s (%v)", t.Name, err)
+		}
+	}
+}
+
+// Open 打开端口转发（已打开时直接返回当前状态）
+// 首次连接失败时：开启自动重连则在后台继续重试，否则返回错误
+func (tm *TunnelManager) Open(id string) (TunnelInfo, error) {
+	def, err := storage.GetTunnel(id)
+	if err != nil {
+		return TunnelInfo{}, err
+	}
+	info, err := tm.start(*def)
+	if err != nil {
+		return TunnelInfo{}, err
+	}
+	if err := storage.SetTunnelEnabled(id, true); err != nil {
+		log.Printf("⚠️ 保存端口转发状态失败: %v", err)
+	}
+	return info, nil
+}
+
+// Close 关闭端口转发（保留定义），同时清除失败状态
+func (tm *TunnelManager) Close(id string) error {
+	tm.remove(id)
+	return storage.SetTunnelEnabled(id, false)
+}
+
+// Delete 关闭并删除端口转发定义
+func (tm *TunnelManager) Delete(id string) error {
+	tm.remove(id)
+	return storage.DeleteTunnel(id)
+}
+
+// remove 停止转发并移出管理器（包括已失败的转发）
+func (tm *TunnelManager) remove(id string) {
+	tm.mu.Lock()
+	t := tm.tunnels[id]
+	delete(tm.tunnels, id)
+	tm.mu.Unlock()
+
+	if t != nil {
+		t.shutdown()
+	}
+}
+
+// List 列出所有端口转发及其运行状态
+func (tm *TunnelManager) List() ([]TunnelInfo, error) {
+	defs, err := storage.GetTunnels()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]TunnelInfo, 0, len(defs))
+	for _, def := range defs {
+		infos = append(infos, tm.info(def))
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].CreatedAt.Before(infos[j].CreatedAt) })
+	return infos, nil
+}
+
+// ServerInUse 服务器是否配置了端口转发
+func (tm *TunnelManager) ServerInUse(serverID string) bool {
+	defs, err := storage.GetTunnels()
+	if err != nil {
+		return false
+	}
+	for _, def := range defs {
+		if def.ServerID == serverID {
+			return true
+		}
+	}
+	return false
+}
+
+// info 定义及运行状态
+func (tm *TunnelManager) info(def storage.Tunnel) TunnelInfo {
+	tm.mu.Lock()
+	t := tm.tunnels[def.ID]
+	tm.mu.Unlock()
+
+	if t == nil {
+		return TunnelInfo{Tunnel: def, Status: tunnelStopped}
+	}
+	info := t.info()
+	info.Tunnel = def
+	return info
+}
+
+// start 启动转发并等待首次连接结果（已失败的转发重新启动）
+func (tm *TunnelManager) start(def storage.Tunnel) (TunnelInfo, error) {
+	tm.mu.Lock()
+	if t, ok := tm.tunnels[def.ID]; ok && !t.exited() {
+		tm.mu.Unlock()
+		return t.info(), nil
+	}
+	t := &tunnelRuntime{
+		def:    def,
+		status: tunnelConnecting,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	tm.tunnels[def.ID] = t
+	tm.mu.Unlock()
+
+	ready := make(chan error, 1)
+	go func() {
+		t.run(ready)
+
+		// 正常关闭的移出管理器；失败的保留以便列表显示状态和错误，
+		// 并取消打开状态，避免服务重启后静默恢复一个已中断的转发
+		tm.mu.Lock()
+		defer tm.mu.Unlock()
+		if tm.tunnels[def.ID] != t {
+			return
+		}
+		if t.info().Status != tunnelFailed {
+			delete(tm.tunnels, def.ID)
+			return
+		}
+		if err := storage.SetTunnelEnabled(def.ID, false); err != nil {
+			log.Printf("⚠️ 保存端口转发状态失败: %v", err)
+		}
+	}()
+
+	if err := <-ready; err != nil && !def.AutoReconnect {
+		<-t.done
+		return TunnelInfo{}, err
+	}
+	return t.info(), nil
+}
+
+// run 建立连接并转发，断开后按配置自动重连；ready接收首次连接的结果
+func (t *tunnelRuntime) run(ready chan<- error) {
+	defer close(t.done)
+
+	retry := tunnelRetryMin
+	for {
+		connectedAt := time.Now()
+		err := t.serve(ready)
+		ready = nil
+
+		select {
+		case <-t.stop:
+			t.setStatus(tunnelStopped, "")
+			log.Printf("⏹ 端口转发已关闭: %s", t.def.Name)
+			return
+		default:
+		}
+
+		if !t.def.AutoReconnect {
+			t.setStatus(tunnelFailed, err.Error())
+			log.Printf("⚠️ 端口转发中断: %s (%v)", t.def.Name, err)
+			return
+		}
+
+		// 稳定运行过一段时间后重置退避间隔
+		if time.Since(connectedAt) > tunnelRetryMax {
+			retry = tunnelRetryMin
+		}
+		t.setStatus(tunnelReconnecting, err.Error())
+		log.Printf("⚠️ 端口转发中断，%v 后重连: %s (%v)", retry, t.def.Name, err)
+
+		select {
+		case <-t.stop:
+			t.setStatus(tunnelStopped, "")
+			return
+		case <-time.After(retry):
+		}
+		if retry *= 2; retry > tunnelRetryMax {
+			retry = tunnelRetryMax
+		}
+	}
+}
+
+// serve 建立一次SSH连接和监听，阻塞直到连接断开或转发被关闭
+func (t *tunnelRuntime) serve(ready chan<- error) error {
+	fail := func(err error) error {
+		if ready != nil {
+			ready <- err
+		}
+		return err
+	}
+
+	t.setStatus(tunnelConnecting, "")
+	server, err := storage.GetServer(t.def.ServerID)
+	if err != nil {
+		return fail(err)
+	}
+	client, err := connectSSH(server)
+	if err != nil {
+		return fail(fmt.Errorf("SSH 连接失败: %v", err))
+	}
+	defer client.Close()
+
+	var listener net.Listener
+	if t.def.Type == storage.TunnelRemote {
+		listener, err = client.Listen("tcp", t.def.BindAddr)
+	} else {
+		listener, err = net.Listen("tcp", t.def.BindAddr)
+	}
+	if err != nil {
+		return fail(fmt.Errorf("监听 %s 失败: %v", t.def.BindAddr, err))
+	}
+	defer listener.Close()
+
+	t.mu.Lock()
+	t.status = tunnelRunning
+	t.lastErr = ""
+	t.listenAddr = listener.Addr().String()
+	t.startedAt = time.Now()
+	t.mu.Unlock()
+	log.Printf("🔀 端口转发已建立: %s (%s %s → %s)", t.def.Name, t.def.Type, t.listenAddr, t.def.TargetAddr)
+	if ready != nil {
+		ready <- nil
+	}
+
+	acceptErr := make(chan error, 1)
+	go func() { acceptErr <- t.acceptLoop(client, listener) }()
+	clientErr := make(chan error, 1)
+	go func() { clientErr <- client.Wait() }()
+
+	select {
+	case <-t.stop:
+		return nil
+	case err := <-acceptErr:
+		return err
+	case err := <-clientErr:
+		if err == nil {
+			err = io.EOF
+		}
+		return fmt.Errorf("SSH 连接断开: %v", err)
+	}
+}
+
+// acceptLoop 接受连接并转发
+func (t *tunnelRuntime) acceptLoop(client *ssh.Client, listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return fmt.Errorf("接受连接失败: %v", err)
+		}
+		go t.handleConn(client, conn)
+	}
+}
+
+// handleConn 为一个连接建立到目标的通道并双向复制
+func (t *tunnelRuntime) handleConn(client *ssh.Client, conn net.Conn) {
+	defer conn.Close()
+
+	var target net.Conn
+	var err error
+	switch t.def.Type {
+	case storage.TunnelLocal:
+		target, err = client.Dial("tcp", t.def.TargetAddr)
+	case storage.TunnelRemote:
+		target, err = net.DialTimeout("tcp", t.def.TargetAddr, 10*time.Second)
+	case storage.TunnelDynamic:
+		var addr string
+		addr, err = socks5Handshake(conn)
+		if err != nil {
+			log.Printf("SOCKS5握手失败 (%s): %v", t.def.Name, err)
+			return
+		}
+		target, err = client.Dial("tcp", addr)
+		if err != nil {
+			socks5Reply(conn, socks5ReplyFailure)
+		} else {
+			err = socks5Reply(conn, socks5ReplySucceeded)
+		}
+	default:
+		err = fmt.Errorf("未知的转发类型: %s", t.def.Type)
+	}
+	if err != nil {
+		log.Printf("端口转发连接目标失败 (%s): %v", t.def.Name, err)
+		if target != nil {
+			target.Close()
+		}
+		return
+	}
+	defer target.Close()
+
+	atomic.AddInt64(&t.activeConns, 1)
+	defer atomic.AddInt64(&t.activeConns, -1)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		copyCounted(target, conn, &t.bytesSent)
+	}()
+	go func() {
+		defer wg.Done()
+		copyCounted(conn, target, &t.bytesReceived)
+	}()
+	wg.Wait()
+}
+
+// copyCounted 复制数据并累计字节数，读完后半关闭写端
+func copyCounted(dst, src net.Conn, counter *int64) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				break
+			}
+			atomic.AddInt64(counter, int64(n))
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	} else {
+		dst.Close()
+	}
+}
+
+// exited 转发是否已退出（失败或已关闭）
+func (t *tunnelRuntime) exited() bool {
+	select {
+	case <-t.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// shutdown 关闭转发并等待退出
+func (t *tunnelRuntime) shutdown() {
+	t.stopOnce.Do(func() { close(t.stop) })
+	<-t.done
+}
+
+func (t *tunnelRuntime) setStatus(status, errMsg string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status = status
+	t.lastErr = errMsg
+	if status != tunnelRunning {
+		t.listenAddr = ""
+	}
+}
+
+// info 运行状态快照
+func (t *tunnelRuntime) info() TunnelInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	info := TunnelInfo{
+		Tunnel:        t.def,
+		Status:        t.status,
+		Error:         t.lastErr,
+		ListenAddr:    t.listenAddr,
+		ActiveConns:   atomic.LoadInt64(&t.activeConns),
+		BytesSent:     atomic.LoadInt64(&t.bytesSent),
+		BytesReceived: atomic.LoadInt64(&t.bytesReceived),
+	}
+	if t.status == tunnelRunning {
+		startedAt := t.startedAt
+		info.StartedAt = &startedAt
+	}
+	return info
+}
+
+// normalizeTunnel 校验端口转发定义，只写端口的监听地址绑定到127.0.0.1
+func normalizeTunnel(t *storage.Tunnel) error {
+	t.Name = strings.TrimSpace(t.Name)
+	t.BindAddr = strings.TrimSpace(t.BindAddr)
+	t.TargetAddr = strings.TrimSpace(t.TargetAddr)
+
+	switch t.Type {
+	case storage.TunnelLocal, storage.TunnelRemote, storage.TunnelDynamic:
+	default:
+		return fmt.Errorf("无效的转发类型: %s（可选 local/remote/dynamic）", t.Type)
+	}
+
+	if t.ServerID == "" {
+		return errors.New("缺少server_id")
+	}
+	if _, err := storage.GetServer(t.ServerID); err != nil {
+		return err
+	}
+
+	if !strings.Contains(t.BindAddr, ":") {
+		t.BindAddr = ":" + t.BindAddr
+	}
+	host, port, err := net.SplitHostPort(t.BindAddr)
+	if err != nil || port == "" {
+		return fmt.Errorf("无效的监听地址: %s", t.BindAddr)
+	}
+	if host == "" {
+		t.BindAddr = net.JoinHostPort("127.0.0.1", port)
+	}
+
+	if t.Type == storage.TunnelDynamic {
+		t.TargetAddr = ""
+	} else if _, _, err := net.SplitHostPort(t.TargetAddr); err != nil {
+		return fmt.Errorf("无效的目标地址: %s", t.TargetAddr)
+	}
+
+	if t.Name == "" {
+		t.Name = fmt.Sprintf("%s %s", t.Type, t.BindAddr)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// SOCKS5 协议常量（RFC 1928，仅支持无认证的CONNECT）
+const (
+	socks5Version      = 0x05
+	socks5NoAuth       = 0x00
+	socks5NoAcceptable = 0xff
+	socks5CmdConnect   = 0x01
+	socks5AtypIPv4     = 0x01
+	socks5AtypDomain   = 0x03
+	socks5AtypIPv6     = 0x04
+
+	socks5ReplySucceeded       = 0x00
+	socks5ReplyFailure         = 0x01
+	socks5ReplyCmdUnsupported  = 0x07
+	socks5ReplyAtypUnsupported = 0x08
+)
+
+// socks5Handshake 完成SOCKS5握手，返回客户端请求连接的目标地址（host:port）
+func socks5Handshake(conn net.Conn) (string, error) {
+	// 版本与认证方式协商
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	if header[0] != socks5Version {
+		return "", fmt.Errorf("不支持的SOCKS版本: %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+	noAuth := false
+	for _, m := range methods {
+		if m == socks5NoAuth {
+			noAuth = true
+			break
+		}
+	}
+	if !noAuth {
+		conn.Write([]byte{socks5Version, socks5NoAcceptable})
+		return "", fmt.Errorf("客户端不支持无认证方式")
+	}
+	if _, err := conn.Write([]byte{socks5Version, socks5NoAuth}); err != nil {
+		return "", err
+	}
+
+	// 连接请求：VER CMD RSV ATYP DST.ADDR DST.PORT
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return "", err
+	}
+	if request[1] != socks5CmdConnect {
+		socks5Reply(conn, socks5ReplyCmdUnsupported)
+		return "", fmt.Errorf("不支持的SOCKS命令: %d", request[1])
+	}
+
+	var host string
+	switch request[3] {
+	case socks5AtypIPv4, socks5AtypIPv6:
+		size := net.IPv4len
+		if request[3] == socks5AtypIPv6 {
+			size = net.IPv6len
+		}
+		ip := make([]byte, size)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case socks5AtypDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		socks5Reply(conn, socks5ReplyAtypUnsupported)
+		return "", fmt.Errorf("不支持的地址类型: %d", request[3])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// socks5Reply 发送连接结果（绑定地址固定为0.0.0.0:0）
+func socks5Reply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{socks5Version, code, 0x00, socks5AtypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"all_project/storage"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -32,9 +33,29 @@ func NewWebSocketHandler() *WebSocketHandler {
 }
 
 // GinHandleWebSocket 处理 WebSocket 连接 (Gin版本)
+// session_id 对应的会话仍在运行时直接重新连接（回放输出），否则新建SSH会话
 func (h *WebSocketHandler) GinHandleWebSocket(c *gin.Context) {
-	// 获取服务器ID
 	serverID := c.Query("server_id")
+	sessionID := c.Query("session_id")
+
+	// 重新连接已断开的会话（页面刷新、网络中断后）
+	if sessionID != "" {
+		if existing := GetSessionManager().GetSession(sessionID); existing != nil && existing.Shell != nil &&
+			(serverID == "" || existing.ServerID == serverID) {
+			ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+			if err != nil {
+				log.Println("WebSocket 升级失败:", err)
+				return
+			}
+			defer ws.Close()
+
+			log.Printf("重新连接SSH会话: %s (%s)", sessionID, existing.ServerName)
+			attachSSHSession(ws, existing)
+			return
+		}
+	}
+
+	// 获取服务器ID
 	if serverID == "" {
 		c.JSON(400, gin.H{"error": "缺少服务器ID"})
 		return
@@ -55,14 +76,34 @@ func (h *WebSocketHandler) GinHandleWebSocket(c *gin.Context) {
 	}
 	defer ws.Close()
 
-	// 建立 SSH 连接
-	sshClient, err := connectSSH(server)
+	session, err := startSSHSession(server, sessionID)
 	if err != nil {
 		log.Println("SSH 连接失败:", err)
+		var mismatch *HostKeyMismatchError
+		if errors.As(err, &mismatch) {
+			// 以红色醒目提示，避免用户忽略主机密钥变更
+			ws.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("\r\n\x1b[1;31m%s\x1b[0m\r\n", mismatch.Error())))
+			return
+		}
 		ws.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("SSH 连接失败: %v", err)))
 		return
 	}
-	defer sshClient.Close()
+
+	attachSSHSession(ws, session)
+}
+
+// startSSHSession 建立SSH连接并启动带PTY的shell，注册到会话管理器
+// 会话在shell退出、被结束或断开后空闲超时时关闭，而不是随WebSocket关闭
+func startSSHSession(server *storage.Server, sessionID string) (*SSHSession, error) {
+	if sessionID == "" {
+		sessionID = generateID()
+	}
+
+	// 建立 SSH 连接
+	sshClient, err := connectSSH(server)
+	if err != nil {
+		return nil, err
+	}
 
 	// 创建 SFTP 客户端（复用SSH连接）
 	sftpClient, err := sftp.NewClient(sshClient)
@@ -71,27 +112,25 @@ func (h *WebSocketHandler) GinHandleWebSocket(c *gin.Context) {
 		// SFTP失败不影响终端使用，继续
 		sftpClient = nil
 	} else {
-		defer sftpClient.Close()
 		log.Println("SFTP 客户端创建成功")
 	}
 
-	// 从URL获取sessionID（前端需要传递）
-	sessionID := c.Query("session_id")
-	if sessionID != "" && sftpClient != nil {
-		// 保存到会话管理器
-		GetSessionManager().AddSession(sessionID, sshClient, sftpClient)
-		defer GetSessionManager().RemoveSession(sessionID)
-		log.Printf("会话已保存: %s", sessionID)
+	session := &SSHSession{
+		ID:         sessionID,
+		ServerID:   server.ID,
+		ServerName: server.Name,
+		SSHClient:  sshClient,
+		SFTPClient: sftpClient,
+		Output:     newTerminalStream(),
 	}
 
 	// 创建 SSH 会话
-	session, err := sshClient.NewSession()
+	shell, err := sshClient.NewSession()
 	if err != nil {
-		log.Println("创建 SSH 会话失败:", err)
-		ws.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("创建会话失败: %v", err)))
-		return
+		session.close()
+		return nil, fmt.Errorf("创建会话失败: %v", err)
 	}
-	defer session.Close()
+	session.Shell = shell
 
 	// 请求 PTY（伪终端）
 	modes := ssh.TerminalModes{
@@ -99,24 +138,71 @@ func (h *WebSocketHandler) GinHandleWebSocket(c *gin.Context) {
 		ssh.TTY_OP_ISPEED: 14400,
 		ssh.TTY_OP_OSPEED: 14400,
 	}
-	if err := session.RequestPty("xterm-256color", 40, 120, modes); err != nil {
-		log.Println("请求 PTY 失败:", err)
-		return
+	if err := shell.RequestPty("xterm-256color", defaultTermRows, defaultTermCols, modes); err != nil {
+		session.close()
+		return nil, fmt.Errorf("请求 PTY 失败: %v", err)
 	}
 
 	// 获取输入输出管道
-	stdin, _ := session.StdinPipe()
-	stdout, _ := session.StdoutPipe()
-	stderr, _ := session.StderrPipe()
+	stdin, _ := shell.StdinPipe()
+	stdout, _ := shell.StdoutPipe()
+	stderr, _ := shell.StderrPipe()
+	session.startInput(stdin)
 
 	// 启动 Shell
-	if err := session.Shell(); err != nil {
-		log.Println("启动 Shell 失败:", err)
-		return
+	if err := shell.Shell(); err != nil {
+		session.close()
+		return nil, fmt.Errorf("启动 Shell 失败: %v", err)
 	}
 
 	log.Printf("SSH 连接成功: %s@%s:%d", server.Username, server.Host, server.Port)
 
+	// 会话录像（全局或服务器单独开启）
+	if shouldRecord(server.Record) {
+		recorder, err := newTerminalRecorder(server.ID, sessionID, server.Name, "")
+		if err != nil {
+			log.Printf("⚠️ 创建录像失败: %v", err)
+		}
+		session.Recorder = recorder
+	}
+
+	// 命令历史：解析shell集成序列（OSC 133/633），不依赖前端提交
+	session.Commands = newCommandCapture(server.ID, server.Name)
+
+	// SSH 输出（stdout/stderr）→ 回滚缓冲（命令捕获、录像），慢客户端从缓冲追赶而不是丢帧
+	output := session.outputWriter()
+	go func() {
+		if _, err := io.Copy(output, stdout); err != nil {
+			log.Println("读取 stdout 失败:", err)
+		}
+		session.Output.Close()
+	}()
+	go func() {
+		if _, err := io.Copy(output, stderr); err != nil {
+			log.Println("读取 stderr 失败:", err)
+		}
+	}()
+
+	// shell退出后关闭会话
+	go func() {
+		shell.Wait()
+		log.Printf("SSH 会话结束: %s (%s)", session.ID, session.ServerName)
+		GetSessionManager().removeIfSame(session)
+	}()
+
+	// 保存到会话管理器
+	GetSessionManager().AddSession(session)
+	log.Printf("会话已保存: %s", sessionID)
+
+	return session, nil
+}
+
+// attachSSHSession 将WebSocket连接到会话，直到连接断开或shell退出
+// 连接后先回放输出缓冲，断开时会话继续运行
+func attachSSHSession(ws *websocket.Conn, session *SSHSession) {
+	session.Attach()
+	defer session.Detach()
+
 	// 设置WebSocket为二进制模式，禁用压缩提高性能
 	ws.SetReadDeadline(time.Time{})  // 不设置读超时
 	ws.SetWriteDeadline(time.Time{}) // 不设置写超时
@@ -124,45 +210,17 @@ func (h *WebSocketHandler) GinHandleWebSocket(c *gin.Context) {
 	// 双向数据转发
 	done := make(chan bool, 2)
 
-	// SSH 输出 → WebSocket (优化：使用32KB缓冲)
+	// 回滚缓冲 → WebSocket（单一writer，避免并发写入）
+	sub := session.Output.Subscribe()
+	defer session.Output.Unsubscribe(sub)
 	go func() {
 		defer func() { done <- true }()
-		buffer := make([]byte, 32768) // 32KB缓冲
-		for {
-			n, err := stdout.Read(buffer)
-			if err != nil {
-				if err != io.EOF {
-					log.Println("读取 stdout 失败:", err)
-				}
-				return
-			}
-			if n > 0 {
-				if err := ws.WriteMessage(websocket.BinaryMessage, buffer[:n]); err != nil {
-					log.Println("写入 WebSocket 失败:", err)
-					return
-				}
-			}
-		}
-	}()
-
-	// SSH 错误输出 → WebSocket (合并到stdout处理)
-	go func() {
-		buffer := make([]byte, 8192) // 8KB缓冲
-		for {
-			n, err := stderr.Read(buffer)
-			if err != nil {
-				if err != io.EOF {
-					log.Println("读取 stderr 失败:", err)
-				}
-				return
-			}
-			if n > 0 {
-				ws.WriteMessage(websocket.BinaryMessage, buffer[:n])
-			}
+		if err := writeStream(ws, sub); err != nil {
+			log.Println("写入 WebSocket 失败:", err)
 		}
 	}()
 
-	// WebSocket → SSH 输入 (优化：批量写入)
+	// WebSocket → SSH 输入
 	go func() {
 		defer func() { done <- true }()
 		for {
@@ -172,12 +230,25 @@ func (h *WebSocketHandler) GinHandleWebSocket(c *gin.Context) {
 				return
 			}
 
+			// 控制消息（调整窗口大小等）
+			if ctrl, ok := parseTerminalControl(msgType, data); ok {
+				if ctrl.Type == termCtrlResize && ctrl.validSize() {
+					if err := session.Resize(ctrl.Rows, ctrl.Cols); err != nil {
+						log.Println("调整终端大小失败:", err)
+					}
+				}
+				continue
+			}
+
 			if msgType == websocket.TextMessage || msgType == websocket.BinaryMessage {
 				if len(data) > 0 {
-					if _, err := stdin.Write(data); err != nil {
+					session.Recorder.Input(data)
+					if err := session.WriteInput(data); err != nil {
 						log.Println("写入 stdin 失败:", err)
 						return
 					}
+					// 所在广播组启用时同步写入组内其他会话
+					broadcastInput(session, data)
 				}
 			}
 		}
@@ -185,23 +256,46 @@ func (h *WebSocketHandler) GinHandleWebSocket(c *gin.Context) {
 
 	// 等待连接结束
 	<-done
-	log.Println("SSH 会话结束")
+	log.Printf("客户端已断开SSH会话: %s", session.ID)
 }
 
-// connectSSH 连接 SSH 服务器
-func connectSSH(server *storage.Server) (*ssh.Client, error) {
-	config := &ssh.ClientConfig{
-		User: server.Username,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(server.Password),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         10 * time.Second,        // 连接超时10秒
-		ClientVersion:   "SSH-2.0-WebSSH_Client", // 客户端版本标识
+// GinListSSHSessions 列出SSH会话（含已断开、等待重新连接的会话）
+func (h *WebSocketHandler) GinListSSHSessions(c *gin.Context) {
+	sessions := GetSessionManager().ListSessions()
+	if c.Query("detached") == "true" {
+		detached := []SSHSessionInfo{}
+		for _, s := range sessions {
+			if s.Detached {
+				detached = append(detached, s)
+			}
+		}
+		sessions = detached
 	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": sessions})
+}
 
-	address := fmt.Sprintf("%s:%d", server.Host, server.Port)
-	client, err := ssh.Dial("tcp", address, config)
+// GinKillSSHSession 结束SSH会话（关闭shell和连接）
+func (h *WebSocketHandler) GinKillSSHSession(c *gin.Context) {
+	id := c.Query("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "缺少id参数"})
+		return
+	}
+
+	if err := GetSessionManager().KillSession(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "会话已结束"})
+}
+
+// maxJumpHops 跳板链最大层数
+const maxJumpHops = 8
+
+// connectSSH 连接 SSH 服务器（配置了跳板机时经跳板链隧道连接）
+func connectSSH(server *storage.Server) (*ssh.Client, error) {
+	client, err := dialSSH(server, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -220,3 +314,89 @@ func connectSSH(server *storage.Server) (*ssh.Client, error) {
 
 	return client, nil
 }
+
+// dialSSH 建立SSH连接，visited为已经过的跳板（用于检测循环引用）
+func dialSSH(server *storage.Server, visited []string) (*ssh.Client, error) {
+	return dialSSHWith(server, visited, hostKeyCallback)
+}
+
+// dialSSHWith 同dialSSH，hostKeys为链上每一跳的主机密钥校验（探测时只校验不记录）
+func dialSSHWith(server *storage.Server, visited []string, hostKeys func(*storage.Server) ssh.HostKeyCallback) (*ssh.Client, error) {
+	for _, id := range visited {
+		if id == server.ID {
+			return nil, fmt.Errorf("跳板机配置存在循环引用: %s", server.Name)
+		}
+	}
+	if len(visited) >= maxJumpHops {
+		return nil, fmt.Errorf("跳板链超过最大层数 %d", maxJumpHops)
+	}
+
+	// 未设置的用户名/端口/凭据/跳板机继承自所在分组
+	effective, err := storage.ApplyGroupDefaults(*server)
+	if err != nil {
+		return nil, err
+	}
+	server = &effective
+
+	// 按配置顺序尝试认证方式（密码/私钥/证书/agent/键盘交互）
+	auths, cleanup, err := buildAuthMethods(server)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	config := &ssh.ClientConfig{
+		User:            server.Username,
+		Auth:            auths,
+		HostKeyCallback: hostKeys(server),
+		Timeout:         10 * time.Second,        // 连接超时10秒
+		ClientVersion:   "SSH-2.0-WebSSH_Client", // 客户端版本标识
+	}
+
+	address := fmt.Sprintf("%s:%d", server.Host, server.Port)
+	if server.JumpHostID == "" {
+		return ssh.Dial("tcp", address, config)
+	}
+
+	jumpServer, err := storage.GetServer(server.JumpHostID)
+	if err != nil {
+		return nil, fmt.Errorf("跳板机不存在: %v", err)
+	}
+	jumpClient, err := dialSSHWith(jumpServer, append(visited, server.ID), hostKeys)
+	if err != nil {
+		return nil, fmt.Errorf("连接跳板机 %s 失败: %w", jumpServer.Name, err)
+	}
+
+	// 通过跳板机的direct-tcpip通道连接目标
+	conn, err := jumpClient.Dial("tcp", address)
+	if err != nil {
+		jumpClient.Close()
+		return nil, fmt.Errorf("跳板机 %s 无法连接 %s: %v", jumpServer.Name, address, err)
+	}
+
+	// 隧道通道不支持SetDeadline，握手超时通过关闭通道实现
+	timer := time.AfterFunc(config.Timeout, func() { conn.Close() })
+	c, chans, reqs, err := ssh.NewClientConn(conn, address, config)
+	if !timer.Stop() {
+		if err == nil {
+			c.Close()
+		}
+		jumpClient.Close()
+		return nil, fmt.Errorf("经跳板机 %s 连接 %s 超时", jumpServer.Name, address)
+	}
+	if err != nil {
+		conn.Close()
+		jumpClient.Close()
+		return nil, err
+	}
+
+	client := ssh.NewClient(c, chans, reqs)
+
+	// 目标连接关闭时一并关闭跳板连接
+	go func() {
+		client.Wait()
+		jumpClient.Close()
+	}()
+
+	return client, nil
+}
@@ -19,6 +19,11 @@ func main() {
 		log.Fatalf("❌ 配置文件加载失败: %v", err)
 	}
 
+	// 设置敏感字段加密主密钥（存储初始化时会迁移旧版明文数据）
+	if err := storage.SetMasterKey(config.GetMasterKey()); err != nil {
+		log.Fatalf("❌ 主密钥设置失败: %v", err)
+	}
+
 	// 初始化存储
 	if err := storage.Init(); err != nil {
 		log.Fatalf("❌ 存储初始化失败: %v", err)
@@ -52,15 +57,21 @@ func main() {
 	wsHandler := handlers.NewWebSocketHandler()
 	fileHandler := handlers.NewFileHandler()
 	localFileHandler := handlers.NewLocalFileHandler()
+	recordingHandler := handlers.NewRecordingHandler()
+	execHandler := handlers.NewExecHandler()
+	broadcastHandler := handlers.NewBroadcastHandler()
+	tunnelHandler := handlers.NewTunnelHandler()
 
 	// AI相关handlers
 	aiProvidersHandler := handlers.NewAIProvidersHandler()
 	aiConfigHandler := handlers.NewAIConfigHandler()
+	aiPromptsHandler := handlers.NewAIPromptsHandler()
+	aiUsageHandler := handlers.NewAIUsageHandler()
 	aiSessionsHandler := handlers.NewAISessionsHandler()
 	aiChatHandler := handlers.NewAIChatHandler()
 	aiEditHandler := handlers.NewAIEditHandler()
 
-	// 初始化全局本地终端
+	// 创建默认本地终端（其他会话通过 /api/local/terminal/create 按需创建）
 	if err := handlers.InitGlobalLocalTerminal(); err != nil {
 		log.Printf("⚠️ 本地终端初始化失败: %v", err)
 	}
@@ -69,6 +80,18 @@ func main() {
 	middleware.StartCleanupTask()
 	log.Println("✓ Session清理任务已启动")
 
+	// 断开后的SSH会话保留一段时间，超时未重新连接则关闭
+	handlers.GetSessionManager().StartCleanup(config.GetSessionIdleTimeout())
+
+	// 按保留天数清理终端录像
+	storage.StartRecordingCleanup(config.GetRecordingRetentionDays())
+
+	// 恢复重启前处于打开状态的端口转发
+	go handlers.GetTunnelManager().Restore()
+
+	// 定期探测服务器可达性（状态通过 /ws/servers/status 推送）
+	handlers.GetServerProber().Start(config.GetProbeInterval(), config.GetProbeConcurrency(), config.ProbeAuth())
+
 	// 设置Gin为发布模式（生产环境）
 	gin.SetMode(gin.ReleaseMode)
 
@@ -128,6 +151,52 @@ func main() {
 		api.POST("/server/update", serverHandler.GinUpdateServer)
 		api.POST("/server/delete", serverHandler.GinDeleteServer)
 		api.GET("/servers/search", serverHandler.GinSearchServers)
+		api.POST("/servers/import", serverHandler.GinImportServers) // ssh_config/csv/json，dry_run=true 预览
+		api.GET("/servers/export", serverHandler.GinExportServers)
+		api.GET("/server/hostkey", serverHandler.GinGetHostKey)
+		api.POST("/server/hostkey/reset", serverHandler.GinResetHostKey)
+		api.GET("/servers/status", serverHandler.GinGetServerStatuses)
+		api.POST("/server/probe", serverHandler.GinProbeServer)
+
+		// 服务器分组（子分组和服务器继承连接默认值）
+		api.GET("/server/groups", serverHandler.GinGetServerGroups)
+		api.GET("/server/tree", serverHandler.GinGetServerTree)
+		api.POST("/server/group/create", serverHandler.GinCreateServerGroup)
+		api.POST("/server/group/update", serverHandler.GinUpdateServerGroup)
+		api.POST("/server/group/delete", serverHandler.GinDeleteServerGroup)
+		api.POST("/servers/move", serverHandler.GinMoveServers)
+
+		// SSH会话（断开后可通过 /ws?session_id= 重新连接）
+		api.GET("/ssh/sessions", wsHandler.GinListSSHSessions)
+		api.POST("/ssh/session/kill", wsHandler.GinKillSSHSession)
+
+		// 输入广播组（键盘输入同步到多个SSH会话）
+		api.GET("/broadcast/groups", broadcastHandler.GinListBroadcastGroups)
+		api.POST("/broadcast/group/create", broadcastHandler.GinCreateBroadcastGroup)
+		api.POST("/broadcast/group/delete", broadcastHandler.GinDeleteBroadcastGroup)
+		api.POST("/broadcast/group/join", broadcastHandler.GinJoinBroadcastGroup)
+		api.POST("/broadcast/group/leave", broadcastHandler.GinLeaveBroadcastGroup)
+		api.POST("/broadcast/group/toggle", broadcastHandler.GinToggleBroadcastGroup)
+
+		// 端口转发（-L/-R/-D）
+		api.GET("/tunnels", tunnelHandler.GinListTunnels)
+		api.POST("/tunnel/create", tunnelHandler.GinCreateTunnel)
+		api.POST("/tunnel/open", tunnelHandler.GinOpenTunnel)
+		api.POST("/tunnel/close", tunnelHandler.GinCloseTunnel)
+		api.POST("/tunnel/delete", tunnelHandler.GinDeleteTunnel)
+
+		// 终端录像（asciicast v2）
+		api.GET("/recordings", recordingHandler.GinListRecordings)
+		api.GET("/recording/download", recordingHandler.GinDownloadRecording)
+		api.GET("/recording/play", recordingHandler.GinPlayRecording)
+		api.POST("/recording/delete", recordingHandler.GinDeleteRecording)
+
+		// 批量执行（实时输出通过 /ws/exec?job_id= 获取）
+		api.POST("/exec/run", execHandler.GinRunExec)
+		api.GET("/exec/jobs", execHandler.GinListExecJobs)
+		api.GET("/exec/job", execHandler.GinGetExecJob)
+		api.POST("/exec/job/cancel", execHandler.GinCancelExecJob)
+		api.POST("/exec/job/delete", execHandler.GinDeleteExecJob)
 
 		// 命令历史
 		api.POST("/command/save", commandHandler.GinSaveCommand)
@@ -161,6 +230,11 @@ func main() {
 		api.POST("/local/files/rename", localFileHandler.RenameLocalFile)
 		api.POST("/local/files/copy", localFileHandler.CopyLocalFile)
 
+		// 本地终端会话（通过 /ws/local?session_id= 连接）
+		api.GET("/local/terminals", handlers.GinListLocalTerminals)
+		api.POST("/local/terminal/create", handlers.GinCreateLocalTerminal)
+		api.POST("/local/terminal/kill", handlers.GinKillLocalTerminal)
+
 		// AI供应商和模型管理
 		api.GET("/ai/providers", aiProvidersHandler.GetProviders)
 		api.GET("/ai/provider", aiProvidersHandler.GetProvider)
@@ -173,6 +247,17 @@ func main() {
 		api.GET("/ai/config", aiConfigHandler.GetConfig)
 		api.POST("/ai/config/update", aiConfigHandler.UpdateConfig)
 
+		// 系统提示词预设
+		api.GET("/ai/prompts", aiPromptsHandler.GetPresets)
+		api.POST("/ai/prompt/create", aiPromptsHandler.CreatePreset)
+		api.POST("/ai/prompt/update", aiPromptsHandler.UpdatePreset)
+		api.POST("/ai/prompt/delete", aiPromptsHandler.DeletePreset)
+		api.GET("/ai/prompt/variables", aiPromptsHandler.GetVariables)
+		api.POST("/ai/prompt/preview", aiPromptsHandler.PreviewPreset)
+
+		// token用量与费用统计
+		api.GET("/ai/usage", aiUsageHandler.GetUsage)
+
 		// AI会话管理
 		api.GET("/ai/sessions", aiSessionsHandler.GetSessions)
 		api.GET("/ai/session", aiSessionsHandler.GetSession)
@@ -180,11 +265,14 @@ func main() {
 		api.POST("/ai/session/delete", aiSessionsHandler.DeleteSession)
 		api.POST("/ai/session/clear", aiSessionsHandler.ClearSession)
 		api.POST("/ai/session/update-model", aiSessionsHandler.UpdateSessionModel)
+		api.POST("/ai/session/update-settings", aiSessionsHandler.UpdateSessionSettings)
+		api.POST("/ai/session/update-preset", aiSessionsHandler.UpdateSessionPreset)
+		api.GET("/ai/session/effective-config", aiSessionsHandler.GetEffectiveConfig)
 		api.GET("/ai/messages", aiSessionsHandler.GetMessages)
 		api.POST("/ai/message/update", aiSessionsHandler.UpdateMessage)
 		api.POST("/ai/message/revoke", aiSessionsHandler.RevokeMessage)
 
-		// AI工具确认/拒绝（更新状态，实际文件操作由前端调用文件API）
+		// AI工具确认/拒绝（由后端写入本地或远程服务器）
 		api.POST("/ai/edit/apply", aiEditHandler.ApplyEdit)
 		// 注：文件历史自动备份，回退通过消息撤销自动实现
 	}
@@ -192,6 +280,8 @@ func main() {
 	// WebSocket 路由（需要认证，未登录则重定向）
 	r.GET("/ws", middleware.GinPageAuthMiddleware(), wsHandler.GinHandleWebSocket)
 	r.GET("/ws/local", middleware.GinPageAuthMiddleware(), handlers.GinHandleLocalTerminal)
+	r.GET("/ws/exec", middleware.GinPageAuthMiddleware(), execHandler.GinExecStream)
+	r.GET("/ws/servers/status", middleware.GinPageAuthMiddleware(), serverHandler.GinServerStatusStream)
 	r.GET("/ws/ai", middleware.GinPageAuthMiddleware(), func(c *gin.Context) {
 		aiChatHandler.ChatStream(c.Writer, c.Request)
 	})
@@ -58,7 +58,10 @@ func GetFileHistoryManager() *FileHistoryManager {
 func (m *FileHistoryManager) AddSnapshot(conversationID, filePath string, userMessageIndex int, content string) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
+	return m.addSnapshotLocked(conversationID, filePath, userMessageIndex, content)
+}
 
+func (m *FileHistoryManager) addSnapshotLocked(conversationID, filePath string, userMessageIndex int, content string) error {
 	// 获取或创建会话历史
 	conv, exists := m.histories[conversationID]
 	if !exists {
@@ -92,6 +95,27 @@ func (m *FileHistoryManager) AddSnapshot(conversationID, filePath string, userMe
 	return m.saveLocked()
 }
 
+// SetSnapshot 设置快照（替换该文件指定Turn的已有快照，不存在则添加）
+func (m *FileHistoryManager) SetSnapshot(conversationID, filePath string, userMessageIndex int, content string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if conv, exists := m.histories[conversationID]; exists {
+		if fileHist, exists := conv.Files[filePath]; exists {
+			for i := range fileHist.Snapshots {
+				if fileHist.Snapshots[i].UserMessageIndex == userMessageIndex {
+					fileHist.Snapshots[i].Content = content
+					fileHist.Snapshots[i].Timestamp = time.Now()
+					log.Printf("📸 更新快照 Turn%d: %s (%d字节)", userMessageIndex, filePath, len(content))
+					return m.saveLocked()
+				}
+			}
+		}
+	}
+
+	return m.addSnapshotLocked(conversationID, filePath, userMessageIndex, content)
+}
+
 // GetLastSnapshot 获取最后一个快照
 func (m *FileHistoryManager) GetLastSnapshot(conversationID, filePath string) (string, bool) {
 	m.mutex.RLock()
@@ -267,6 +291,39 @@ func (m *FileHistoryManager) RemoveSnapshot(conversationID string, turnIndex int
 	return m.saveLocked()
 }
 
+// RemoveFileSnapshot 删除单个文件指定Turn的快照
+func (m *FileHistoryManager) RemoveFileSnapshot(conversationID, filePath string, turnIndex int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	conv, exists := m.histories[conversationID]
+	if !exists {
+		return nil
+	}
+
+	fileHist, exists := conv.Files[filePath]
+	if !exists {
+		return nil
+	}
+
+	newSnapshots := []TurnSnapshot{}
+	for _, snapshot := range fileHist.Snapshots {
+		if snapshot.UserMessageIndex != turnIndex {
+			newSnapshots = append(newSnapshots, snapshot)
+		}
+	}
+	fileHist.Snapshots = newSnapshots
+
+	if len(newSnapshots) == 0 {
+		delete(conv.Files, filePath)
+	}
+	if len(conv.Files) == 0 {
+		delete(m.histories, conversationID)
+	}
+
+	return m.saveLocked()
+}
+
 // ClearConversation 清空会话的所有历史
 func (m *FileHistoryManager) ClearConversation(conversationID string) error {
 	m.mutex.Lock()
@@ -12,16 +12,26 @@ import (
 
 // EditOperation 单次编辑操作
 type EditOperation struct {
+	Type       string `json:"type,omitempty"` // "edit"(默认，搜索替换) 或 "write"(整文件覆盖)
 	ToolCallID string `json:"tool_call_id"`
 	MessageID  string `json:"message_id"`
 	OldString  string `json:"old_string"`
 	NewString  string `json:"new_string"`
+	Content    string `json:"content,omitempty"` // write 专用：完整文件内容
+}
+
+// Apply 在content上应用该操作，返回新内容
+func (e EditOperation) Apply(content string) string {
+	if e.Type == "write" {
+		return e.Content
+	}
+	return strings.Replace(content, e.OldString, e.NewString, 1)
 }
 
 // TurnEdits 一轮对话的编辑
 type TurnEdits struct {
 	UserMessageIndex int                        `json:"user_message_index"` // 用户消息索引
-	FileEdits        map[string][]EditOperation `json:"file_edits"`         // {文件路径: [edit操作]}
+	FileEdits        map[string][]EditOperation `json:"file_edits"`         // {文件键: [edit操作]}，见FileKey
 	Timestamp        time.Time                  `json:"timestamp"`
 }
 
@@ -39,6 +49,31 @@ type PendingStateManager struct {
 	dataDir string
 }
 
+// remoteKeyPrefix 远程文件键前缀（ssh://<serverID><绝对路径>）
+const remoteKeyPrefix = "ssh://"
+
+// FileKey 生成pending/快照使用的文件键
+// 本地文件直接使用路径（兼容已有数据），远程文件加上服务器前缀，避免不同服务器的同名路径冲突
+func FileKey(serverID, filePath string) string {
+	if serverID == "" || serverID == "local" {
+		return filePath
+	}
+	return remoteKeyPrefix + serverID + filePath
+}
+
+// ParseFileKey 解析文件键，返回服务器ID（本地为"local"）和文件路径
+func ParseFileKey(key string) (serverID, filePath string) {
+	if !strings.HasPrefix(key, remoteKeyPrefix) {
+		return "local", key
+	}
+	rest := strings.TrimPrefix(key, remoteKeyPrefix)
+	idx := strings.Index(rest, "/")
+	if idx < 0 {
+		return rest, ""
+	}
+	return rest[:idx], rest[idx:]
+}
+
 var pendingStateManagerInstance *PendingStateManager
 var pendingStateOnce sync.Once
 
@@ -118,7 +153,7 @@ func (m *PendingStateManager) GetCurrentContent(conversationID, filePath string,
 	for _, turn := range conv.Turns {
 		if edits, ok := turn.FileEdits[filePath]; ok {
 			for _, edit := range edits {
-				content = strings.Replace(content, edit.OldString, edit.NewString, 1)
+				content = edit.Apply(content)
 			}
 		}
 	}
@@ -183,6 +218,105 @@ func (m *PendingStateManager) RemoveTurnsFrom(conversationID string, fromMessage
 	return m.saveLocked()
 }
 
+// EditSelector 选择部分pending操作（空字段表示不限制）
+type EditSelector struct {
+	FileKey    string // 文件键（见FileKey）
+	ToolCallID string // 单个工具调用（一次edit/write）
+}
+
+// IsEmpty 是否未指定任何条件（即全部）
+func (s EditSelector) IsEmpty() bool {
+	return s.FileKey == "" && s.ToolCallID == ""
+}
+
+// Match 判断操作是否被选中
+func (s EditSelector) Match(fileKey string, edit EditOperation) bool {
+	if s.FileKey != "" && s.FileKey != fileKey {
+		return false
+	}
+	if s.ToolCallID != "" && s.ToolCallID != edit.ToolCallID {
+		return false
+	}
+	return true
+}
+
+// SelectEdits 返回匹配的操作（按轮次组织的副本，不修改pending）
+func (m *PendingStateManager) SelectEdits(conversationID string, sel EditSelector) []TurnEdits {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	selected := []TurnEdits{}
+	conv, exists := m.states[conversationID]
+	if !exists {
+		return selected
+	}
+
+	for _, turn := range conv.Turns {
+		fileEdits := make(map[string][]EditOperation)
+		for fileKey, edits := range turn.FileEdits {
+			for _, edit := range edits {
+				if sel.Match(fileKey, edit) {
+					fileEdits[fileKey] = append(fileEdits[fileKey], edit)
+				}
+			}
+		}
+		if len(fileEdits) > 0 {
+			selected = append(selected, TurnEdits{
+				UserMessageIndex: turn.UserMessageIndex,
+				FileEdits:        fileEdits,
+				Timestamp:        turn.Timestamp,
+			})
+		}
+	}
+
+	return selected
+}
+
+// RemoveEdits 从pending中移除匹配的操作（空文件/空轮次一并删除）
+func (m *PendingStateManager) RemoveEdits(conversationID string, sel EditSelector) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	conv, exists := m.states[conversationID]
+	if !exists {
+		return nil
+	}
+
+	removed := 0
+	newTurns := []TurnEdits{}
+	for _, turn := range conv.Turns {
+		for fileKey, edits := range turn.FileEdits {
+			kept := []EditOperation{}
+			for _, edit := range edits {
+				if sel.Match(fileKey, edit) {
+					removed++
+				} else {
+					kept = append(kept, edit)
+				}
+			}
+			if len(kept) > 0 {
+				turn.FileEdits[fileKey] = kept
+			} else {
+				delete(turn.FileEdits, fileKey)
+			}
+		}
+		if len(turn.FileEdits) > 0 {
+			newTurns = append(newTurns, turn)
+		}
+	}
+
+	if len(newTurns) == 0 {
+		delete(m.states, conversationID)
+	} else {
+		conv.Turns = newTurns
+		conv.UpdatedAt = time.Now()
+	}
+
+	log.Printf("🧹 移除%d个pending操作: %s", removed, conversationID)
+
+	return m.saveLocked()
+}
+
 // GetTurns 获取所有轮次（用于计算快照）
 func (m *PendingStateManager) GetTurns(conversationID string) []TurnEdits {
 	m.mutex.RLock()
@@ -5,6 +5,7 @@ import { state } from './config.js';
 import { getEditorInstance } from './editor.js';
 import { showToast } from './toast.js';
 import aiToolsManager from './ai-tools.js';
+import { sendInput } from './terminal.js';
 
 // 全局变量
 let currentSession = null;
@@ -81,6 +82,11 @@ window.toggleModelSelector = async function() {
             // 使用缓存，直接渲染
             renderModelList(allModels);
         }
+        if (!presetsLoaded) {
+            await loadPresetList();
+        } else {
+            renderPresetList(allPresets);
+        }
         popup.style.display = 'block';
     }
 };
@@ -157,6 +163,67 @@ window.selectTempModel = async function(modelId) {
     }
 };
 
+// ========== 提示词预设 ==========
+
+let allPresets = []; // 缓存提示词预设
+let presetsLoaded = false;
+
+async function loadPresetList() {
+    try {
+        const data = await apiRequest('/api/ai/prompts');
+        allPresets = data.data || [];
+        presetsLoaded = true;
+        renderPresetList(allPresets);
+    } catch (error) {
+        console.error('加载提示词预设失败:', error);
+        const container = document.getElementById('presetList');
+        if (container) container.innerHTML = '<div class="loading-small">加载失败</div>';
+    }
+}
+
+// 刷新预设缓存（设置面板编辑预设后调用）
+window.refreshPresetCache = async function() {
+    presetsLoaded = false;
+    await loadPresetList();
+};
+
+// 渲染预设列表（第一项为不使用预设）
+function renderPresetList(presets) {
+    const container = document.getElementById('presetList');
+    if (!container) return;
+
+    const currentPresetId = currentSession?.preset_id || '';
+    const options = [{ id: '', name: '默认（全局提示词）' }, ...presets];
+
+    container.innerHTML = options.map(preset => `
+        <div class="model-option ${currentPresetId === preset.id ? 'active' : ''}"
+             onclick="selectSessionPreset('${preset.id}')"
+             title="${escapeHtml(preset.description || '')}">
+            <div class="model-info">
+                <div class="model-name">${escapeHtml(preset.name)}</div>
+            </div>
+            ${currentPresetId === preset.id ? '<i class="fa-solid fa-check"></i>' : ''}
+        </div>
+    `).join('');
+}
+
+// 选择会话使用的提示词预设
+window.selectSessionPreset = async function(presetId) {
+    if (!currentSession) return;
+
+    try {
+        await apiRequest('/api/ai/session/update-preset', 'POST', {
+            session_id: currentSession.id,
+            preset_id: presetId
+        });
+        currentSession.preset_id = presetId;
+        toggleModelSelector();
+    } catch (error) {
+        console.error('切换提示词预设失败:', error);
+        showToast('切换提示词预设失败: ' + error.message, 'error');
+    }
+};
+
 // ========== 会话管理 ==========
 
 // 加载会话列表
@@ -733,7 +800,7 @@ async function revokeMessage(messageId) {
 /**
  * 获取当前激活终端的缓冲区数据
  * @param {number} lines - 获取最近多少行（默认50行）
- * @returns {object|null} { content: string, serverName: string, sessionId: string }
+ * @returns {object|null} { content: string, serverName: string, serverId: string, sessionId: string }
  */
 window.getTerminalBuffer = function(lines = 50) {
     try {
@@ -765,10 +832,12 @@ window.getTerminalBuffer = function(lines = 50) {
         
         const content = bufferLines.join('\n').trim();
         const serverName = terminalSession.server?.name || '本地终端';
+        const serverId = terminalSession.server?.id || 'local';
         
         return {
             content: content,
             serverName: serverName,
+            serverId: serverId,
             sessionId: sessionId,
             lineCount: bufferLines.length
         };
@@ -1121,6 +1190,7 @@ async function streamChat(sessionId, message, thinkingId) {
         let messageElement = null;
         let currentContentDiv = null;  // 当前正在更新的 content div
         let currentBlockText = '';      // 当前块的文本（工具前后分开）
+        const turnUsages = [];          // 本轮各次模型调用的用量
         
         // 收集上下文信息
         const terminalInfo = window.getTerminalBuffer(200);  // 终端200行
@@ -1136,6 +1206,7 @@ async function streamChat(sessionId, message, thinkingId) {
         if (terminalInfo) {
             payload.real_time_info = terminalInfo.content;
             payload.source_info = `终端 - ${terminalInfo.serverName}`;
+            payload.server_id = terminalInfo.serverId;
             console.log(`📺 终端上下文 - ${terminalInfo.serverName}, ${terminalInfo.lineCount}行`);
         }
         
@@ -1269,10 +1340,21 @@ async function streamChat(sessionId, message, thinkingId) {
                         scrollToBottom();
                     }
                     
+                } else if (data.type === 'usage') {
+                    // 一次模型调用的用量（工具调用循环中每轮一次）
+                    turnUsages.push(data.usage);
+                    
                 } else if (data.type === 'done') {
                     // 完成
                     console.log('✅ 对话完成');
                     
+                    if (messageElement && turnUsages.length > 0) {
+                        const contentWrapper = messageElement.querySelector('.message-content-wrapper');
+                        if (contentWrapper) {
+                            contentWrapper.appendChild(createUsageElement(turnUsages));
+                        }
+                    }
+                    
                     // 停止所有流光效果
                     if (messageElement) {
                         const shimmerElements = messageElement.querySelectorAll('.shimmer-text');
@@ -1367,6 +1449,13 @@ async function streamChat(sessionId, message, thinkingId) {
                     // edit_preview 已经在 tool_result 中显示了横条
                     // 这里不需要额外处理，由用户点击横条查看
                     
+                } else if (data.type === 'context_compacted') {
+                    // 较早的对话已总结为摘要（原始消息仍保留在会话中）
+                    if (currentSession) {
+                        currentSession.summary_up_to = data.summary_up_to;
+                    }
+                    showToast('对话较长，较早的消息已压缩为摘要', 'info');
+                    
                 } else if (data.type === 'error') {
                     // 错误
                     const errorMsg = data.error || data.content || '未知错误';
@@ -1422,6 +1511,31 @@ function appendMessage(role, content, reasoning = null, messageId = null, fullMe
     messagesContainer.appendChild(messageDiv);
 }
 
+// 创建token用量行（一轮对话可能包含多次模型调用，合计显示）
+function createUsageElement(usages) {
+    let prompt = 0, completion = 0, reasoning = 0, estimated = false;
+    const cost = {};
+    usages.forEach(u => {
+        estimated = estimated || !!u.estimated;
+        prompt += u.prompt_tokens || 0;
+        completion += u.completion_tokens || 0;
+        reasoning += u.reasoning_tokens || 0;
+        if (u.cost) {
+            cost[u.currency] = (cost[u.currency] || 0) + u.cost;
+        }
+    });
+
+    const parts = [`输入 ${prompt.toLocaleString()}`, `输出 ${completion.toLocaleString()}`];
+    if (reasoning) parts.push(`推理 ${reasoning.toLocaleString()}`);
+    Object.entries(cost).forEach(([currency, amount]) => parts.push(`${amount.toFixed(4)} ${currency}`));
+
+    const usageDiv = document.createElement('div');
+    usageDiv.className = 'message-usage';
+    usageDiv.textContent = (estimated ? '≈ ' : '') + parts.join(' · ');
+    if (estimated) usageDiv.title = '生成中断，接口未返回用量，按已生成内容估算';
+    return usageDiv;
+}
+
 // 创建消息元素（只创建，不添加到容器）
 function createMessageElement(role, content, reasoning = null, messageId = null, fullMessage = null) {
     const messageDiv = document.createElement('div');
@@ -1551,6 +1665,11 @@ function createMessageElement(role, content, reasoning = null, messageId = null,
         });
     }
     
+    // token用量
+    if (role === 'assistant' && fullMessage && fullMessage.usage) {
+        contentWrapper.appendChild(createUsageElement([fullMessage.usage]));
+    }
+    
     messageDiv.appendChild(avatar);
     messageDiv.appendChild(contentWrapper);
     
@@ -1863,7 +1982,7 @@ window.executeCode = function(codeId) {
     }
     
     // 发送命令到终端
-    session.ws.send(command + '\r');
+    sendInput(session.ws, command + '\r');
     
     // 视觉反馈
     console.log('✅ 已执行命令:', command);
@@ -4,7 +4,8 @@ import { apiRequest } from './api.js';
 
 // 全局变量
 let currentProviders = [];
-let currentTab = 'providers'; // 'providers' 或 'config'
+let currentPresets = [];
+let currentTab = 'providers'; // 'providers'、'config'、'prompts' 或 'usage'
 
 // ========== 打开/关闭设置面板 ==========
 
@@ -34,14 +35,19 @@ window.switchSettingsTab = function(tab) {
     document.querySelector(`.settings-panel-tabs [onclick="switchSettingsTab('${tab}')"]`).classList.add('active');
     
     // 显示对应内容
+    document.getElementById('providersTab').style.display = tab === 'providers' ? 'block' : 'none';
+    document.getElementById('configTab').style.display = tab === 'config' ? 'block' : 'none';
+    document.getElementById('promptsTab').style.display = tab === 'prompts' ? 'block' : 'none';
+    document.getElementById('usageTab').style.display = tab === 'usage' ? 'block' : 'none';
     if (tab === 'providers') {
-        document.getElementById('providersTab').style.display = 'block';
-        document.getElementById('configTab').style.display = 'none';
         loadProviders();
     } else if (tab === 'config') {
-        document.getElementById('providersTab').style.display = 'none';
-        document.getElementById('configTab').style.display = 'block';
         loadGlobalConfig();
+    } else if (tab === 'prompts') {
+        cancelPresetForm();
+        loadPresets();
+    } else if (tab === 'usage') {
+        loadUsageReport();
     }
 };
 
@@ -75,6 +81,7 @@ async function loadProviders() {
                 </div>
                 <div class="provider-info">
                     <div><strong>ID:</strong> ${escapeHtml(provider.id)}</div>
+                    <div><strong>协议:</strong> ${escapeHtml(provider.type || 'openai')}</div>
                     <div><strong>Base URL:</strong> ${escapeHtml(provider.base_url)}</div>
                     <div><strong>API Key:</strong> ${maskApiKey(provider.api_key)}</div>
                 </div>
@@ -106,19 +113,26 @@ window.openProviderForm = function(providerId = null) {
         document.getElementById('providerFormTitle').textContent = '编辑供应商';
         document.getElementById('providerId').value = provider.id;
         document.getElementById('providerName').value = provider.name;
+        document.getElementById('providerType').value = provider.type || 'openai';
         document.getElementById('providerBaseUrl').value = provider.base_url;
-        document.getElementById('providerApiKey').value = provider.api_key;
+        // API Key不回填脱敏值，留空表示不修改
+        const apiKeyInput = document.getElementById('providerApiKey');
+        apiKeyInput.value = '';
+        apiKeyInput.required = false;
+        apiKeyInput.placeholder = provider.api_key ? `${provider.api_key}（留空不修改）` : '';
         
         // 填充模型列表
         const modelsContainer = document.getElementById('providerModels');
         modelsContainer.innerHTML = '';
         (provider.models || []).forEach(model => {
-            addModelRow(model.id, model.name);
+            addModelRow(model.id, model.name, model);
         });
     } else {
         // 新建模式
         document.getElementById('providerFormTitle').textContent = '添加供应商';
         form.reset();
+        document.getElementById('providerApiKey').required = true;
+        document.getElementById('providerApiKey').placeholder = '';
         document.getElementById('providerModels').innerHTML = '';
         addModelRow(); // 添加一个空行
     }
@@ -138,13 +152,25 @@ window.cancelProviderForm = function() {
 window.closeProviderForm = window.cancelProviderForm;
 
 // 添加模型输入行
-window.addModelRow = function(modelId = '', modelName = '') {
+// model 为已保存的模型配置，用于保留生成参数覆盖（settings）和能力标记
+window.addModelRow = function(modelId = '', modelName = '', model = {}) {
     const container = document.getElementById('providerModels');
     const row = document.createElement('div');
+    const caps = model.capabilities || {};
     row.className = 'model-row';
+    const pricing = model.pricing || {};
+    row.dataset.settings = model.settings ? JSON.stringify(model.settings) : '';
+    row.dataset.pricing = model.pricing ? JSON.stringify(model.pricing) : '';
     row.innerHTML = `
         <input type="text" class="model-id" placeholder="模型ID (如: gpt-4)" value="${escapeHtml(modelId)}">
         <input type="text" class="model-name" placeholder="显示名称 (如: GPT-4)" value="${escapeHtml(modelName)}">
+        <label title="模型不支持工具调用"><input type="checkbox" class="model-no-tools" ${caps.no_tools ? 'checked' : ''}> 无工具</label>
+        <label title="模型不支持temperature/top_p等采样参数"><input type="checkbox" class="model-no-temperature" ${caps.no_temperature ? 'checked' : ''}> 无采样参数</label>
+        <label title="接口不支持stream_options，不在流式响应中请求用量"><input type="checkbox" class="model-no-stream-usage" ${caps.no_stream_usage ? 'checked' : ''}> 无用量</label>
+        <label title="输出上限以max_completion_tokens发送（OpenAI o系列等推理模型不接受max_tokens）"><input type="checkbox" class="model-max-completion-tokens" ${caps.max_completion_tokens ? 'checked' : ''}> max_completion_tokens</label>
+        <input type="number" class="model-max-context" min="0" placeholder="最大上下文" value="${caps.max_context || ''}">
+        <input type="number" class="model-price-input" min="0" step="any" placeholder="输入价格/百万" title="每百万输入token价格" value="${pricing.input || ''}">
+        <input type="number" class="model-price-output" min="0" step="any" placeholder="输出价格/百万" title="每百万输出token价格" value="${pricing.output || ''}">
         <button type="button" onclick="this.parentElement.remove()" class="remove-model-btn">
             <i class="fa-solid fa-times"></i>
         </button>
@@ -158,10 +184,13 @@ window.saveProvider = async function(event) {
     
     const providerId = document.getElementById('providerId').value.trim();
     const providerName = document.getElementById('providerName').value.trim();
+    const providerType = document.getElementById('providerType').value;
     const baseUrl = document.getElementById('providerBaseUrl').value.trim();
     const apiKey = document.getElementById('providerApiKey').value.trim();
+    const isEdit = document.getElementById('providerForm').dataset.providerId;
     
-    if (!providerId || !providerName || !baseUrl || !apiKey) {
+    // 编辑时API Key可留空（保留原值）
+    if (!providerId || !providerName || !baseUrl || (!apiKey && !isEdit)) {
         showToast('请填写所有必填字段', 'warning');
         return;
     }
@@ -173,7 +202,28 @@ window.saveProvider = async function(event) {
         const id = row.querySelector('.model-id').value.trim();
         const name = row.querySelector('.model-name').value.trim();
         if (id && name) {
-            models.push({ id, name });
+            const model = {
+                id,
+                name,
+                capabilities: {
+                    no_tools: row.querySelector('.model-no-tools').checked,
+                    no_temperature: row.querySelector('.model-no-temperature').checked,
+                    no_stream_usage: row.querySelector('.model-no-stream-usage').checked,
+                    max_completion_tokens: row.querySelector('.model-max-completion-tokens').checked,
+                    max_context: parseInt(row.querySelector('.model-max-context').value, 10) || 0
+                }
+            };
+            if (row.dataset.settings) {
+                model.settings = JSON.parse(row.dataset.settings);
+            }
+            // 价格：保留表单中未展示的字段（缓存读写价格、币种）
+            const pricing = row.dataset.pricing ? JSON.parse(row.dataset.pricing) : {};
+            pricing.input = parseFloat(row.querySelector('.model-price-input').value) || 0;
+            pricing.output = parseFloat(row.querySelector('.model-price-output').value) || 0;
+            if (pricing.input || pricing.output || pricing.cached_input || pricing.cache_write) {
+                model.pricing = pricing;
+            }
+            models.push(model);
         }
     });
     
@@ -185,14 +235,13 @@ window.saveProvider = async function(event) {
     const data = {
         id: providerId,
         name: providerName,
+        type: providerType,
         base_url: baseUrl,
         api_key: apiKey,
         models: models
     };
     
     try {
-        const isEdit = document.getElementById('providerForm').dataset.providerId;
-        
         if (isEdit) {
             await apiRequest('/api/ai/provider/update', 'POST', data);
         } else {
@@ -292,6 +341,221 @@ window.updateRangeDisplay = function() {
     document.getElementById('presValue').textContent = document.getElementById('presencePenalty').value;
 };
 
+// ========== 提示词预设 ==========
+
+async function loadPresets() {
+    const container = document.getElementById('presetsList');
+    container.innerHTML = '<div class="loading">加载中...</div>';
+
+    try {
+        const data = await apiRequest('/api/ai/prompts');
+        currentPresets = data.data || [];
+
+        container.innerHTML = currentPresets.map(preset => `
+            <div class="provider-card">
+                <div class="provider-header">
+                    <h3>${escapeHtml(preset.name)}${preset.builtin ? ' <span class="model-tag">内置</span>' : ''}</h3>
+                    <div class="provider-actions">
+                        ${preset.builtin ? `
+                        <button onclick="copyPreset('${preset.id}')" title="复制为新预设">
+                            <i class="fa-solid fa-copy"></i>
+                        </button>` : `
+                        <button onclick="openPresetForm('${preset.id}')" title="编辑">
+                            <i class="fa-solid fa-edit"></i>
+                        </button>
+                        <button onclick="deletePreset('${preset.id}')" title="删除">
+                            <i class="fa-solid fa-trash"></i>
+                        </button>`}
+                    </div>
+                </div>
+                <div class="provider-models">${escapeHtml(preset.description || '')}</div>
+            </div>
+        `).join('');
+    } catch (error) {
+        console.error('加载提示词预设失败:', error);
+        container.innerHTML = '<div class="error">加载失败</div>';
+    }
+}
+
+// 模板变量提示（点击插入到光标处）
+async function loadPresetVariables() {
+    const container = document.getElementById('presetVariables');
+    if (container.dataset.loaded) return;
+
+    try {
+        const data = await apiRequest('/api/ai/prompt/variables');
+        container.innerHTML = (data.data || []).map(v => `
+            <code title="${escapeHtml(v.description)}" onclick="insertPresetVariable('${v.name}')">{{${v.name}}}</code>
+        `).join('');
+        container.dataset.loaded = '1';
+    } catch (error) {
+        console.error('加载模板变量失败:', error);
+    }
+}
+
+window.insertPresetVariable = function(name) {
+    const textarea = document.getElementById('presetContent');
+    const text = `{{${name}}}`;
+    const start = textarea.selectionStart;
+    textarea.value = textarea.value.slice(0, start) + text + textarea.value.slice(textarea.selectionEnd);
+    textarea.focus();
+    textarea.selectionStart = textarea.selectionEnd = start + text.length;
+};
+
+// 打开预设表单（preset为初始内容，复制内置预设时使用）
+window.openPresetForm = function(presetId = null, preset = null) {
+    const form = document.getElementById('presetForm');
+    form.reset();
+    form.dataset.presetId = presetId || '';
+
+    const source = presetId ? currentPresets.find(p => p.id === presetId) : preset;
+    document.getElementById('presetFormTitle').textContent = presetId ? '编辑预设' : '添加预设';
+    if (source) {
+        document.getElementById('presetName').value = source.name;
+        document.getElementById('presetDescription').value = source.description || '';
+        document.getElementById('presetContent').value = source.content;
+    }
+    document.getElementById('presetPreviewGroup').style.display = 'none';
+
+    loadPresetVariables();
+    document.getElementById('presetsListView').style.display = 'none';
+    document.getElementById('presetFormView').style.display = 'block';
+};
+
+window.cancelPresetForm = function() {
+    document.getElementById('presetsListView').style.display = 'block';
+    document.getElementById('presetFormView').style.display = 'none';
+};
+
+// 复制内置预设为新的自定义预设
+window.copyPreset = function(presetId) {
+    const preset = currentPresets.find(p => p.id === presetId);
+    if (!preset) return;
+    openPresetForm(null, { ...preset, name: preset.name + ' (副本)' });
+};
+
+window.savePreset = async function(event) {
+    event.preventDefault();
+
+    const presetId = document.getElementById('presetForm').dataset.presetId;
+    const data = {
+        id: presetId,
+        name: document.getElementById('presetName').value.trim(),
+        description: document.getElementById('presetDescription').value.trim(),
+        content: document.getElementById('presetContent').value
+    };
+
+    if (!data.name || !data.content.trim()) {
+        showToast('请填写名称和提示词', 'warning');
+        return;
+    }
+
+    try {
+        await apiRequest(presetId ? '/api/ai/prompt/update' : '/api/ai/prompt/create', 'POST', data);
+        cancelPresetForm();
+        await loadPresets();
+        showToast('保存成功', 'success');
+
+        if (window.refreshPresetCache) {
+            window.refreshPresetCache();
+        }
+    } catch (error) {
+        showToast('保存失败: ' + error.message, 'error');
+    }
+};
+
+window.deletePreset = async function(presetId) {
+    const confirmed = await window.showConfirm(
+        '确定要删除这个提示词预设吗？使用该预设的会话将恢复默认提示词。',
+        '删除预设'
+    );
+    if (!confirmed) return;
+
+    try {
+        await apiRequest(`/api/ai/prompt/delete?id=${encodeURIComponent(presetId)}`, 'POST');
+        await loadPresets();
+
+        if (window.refreshPresetCache) {
+            window.refreshPresetCache();
+        }
+    } catch (error) {
+        showToast('删除失败: ' + error.message, 'error');
+    }
+};
+
+// 按本地终端渲染模板变量，预览实际发送的内容
+window.previewPreset = async function() {
+    try {
+        const data = await apiRequest('/api/ai/prompt/preview', 'POST', {
+            content: document.getElementById('presetContent').value
+        });
+        document.getElementById('presetPreview').textContent = data.data.content;
+        document.getElementById('presetPreviewGroup').style.display = 'block';
+    } catch (error) {
+        showToast('预览失败: ' + error.message, 'error');
+    }
+};
+
+// ========== 用量统计 ==========
+
+window.loadUsageReport = async function() {
+    const container = document.getElementById('usageReport');
+    container.innerHTML = '<div class="loading">加载中...</div>';
+
+    const params = new URLSearchParams({ group_by: document.getElementById('usageGroupBy').value });
+    const from = document.getElementById('usageFrom').value;
+    const to = document.getElementById('usageTo').value;
+    if (from) params.set('from', from);
+    if (to) params.set('to', to);
+
+    try {
+        const data = await apiRequest(`/api/ai/usage?${params}`);
+        const report = data.data;
+
+        if (report.groups.length === 0) {
+            container.innerHTML = '<div class="empty">暂无用量记录</div>';
+            return;
+        }
+
+        const row = (g, cls = '') => `
+            <tr class="${cls}">
+                <td title="${escapeHtml(g.key)}">${escapeHtml(g.label)}</td>
+                <td>${g.requests}</td>
+                <td>${formatTokens(g.prompt_tokens)}</td>
+                <td>${formatTokens(g.completion_tokens)}</td>
+                <td>${formatTokens(g.reasoning_tokens)}</td>
+                <td>${formatTokens(g.cached_tokens)}</td>
+                <td>${formatCost(g.cost)}</td>
+            </tr>
+        `;
+        container.innerHTML = `
+            <table class="usage-table">
+                <thead>
+                    <tr><th></th><th>请求</th><th>输入</th><th>输出</th><th>推理</th><th>缓存命中</th><th>费用</th></tr>
+                </thead>
+                <tbody>
+                    ${report.groups.map(g => row(g)).join('')}
+                    ${row(report.total, 'usage-total')}
+                </tbody>
+            </table>
+        `;
+    } catch (error) {
+        console.error('加载用量统计失败:', error);
+        container.innerHTML = '<div class="error">加载失败</div>';
+    }
+};
+
+function formatTokens(n) {
+    return (n || 0).toLocaleString();
+}
+
+// 费用按币种分别显示
+function formatCost(cost) {
+    const entries = Object.entries(cost || {});
+    if (entries.length === 0) return '-';
+    return entries.map(([currency, amount]) => `${amount.toFixed(4)} ${escapeHtml(currency)}`).join('<br>');
+}
+
 // ========== 工具函数 ==========
 
 function escapeHtml(text) {
@@ -61,6 +61,143 @@ export const api = {
         return res.json();
     },
     
+    // SSH会话（断开后服务端保留，可重新连接）
+    async getSSHSessions(detachedOnly = false) {
+        const res = await fetch(`${config.API_BASE}/ssh/sessions${detachedOnly ? '?detached=true' : ''}`);
+        return res.json();
+    },
+    
+    async killSSHSession(id) {
+        const res = await fetch(`${config.API_BASE}/ssh/session/kill?id=${encodeURIComponent(id)}`, {
+            method: 'POST'
+        });
+        return res.json();
+    },
+    
+    // 服务器导入（ssh_config/csv/json，dryRun 只预览）与导出
+    async importServers(file, { format = '', dryRun = true, defaultUser = '', groupId = '' } = {}) {
+        const form = new FormData();
+        form.append('file', file);
+        const params = new URLSearchParams({ dry_run: dryRun, default_user: defaultUser });
+        if (format) params.set('format', format);
+        if (groupId) params.set('group_id', groupId);
+        const res = await fetch(`${config.API_BASE}/servers/import?${params}`, {
+            method: 'POST',
+            body: form
+        });
+        return res.json();
+    },
+    
+    exportServersURL(format = 'json', includeSecrets = false) {
+        return `${config.API_BASE}/servers/export?format=${encodeURIComponent(format)}&include_secrets=${includeSecrets}`;
+    },
+
+    // 服务器可达性（实时状态通过 /ws/servers/status 推送）
+    async getServerStatuses() {
+        const res = await fetch(`${config.API_BASE}/servers/status`);
+        return res.json();
+    },
+
+    async probeServer(id) {
+        const res = await fetch(`${config.API_BASE}/server/probe?id=${encodeURIComponent(id)}`, {
+            method: 'POST'
+        });
+        return res.json();
+    },
+
+    // 服务器分组（action: create/update/delete）
+    async getServerTree() {
+        const res = await fetch(`${config.API_BASE}/server/tree`);
+        return res.json();
+    },
+
+    async serverGroupAction(action, group) {
+        const query = action === 'delete' ? `?id=${encodeURIComponent(group.id)}` : '';
+        const res = await fetch(`${config.API_BASE}/server/group/${action}${query}`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: action === 'delete' ? undefined : JSON.stringify(group)
+        });
+        return res.json();
+    },
+
+    async moveServers(serverIds, groupId = '') {
+        const res = await fetch(`${config.API_BASE}/servers/move`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ server_ids: serverIds, group_id: groupId })
+        });
+        return res.json();
+    },
+
+    // 输入广播组（键盘输入同步到多个SSH会话）
+    async getBroadcastGroups() {
+        const res = await fetch(`${config.API_BASE}/broadcast/groups`);
+        return res.json();
+    },
+    
+    async broadcastGroupAction(action, params) {
+        // action: create/delete/join/leave/toggle
+        const res = await fetch(`${config.API_BASE}/broadcast/group/${action}`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(params)
+        });
+        return res.json();
+    },
+    
+    // 端口转发（type: local/remote/dynamic）
+    async getTunnels() {
+        const res = await fetch(`${config.API_BASE}/tunnels`);
+        return res.json();
+    },
+    
+    async createTunnel(tunnel) {
+        const res = await fetch(`${config.API_BASE}/tunnel/create`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(tunnel)
+        });
+        return res.json();
+    },
+    
+    async tunnelAction(action, id) {
+        // action: open/close/delete
+        const res = await fetch(`${config.API_BASE}/tunnel/${action}?id=${encodeURIComponent(id)}`, {
+            method: 'POST'
+        });
+        return res.json();
+    },
+    
+    // 批量执行（实时输出通过 /ws/exec?job_id= 获取）
+    async runBatchExec(params) {
+        const res = await fetch(`${config.API_BASE}/exec/run`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(params)
+        });
+        return res.json();
+    },
+    
+    async getExecJobs() {
+        const res = await fetch(`${config.API_BASE}/exec/jobs`);
+        return res.json();
+    },
+    
+    async getExecJob(id) {
+        const res = await fetch(`${config.API_BASE}/exec/job?id=${encodeURIComponent(id)}`);
+        return res.json();
+    },
+    
+    async cancelExecJob(id) {
+        const res = await fetch(`${config.API_BASE}/exec/job/cancel`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ id })
+        });
+        return res.json();
+    },
+    
     async searchServers(keyword) {
         const res = await fetch(`${config.API_BASE}/servers/search?q=${encodeURIComponent(keyword)}`);
         return res.json();
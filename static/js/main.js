@@ -6,7 +6,7 @@ import { showToast } from './toast.js';
 import './confirm.js'; // 确认对话框组件
 import { showConfirm } from './confirm.js';
 import { loadServers, searchServers, deleteServer, renderServerList } from './server.js';
-import { createTerminal, connectSSH, openLocalTerminal } from './terminal.js';
+import { createTerminal, connectSSH, openLocalTerminal, sendInput } from './terminal.js';
 import { loadCommandHistory, saveCommandToHistory } from './commands.js';
 import { initFileTree, setCurrentServer, setLocalTerminal, loadDirectory, initDragUpload } from './filetree.js';
 import { openFileEditor } from './editor.js';
@@ -421,6 +421,11 @@ window.closeTab = function(sessionId) {
     const session = state.terminals.get(sessionId);
     if (session?.ws) session.ws.close();
     
+    // 主动关闭标签时结束服务端SSH会话（仅意外断开的会话保留以便重新连接）
+    if (sessionId !== 'local') {
+        api.killSSHSession(sessionId).catch(() => {});
+    }
+    
     document.getElementById(sessionId)?.remove();
     state.terminals.delete(sessionId);
     
@@ -468,7 +473,7 @@ window.writeCommandToTerminal = function(command) {
         return;
     }
     
-    session.ws.send(command);
+    sendInput(session.ws, command);
     showToast('✅ 已填充到终端');
 };
 
@@ -3,6 +3,23 @@ import { state, config } from './config.js';
 import { showToast } from './utils.js';
 import { saveCommandToHistory } from './commands.js';
 
+// 终端WebSocket帧约定：输入使用二进制帧，文本帧为JSON控制消息（resize/ping）
+const textEncoder = new TextEncoder();
+
+export function sendInput(ws, data) {
+    ws.send(textEncoder.encode(data));
+}
+
+function sendControl(ws, message) {
+    if (ws && ws.readyState === WebSocket.OPEN) {
+        ws.send(JSON.stringify(message));
+    }
+}
+
+function sendResize(ws, term) {
+    sendControl(ws, { type: 'resize', cols: term.cols, rows: term.rows });
+}
+
 export function createTerminal() {
     const term = new Terminal({
         cursorBlink: true,
@@ -54,9 +71,44 @@ export function createTerminal() {
         console.warn('⚠️ WebGL渲染器加载失败，使用Canvas渲染:', e.message);
     }
     
+    // 检测shell集成（OSC 133/633）：启用后命令历史由服务端捕获，前端不再提交
+    term.shellIntegration = false;
+    [133, 633].forEach(ident => {
+        term.parser.registerOscHandler(ident, () => {
+            term.shellIntegration = true;
+            return false; // 不拦截，交给默认处理
+        });
+    });
+    
     return { term, fitAddon };
 }
 
+// 回车时从终端当前行提取命令并保存到历史
+// 仅用于未启用shell集成的会话；启用后服务端从OSC 133/633序列捕获命令（含退出码、目录）
+function captureCommandFallback(term, serverId, serverName) {
+    if (term.shellIntegration) return;
+    
+    try {
+        // 从终端buffer获取当前行内容
+        const buffer = term.buffer.active;
+        const line = buffer.getLine(buffer.cursorY);
+        if (!line) return;
+        
+        // 提取行内容，尝试去除常见的提示符（$, #, >, 等）
+        const lineText = line.translateToString(true).trim();
+        const commandMatch = lineText.match(/[#$>]\s*(.+)$/);
+        const command = commandMatch ? commandMatch[1].trim() : lineText;
+        
+        // 只保存有意义的命令（排除空命令和单个字符的交互响应）
+        if (command && command.length > 1 && !['y', 'n', 'yes', 'no'].includes(command.toLowerCase())) {
+            console.log('📝 捕获命令:', command);
+            saveCommandToHistory(serverId, serverName, command);
+        }
+    } catch (error) {
+        console.error('提取命令失败:', error);
+    }
+}
+
 export function connectSSH(sessionId, server) {
     const session = state.terminals.get(sessionId);
     const { term } = session;
@@ -74,6 +126,9 @@ export function connectSSH(sessionId, server) {
     ws.onopen = () => {
         session.status = 'connecting'; // 先保持连接中
         // 不立即更新状态灯，等文件树加载完成
+        
+        // 同步终端大小到远程PTY
+        sendResize(ws, term);
     };
     
     ws.onmessage = (event) => {
@@ -122,10 +177,21 @@ export function connectSSH(sessionId, server) {
         showDisconnectOverlay(sessionId, '连接已断开', 'SSH会话已关闭');
     };
     
-    // 清除旧的onData监听器（如果有）
+    // 清除旧的onData/onResize监听器（如果有）
     if (session.disposeOnData) {
         session.disposeOnData.dispose();
     }
+    if (session.disposeOnResize) {
+        session.disposeOnResize.dispose();
+    }
+    
+    // 终端大小变化（fit）时通知服务端调整PTY
+    session.disposeOnResize = term.onResize(() => {
+        const currentSession = state.terminals.get(sessionId);
+        if (currentSession) {
+            sendResize(currentSession.ws, currentSession.term);
+        }
+    });
     
     // 绑定新的onData监听器
     session.disposeOnData = term.onData(data => {
@@ -136,33 +202,11 @@ export function connectSSH(sessionId, server) {
         }
         
         if (currentSession.ws.readyState === WebSocket.OPEN) {
-            currentSession.ws.send(data);
+            sendInput(currentSession.ws, data);
             
-            // 捕获命令：当用户按回车时，从终端当前行提取完整命令
+            // 未启用shell集成时，回车时从终端当前行提取命令
             if (data === '\r' || data === '\n') {
-                try {
-                    // 从终端buffer获取当前行内容
-                    const buffer = currentSession.term.buffer.active;
-                    const cursorY = buffer.cursorY;
-                    const line = buffer.getLine(cursorY);
-                    
-                    if (line) {
-                        // 提取行内容（去除ANSI转义序列和提示符）
-                        let lineText = line.translateToString(true).trim();
-                        
-                        // 尝试提取命令：去除常见的提示符（$, #, >, 等）
-                        const commandMatch = lineText.match(/[#$>]\s*(.+)$/);
-                        const command = commandMatch ? commandMatch[1].trim() : lineText;
-                        
-                        // 只保存有意义的命令（排除空命令和单个字符的交互响应）
-                        if (command && command.length > 1 && !['y', 'n', 'yes', 'no'].includes(command.toLowerCase())) {
-                            console.log('📝 捕获命令:', command);
-                            saveCommandToHistory(server.id, server.name, command);
-                        }
-                    }
-                } catch (error) {
-                    console.error('提取命令失败:', error);
-                }
+                captureCommandFallback(currentSession.term, server.id, server.name);
             }
         }
     });
@@ -229,6 +273,14 @@ export function openLocalTerminal() {
         status: 'connecting'
     });
     
+    // 终端大小变化（fit）时通知服务端调整PTY（重连后复用同一监听器）
+    term.onResize(() => {
+        const session = state.terminals.get(sessionId);
+        if (session) {
+            sendResize(session.ws, term);
+        }
+    });
+    
     state.activeSessionId = sessionId;
     window.renderTabs();
     window.switchTab(sessionId);
@@ -268,23 +320,28 @@ function connectLocalTerminal(sessionId) {
         session.status = 'connected';
         updateStatusLight('connected');
         
-        // 调整终端大小以适配容器
+        // 服务端连接后会回放回滚缓冲，重连时先清屏避免内容重复
+        if (session.everConnected) {
+            term.reset();
+        }
+        session.everConnected = true;
+        
+        // 调整终端大小以适配容器，并同步到PTY（大小未变化时不会触发onResize）
         setTimeout(() => {
             session.fitAddon.fit();
+            sendResize(ws, term);
             console.log('🔧 终端大小已调整');
         }, 100);
         
-        // 启动心跳
+        // 启动心跳（控制消息，不写入终端）
         heartbeatInterval = setInterval(() => {
-            if (ws.readyState === WebSocket.OPEN) {
-                ws.send(new Uint8Array([0])); // 发送空字节保活
-            }
+            sendControl(ws, { type: 'ping' });
         }, 30000); // 每30秒一次
         
         // 发送换行符触发shell显示提示符
         setTimeout(() => {
             if (ws.readyState === WebSocket.OPEN) {
-                ws.send('\r'); // 发送回车，触发提示符
+                sendInput(ws, '\r'); // 发送回车，触发提示符
                 console.log('✅ 本地终端已就绪');
             }
         }, 200); // 等待200ms让shell启动完成
@@ -339,33 +396,11 @@ function connectLocalTerminal(sessionId) {
     
     term.onData(data => {
         if (ws && ws.readyState === WebSocket.OPEN) {
-            ws.send(data);
+            sendInput(ws, data);
             
-            // 捕获命令：当用户按回车时，从终端当前行提取完整命令
+            // 未启用shell集成时，回车时从终端当前行提取命令
             if (data === '\r' || data === '\n') {
-                try {
-                    // 从终端buffer获取当前行内容
-                    const buffer = term.buffer.active;
-                    const cursorY = buffer.cursorY;
-                    const line = buffer.getLine(cursorY);
-                    
-                    if (line) {
-                        // 提取行内容（去除ANSI转义序列和提示符）
-                        let lineText = line.translateToString(true).trim();
-                        
-                        // 尝试提取命令：去除常见的提示符（$, #, >, PS1等）
-                        const commandMatch = lineText.match(/[#$>]\s*(.+)$/);
-                        const command = commandMatch ? commandMatch[1].trim() : lineText;
-                        
-                        // 只保存有意义的命令（排除空命令和单个字符的交互响应）
-                        if (command && command.length > 1 && !['y', 'n', 'yes', 'no'].includes(command.toLowerCase())) {
-                            console.log('📝 捕获本地命令:', command);
-                            saveCommandToHistory('0', '本地', command);
-                        }
-                    }
-                } catch (error) {
-                    console.error('提取本地命令失败:', error);
-                }
+                captureCommandFallback(term, '0', '本地');
             }
         }
     });
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"fmt"
 	"path/filepath"
 	"sync"
 )
@@ -71,3 +72,75 @@ func UpdateAIConfig(config *AIConfig) error {
 	// 写入文件
 	return writeJSON(aiConfigFile, config)
 }
+
+// ResolveAIConfig 计算实际使用的生成参数：会话设置 > 模型设置 > 全局配置
+// 系统提示词：会话设置 > 会话选用的预设 > 模型设置 > 全局配置（预设已删除时忽略）
+func ResolveAIConfig(global *AIConfig, model *Model, session *ChatSession) *AIConfig {
+	resolved := *global
+	if model != nil {
+		model.Settings.applyTo(&resolved)
+	}
+	if session != nil {
+		if session.PresetID != "" {
+			if preset, err := GetPromptPreset(session.PresetID); err == nil {
+				resolved.SystemPrompt = preset.Content
+			}
+		}
+		session.Settings.applyTo(&resolved)
+	}
+	return &resolved
+}
+
+// applyTo 用已设置的字段覆盖配置
+func (s *GenerationSettings) applyTo(config *AIConfig) {
+	if s == nil {
+		return
+	}
+	if s.SystemPrompt != nil {
+		config.SystemPrompt = *s.SystemPrompt
+	}
+	if s.Temperature != nil {
+		config.Temperature = *s.Temperature
+	}
+	if s.MaxTokens != nil {
+		config.MaxTokens = *s.MaxTokens
+	}
+	if s.TopP != nil {
+		config.TopP = *s.TopP
+	}
+	if s.FrequencyPenalty != nil {
+		config.FrequencyPenalty = *s.FrequencyPenalty
+	}
+	if s.PresencePenalty != nil {
+		config.PresencePenalty = *s.PresencePenalty
+	}
+}
+
+// Validate 校验参数范围
+func (s *GenerationSettings) Validate() error {
+	if s == nil {
+		return nil
+	}
+	if s.Temperature != nil && (*s.Temperature < 0 || *s.Temperature > 2) {
+		return fmt.Errorf("temperature 应在 0-2 之间")
+	}
+	if s.TopP != nil && (*s.TopP < 0 || *s.TopP > 1) {
+		return fmt.Errorf("top_p 应在 0-1 之间")
+	}
+	if s.MaxTokens != nil && *s.MaxTokens < 0 {
+		return fmt.Errorf("max_tokens 不能为负数")
+	}
+	if s.FrequencyPenalty != nil && (*s.FrequencyPenalty < -2 || *s.FrequencyPenalty > 2) {
+		return fmt.Errorf("frequency_penalty 应在 -2 到 2 之间")
+	}
+	if s.PresencePenalty != nil && (*s.PresencePenalty < -2 || *s.PresencePenalty > 2) {
+		return fmt.Errorf("presence_penalty 应在 -2 到 2 之间")
+	}
+	return nil
+}
+
+// IsEmpty 是否没有任何覆盖
+func (s *GenerationSettings) IsEmpty() bool {
+	return s == nil || (s.SystemPrompt == nil && s.Temperature == nil && s.MaxTokens == nil &&
+		s.TopP == nil && s.FrequencyPenalty == nil && s.PresencePenalty == nil)
+}
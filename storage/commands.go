@@ -36,6 +36,17 @@ func LoadCommandsCache() error {
 
 // SaveCommand 保存命令历史（统一时间线）
 func SaveCommand(serverID, serverName, command string) error {
+	return SaveCommandRecord(CommandHistory{
+		ServerID:   serverID,
+		ServerName: serverName,
+		Command:    command,
+	})
+}
+
+// SaveCommandRecord 保存完整的命令记录（含cwd、退出码、耗时），ID与时间自动填充
+func SaveCommandRecord(record CommandHistory) error {
+	serverID, command := record.ServerID, record.Command
+
 	commandStoreLock.Lock()
 	defer commandStoreLock.Unlock()
 
@@ -72,12 +83,10 @@ func SaveCommand(serverID, serverName, command string) error {
 	}
 
 	// 创建新命令记录
-	history := CommandHistory{
-		ID:         commandStore.NextID,
-		ServerID:   serverID,
-		ServerName: serverName,
-		Command:    command,
-		Timestamp:  time.Now(),
+	history := record
+	history.ID = commandStore.NextID
+	if history.Timestamp.IsZero() {
+		history.Timestamp = time.Now()
 	}
 
 	// 添加到列表末尾（最新的）
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// execJobPath 任务记录文件路径（每个任务一个文件）
+func execJobPath(id string) (string, error) {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return "", fmt.Errorf("无效的任务ID: %s", id)
+	}
+	return filepath.Join(execJobsDir, id+".json"), nil
+}
+
+// SaveExecJob 保存批量执行任务
+func SaveExecJob(job *ExecJob) error {
+	path, err := execJobPath(job.ID)
+	if err != nil {
+		return err
+	}
+	return writeJSON(path, job)
+}
+
+// GetExecJob 获取批量执行任务（含完整输出）
+func GetExecJob(id string) (*ExecJob, error) {
+	path, err := execJobPath(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var job ExecJob
+	if err := readJSON(path, &job); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("任务不存在: %s", id)
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ListExecJobs 列出批量执行任务（不含输出），按创建时间倒序
+func ListExecJobs() ([]ExecJob, error) {
+	files, err := os.ReadDir(execJobsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []ExecJob{}, nil
+		}
+		return nil, err
+	}
+
+	jobs := []ExecJob{}
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		job, err := GetExecJob(strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		for i := range job.Results {
+			job.Results[i].Stdout = ""
+			job.Results[i].Stderr = ""
+		}
+		jobs = append(jobs, *job)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.After(jobs[j].CreatedAt) })
+	return jobs, nil
+}
+
+// DeleteExecJob 删除批量执行任务
+func DeleteExecJob(id string) error {
+	path, err := execJobPath(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("任务不存在: %s", id)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"os"
+	"sync"
+)
+
+// knownHostsLock 保护known_hosts.json的读-改-写
+var knownHostsLock sync.Mutex
+
+// loadKnownHosts 读取所有已信任主机（文件不存在时返回空表）
+func loadKnownHosts() (map[string]KnownHost, error) {
+	hosts := make(map[string]KnownHost)
+	if err := readJSON(knownHostsFile, &hosts); err != nil {
+		if os.IsNotExist(err) {
+			return make(map[string]KnownHost), nil
+		}
+		return nil, err
+	}
+	if hosts == nil {
+		hosts = make(map[string]KnownHost)
+	}
+	return hosts, nil
+}
+
+// GetKnownHost 获取服务器已记录的主机密钥（未记录时返回nil）
+func GetKnownHost(serverID string) (*KnownHost, error) {
+	knownHostsLock.Lock()
+	defer knownHostsLock.Unlock()
+
+	hosts, err := loadKnownHosts()
+	if err != nil {
+		return nil, err
+	}
+
+	host, ok := hosts[serverID]
+	if !ok {
+		return nil, nil
+	}
+	return &host, nil
+}
+
+// TrustHostKey 首次连接时记录主机密钥（已存在则不覆盖，返回已记录的值）
+func TrustHostKey(host *KnownHost) (*KnownHost, error) {
+	knownHostsLock.Lock()
+	defer knownHostsLock.Unlock()
+
+	hosts, err := loadKnownHosts()
+	if err != nil {
+		return nil, err
+	}
+
+	if existing, ok := hosts[host.ServerID]; ok {
+		return &existing, nil
+	}
+
+	hosts[host.ServerID] = *host
+	if err := writeJSON(knownHostsFile, hosts); err != nil {
+		return nil, err
+	}
+	return host, nil
+}
+
+// ResetKnownHost 删除服务器的主机密钥记录（下次连接重新信任）
+func ResetKnownHost(serverID string) error {
+	knownHostsLock.Lock()
+	defer knownHostsLock.Unlock()
+
+	hosts, err := loadKnownHosts()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := hosts[serverID]; !ok {
+		return nil
+	}
+
+	delete(hosts, serverID)
+	return writeJSON(knownHostsFile, hosts)
+}
@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// presetsLock 保护prompt_presets.json的读-改-写
+var presetsLock sync.Mutex
+
+// builtinPresets 内置提示词预设（不写入文件，不可修改或删除）
+var builtinPresets = []PromptPreset{
+	{
+		ID:          "builtin-ops",
+		Name:        "运维助手",
+		Description: "排查故障、执行运维操作，命令先解释后执行",
+		Content: `你是一名经验丰富的Linux运维工程师，正在协助用户管理服务器。
+
+当前服务器：{{server_name}}（{{server_host}}）
+操作系统：{{os}}
+工作目录：{{cwd}}
+当前日期：{{date}}
+
+可用服务器：
+{{servers}}
+
+工作要求：
+1. 执行命令前说明目的和影响，危险操作（删除、重启、修改配置）先征得用户确认
+2. 优先使用只读命令收集信息，再给出结论
+3. 命令要适配当前操作系统的发行版和包管理器
+4. 回答简洁，给出可直接执行的命令`,
+	},
+	{
+		ID:          "builtin-code-review",
+		Name:        "代码审查",
+		Description: "审查代码的正确性、安全性和可维护性",
+		Content: `你是一名严格但友善的资深工程师，负责代码审查。
+
+当前服务器：{{server_name}}（{{server_host}}）
+工作目录：{{cwd}}
+当前日期：{{date}}
+
+审查时按以下顺序关注：
+1. 正确性：逻辑错误、边界条件、并发问题、错误处理
+2. 安全性：注入、越权、敏感信息泄露
+3. 可维护性：命名、结构、重复代码、与周围代码风格是否一致
+4. 性能：明显的低效实现
+
+指出问题时引用具体的文件和行，说明原因并给出修改建议；没有问题的部分不必逐条复述。`,
+	},
+	{
+		ID:          "builtin-dba",
+		Name:        "数据库管理员",
+		Description: "SQL调优、备份恢复和数据库排障",
+		Content: `你是一名资深数据库管理员（DBA），熟悉MySQL、PostgreSQL、Redis等常见数据库。
+
+当前服务器：{{server_name}}（{{server_host}}）
+操作系统：{{os}}
+当前日期：{{date}}
+
+工作要求：
+1. 修改数据的语句（UPDATE/DELETE/DDL）执行前必须说明影响范围并提醒备份
+2. 分析慢查询时先查看执行计划和索引，再给出优化建议
+3. 涉及生产库的操作优先给出只读的诊断语句
+4. 给出的SQL注明适用的数据库类型和版本`,
+	},
+}
+
+// loadPresets 读取用户自定义预设（文件不存在时返回空列表）
+func loadPresets() ([]PromptPreset, error) {
+	var presets []PromptPreset
+	if err := readJSON(presetsFile, &presets); err != nil {
+		if os.IsNotExist(err) {
+			return []PromptPreset{}, nil
+		}
+		return nil, err
+	}
+	if presets == nil {
+		presets = []PromptPreset{}
+	}
+	return presets, nil
+}
+
+// isBuiltinPreset 判断是否为内置预设
+func isBuiltinPreset(id string) bool {
+	for _, p := range builtinPresets {
+		if p.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// validatePreset 校验预设字段
+func validatePreset(preset *PromptPreset) error {
+	preset.ID = strings.TrimSpace(preset.ID)
+	preset.Name = strings.TrimSpace(preset.Name)
+	if preset.ID == "" {
+		return fmt.Errorf("预设ID不能为空")
+	}
+	if preset.Name == "" {
+		return fmt.Errorf("预设名称不能为空")
+	}
+	if strings.TrimSpace(preset.Content) == "" {
+		return fmt.Errorf("提示词内容不能为空")
+	}
+	return nil
+}
+
+// GetPromptPresets 获取所有预设（内置在前）
+func GetPromptPresets() ([]PromptPreset, error) {
+	presetsLock.Lock()
+	defer presetsLock.Unlock()
+
+	presets, err := loadPresets()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]PromptPreset, 0, len(builtinPresets)+len(presets))
+	for _, p := range builtinPresets {
+		p.Builtin = true
+		result = append(result, p)
+	}
+	return append(result, presets...), nil
+}
+
+// GetPromptPreset 根据ID获取预设
+func GetPromptPreset(id string) (*PromptPreset, error) {
+	presets, err := GetPromptPresets()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range presets {
+		if p.ID == id {
+			return &p, nil
+		}
+	}
+	return nil, fmt.Errorf("提示词预设不存在: %s", id)
+}
+
+// CreatePromptPreset 创建预设
+func CreatePromptPreset(preset *PromptPreset) error {
+	if err := validatePreset(preset); err != nil {
+		return err
+	}
+
+	presetsLock.Lock()
+	defer presetsLock.Unlock()
+
+	presets, err := loadPresets()
+	if err != nil {
+		return err
+	}
+	if isBuiltinPreset(preset.ID) {
+		return fmt.Errorf("预设ID已存在: %s", preset.ID)
+	}
+	for _, p := range presets {
+		if p.ID == preset.ID {
+			return fmt.Errorf("预设ID已存在: %s", preset.ID)
+		}
+	}
+
+	preset.Builtin = false
+	preset.CreatedAt = time.Now()
+	preset.UpdatedAt = preset.CreatedAt
+	presets = append(presets, *preset)
+	return writeJSON(presetsFile, presets)
+}
+
+// UpdatePromptPreset 更新预设（内置预设不可修改）
+func UpdatePromptPreset(preset *PromptPreset) error {
+	if err := validatePreset(preset); err != nil {
+		return err
+	}
+	if isBuiltinPreset(preset.ID) {
+		return fmt.Errorf("内置预设不可修改，请另存为新预设")
+	}
+
+	presetsLock.Lock()
+	defer presetsLock.Unlock()
+
+	presets, err := loadPresets()
+	if err != nil {
+		return err
+	}
+	for i, p := range presets {
+		if p.ID == preset.ID {
+			preset.Builtin = false
+			preset.CreatedAt = p.CreatedAt
+			preset.UpdatedAt = time.Now()
+			presets[i] = *preset
+			return writeJSON(presetsFile, presets)
+		}
+	}
+	return fmt.Errorf("提示词预设不存在: %s", preset.ID)
+}
+
+// DeletePromptPreset 删除预设（内置预设不可删除），选用该预设的会话回退到全局/模型提示词
+func DeletePromptPreset(id string) error {
+	if isBuiltinPreset(id) {
+		return fmt.Errorf("内置预设不可删除")
+	}
+
+	presetsLock.Lock()
+	defer presetsLock.Unlock()
+
+	presets, err := loadPresets()
+	if err != nil {
+		return err
+	}
+	for i, p := range presets {
+		if p.ID == id {
+			presets = append(presets[:i], presets[i+1:]...)
+			return writeJSON(presetsFile, presets)
+		}
+	}
+	return fmt.Errorf("提示词预设不存在: %s", id)
+}
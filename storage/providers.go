@@ -22,10 +22,30 @@ func LoadProvidersCache() error {
 		providersCache = []Provider{}
 	}
 
+	for i := range providersCache {
+		if err := transformSecrets(providersCache[i].secretFields(), decryptSecret); err != nil {
+			name := providersCache[i].Name
+			providersCache = []Provider{}
+			return fmt.Errorf("解密供应商 %s 失败: %w", name, err)
+		}
+	}
+
 	providersLoaded = true
 	return nil
 }
 
+// writeProviders 加密API Key后写入文件（不修改传入的切片）
+func writeProviders(providers []Provider) error {
+	encrypted := make([]Provider, len(providers))
+	copy(encrypted, providers)
+	for i := range encrypted {
+		if err := transformSecrets(encrypted[i].secretFields(), encryptSecret); err != nil {
+			return err
+		}
+	}
+	return writeSecretJSON(providersFile, encrypted)
+}
+
 // GetProviders 获取所有供应商（从内存读取）
 func GetProviders() ([]Provider, error) {
 	providersCacheLock.RLock()
@@ -64,6 +84,9 @@ func CreateProvider(provider *Provider) error {
 	if !providersLoaded {
 		return fmt.Errorf("供应商缓存未初始化")
 	}
+	if err := normalizeProvider(provider); err != nil {
+		return err
+	}
 
 	// 检查ID是否已存在
 	for _, p := range providersCache {
@@ -76,7 +99,7 @@ func CreateProvider(provider *Provider) error {
 	providersCache = append(providersCache, *provider)
 
 	// 写入文件
-	return writeJSON(providersFile, providersCache)
+	return writeProviders(providersCache)
 }
 
 // UpdateProvider 更新供应商（操作内存+写文件）
@@ -87,10 +110,15 @@ func UpdateProvider(provider *Provider) error {
 	if !providersLoaded {
 		return fmt.Errorf("供应商缓存未初始化")
 	}
+	if err := normalizeProvider(provider); err != nil {
+		return err
+	}
 
 	found := false
 	for i, p := range providersCache {
 		if p.ID == provider.ID {
+			// API Key留空表示不修改
+			provider.APIKey = keepSecret(provider.APIKey, p.APIKey, false)
 			providersCache[i] = *provider
 			found = true
 			break
@@ -102,7 +130,7 @@ func UpdateProvider(provider *Provider) error {
 	}
 
 	// 写入文件
-	return writeJSON(providersFile, providersCache)
+	return writeProviders(providersCache)
 }
 
 // DeleteProvider 删除供应商（操作内存+写文件）
@@ -132,7 +160,51 @@ func DeleteProvider(id string) error {
 	providersCache = newProviders
 
 	// 写入文件
-	return writeJSON(providersFile, providersCache)
+	return writeProviders(providersCache)
+}
+
+// normalizeProvider 校验接口协议（未指定时为openai）和模型配置
+func normalizeProvider(provider *Provider) error {
+	switch provider.Type {
+	case "":
+		provider.Type = ProviderOpenAI
+	case ProviderOpenAI, ProviderAnthropic, ProviderGemini:
+	default:
+		return fmt.Errorf("不支持的供应商类型: %s", provider.Type)
+	}
+
+	for i := range provider.Models {
+		m := &provider.Models[i]
+		if err := m.Settings.Validate(); err != nil {
+			return fmt.Errorf("模型 %s: %v", m.ID, err)
+		}
+		if m.Settings.IsEmpty() {
+			m.Settings = nil
+		}
+		if m.Capabilities.MaxContext < 0 {
+			return fmt.Errorf("模型 %s: max_context 不能为负数", m.ID)
+		}
+		if pr := m.Pricing; pr != nil {
+			if pr.Input < 0 || pr.Output < 0 || pr.CachedInput < 0 {
+				return fmt.Errorf("模型 %s: 价格不能为负数", m.ID)
+			}
+			if pr.Input == 0 && pr.Output == 0 && pr.CachedInput == 0 {
+				m.Pricing = nil
+			}
+		}
+	}
+	return nil
+}
+
+// FindModel 查找供应商下的模型
+func (p *Provider) FindModel(modelID string) *Model {
+	for i := range p.Models {
+		if p.Models[i].ID == modelID {
+			m := p.Models[i]
+			return &m
+		}
+	}
+	return nil
 }
 
 // FindProviderByModel 根据模型ID查找供应商
@@ -168,6 +240,9 @@ func GetAllModels() ([]map[string]interface{}, error) {
 				"name":          m.Name,
 				"provider_id":   p.ID,
 				"provider_name": p.Name,
+				"provider_type": p.Type,
+				"capabilities":  m.Capabilities,
+				"pricing":       m.Pricing,
 			})
 		}
 	}
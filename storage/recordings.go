@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// recordingsDir 终端录像目录：recordings/<服务器ID>/<日期>/<会话ID>-<时间>.cast
+var recordingsDir = filepath.Join(dataDir, "recordings")
+
+// recordingDateLayout 录像按日期分目录
+const recordingDateLayout = "2006-01-02"
+
+// RecordingInfo 终端录像信息
+type RecordingInfo struct {
+	ID        string    `json:"id"` // 相对路径：<服务器ID>/<日期>/<文件名>
+	ServerID  string    `json:"server_id"`
+	Date      string    `json:"date"`
+	SessionID string    `json:"session_id"`
+	Size      int64     `json:"size"`
+	StartedAt time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CreateRecordingFile 创建录像文件（仅所有者可读写）
+func CreateRecordingFile(serverID, sessionID string, startedAt time.Time) (*os.File, string, error) {
+	if !validRecordingPart(serverID) {
+		return nil, "", fmt.Errorf("无效的服务器ID: %s", serverID)
+	}
+	sessionID = sanitizeRecordingPart(sessionID)
+
+	date := startedAt.Format(recordingDateLayout)
+	dir := filepath.Join(recordingsDir, serverID, date)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, "", err
+	}
+
+	name := fmt.Sprintf("%s-%s.cast", sessionID, startedAt.Format("150405"))
+	file, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, "", err
+	}
+	return file, serverID + "/" + date + "/" + name, nil
+}
+
+// ListRecordings 列出录像（serverID、date为空时不过滤），按开始时间倒序
+func ListRecordings(serverID, date string) ([]RecordingInfo, error) {
+	recordings := []RecordingInfo{}
+
+	servers, err := os.ReadDir(recordingsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return recordings, nil
+		}
+		return nil, err
+	}
+
+	for _, s := range servers {
+		if !s.IsDir() || (serverID != "" && s.Name() != serverID) {
+			continue
+		}
+		dates, err := os.ReadDir(filepath.Join(recordingsDir, s.Name()))
+		if err != nil {
+			continue
+		}
+		for _, d := range dates {
+			if !d.IsDir() || (date != "" && d.Name() != date) {
+				continue
+			}
+			files, err := os.ReadDir(filepath.Join(recordingsDir, s.Name(), d.Name()))
+			if err != nil {
+				continue
+			}
+			for _, f := range files {
+				if f.IsDir() || !strings.HasSuffix(f.Name(), ".cast") {
+					continue
+				}
+				info, err := f.Info()
+				if err != nil {
+					continue
+				}
+				recordings = append(recordings, newRecordingInfo(s.Name(), d.Name(), info))
+			}
+		}
+	}
+
+	sort.Slice(recordings, func(i, j int) bool { return recordings[i].StartedAt.After(recordings[j].StartedAt) })
+	return recordings, nil
+}
+
+// newRecordingInfo 根据文件信息构建录像信息（开始时间取自目录日期和文件名）
+func newRecordingInfo(serverID, date string, info os.FileInfo) RecordingInfo {
+	name := strings.TrimSuffix(info.Name(), ".cast")
+	sessionID := name
+	startedAt := info.ModTime()
+	if i := strings.LastIndex(name, "-"); i >= 0 {
+		sessionID = name[:i]
+		if t, err := time.ParseInLocation(recordingDateLayout+"150405", date+name[i+1:], time.Local); err == nil {
+			startedAt = t
+		}
+	}
+
+	return RecordingInfo{
+		ID:        serverID + "/" + date + "/" + info.Name(),
+		ServerID:  serverID,
+		Date:      date,
+		SessionID: sessionID,
+		Size:      info.Size(),
+		StartedAt: startedAt,
+		UpdatedAt: info.ModTime(),
+	}
+}
+
+// GetRecordingPath 根据录像ID获取文件路径（校验ID防止路径穿越）
+func GetRecordingPath(id string) (string, error) {
+	parts := strings.Split(id, "/")
+	if len(parts) != 3 || !strings.HasSuffix(parts[2], ".cast") {
+		return "", fmt.Errorf("无效的录像ID: %s", id)
+	}
+	for _, part := range parts {
+		if !validRecordingPart(part) {
+			return "", fmt.Errorf("无效的录像ID: %s", id)
+		}
+	}
+
+	path := filepath.Join(recordingsDir, parts[0], parts[1], parts[2])
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("录像不存在: %s", id)
+	}
+	return path, nil
+}
+
+// DeleteRecording 删除录像
+func DeleteRecording(id string) error {
+	path, err := GetRecordingPath(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	// 清理空的日期目录
+	os.Remove(filepath.Dir(path))
+	return nil
+}
+
+// CleanupRecordings 删除早于保留天数的录像（按日期目录整体删除）
+func CleanupRecordings(retentionDays int) (int, error) {
+	if retentionDays <= 0 {
+		return 0, nil
+	}
+	cutoff := time.Now().AddDate(0, 0, -retentionDays).Format(recordingDateLayout)
+
+	servers, err := os.ReadDir(recordingsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	removed := 0
+	for _, s := range servers {
+		if !s.IsDir() {
+			continue
+		}
+		serverDir := filepath.Join(recordingsDir, s.Name())
+		dates, err := os.ReadDir(serverDir)
+		if err != nil {
+			continue
+		}
+		for _, d := range dates {
+			// 日期格式可按字符串比较
+			if d.IsDir() && d.Name() < cutoff {
+				if err := os.RemoveAll(filepath.Join(serverDir, d.Name())); err == nil {
+					removed++
+				}
+			}
+		}
+		os.Remove(serverDir) // 仅在为空时成功
+	}
+	return removed, nil
+}
+
+// StartRecordingCleanup 启动录像保留策略（启动时执行一次，之后每天执行）
+func StartRecordingCleanup(retentionDays int) {
+	if retentionDays <= 0 {
+		return
+	}
+	go func() {
+		for {
+			if removed, err := CleanupRecordings(retentionDays); err != nil {
+				log.Printf("⚠️ 清理过期录像失败: %v", err)
+			} else if removed > 0 {
+				log.Printf("✓ 已清理 %d 天前的录像目录 %d 个", retentionDays, removed)
+			}
+			time.Sleep(24 * time.Hour)
+		}
+	}()
+}
+
+// sanitizeRecordingPart 将不允许的字符替换为下划线
+func sanitizeRecordingPart(part string) string {
+	part = strings.Map(func(r rune) rune {
+		if validRecordingRune(r) {
+			return r
+		}
+		return '_'
+	}, strings.TrimLeft(part, "."))
+	if part == "" {
+		return "session"
+	}
+	return part
+}
+
+// validRecordingPart 路径片段只允许字母数字及 - _ .（不允许 .. 与分隔符）
+func validRecordingPart(part string) bool {
+	if part == "" || part == "." || part == ".." || strings.HasPrefix(part, ".") {
+		return false
+	}
+	for _, r := range part {
+		if !validRecordingRune(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func validRecordingRune(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.'
+}
@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// encryptedPrefix 已加密字段的前缀（无前缀视为旧版明文，读取时兼容并在启动时迁移）
+const encryptedPrefix = "enc:v1:"
+
+// secretKey AES-256-GCM密钥（由主密钥派生）
+var secretKey []byte
+
+// SetMasterKey 设置敏感字段加密主密钥（需在Init之前调用）
+func SetMasterKey(masterKey string) error {
+	if masterKey == "" {
+		return errors.New("主密钥为空")
+	}
+	sum := sha256.Sum256([]byte(masterKey))
+	secretKey = sum[:]
+	return nil
+}
+
+// encryptSecret 加密敏感字段（空值与已加密值原样返回）
+func encryptSecret(plain string) (string, error) {
+	if plain == "" || strings.HasPrefix(plain, encryptedPrefix) {
+		return plain, nil
+	}
+	if secretKey == nil {
+		return "", errors.New("未设置主密钥")
+	}
+
+	block, err := aes.NewCipher(secretKey)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(plain), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptSecret 解密敏感字段（无前缀的旧版明文原样返回）
+func decryptSecret(value string) (string, error) {
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		return value, nil
+	}
+	if secretKey == nil {
+		return "", errors.New("未设置主密钥")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil {
+		return "", fmt.Errorf("密文格式错误: %v", err)
+	}
+
+	block, err := aes.NewCipher(secretKey)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("密文格式错误: 长度不足")
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", errors.New("解密失败，请检查主密钥是否正确")
+	}
+	return string(plain), nil
+}
+
+// secretFields 服务器配置中需要加密的字段
+func (s *Server) secretFields() []*string {
+	return []*string{&s.Password, &s.PrivateKey, &s.Passphrase}
+}
+
+// secretFields 分组默认凭据中需要加密的字段
+func (g *ServerGroup) secretFields() []*string {
+	return []*string{&g.Password, &g.PrivateKey, &g.Passphrase}
+}
+
+// secretFields 供应商配置中需要加密的字段
+func (p *Provider) secretFields() []*string {
+	return []*string{&p.APIKey}
+}
+
+// transformSecrets 对字段逐个执行加密/解密
+func transformSecrets(fields []*string, fn func(string) (string, error)) error {
+	for _, field := range fields {
+		value, err := fn(*field)
+		if err != nil {
+			return err
+		}
+		*field = value
+	}
+	return nil
+}
+
+// hasPlaintextSecret 是否存在尚未加密的敏感字段
+func hasPlaintextSecret(fields []*string) bool {
+	for _, field := range fields {
+		if *field != "" && !strings.HasPrefix(*field, encryptedPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeSecretJSON 写入包含敏感字段的文件（仅所有者可读写）
+func writeSecretJSON(path string, v interface{}) error {
+	if err := writeJSON(path, v); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0600)
+}
+
+// migrateSecrets 将旧版明文保存的密码/API Key加密重写（启动时执行一次）
+func migrateSecrets() error {
+	var servers []Server
+	if err := readJSON(serversFile, &servers); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := range servers {
+		if hasPlaintextSecret(servers[i].secretFields()) {
+			if err := writeServers(servers); err != nil {
+				return fmt.Errorf("迁移服务器密码失败: %w", err)
+			}
+			break
+		}
+	}
+
+	var providers []Provider
+	if err := readJSON(providersFile, &providers); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := range providers {
+		if hasPlaintextSecret(providers[i].secretFields()) {
+			if err := writeProviders(providers); err != nil {
+				return fmt.Errorf("迁移供应商API Key失败: %w", err)
+			}
+			break
+		}
+	}
+
+	// 已是密文的文件也收紧权限
+	for _, path := range []string{serversFile, providersFile} {
+		if _, err := os.Stat(path); err == nil {
+			os.Chmod(path, 0600)
+		}
+	}
+	return nil
+}
+
+// maskedSecret 脱敏后的固定占位值（不泄露长度和任何字符）
+const maskedSecret = "********"
+
+// MaskSecret 脱敏显示API Key（保留首尾各4位便于区分）
+// SSH密码、私钥等凭据使用maskCredential，不保留任何字符
+func MaskSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	if len(secret) <= 12 {
+		return maskedSecret
+	}
+	return secret[:4] + maskedSecret + secret[len(secret)-4:]
+}
+
+// maskCredential 脱敏显示凭据（非空时一律返回固定占位值）
+func maskCredential(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return maskedSecret
+}
+
+// keepSecret 合并更新时提交的凭据：clear为true时清空；提交空值时保留原值；否则使用新值
+// 不与脱敏值比较，提交的任何非空值（包括"********"）都按新凭据保存
+func keepSecret(submitted, stored string, clear bool) string {
+	if clear {
+		return ""
+	}
+	if submitted == "" {
+		return stored
+	}
+	return submitted
+}
+
+// 可通过clear_secrets显式清空的凭据字段
+const (
+	SecretPassword   = "password"
+	SecretPrivateKey = "private_key"
+	SecretPassphrase = "passphrase"
+)
+
+// containsSecret 字段是否在清空列表中
+func containsSecret(clear []string, field string) bool {
+	for _, f := range clear {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Masked 返回敏感字段已脱敏的副本（用于API响应）
+func (s Server) Masked() Server {
+	s.Password = maskCredential(s.Password)
+	s.PrivateKey = maskCredential(s.PrivateKey)
+	s.Passphrase = maskCredential(s.Passphrase)
+	return s
+}
+
+// Masked 返回默认凭据已脱敏的副本（用于API响应）
+func (g ServerGroup) Masked() ServerGroup {
+	g.Password = maskCredential(g.Password)
+	g.PrivateKey = maskCredential(g.PrivateKey)
+	g.Passphrase = maskCredential(g.Passphrase)
+	return g
+}
+
+// Masked 返回API Key已脱敏的副本（用于API响应）
+func (p Provider) Masked() Provider {
+	p.APIKey = MaskSecret(p.APIKey)
+	return p
+}
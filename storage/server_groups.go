@@ -0,0 +1,302 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// serverGroupsLock 保护server_groups.json的读-改-写
+var serverGroupsLock sync.Mutex
+
+// maxGroupDepth 分组最大嵌套层数
+const maxGroupDepth = 16
+
+// loadServerGroups 读取所有分组（敏感字段已解密，文件不存在时返回空列表）
+func loadServerGroups() ([]ServerGroup, error) {
+	var groups []ServerGroup
+	if err := readJSON(groupsFile, &groups); err != nil {
+		if os.IsNotExist(err) {
+			return []ServerGroup{}, nil
+		}
+		return nil, err
+	}
+	for i := range groups {
+		if err := transformSecrets(groups[i].secretFields(), decryptSecret); err != nil {
+			return nil, fmt.Errorf("解密分组 %s 失败: %w", groups[i].Name, err)
+		}
+	}
+	if groups == nil {
+		groups = []ServerGroup{}
+	}
+	return groups, nil
+}
+
+// writeServerGroups 加密敏感字段后写入文件（不修改传入的切片）
+func writeServerGroups(groups []ServerGroup) error {
+	encrypted := make([]ServerGroup, len(groups))
+	copy(encrypted, groups)
+	for i := range encrypted {
+		if err := transformSecrets(encrypted[i].secretFields(), encryptSecret); err != nil {
+			return err
+		}
+	}
+	return writeSecretJSON(groupsFile, encrypted)
+}
+
+// GetServerGroups 获取所有分组
+func GetServerGroups() ([]ServerGroup, error) {
+	serverGroupsLock.Lock()
+	defer serverGroupsLock.Unlock()
+	return loadServerGroups()
+}
+
+// GetServerGroup 根据ID获取分组
+func GetServerGroup(id string) (*ServerGroup, error) {
+	groups, err := GetServerGroups()
+	if err != nil {
+		return nil, err
+	}
+	if g := findGroup(groups, id); g != nil {
+		return g, nil
+	}
+	return nil, fmt.Errorf("分组不存在: %s", id)
+}
+
+// CreateServerGroup 创建分组
+func CreateServerGroup(group *ServerGroup) error {
+	serverGroupsLock.Lock()
+	defer serverGroupsLock.Unlock()
+
+	groups, err := loadServerGroups()
+	if err != nil {
+		return err
+	}
+	if err := checkGroupParent(groups, group.ID, group.ParentID); err != nil {
+		return err
+	}
+
+	group.CreatedAt = time.Now()
+	group.UpdatedAt = time.Now()
+	groups = append(groups, *group)
+	return writeServerGroups(groups)
+}
+
+// UpdateServerGroup 更新分组（凭据留空时保留原值，clear中列出的字段被清空）
+func UpdateServerGroup(group *ServerGroup, clear []string) error {
+	serverGroupsLock.Lock()
+	defer serverGroupsLock.Unlock()
+
+	groups, err := loadServerGroups()
+	if err != nil {
+		return err
+	}
+	if err := checkGroupParent(groups, group.ID, group.ParentID); err != nil {
+		return err
+	}
+
+	for i, g := range groups {
+		if g.ID == group.ID {
+			group.CreatedAt = g.CreatedAt
+			group.UpdatedAt = time.Now()
+			group.Password = keepSecret(group.Password, g.Password, containsSecret(clear, SecretPassword))
+			group.PrivateKey = keepSecret(group.PrivateKey, g.PrivateKey, containsSecret(clear, SecretPrivateKey))
+			group.Passphrase = keepSecret(group.Passphrase, g.Passphrase, containsSecret(clear, SecretPassphrase))
+			groups[i] = *group
+			return writeServerGroups(groups)
+		}
+	}
+	return fmt.Errorf("分组不存在: %s", group.ID)
+}
+
+// DeleteServerGroup 删除分组，子分组和服务器移到上级分组
+func DeleteServerGroup(id string) error {
+	serverGroupsLock.Lock()
+	defer serverGroupsLock.Unlock()
+
+	groups, err := loadServerGroups()
+	if err != nil {
+		return err
+	}
+	deleted := findGroup(groups, id)
+	if deleted == nil {
+		return fmt.Errorf("分组不存在: %s", id)
+	}
+
+	servers, err := GetServers()
+	if err != nil {
+		return err
+	}
+	moved := false
+	for i := range servers {
+		if servers[i].GroupID == id {
+			servers[i].GroupID = deleted.ParentID
+			moved = true
+		}
+	}
+	if moved {
+		if err := writeServers(servers); err != nil {
+			return err
+		}
+	}
+
+	remaining := make([]ServerGroup, 0, len(groups))
+	for _, g := range groups {
+		if g.ID == id {
+			continue
+		}
+		if g.ParentID == id {
+			g.ParentID = deleted.ParentID
+		}
+		remaining = append(remaining, g)
+	}
+	return writeServerGroups(remaining)
+}
+
+// MoveServers 将服务器移到分组（groupID为空表示移出分组）
+func MoveServers(serverIDs []string, groupID string) error {
+	if groupID != "" {
+		if _, err := GetServerGroup(groupID); err != nil {
+			return err
+		}
+	}
+
+	servers, err := GetServers()
+	if err != nil {
+		return err
+	}
+
+	move := make(map[string]bool)
+	for _, id := range serverIDs {
+		move[id] = true
+	}
+	found := 0
+	for i := range servers {
+		if move[servers[i].ID] {
+			servers[i].GroupID = groupID
+			servers[i].UpdatedAt = time.Now()
+			found++
+		}
+	}
+	if found != len(move) {
+		return fmt.Errorf("部分服务器不存在")
+	}
+	return writeServers(servers)
+}
+
+// GroupPath 分组从根到自身的路径（用于显示和搜索）
+func GroupPath(groups []ServerGroup, id string) []ServerGroup {
+	var path []ServerGroup
+	for g := findGroup(groups, id); g != nil && len(path) < maxGroupDepth; g = findGroup(groups, g.ParentID) {
+		path = append([]ServerGroup{*g}, path...)
+	}
+	return path
+}
+
+// ApplyGroupDefaults 返回应用了分组默认值的服务器配置（用于建立连接）
+// 服务器自身已设置的字段优先，其余按所在分组到根分组的顺序取第一个非空值
+func ApplyGroupDefaults(server Server) (Server, error) {
+	if server.GroupID == "" {
+		return server, nil
+	}
+
+	groups, err := GetServerGroups()
+	if err != nil {
+		return server, err
+	}
+
+	server = InheritGroupDefaults(server, groups)
+	if server.Port == 0 {
+		server.Port = 22
+	}
+	return server, nil
+}
+
+// InheritGroupDefaults 用给定的分组列表填充服务器未设置的字段（不补默认端口）
+func InheritGroupDefaults(server Server, groups []ServerGroup) Server {
+	path := GroupPath(groups, server.GroupID)
+	for i := len(path) - 1; i >= 0; i-- {
+		g := path[i]
+		if server.Username == "" {
+			server.Username = g.Username
+		}
+		if server.Port == 0 {
+			server.Port = g.Port
+		}
+		if server.Password == "" {
+			server.Password = g.Password
+		}
+		if len(server.AuthMethods) == 0 {
+			server.AuthMethods = g.AuthMethods
+		}
+		if server.PrivateKey == "" && server.PrivateKeyPath == "" {
+			server.PrivateKey = g.PrivateKey
+			server.PrivateKeyPath = g.PrivateKeyPath
+			server.Passphrase = g.Passphrase
+		}
+		if server.Certificate == "" {
+			server.Certificate = g.Certificate
+		}
+		if server.JumpHostID == "" && g.JumpHostID != server.ID {
+			server.JumpHostID = g.JumpHostID
+		}
+	}
+	return server
+}
+
+// GroupUsesJumpHost 返回把该服务器作为默认跳板机的分组名称（没有则为空）
+func GroupUsesJumpHost(serverID string) (string, error) {
+	groups, err := GetServerGroups()
+	if err != nil {
+		return "", err
+	}
+	for _, g := range groups {
+		if g.JumpHostID == serverID {
+			return g.Name, nil
+		}
+	}
+	return "", nil
+}
+
+// checkGroupParent 校验上级分组存在且不会形成循环
+func checkGroupParent(groups []ServerGroup, id, parentID string) error {
+	depth := 0
+	for pid := parentID; pid != ""; depth++ {
+		if pid == id {
+			return fmt.Errorf("不能将分组移到自身或其子分组下")
+		}
+		if depth >= maxGroupDepth {
+			return fmt.Errorf("分组嵌套超过最大层数 %d", maxGroupDepth)
+		}
+		parent := findGroup(groups, pid)
+		if parent == nil {
+			return fmt.Errorf("上级分组不存在: %s", pid)
+		}
+		pid = parent.ParentID
+	}
+	return nil
+}
+
+func findGroup(groups []ServerGroup, id string) *ServerGroup {
+	if id == "" {
+		return nil
+	}
+	for i := range groups {
+		if groups[i].ID == id {
+			g := groups[i]
+			return &g
+		}
+	}
+	return nil
+}
+
+// groupPathNames 分组路径上所有分组名称（小写）
+func groupPathNames(groups []ServerGroup, id string) []string {
+	var names []string
+	for _, g := range GroupPath(groups, id) {
+		names = append(names, strings.ToLower(g.Name))
+	}
+	return names
+}
@@ -2,18 +2,36 @@ package storage
 
 import (
 	"fmt"
+	"strings"
 	"time"
 )
 
-// GetServers 获取所有服务器
+// GetServers 获取所有服务器（敏感字段已解密）
 func GetServers() ([]Server, error) {
 	var servers []Server
 	if err := readJSON(serversFile, &servers); err != nil {
 		return nil, err
 	}
+	for i := range servers {
+		if err := transformSecrets(servers[i].secretFields(), decryptSecret); err != nil {
+			return nil, fmt.Errorf("解密服务器 %s 失败: %w", servers[i].Name, err)
+		}
+	}
 	return servers, nil
 }
 
+// writeServers 加密敏感字段后写入文件（不修改传入的切片）
+func writeServers(servers []Server) error {
+	encrypted := make([]Server, len(servers))
+	copy(encrypted, servers)
+	for i := range encrypted {
+		if err := transformSecrets(encrypted[i].secretFields(), encryptSecret); err != nil {
+			return err
+		}
+	}
+	return writeSecretJSON(serversFile, encrypted)
+}
+
 // GetServer 根据ID获取服务器
 func GetServer(id string) (*Server, error) {
 	servers, err := GetServers()
@@ -40,11 +58,28 @@ func CreateServer(server *Server) error {
 	server.UpdatedAt = time.Now()
 	servers = append(servers, *server)
 
-	return writeJSON(serversFile, servers)
+	return writeServers(servers)
 }
 
-// UpdateServer 更新服务器
-func UpdateServer(server *Server) error {
+// CreateServers 批量创建服务器（一次写入，用于导入）
+func CreateServers(newServers []Server) error {
+	servers, err := GetServers()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, server := range newServers {
+		server.CreatedAt = now
+		server.UpdatedAt = now
+		servers = append(servers, server)
+	}
+
+	return writeServers(servers)
+}
+
+// UpdateServer 更新服务器（clear中列出的凭据字段被清空，见SecretPassword等）
+func UpdateServer(server *Server, clear []string) error {
 	servers, err := GetServers()
 	if err != nil {
 		return err
@@ -55,6 +90,10 @@ func UpdateServer(server *Server) error {
 		if s.ID == server.ID {
 			server.UpdatedAt = time.Now()
 			server.CreatedAt = s.CreatedAt // 保留创建时间
+			// 编辑时留空表示不修改，清空需显式指定
+			server.Password = keepSecret(server.Password, s.Password, containsSecret(clear, SecretPassword))
+			server.PrivateKey = keepSecret(server.PrivateKey, s.PrivateKey, containsSecret(clear, SecretPrivateKey))
+			server.Passphrase = keepSecret(server.Passphrase, s.Passphrase, containsSecret(clear, SecretPassphrase))
 			servers[i] = *server
 			found = true
 			break
@@ -65,7 +104,7 @@ func UpdateServer(server *Server) error {
 		return fmt.Errorf("服务器不存在: %s", server.ID)
 	}
 
-	return writeJSON(serversFile, servers)
+	return writeServers(servers)
 }
 
 // DeleteServer 删除服务器
@@ -89,24 +128,39 @@ func DeleteServer(id string) error {
 		return fmt.Errorf("服务器不存在: %s", id)
 	}
 
-	return writeJSON(serversFile, newServers)
+	return writeServers(newServers)
 }
 
-// SearchServers 搜索服务器
+// SearchServers 搜索服务器（不区分大小写，多个条件同时满足）
+// 支持限定词：tag:标签、group:分组名（含子分组）、host:主机地址，值含空格时加引号；
+// 其余关键字匹配名称、主机、描述、标签和分组名
 func SearchServers(keyword string) ([]Server, error) {
 	servers, err := GetServers()
 	if err != nil {
 		return nil, err
 	}
 
-	if keyword == "" {
+	terms := parseSearchTerms(keyword)
+	if len(terms) == 0 {
 		return servers, nil
 	}
 
+	groups, err := GetServerGroups()
+	if err != nil {
+		return nil, err
+	}
+
 	result := []Server{}
 	for _, s := range servers {
-		if contains(s.Name, keyword) || contains(s.Host, keyword) ||
-			contains(s.Description, keyword) || containsTag(s.Tags, keyword) {
+		groupNames := groupPathNames(groups, s.GroupID)
+		matched := true
+		for _, term := range terms {
+			if !term.match(s, groupNames) {
+				matched = false
+				break
+			}
+		}
+		if matched {
 			result = append(result, s)
 		}
 	}
@@ -114,23 +168,114 @@ func SearchServers(keyword string) ([]Server, error) {
 	return result, nil
 }
 
-func contains(s, substr string) bool {
-	return len(s) > 0 && len(substr) > 0 && (s == substr || len(s) >= len(substr) && findSubstring(s, substr))
+// searchTerm 搜索条件（field为空表示匹配任意字段）
+type searchTerm struct {
+	field string
+	value string
 }
 
-func findSubstring(s, substr string) bool {
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
+func (t searchTerm) match(s Server, groupNames []string) bool {
+	switch t.field {
+	case "tag":
+		for _, tag := range s.Tags {
+			if containsFold(tag, t.value) {
+				return true
+			}
+		}
+		return false
+	case "group":
+		for _, name := range groupNames {
+			if strings.Contains(name, t.value) {
+				return true
+			}
+		}
+		return false
+	case "host":
+		return containsFold(s.Host, t.value)
+	}
+
+	if containsFold(s.Name, t.value) || containsFold(s.Host, t.value) || containsFold(s.Description, t.value) {
+		return true
+	}
+	for _, tag := range s.Tags {
+		if containsFold(tag, t.value) {
+			return true
+		}
+	}
+	for _, name := range groupNames {
+		if strings.Contains(name, t.value) {
 			return true
 		}
 	}
 	return false
 }
 
-func containsTag(tags []string, keyword string) bool {
-	for _, tag := range tags {
-		if contains(tag, keyword) {
-			return true
+// parseSearchTerms 拆分搜索关键字（空格分隔，双引号内的空格保留）
+func parseSearchTerms(keyword string) []searchTerm {
+	var tokens []string
+	var current strings.Builder
+	quoted := false
+	for _, r := range keyword {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case (r == ' ' || r == '\t') && !quoted:
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+
+	terms := make([]searchTerm, 0, len(tokens))
+	for _, token := range tokens {
+		term := searchTerm{value: token}
+		if field, value, ok := strings.Cut(token, ":"); ok {
+			switch strings.ToLower(field) {
+			case "tag", "group", "host":
+				term = searchTerm{field: strings.ToLower(field), value: value}
+			}
+		}
+		term.value = strings.ToLower(term.value)
+		if term.value != "" {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// containsFold 不区分大小写的子串匹配（substr需已转为小写）
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), substr)
+}
+
+// GetServersByTags 获取带有任意一个指定标签的服务器
+func GetServersByTags(tags []string) ([]Server, error) {
+	servers, err := GetServers()
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []Server
+	for _, s := range servers {
+		if hasAnyTag(s.Tags, tags) {
+			matched = append(matched, s)
+		}
+	}
+	return matched, nil
+}
+
+func hasAnyTag(serverTags, tags []string) bool {
+	for _, t := range serverTags {
+		for _, want := range tags {
+			if strings.EqualFold(strings.TrimSpace(t), strings.TrimSpace(want)) {
+				return true
+			}
 		}
 	}
 	return false
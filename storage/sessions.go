@@ -237,6 +237,7 @@ func ClearMessages(sessionID string) error {
 
 	// 清空消息
 	session.Messages = []ChatMessage{}
+	session.clearSummary()
 	session.UpdatedAt = time.Now()
 
 	// 写入文件
@@ -270,6 +271,101 @@ func UpdateSessionModel(sessionID, modelID string) error {
 	return writeJSON(sessionFile, session)
 }
 
+// UpdateSessionSettings 更新会话级生成参数（nil或全部为空表示沿用模型和全局配置）
+func UpdateSessionSettings(sessionID string, settings *GenerationSettings) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+	if settings.IsEmpty() {
+		settings = nil
+	}
+
+	sessionCacheLock.Lock()
+	defer sessionCacheLock.Unlock()
+
+	// 从缓存获取或加载
+	session, ok := sessionCache[sessionID]
+	if !ok {
+		sessionFile := filepath.Join(sessionsDir, sessionID+".json")
+		var loadedSession ChatSession
+		if err := readJSON(sessionFile, &loadedSession); err != nil {
+			return err
+		}
+		session = &loadedSession
+		sessionCache[sessionID] = session
+	}
+
+	session.Settings = settings
+	session.UpdatedAt = time.Now()
+
+	sessionFile := filepath.Join(sessionsDir, sessionID+".json")
+	return writeJSON(sessionFile, session)
+}
+
+// UpdateSessionPreset 更新会话选用的提示词预设（为空表示不使用预设）
+func UpdateSessionPreset(sessionID, presetID string) error {
+	if presetID != "" {
+		if _, err := GetPromptPreset(presetID); err != nil {
+			return err
+		}
+	}
+
+	sessionCacheLock.Lock()
+	defer sessionCacheLock.Unlock()
+
+	// 从缓存获取或加载
+	session, ok := sessionCache[sessionID]
+	if !ok {
+		sessionFile := filepath.Join(sessionsDir, sessionID+".json")
+		var loadedSession ChatSession
+		if err := readJSON(sessionFile, &loadedSession); err != nil {
+			return err
+		}
+		session = &loadedSession
+		sessionCache[sessionID] = session
+	}
+
+	session.PresetID = presetID
+	session.UpdatedAt = time.Now()
+
+	sessionFile := filepath.Join(sessionsDir, sessionID+".json")
+	return writeJSON(sessionFile, session)
+}
+
+// UpdateSessionSummary 保存上下文压缩生成的摘要（覆盖 Messages[:upTo]）
+func UpdateSessionSummary(sessionID, summary string, upTo int) error {
+	sessionCacheLock.Lock()
+	defer sessionCacheLock.Unlock()
+
+	// 从缓存获取或加载
+	session, ok := sessionCache[sessionID]
+	if !ok {
+		sessionFile := filepath.Join(sessionsDir, sessionID+".json")
+		var loadedSession ChatSession
+		if err := readJSON(sessionFile, &loadedSession); err != nil {
+			return err
+		}
+		session = &loadedSession
+		sessionCache[sessionID] = session
+	}
+
+	if upTo < 0 || upTo > len(session.Messages) {
+		return fmt.Errorf("摘要范围超出消息数量")
+	}
+	session.Summary = summary
+	session.SummaryUpTo = upTo
+	session.UpdatedAt = time.Now()
+
+	sessionFile := filepath.Join(sessionsDir, sessionID+".json")
+	return writeJSON(sessionFile, session)
+}
+
+// clearSummary 清除摘要（被摘要覆盖的消息变化时调用）
+func (s *ChatSession) clearSummary() {
+	s.Summary = ""
+	s.SummaryUpTo = 0
+}
+
 // UpdateMessageInSession 更新会话中的指定消息
 func UpdateMessageInSession(sessionID string, messageIndex int, newContent string) error {
 	sessionCacheLock.Lock()
@@ -292,9 +388,12 @@ func UpdateMessageInSession(sessionID string, messageIndex int, newContent strin
 		return fmt.Errorf("消息索引超出范围")
 	}
 
-	// 更新消息内容
+	// 更新消息内容（被摘要覆盖的消息修改后摘要失效）
 	session.Messages[messageIndex].Content = newContent
 	session.Messages[messageIndex].Timestamp = time.Now()
+	if messageIndex < session.SummaryUpTo {
+		session.clearSummary()
+	}
 	session.UpdatedAt = time.Now()
 
 	// 写入文件
@@ -378,6 +477,9 @@ func RevokeMessagesFromIndex(sessionID string, messageIndex int) error {
 
 	// 撤销该消息及之后的所有消息
 	session.Messages = session.Messages[:actualIndex]
+	if actualIndex < session.SummaryUpTo {
+		session.clearSummary()
+	}
 	session.UpdatedAt = time.Now()
 
 	// 写入文件
@@ -12,10 +12,16 @@ import (
 var (
 	dataDir     = "./data"
 	sessionsDir = "./data/sessions"
+	execJobsDir = "./data/exec_jobs"
 
-	serversFile   = filepath.Join(dataDir, "servers.json")
-	providersFile = filepath.Join(dataDir, "providers.json")
-	commandsFile  = filepath.Join(dataDir, "commands.json")
+	serversFile    = filepath.Join(dataDir, "servers.json")
+	providersFile  = filepath.Join(dataDir, "providers.json")
+	commandsFile   = filepath.Join(dataDir, "commands.json")
+	knownHostsFile = filepath.Join(dataDir, "known_hosts.json")
+	tunnelsFile    = filepath.Join(dataDir, "tunnels.json")
+	groupsFile     = filepath.Join(dataDir, "server_groups.json")
+	presetsFile    = filepath.Join(dataDir, "prompt_presets.json")
+	usageFile      = filepath.Join(dataDir, "usage.jsonl")
 
 	mu sync.RWMutex // 全局锁保护文件读写
 )
@@ -23,7 +29,7 @@ var (
 // Init 初始化存储目录
 func Init() error {
 	// 创建目录
-	dirs := []string{dataDir, sessionsDir}
+	dirs := []string{dataDir, sessionsDir, execJobsDir}
 	for _, dir := range dirs {
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			return fmt.Errorf("创建目录失败 %s: %w", dir, err)
@@ -35,6 +41,11 @@ func Init() error {
 		return err
 	}
 
+	// 旧版明文密码/API Key加密迁移
+	if err := migrateSecrets(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// tunnelsLock 保护tunnels.json的读-改-写
+var tunnelsLock sync.Mutex
+
+// loadTunnels 读取所有端口转发定义（文件不存在时返回空列表）
+func loadTunnels() ([]Tunnel, error) {
+	var tunnels []Tunnel
+	if err := readJSON(tunnelsFile, &tunnels); err != nil {
+		if os.IsNotExist(err) {
+			return []Tunnel{}, nil
+		}
+		return nil, err
+	}
+	if tunnels == nil {
+		tunnels = []Tunnel{}
+	}
+	return tunnels, nil
+}
+
+// GetTunnels 获取所有端口转发定义
+func GetTunnels() ([]Tunnel, error) {
+	tunnelsLock.Lock()
+	defer tunnelsLock.Unlock()
+	return loadTunnels()
+}
+
+// GetTunnel 根据ID获取端口转发定义
+func GetTunnel(id string) (*Tunnel, error) {
+	tunnels, err := GetTunnels()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tunnels {
+		if t.ID == id {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("端口转发不存在: %s", id)
+}
+
+// SaveTunnel 创建或更新端口转发定义
+func SaveTunnel(tunnel *Tunnel) error {
+	tunnelsLock.Lock()
+	defer tunnelsLock.Unlock()
+
+	tunnels, err := loadTunnels()
+	if err != nil {
+		return err
+	}
+
+	tunnel.UpdatedAt = time.Now()
+	for i := range tunnels {
+		if tunnels[i].ID == tunnel.ID {
+			tunnel.CreatedAt = tunnels[i].CreatedAt
+			tunnels[i] = *tunnel
+			return writeJSON(tunnelsFile, tunnels)
+		}
+	}
+
+	tunnel.CreatedAt = tunnel.UpdatedAt
+	tunnels = append(tunnels, *tunnel)
+	return writeJSON(tunnelsFile, tunnels)
+}
+
+// SetTunnelEnabled 更新端口转发的打开状态
+func SetTunnelEnabled(id string, enabled bool) error {
+	tunnelsLock.Lock()
+	defer tunnelsLock.Unlock()
+
+	tunnels, err := loadTunnels()
+	if err != nil {
+		return err
+	}
+	for i := range tunnels {
+		if tunnels[i].ID == id {
+			tunnels[i].Enabled = enabled
+			return writeJSON(tunnelsFile, tunnels)
+		}
+	}
+	return fmt.Errorf("端口转发不存在: %s", id)
+}
+
+// DeleteTunnel 删除端口转发定义
+func DeleteTunnel(id string) error {
+	tunnelsLock.Lock()
+	defer tunnelsLock.Unlock()
+
+	tunnels, err := loadTunnels()
+	if err != nil {
+		return err
+	}
+	for i := range tunnels {
+		if tunnels[i].ID == id {
+			tunnels = append(tunnels[:i], tunnels[i+1:]...)
+			return writeJSON(tunnelsFile, tunnels)
+		}
+	}
+	return fmt.Errorf("端口转发不存在: %s", id)
+}
@@ -2,33 +2,162 @@ package storage
 
 import "time"
 
+// SSH认证方式
+const (
+	AuthPassword            = "password"             // 密码
+	AuthPublicKey           = "publickey"            // 私钥（可附带OpenSSH用户证书）
+	AuthAgent               = "agent"                // ssh-agent（SSH_AUTH_SOCK）
+	AuthKeyboardInteractive = "keyboard-interactive" // 键盘交互（用密码应答）
+)
+
 // Server SSH服务器配置
 type Server struct {
-	ID          string    `json:"id"`
-	Name        string    `json:"name"`
+	ID             string    `json:"id"`
+	Name           string    `json:"name"`
+	Host           string    `json:"host"`
+	Port           int       `json:"port"`
+	Username       string    `json:"username"`
+	Password       string    `json:"password"`
+	AuthMethods    []string  `json:"auth_methods,omitempty"`     // 按顺序尝试的认证方式，为空时根据已配置的凭据推断
+	PrivateKey     string    `json:"private_key,omitempty"`      // PEM私钥内容
+	PrivateKeyPath string    `json:"private_key_path,omitempty"` // 私钥文件路径（与PrivateKey二选一）
+	Passphrase     string    `json:"passphrase,omitempty"`       // 私钥密码
+	Certificate    string    `json:"certificate,omitempty"`      // OpenSSH用户证书（*-cert.pub内容）
+	JumpHostID     string    `json:"jump_host_id,omitempty"`     // 跳板机（另一台服务器的ID），可多级串联
+	Record         bool      `json:"record,omitempty"`           // 录制该服务器的终端会话
+	GroupID        string    `json:"group_id,omitempty"`         // 所属分组，未设置的用户名/端口/凭据/跳板机继承自分组
+	Description    string    `json:"description"`
+	Tags           []string  `json:"tags"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// ServerGroup 服务器分组（可嵌套）
+// 连接默认值逐级向上继承：服务器未设置的字段取所在分组的值，分组未设置的再取上级分组
+type ServerGroup struct {
+	ID             string    `json:"id"`
+	Name           string    `json:"name"`
+	ParentID       string    `json:"parent_id,omitempty"`
+	Username       string    `json:"username,omitempty"`
+	Port           int       `json:"port,omitempty"`
+	Password       string    `json:"password,omitempty"`
+	AuthMethods    []string  `json:"auth_methods,omitempty"`
+	PrivateKey     string    `json:"private_key,omitempty"`
+	PrivateKeyPath string    `json:"private_key_path,omitempty"`
+	Passphrase     string    `json:"passphrase,omitempty"`
+	Certificate    string    `json:"certificate,omitempty"`
+	JumpHostID     string    `json:"jump_host_id,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// KnownHost 已信任的服务器主机密钥（首次连接时记录）
+type KnownHost struct {
+	ServerID    string    `json:"server_id"`
 	Host        string    `json:"host"`
 	Port        int       `json:"port"`
-	Username    string    `json:"username"`
-	Password    string    `json:"password"`
-	Description string    `json:"description"`
-	Tags        []string  `json:"tags"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	KeyType     string    `json:"key_type"`    // 如 ssh-ed25519
+	Fingerprint string    `json:"fingerprint"` // SHA256:...
+	PublicKey   string    `json:"public_key"`  // authorized_keys 格式
+	FirstSeen   time.Time `json:"first_seen"`
+}
+
+// 端口转发类型
+const (
+	TunnelLocal   = "local"   // -L：本机监听，经服务器连接目标地址
+	TunnelRemote  = "remote"  // -R：服务器上监听，连接本机可达的目标地址
+	TunnelDynamic = "dynamic" // -D：本机SOCKS5代理，经服务器连接任意地址
+)
+
+// Tunnel 端口转发定义（持久化，服务重启后恢复之前已打开的转发）
+type Tunnel struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	ServerID      string    `json:"server_id"`
+	Type          string    `json:"type"`                  // local/remote/dynamic
+	BindAddr      string    `json:"bind_addr"`             // 监听地址 host:port（remote在服务器上监听），只写端口时绑定127.0.0.1
+	TargetAddr    string    `json:"target_addr,omitempty"` // 转发目标 host:port（dynamic不需要）
+	AutoReconnect bool      `json:"auto_reconnect"`        // 连接断开后自动重连
+	Enabled       bool      `json:"enabled"`               // 是否处于打开状态（服务启动时据此恢复）
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
 }
 
 // Provider AI供应商配置
 type Provider struct {
 	ID      string  `json:"id"`
 	Name    string  `json:"name"`
+	Type    string  `json:"type,omitempty"` // 接口协议：openai/anthropic/gemini，为空按openai处理
 	BaseURL string  `json:"base_url"`
 	APIKey  string  `json:"api_key"`
 	Models  []Model `json:"models"`
 }
 
+// 供应商接口协议
+const (
+	ProviderOpenAI    = "openai"    // OpenAI Chat Completions（及兼容接口）
+	ProviderAnthropic = "anthropic" // Anthropic Messages
+	ProviderGemini    = "gemini"    // Google Gemini generateContent
+)
+
 // Model AI模型
 type Model struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+	ID           string              `json:"id"`
+	Name         string              `json:"name"`
+	Settings     *GenerationSettings `json:"settings,omitempty"` // 覆盖全局生成参数（会话设置优先）
+	Capabilities ModelCapabilities   `json:"capabilities"`
+	Pricing      *ModelPricing       `json:"pricing,omitempty"` // 价格（未设置时不计算费用）
+}
+
+// ModelPricing 模型价格（每百万token）
+type ModelPricing struct {
+	Input       float64 `json:"input"`                  // 输入
+	Output      float64 `json:"output"`                 // 输出（含推理）
+	CachedInput float64 `json:"cached_input,omitempty"` // 命中缓存的输入，0表示按输入价格计算
+	CacheWrite  float64 `json:"cache_write,omitempty"`  // 写入缓存的输入（Anthropic按输入价格加价收取），0表示按输入价格计算
+	Currency    string  `json:"currency,omitempty"`     // 币种，默认USD
+}
+
+// TokenUsage 一次模型调用的token用量
+// PromptTokens 含命中缓存和写入缓存的部分，CompletionTokens 含推理部分（与OpenAI的统计口径一致）
+type TokenUsage struct {
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	ReasoningTokens  int     `json:"reasoning_tokens,omitempty"`
+	CachedTokens     int     `json:"cached_tokens,omitempty"`
+	CacheWriteTokens int     `json:"cache_write_tokens,omitempty"` // 写入缓存的输入（目前仅Anthropic单独计费）
+	ModelID          string  `json:"model_id,omitempty"`
+	ProviderID       string  `json:"provider_id,omitempty"`
+	Cost             float64 `json:"cost,omitempty"`      // 按调用时的模型价格计算
+	Currency         string  `json:"currency,omitempty"`  // 费用币种
+	Estimated        bool    `json:"estimated,omitempty"` // 接口未返回用量（如生成中断），按文本长度估算
+}
+
+// ModelCapabilities 模型能力
+// no_*开关为"不支持"标记：默认（false/未设置）即支持，旧数据无需迁移；
+// 判断时使用SupportsTools、SupportsTemperature，不要直接读取No*字段
+type ModelCapabilities struct {
+	NoTools             bool `json:"no_tools,omitempty"`              // 不支持工具调用
+	NoTemperature       bool `json:"no_temperature,omitempty"`        // 不支持temperature/top_p/penalty等采样参数（如部分推理模型）
+	NoStreamUsage       bool `json:"no_stream_usage,omitempty"`       // 不支持在流式响应中返回用量（OpenAI兼容接口的stream_options）
+	MaxCompletionTokens bool `json:"max_completion_tokens,omitempty"` // 输出上限使用max_completion_tokens代替max_tokens（OpenAI o系列等推理模型）
+	MaxContext          int  `json:"max_context,omitempty"`           // 最大上下文token数，0表示未知
+}
+
+// SupportsTools 是否支持工具调用
+func (c ModelCapabilities) SupportsTools() bool { return !c.NoTools }
+
+// SupportsTemperature 是否支持采样参数
+func (c ModelCapabilities) SupportsTemperature() bool { return !c.NoTemperature }
+
+// GenerationSettings 可选的生成参数覆盖（nil字段沿用上一级配置）
+type GenerationSettings struct {
+	SystemPrompt     *string  `json:"system_prompt,omitempty"`
+	Temperature      *float64 `json:"temperature,omitempty"`
+	MaxTokens        *int     `json:"max_tokens,omitempty"`
+	TopP             *float64 `json:"top_p,omitempty"`
+	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty"`
+	PresencePenalty  *float64 `json:"presence_penalty,omitempty"`
 }
 
 // AIConfig 全局AI配置（唯一，提示词+参数）
@@ -41,14 +170,33 @@ type AIConfig struct {
 	PresencePenalty  float64 `json:"presence_penalty"`
 }
 
+// PromptPreset 系统提示词预设
+// Content 支持模板变量（如 {{server_name}}、{{os}}），发送请求时填充
+type PromptPreset struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Content     string    `json:"content"`
+	Builtin     bool      `json:"builtin,omitempty"` // 内置预设（只读）
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
 // ChatSession 对话会话
 type ChatSession struct {
-	ID        string        `json:"id"`
-	Title     string        `json:"title"`
-	ModelID   string        `json:"model_id"` // 使用的模型ID
-	CreatedAt time.Time     `json:"created_at"`
-	UpdatedAt time.Time     `json:"updated_at"`
-	Messages  []ChatMessage `json:"messages"`
+	ID        string              `json:"id"`
+	Title     string              `json:"title"`
+	ModelID   string              `json:"model_id"`            // 使用的模型ID
+	PresetID  string              `json:"preset_id,omitempty"` // 选用的提示词预设（替换全局/模型的系统提示词）
+	Settings  *GenerationSettings `json:"settings,omitempty"`  // 会话级生成参数（优先于模型和全局配置）
+	CreatedAt time.Time           `json:"created_at"`
+	UpdatedAt time.Time           `json:"updated_at"`
+	Messages  []ChatMessage       `json:"messages"`
+
+	// 上下文压缩：Messages[:SummaryUpTo] 已总结为Summary，发送给模型时以摘要代替
+	// SummaryUpTo 总是落在user消息处，不会拆开assistant的tool_calls和对应的tool响应
+	Summary     string `json:"summary,omitempty"`
+	SummaryUpTo int    `json:"summary_up_to,omitempty"`
 }
 
 // ChatMessage 对话消息
@@ -59,6 +207,8 @@ type ChatMessage struct {
 	ToolCalls        []map[string]interface{} `json:"tool_calls,omitempty"`        // 工具调用（assistant role）
 	ToolCallID       string                   `json:"tool_call_id,omitempty"`      // 工具调用ID（tool role）
 	ToolName         string                   `json:"tool_name,omitempty"`         // 工具名称（tool role）
+	Interrupted      bool                     `json:"interrupted,omitempty"`       // 用户停止生成时被中断
+	Usage            *TokenUsage              `json:"usage,omitempty"`             // token用量（assistant role，接口返回时）
 	Timestamp        time.Time                `json:"timestamp"`
 }
 
@@ -69,6 +219,11 @@ type CommandHistory struct {
 	ServerName string    `json:"server_name"` // 服务器名称（用于显示）
 	Command    string    `json:"command"`     // 命令内容
 	Timestamp  time.Time `json:"timestamp"`   // 执行时间
+
+	// 以下字段由服务端解析shell集成序列（OSC 133/633）获得，前端提交的记录为空
+	Cwd        string `json:"cwd,omitempty"`         // 执行目录
+	ExitCode   *int   `json:"exit_code,omitempty"`   // 退出码
+	DurationMs int64  `json:"duration_ms,omitempty"` // 耗时（毫秒）
 }
 
 // CommandHistoryStore 命令历史存储（统一列表）
@@ -94,3 +249,51 @@ type EditPreview struct {
 	Operations []EditOperation `json:"operations"`
 	CreatedAt  time.Time       `json:"created_at"`
 }
+
+// 批量执行任务状态
+const (
+	ExecJobRunning     = "running"     // 执行中
+	ExecJobCompleted   = "completed"   // 全部完成
+	ExecJobCancelled   = "cancelled"   // 已取消
+	ExecJobInterrupted = "interrupted" // 服务重启导致中断
+)
+
+// 单台服务器的执行状态
+const (
+	ExecHostPending   = "pending"   // 等待执行
+	ExecHostRunning   = "running"   // 执行中
+	ExecHostSuccess   = "success"   // 退出码为0
+	ExecHostFailed    = "failed"    // 退出码非0
+	ExecHostTimeout   = "timeout"   // 超时
+	ExecHostError     = "error"     // 连接或执行出错
+	ExecHostCancelled = "cancelled" // 任务被取消
+)
+
+// ExecJob 批量执行任务（同一命令在多台服务器上以exec方式执行，不分配PTY）
+type ExecJob struct {
+	ID          string       `json:"id"`
+	Command     string       `json:"command"`
+	ServerIDs   []string     `json:"server_ids,omitempty"` // 请求中指定的服务器
+	Tags        []string     `json:"tags,omitempty"`       // 请求中指定的标签（匹配任意一个）
+	Concurrency int          `json:"concurrency"`          // 最大并发数
+	Timeout     int          `json:"timeout"`              // 单台服务器超时（秒）
+	Status      string       `json:"status"`
+	Results     []ExecResult `json:"results"`
+	CreatedAt   time.Time    `json:"created_at"`
+	FinishedAt  *time.Time   `json:"finished_at,omitempty"`
+}
+
+// ExecResult 单台服务器的执行结果
+type ExecResult struct {
+	ServerID   string     `json:"server_id"`
+	ServerName string     `json:"server_name"`
+	Status     string     `json:"status"`
+	ExitCode   *int       `json:"exit_code,omitempty"`
+	Stdout     string     `json:"stdout"`
+	Stderr     string     `json:"stderr"`
+	Truncated  bool       `json:"truncated,omitempty"` // 输出超过上限被截断
+	Error      string     `json:"error,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	DurationMs int64      `json:"duration_ms,omitempty"`
+}
@@ -0,0 +1,249 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultCurrency 未指定币种时的默认币种
+const defaultCurrency = "USD"
+
+// 用量报表的分组方式
+const (
+	UsageByDay      = "day"
+	UsageBySession  = "session"
+	UsageByModel    = "model"
+	UsageByProvider = "provider"
+)
+
+// Apply 按价格计算用量的费用（pricing为nil时不计费）
+// 命中缓存的输入按CachedInput计价、写入缓存的输入按CacheWrite计价（未设置时均按Input）
+func (p *ModelPricing) Apply(usage *TokenUsage) {
+	if p == nil || usage == nil {
+		return
+	}
+	cachedPrice := p.CachedInput
+	if cachedPrice == 0 {
+		cachedPrice = p.Input
+	}
+	writePrice := p.CacheWrite
+	if writePrice == 0 {
+		writePrice = p.Input
+	}
+	uncached := usage.PromptTokens - usage.CachedTokens - usage.CacheWriteTokens
+	if uncached < 0 {
+		uncached = 0
+	}
+
+	usage.Cost = (float64(uncached)*p.Input +
+		float64(usage.CachedTokens)*cachedPrice +
+		float64(usage.CacheWriteTokens)*writePrice +
+		float64(usage.CompletionTokens)*p.Output) / 1e6
+	usage.Currency = p.Currency
+	if usage.Currency == "" {
+		usage.Currency = defaultCurrency
+	}
+}
+
+// UsageSummary 一组调用的用量合计
+type UsageSummary struct {
+	Key              string             `json:"key"`
+	Label            string             `json:"label"`
+	Requests         int                `json:"requests"`
+	PromptTokens     int                `json:"prompt_tokens"`
+	CompletionTokens int                `json:"completion_tokens"`
+	ReasoningTokens  int                `json:"reasoning_tokens"`
+	CachedTokens     int                `json:"cached_tokens"`
+	CacheWriteTokens int                `json:"cache_write_tokens"`
+	Cost             map[string]float64 `json:"cost"` // 币种 → 费用（不同币种分别合计）
+}
+
+// add 累加一次调用
+func (s *UsageSummary) add(u *TokenUsage) {
+	s.Requests++
+	s.PromptTokens += u.PromptTokens
+	s.CompletionTokens += u.CompletionTokens
+	s.ReasoningTokens += u.ReasoningTokens
+	s.CachedTokens += u.CachedTokens
+	s.CacheWriteTokens += u.CacheWriteTokens
+	if u.Cost > 0 {
+		if s.Cost == nil {
+			s.Cost = make(map[string]float64)
+		}
+		s.Cost[u.Currency] += u.Cost
+	}
+}
+
+// totalTokens 输入+输出
+func (s *UsageSummary) totalTokens() int {
+	return s.PromptTokens + s.CompletionTokens
+}
+
+// UsageReport 用量报表
+type UsageReport struct {
+	GroupBy string         `json:"group_by"`
+	Groups  []UsageSummary `json:"groups"`
+	Total   UsageSummary   `json:"total"`
+}
+
+// 用量记录的调用类型
+const (
+	UsageKindChat    = "chat"    // 对话回复
+	UsageKindSummary = "summary" // 压缩历史时的总结请求
+)
+
+// UsageRecord 用量账本中的一条记录（每次模型调用一条，只追加不修改）
+// 删除会话、清空或撤回消息都不影响已记录的用量
+type UsageRecord struct {
+	Timestamp    time.Time `json:"timestamp"`
+	SessionID    string    `json:"session_id"`
+	SessionTitle string    `json:"session_title,omitempty"` // 记录时的会话标题（会话删除后仍可显示）
+	Kind         string    `json:"kind"`
+	Interrupted  bool      `json:"interrupted,omitempty"` // 生成被停止或出错（用量可能为估算值）
+	TokenUsage
+}
+
+// usageLock 保护usage.jsonl的追加与读取
+var usageLock sync.Mutex
+
+// RecordUsage 追加一条用量记录到账本（data/usage.jsonl）
+func RecordUsage(record UsageRecord) error {
+	if record.Timestamp.IsZero() {
+		record.Timestamp = time.Now()
+	}
+	if record.Kind == "" {
+		record.Kind = UsageKindChat
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	usageLock.Lock()
+	defer usageLock.Unlock()
+
+	file, err := os.OpenFile(usageFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// readUsageRecords 逐条读取账本（文件不存在时没有记录，格式错误的行跳过）
+func readUsageRecords(fn func(record *UsageRecord)) error {
+	usageLock.Lock()
+	defer usageLock.Unlock()
+
+	file, err := os.Open(usageFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var record UsageRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		fn(&record)
+	}
+	return scanner.Err()
+}
+
+// GetSessionUsage 会话的累计用量（来自账本，含总结请求和已撤回消息的用量）
+func GetSessionUsage(session *ChatSession) (UsageSummary, error) {
+	summary := UsageSummary{Key: session.ID, Label: session.Title}
+	err := readUsageRecords(func(record *UsageRecord) {
+		if record.SessionID == session.ID {
+			summary.add(&record.TokenUsage)
+		}
+	})
+	return summary, err
+}
+
+// GetUsageReport 按天/会话/模型/供应商汇总账本中的用量（from、to为零值时不限制，按记录时间过滤[from, to)）
+func GetUsageReport(groupBy string, from, to time.Time) (*UsageReport, error) {
+	switch groupBy {
+	case UsageByDay, UsageBySession, UsageByModel, UsageByProvider:
+	default:
+		return nil, fmt.Errorf("不支持的分组方式: %s", groupBy)
+	}
+
+	// 模型和供应商的显示名称
+	labels := make(map[string]string)
+	if providers, err := GetProviders(); err == nil {
+		for _, p := range providers {
+			if groupBy == UsageByProvider {
+				labels[p.ID] = p.Name
+			}
+			for _, m := range p.Models {
+				if groupBy == UsageByModel {
+					labels[m.ID] = m.Name
+				}
+			}
+		}
+	}
+
+	report := &UsageReport{GroupBy: groupBy, Total: UsageSummary{Key: "total", Label: "合计"}}
+	groups := make(map[string]*UsageSummary)
+	err := readUsageRecords(func(record *UsageRecord) {
+		if (!from.IsZero() && record.Timestamp.Before(from)) || (!to.IsZero() && !record.Timestamp.Before(to)) {
+			return
+		}
+
+		var key, label string
+		switch groupBy {
+		case UsageByDay:
+			key = record.Timestamp.Local().Format("2006-01-02")
+			label = key
+		case UsageBySession:
+			key, label = record.SessionID, record.SessionTitle
+		case UsageByModel:
+			key, label = record.ModelID, labels[record.ModelID]
+		case UsageByProvider:
+			key, label = record.ProviderID, labels[record.ProviderID]
+		}
+		if label == "" {
+			label = key
+		}
+
+		group, ok := groups[key]
+		if !ok {
+			group = &UsageSummary{Key: key, Label: label}
+			groups[key] = group
+		} else if groupBy == UsageBySession && record.SessionTitle != "" {
+			group.Label = record.SessionTitle // 使用最近一次记录的标题
+		}
+		group.add(&record.TokenUsage)
+		report.Total.add(&record.TokenUsage)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report.Groups = make([]UsageSummary, 0, len(groups))
+	for _, g := range groups {
+		report.Groups = append(report.Groups, *g)
+	}
+	// 按天升序，其余按token用量降序
+	sort.Slice(report.Groups, func(i, j int) bool {
+		a, b := report.Groups[i], report.Groups[j]
+		if groupBy == UsageByDay || a.totalTokens() == b.totalTokens() {
+			return a.Key < b.Key
+		}
+		return a.totalTokens() > b.totalTokens()
+	})
+	return report, nil
+}